@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// reservation is the CPU (in CPUs) and memory (in bytes) a container reserves
+type reservation struct {
+	CPU    float64
+	Memory int64
+}
+
+// hostCapacity returns the CPUs and memory containers can reserve on this host
+var hostCapacity = func() (reservation, error) {
+	memory, err := hostMemory()
+	if err != nil {
+		return reservation{}, err
+	}
+	return reservation{CPU: float64(runtime.NumCPU()), Memory: memory}, nil
+}
+
+// hostMemory returns MemTotal from /proc/meminfo in bytes
+func hostMemory() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read host memory: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid MemTotal in /proc/meminfo: %v", err)
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("MemTotal not found in /proc/meminfo")
+}
+
+// parseReservation parses a container's --reserve-cpu and --reserve-memory
+// and checks that neither exceeds the container's own limit
+func parseReservation(opts *RunOptions) (reservation, error) {
+	var r reservation
+	if opts.ReserveCPU != "" {
+		cpu, err := strconv.ParseFloat(opts.ReserveCPU, 64)
+		if err != nil || cpu <= 0 {
+			return r, fmt.Errorf("invalid CPU reservation: %s (expected a positive number of CPUs)", opts.ReserveCPU)
+		}
+		if opts.CPULimit != "" && opts.CPULimit != "max" {
+			if limit, err := strconv.ParseFloat(opts.CPULimit, 64); err == nil && cpu > limit {
+				return r, fmt.Errorf("CPU reservation %s exceeds CPU limit %s", opts.ReserveCPU, opts.CPULimit)
+			}
+		}
+		r.CPU = cpu
+	}
+	if opts.ReserveMemory != "" {
+		value, err := parseMemoryLimit(opts.ReserveMemory)
+		if err != nil || value == "max" {
+			return r, fmt.Errorf("invalid memory reservation: %s (expected a size such as 512M or 1G)", opts.ReserveMemory)
+		}
+		memory, _ := strconv.ParseInt(value, 10, 64)
+		if limit, err := parseMemoryLimit(opts.MemoryLimit); err == nil && limit != "max" {
+			if limitBytes, _ := strconv.ParseInt(limit, 10, 64); memory > limitBytes {
+				return r, fmt.Errorf("memory reservation %s exceeds memory limit %s", opts.ReserveMemory, opts.MemoryLimit)
+			}
+		}
+		r.Memory = memory
+	}
+	return r, nil
+}
+
+// reservedResources sums the reservations of containers that hold resources:
+// created (about to start), running, and paused containers
+func reservedResources() (reservation, error) {
+	var total reservation
+	ids, err := listContainerIDs()
+	if err != nil {
+		return total, err
+	}
+
+	for _, id := range ids {
+		state, err := readContainerState(id)
+		if err != nil || state.Options == nil {
+			continue
+		}
+		if state.Status != statusCreated && !isActive(state.Status) {
+			continue
+		}
+		r, err := parseReservation(state.Options)
+		if err != nil {
+			logger.Debug("Ignoring invalid reservation", "container", shortID(state.ID), "error", err)
+			continue
+		}
+		total.CPU += r.CPU
+		total.Memory += r.Memory
+	}
+	return total, nil
+}
+
+// admitContainer refuses a container whose reservation does not fit in the
+// host capacity left over by other containers' reservations
+// On success the caller holds the admission lock until the container's state
+// is saved, so concurrent runs cannot both claim the same capacity; release
+// must then be called
+func admitContainer(opts *RunOptions) (release func(), err error) {
+	requested, err := parseReservation(opts)
+	if err != nil {
+		return nil, err
+	}
+	if requested.CPU == 0 && requested.Memory == 0 {
+		return func() {}, nil
+	}
+
+	if err := ensureStateDir(); err != nil {
+		return nil, err
+	}
+	lock, err := lockGlobal("admission")
+	if err != nil {
+		return nil, err
+	}
+	release = func() { unlockContainer(lock) }
+
+	capacity, err := hostCapacity()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	reserved, err := reservedResources()
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	if requested.CPU > 0 && reserved.CPU+requested.CPU > capacity.CPU {
+		release()
+		return nil, fmt.Errorf("cannot reserve %s CPUs: %s of %s host CPUs are already reserved",
+			formatCPUs(requested.CPU), formatCPUs(reserved.CPU), formatCPUs(capacity.CPU))
+	}
+	if requested.Memory > 0 && reserved.Memory+requested.Memory > capacity.Memory {
+		release()
+		return nil, fmt.Errorf("cannot reserve %s of memory: %s of %s host memory is already reserved",
+			formatMemory(requested.Memory), formatMemory(reserved.Memory), formatMemory(capacity.Memory))
+	}
+
+	logger.Debug("Admitted container reservation", "cpu", requested.CPU, "memory", requested.Memory,
+		"reserved_cpu", reserved.CPU, "reserved_memory", reserved.Memory)
+	return release, nil
+}
+
+// formatCPUs formats a CPU count without trailing zeros, e.g. "1.5"
+func formatCPUs(cpus float64) string {
+	return strconv.FormatFloat(cpus, 'f', -1, 64)
+}
+
+// formatMemory formats a byte count in the largest unit, e.g. "512M" or "1.5G"
+func formatMemory(bytes int64) string {
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}}
+	for _, unit := range units {
+		if bytes >= unit.size {
+			value := strconv.FormatFloat(float64(bytes)/float64(unit.size), 'f', 1, 64)
+			return strings.TrimSuffix(value, ".0") + unit.suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10)
+}
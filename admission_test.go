@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestParseReservation checks reservation parsing and validation against limits
+func TestParseReservation(t *testing.T) {
+	tests := []struct {
+		opts    RunOptions
+		want    reservation
+		wantErr string
+	}{
+		{RunOptions{}, reservation{}, ""},
+		{RunOptions{ReserveCPU: "1.5", ReserveMemory: "512M"}, reservation{CPU: 1.5, Memory: 512 << 20}, ""},
+		{RunOptions{ReserveCPU: "0.5", CPULimit: "1", ReserveMemory: "1G", MemoryLimit: "2G"}, reservation{CPU: 0.5, Memory: 1 << 30}, ""},
+		{RunOptions{ReserveCPU: "2", CPULimit: "1"}, reservation{}, "exceeds CPU limit"},
+		{RunOptions{ReserveMemory: "1G", MemoryLimit: "512M"}, reservation{}, "exceeds memory limit"},
+		{RunOptions{ReserveCPU: "-1"}, reservation{}, "invalid CPU reservation"},
+		{RunOptions{ReserveMemory: "max"}, reservation{}, "invalid memory reservation"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseReservation(&tt.opts)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseReservation(%+v): expected error containing %q, got %v", tt.opts, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseReservation(%+v): unexpected error: %v", tt.opts, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseReservation(%+v) = %+v, want %+v", tt.opts, got, tt.want)
+		}
+	}
+}
+
+// TestAdmitContainer checks admission against host capacity and other containers' reservations
+func TestAdmitContainer(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+	savedCapacity := hostCapacity
+	hostCapacity = func() (reservation, error) { return reservation{CPU: 4, Memory: 4 << 30}, nil }
+	t.Cleanup(func() { hostCapacity = savedCapacity })
+
+	states := []*ContainerState{
+		{ID: "running", Status: statusRunning, Options: &RunOptions{ReserveCPU: "2", ReserveMemory: "2G"}},
+		{ID: "paused", Status: statusPaused, Options: &RunOptions{ReserveCPU: "1"}},
+		{ID: "exited", Status: statusExited, Options: &RunOptions{ReserveCPU: "4", ReserveMemory: "4G"}},
+	}
+	for _, state := range states {
+		if err := saveContainerState(state); err != nil {
+			t.Fatalf("saveContainerState failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		opts    RunOptions
+		wantErr string
+	}{
+		{RunOptions{}, ""},
+		{RunOptions{ReserveCPU: "1", ReserveMemory: "2G"}, ""},
+		{RunOptions{ReserveCPU: "1.5"}, "cannot reserve 1.5 CPUs: 3 of 4 host CPUs are already reserved"},
+		{RunOptions{ReserveMemory: "3G"}, "cannot reserve 3G of memory: 2G of 4G host memory is already reserved"},
+	}
+
+	for _, tt := range tests {
+		release, err := admitContainer(&tt.opts)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("admitContainer(%+v): expected error %q, got %v", tt.opts, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("admitContainer(%+v): unexpected error: %v", tt.opts, err)
+			continue
+		}
+		release()
+	}
+}
+
+// TestFormatMemory checks human-readable memory sizes in admission errors
+func TestFormatMemory(t *testing.T) {
+	tests := map[int64]string{
+		512:              "512",
+		2048:             "2K",
+		512 << 20:        "512M",
+		3 << 29:          "1.5G",
+		(8 << 30) - 1024: "8G",
+	}
+	for bytes, want := range tests {
+		if got := formatMemory(bytes); got != want {
+			t.Errorf("formatMemory(%d) = %q, want %q", bytes, got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// containerInfoVersion is the ContainerInfo schema version; fields may be
+// added within a version, but renaming or removing one bumps it
+const containerInfoVersion = "1"
+
+// ContainerInfo is the container state given to webhooks and plugins, so
+// external tools can act per container without reading gocker's state files,
+// whose layout may change
+// Labels select containers; annotations are free-form data for such tools
+type ContainerInfo struct {
+	SchemaVersion string            `json:"schema_version"`
+	ID            string            `json:"id"`
+	Status        string            `json:"status"`
+	PID           int               `json:"pid,omitempty"`
+	Command       []string          `json:"command"`
+	Rootfs        string            `json:"rootfs,omitempty"`
+	CgroupPath    string            `json:"cgroup_path,omitempty"`
+	IP            string            `json:"ip,omitempty"`
+	CPULimit      string            `json:"cpu_limit,omitempty"`
+	MemoryLimit   string            `json:"memory_limit,omitempty"`
+	Volumes       []string          `json:"volumes,omitempty"`
+	Labels        map[string]string `json:"labels"`
+	Annotations   map[string]string `json:"annotations"`
+	CreatedAt     time.Time         `json:"created_at"`
+	FinishedAt    *time.Time        `json:"finished_at,omitempty"`
+	ExitCode      *int              `json:"exit_code,omitempty"`
+}
+
+// newContainerInfo describes a container in the ContainerInfo schema
+func newContainerInfo(state *ContainerState) *ContainerInfo {
+	info := &ContainerInfo{
+		SchemaVersion: containerInfoVersion,
+		ID:            state.ID,
+		Status:        state.Status,
+		PID:           state.PID,
+		Command:       state.Command,
+		Rootfs:        state.RootfsPath,
+		CgroupPath:    state.CgroupPath,
+		IP:            state.ContainerIP,
+		Labels:        keyValueMap(nil),
+		Annotations:   keyValueMap(nil),
+		CreatedAt:     state.CreatedAt,
+		FinishedAt:    state.FinishedAt,
+		ExitCode:      state.ExitCode,
+	}
+	if opts := state.Options; opts != nil {
+		info.CPULimit = opts.CPULimit
+		info.MemoryLimit = opts.MemoryLimit
+		info.Volumes = opts.Volumes
+		info.Labels = keyValueMap(opts.Labels)
+		info.Annotations = keyValueMap(opts.Annotations)
+	}
+	return info
+}
+
+// keyValueMap turns normalized KEY=VALUE pairs into a map; later keys win
+// The map is never nil, so it is encoded as {} rather than null
+func keyValueMap(pairs []string) map[string]string {
+	m := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, _ := strings.Cut(pair, "=")
+		m[key] = value
+	}
+	return m
+}
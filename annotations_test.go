@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewContainerInfo(t *testing.T) {
+	code := 0
+	state := &ContainerState{
+		ID:          "abcdef0123456789",
+		Status:      statusExited,
+		PID:         42,
+		Command:     []string{"/bin/sh"},
+		ContainerIP: "10.0.0.5",
+		CreatedAt:   time.Now(),
+		ExitCode:    &code,
+		Options: &RunOptions{
+			MemoryLimit: "256M",
+			Labels:      []string{"app=web"},
+			Annotations: []string{"gpu=0,1", "audit.level=full"},
+		},
+	}
+	info := newContainerInfo(state)
+	if info.SchemaVersion != containerInfoVersion || info.ID != state.ID || info.IP != "10.0.0.5" || info.MemoryLimit != "256M" {
+		t.Errorf("Unexpected info: %+v", info)
+	}
+	if want := map[string]string{"gpu": "0,1", "audit.level": "full"}; !reflect.DeepEqual(info.Annotations, want) {
+		t.Errorf("Annotations = %v, want %v", info.Annotations, want)
+	}
+	if want := map[string]string{"app": "web"}; !reflect.DeepEqual(info.Labels, want) {
+		t.Errorf("Labels = %v, want %v", info.Labels, want)
+	}
+
+	// Containers without options still encode labels and annotations as objects
+	data, err := json.Marshal(newContainerInfo(&ContainerState{ID: "abc"}))
+	if err != nil {
+		t.Fatalf("Failed to marshal info: %v", err)
+	}
+	if !strings.Contains(string(data), `"labels":{}`) || !strings.Contains(string(data), `"annotations":{}`) {
+		t.Errorf("Expected empty label and annotation objects, got %s", data)
+	}
+}
+
+func TestNormalizeAnnotations(t *testing.T) {
+	annotations, err := normalizeAnnotations([]string{"gpu=0", "audit"})
+	if err != nil {
+		t.Fatalf("normalizeAnnotations failed: %v", err)
+	}
+	if want := []string{"gpu=0", "audit="}; !reflect.DeepEqual(annotations, want) {
+		t.Errorf("normalizeAnnotations = %v, want %v", annotations, want)
+	}
+	if _, err := normalizeAnnotations([]string{"=x"}); err == nil || !strings.Contains(err.Error(), "annotation") {
+		t.Errorf("Expected invalid annotation error, got %v", err)
+	}
+}
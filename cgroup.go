@@ -0,0 +1,626 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cgroup2SuperMagic is the statfs type of a cgroup v2 mount
+const cgroup2SuperMagic = 0x63677270
+
+// CgroupManager creates, limits, and removes per-container cgroups
+// A container's cgroup is identified by its path under cgroupRoot, as if the
+// host used cgroup v2; the v1 backend maps it into each controller hierarchy
+type CgroupManager interface {
+	// Mode describes the backend: "v2", "v1" or "systemd"
+	Mode() string
+	// Path returns the cgroup path for a container
+	Path(containerID string) string
+	// Create creates the cgroup at path
+	Create(path string) error
+	// SetLimits applies the pids limit and optional CPU and memory limits;
+	// memoryHigh is the soft limit above which the cgroup is throttled and
+	// reclaimed, memoryLimit the hard limit that invokes the OOM killer
+	SetLimits(path, cpuLimit, memoryLimit, memoryHigh string) error
+	// StartDir opens the cgroup directory for SysProcAttr.CgroupFD, so a
+	// process starts inside the cgroup; it returns nil if the hierarchy
+	// cannot do that and AddProcess must be used after start instead
+	StartDir(path string) (*os.File, error)
+	// AddProcess moves a running process into the cgroup
+	AddProcess(path string, pid int) error
+	// Freeze freezes or thaws every process in the cgroup
+	Freeze(path string, frozen bool) error
+	// OOMKilled reports whether the OOM killer killed a process in the cgroup
+	OOMKilled(path string) bool
+	// Stats reads the cgroup's resource usage and, on v2, its pressure
+	Stats(path string) (*CgroupStats, error)
+	// Remove removes the cgroup; it fails quietly while processes remain
+	Remove(path string)
+	// RemoveTree removes cgroupParent with every cgroup left under it
+	RemoveTree() error
+}
+
+// cgroups is the cgroup backend for this host, chosen by detectCgroupManager
+var cgroups CgroupManager = &cgroupV2{}
+
+// detectCgroupManager picks the backend for the host's cgroup mode: v2 when
+// cgroupRoot is a cgroup2 mount, v1 when it holds v1 controller hierarchies
+// (legacy and hybrid hosts, where the controllers live in v1), and v2 at
+// another cgroup2 mount when there is neither
+// The systemd driver (cgroup_driver "systemd") requires cgroup v2
+func detectCgroupManager() (CgroupManager, error) {
+	unified := false
+	if fs, err := statFS(cgroupRoot); err == nil && fs.Type == cgroup2SuperMagic {
+		unified = true
+	}
+
+	if cgroupDriver == "systemd" {
+		if !unified {
+			return nil, fmt.Errorf("the systemd cgroup driver requires cgroup v2")
+		}
+		return &cgroupSystemd{limits: make(map[string][]unitProperty)}, nil
+	}
+	if !unified {
+		if info, err := os.Stat(filepath.Join(cgroupRoot, "memory")); err == nil && info.IsDir() {
+			return &cgroupV1{root: cgroupRoot}, nil
+		}
+		// Without v1 controllers either, cgroup2 may be mounted elsewhere
+		if mount := findCgroup2Mount(); mount != "" {
+			useCgroupRoot(mount)
+		}
+	}
+	return &cgroupV2{}, nil
+}
+
+// cgroupsUnavailable stands in for the backend when detectCgroupManager
+// fails, so commands that do not need cgroups, such as version or ps, still
+// run on the host; every operation fails with the reason
+type cgroupsUnavailable struct {
+	err error
+}
+
+func (m cgroupsUnavailable) Mode() string { return "unavailable" }
+
+func (m cgroupsUnavailable) Path(containerID string) string {
+	return filepath.Join(cgroupParent, containerID)
+}
+
+func (m cgroupsUnavailable) Create(path string) error { return m.err }
+
+func (m cgroupsUnavailable) SetLimits(path, cpuLimit, memoryLimit, memoryHigh string) error {
+	return m.err
+}
+
+func (m cgroupsUnavailable) StartDir(path string) (*os.File, error) { return nil, m.err }
+
+func (m cgroupsUnavailable) AddProcess(path string, pid int) error { return m.err }
+
+func (m cgroupsUnavailable) Freeze(path string, frozen bool) error { return m.err }
+
+func (m cgroupsUnavailable) OOMKilled(path string) bool { return false }
+
+func (m cgroupsUnavailable) Stats(path string) (*CgroupStats, error) { return nil, m.err }
+
+func (m cgroupsUnavailable) Remove(path string) {}
+
+func (m cgroupsUnavailable) RemoveTree() error { return m.err }
+
+// cgroupV2 manages cgroups on the unified hierarchy
+type cgroupV2 struct{}
+
+func (m *cgroupV2) Mode() string { return "v2" }
+
+func (m *cgroupV2) Path(containerID string) string {
+	return filepath.Join(cgroupParent, containerID)
+}
+
+func (m *cgroupV2) Create(path string) error {
+	parent := filepath.Dir(path)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return fmt.Errorf("failed to create parent cgroup directory: %v", err)
+	}
+
+	// Non-fatal, controllers might already be enabled or not available
+	// The parent's parent is enabled too: in a nested gocker it is the
+	// container's cgroup, which enables nothing by default
+	for _, dir := range []string{filepath.Dir(parent), parent} {
+		if err := enableControllers(dir); err != nil {
+			logger.Debug("Could not enable cgroup controllers", "cgroup", dir, "error", err)
+		}
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create container cgroup directory: %v", err)
+	}
+	return nil
+}
+
+func (m *cgroupV2) SetLimits(path, cpuLimit, memoryLimit, memoryHigh string) error {
+	if err := os.WriteFile(filepath.Join(path, "pids.max"), []byte(strconv.Itoa(pidsLimit)), 0644); err != nil {
+		return fmt.Errorf("failed to set pids.max: %v", err)
+	}
+
+	if cpuLimit != "" && cpuLimit != "max" {
+		cpuMax, err := parseCPULimit(cpuLimit)
+		if err != nil {
+			return fmt.Errorf("failed to parse CPU limit: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "cpu.max"), []byte(cpuMax), 0644); err != nil {
+			return fmt.Errorf("failed to set cpu.max: %v", err)
+		}
+	}
+
+	if memoryLimit != "" && memoryLimit != "max" {
+		memoryMax, err := parseMemoryLimit(memoryLimit)
+		if err != nil {
+			return fmt.Errorf("failed to parse memory limit: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "memory.max"), []byte(memoryMax), 0644); err != nil {
+			return fmt.Errorf("failed to set memory.max: %v", err)
+		}
+	}
+
+	if memoryHigh != "" && memoryHigh != "max" {
+		high, err := parseMemoryLimit(memoryHigh)
+		if err != nil {
+			return fmt.Errorf("failed to parse memory high: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "memory.high"), []byte(high), 0644); err != nil {
+			return fmt.Errorf("failed to set memory.high: %v", err)
+		}
+	}
+	return nil
+}
+
+func (m *cgroupV2) StartDir(path string) (*os.File, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container cgroup: %v", err)
+	}
+	return dir, nil
+}
+
+func (m *cgroupV2) AddProcess(path string, pid int) error {
+	if err := os.WriteFile(filepath.Join(path, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("failed to add process to cgroup: %v", err)
+	}
+	return nil
+}
+
+func (m *cgroupV2) Freeze(path string, frozen bool) error {
+	value := "0"
+	if frozen {
+		value = "1"
+	}
+	return os.WriteFile(filepath.Join(path, "cgroup.freeze"), []byte(value), 0644)
+}
+
+func (m *cgroupV2) OOMKilled(path string) bool {
+	data, err := os.ReadFile(filepath.Join(path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	return cgroupCounter(string(data), "oom_kill") > 0
+}
+
+func (m *cgroupV2) Stats(path string) (*CgroupStats, error) {
+	memory, err := os.ReadFile(filepath.Join(path, "memory.current"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memory usage: %v", err)
+	}
+	stats := &CgroupStats{
+		MemoryUsage: parseCgroupValue(string(memory)),
+		MemoryLimit: readIntFile(filepath.Join(path, "memory.max")),
+		MemoryPeak:  readIntFile(filepath.Join(path, "memory.peak")), // Linux 5.19+
+		MemoryHigh:  readIntFile(filepath.Join(path, "memory.high")),
+		SwapUsage:   readIntFile(filepath.Join(path, "memory.swap.current")), // missing without swap accounting
+		Pids:        readIntFile(filepath.Join(path, "pids.current")),
+	}
+	if data, err := os.ReadFile(filepath.Join(path, "memory.events")); err == nil {
+		stats.MemoryEvents = &MemoryEvents{
+			High:    cgroupCounter(string(data), "high"),
+			Max:     cgroupCounter(string(data), "max"),
+			OOMKill: cgroupCounter(string(data), "oom_kill"),
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(path, "cpu.stat")); err == nil {
+		stats.CPUUsageUsec = cgroupCounter(string(data), "usage_usec")
+		stats.CPUThrottling = cpuThrottling(string(data), "throttled_usec", 1)
+	}
+
+	// The pressure files are missing when the kernel was built or booted without PSI
+	for _, resource := range pressureResources {
+		data, err := os.ReadFile(filepath.Join(path, resource+".pressure"))
+		if err != nil {
+			continue
+		}
+		pressure, err := parsePressure(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s.pressure: %v", resource, err)
+		}
+		if stats.Pressure == nil {
+			stats.Pressure = make(map[string]*Pressure)
+		}
+		stats.Pressure[resource] = pressure
+	}
+	return stats, nil
+}
+
+func (m *cgroupV2) Remove(path string) {
+	os.Remove(path)
+}
+
+func (m *cgroupV2) RemoveTree() error {
+	return removeCgroupDir(cgroupParent)
+}
+
+// removeCgroupDir removes a cgroup directory after its child cgroups
+func removeCgroupDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cgroup %s: %v", dir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err := removeCgroupDir(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Remove(dir); err != nil {
+		return fmt.Errorf("failed to remove cgroup %s: %v", dir, err)
+	}
+	return nil
+}
+
+// cgroupV1Controllers are the v1 hierarchies a container gets a cgroup in
+var cgroupV1Controllers = []string{"cpu", "memory", "pids", "freezer"}
+
+// cgroupV1 manages cgroups on hosts with per-controller v1 hierarchies, each
+// mounted at root/<controller>
+type cgroupV1 struct {
+	root string
+}
+
+func (m *cgroupV1) Mode() string { return "v1" }
+
+func (m *cgroupV1) Path(containerID string) string {
+	return filepath.Join(cgroupParent, containerID)
+}
+
+// dir returns the directory of the cgroup at path in a controller's hierarchy
+func (m *cgroupV1) dir(controller, path string) string {
+	rel, err := filepath.Rel(m.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	return filepath.Join(m.root, controller, rel)
+}
+
+func (m *cgroupV1) Create(path string) error {
+	for _, controller := range cgroupV1Controllers {
+		if err := os.MkdirAll(m.dir(controller, path), 0755); err != nil {
+			m.Remove(path)
+			return fmt.Errorf("failed to create %s cgroup directory: %v", controller, err)
+		}
+	}
+	return nil
+}
+
+// SetLimits refuses a memory high limit: v1 has no equivalent of memory.high,
+// only a soft limit that is enforced when the whole host runs short
+func (m *cgroupV1) SetLimits(path, cpuLimit, memoryLimit, memoryHigh string) error {
+	if memoryHigh != "" && memoryHigh != "max" {
+		return fmt.Errorf("--memory-high requires cgroup v2")
+	}
+	if err := os.WriteFile(filepath.Join(m.dir("pids", path), "pids.max"), []byte(strconv.Itoa(pidsLimit)), 0644); err != nil {
+		return fmt.Errorf("failed to set pids.max: %v", err)
+	}
+
+	if cpuLimit != "" && cpuLimit != "max" {
+		cpuMax, err := parseCPULimit(cpuLimit)
+		if err != nil {
+			return fmt.Errorf("failed to parse CPU limit: %v", err)
+		}
+		quota, period, _ := strings.Cut(cpuMax, " ")
+		cpu := m.dir("cpu", path)
+		if err := os.WriteFile(filepath.Join(cpu, "cpu.cfs_period_us"), []byte(period), 0644); err != nil {
+			return fmt.Errorf("failed to set cpu.cfs_period_us: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(cpu, "cpu.cfs_quota_us"), []byte(quota), 0644); err != nil {
+			return fmt.Errorf("failed to set cpu.cfs_quota_us: %v", err)
+		}
+	}
+
+	if memoryLimit != "" && memoryLimit != "max" {
+		memoryMax, err := parseMemoryLimit(memoryLimit)
+		if err != nil {
+			return fmt.Errorf("failed to parse memory limit: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(m.dir("memory", path), "memory.limit_in_bytes"), []byte(memoryMax), 0644); err != nil {
+			return fmt.Errorf("failed to set memory.limit_in_bytes: %v", err)
+		}
+	}
+	return nil
+}
+
+// StartDir returns nil: starting a process in a cgroup (CLONE_INTO_CGROUP)
+// only works on cgroup v2
+func (m *cgroupV1) StartDir(path string) (*os.File, error) {
+	return nil, nil
+}
+
+func (m *cgroupV1) AddProcess(path string, pid int) error {
+	for _, controller := range cgroupV1Controllers {
+		procs := filepath.Join(m.dir(controller, path), "cgroup.procs")
+		if err := os.WriteFile(procs, []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("failed to add process to %s cgroup: %v", controller, err)
+		}
+	}
+	return nil
+}
+
+func (m *cgroupV1) Freeze(path string, frozen bool) error {
+	value := "THAWED"
+	if frozen {
+		value = "FROZEN"
+	}
+	return os.WriteFile(filepath.Join(m.dir("freezer", path), "freezer.state"), []byte(value), 0644)
+}
+
+func (m *cgroupV1) OOMKilled(path string) bool {
+	data, err := os.ReadFile(filepath.Join(m.dir("memory", path), "memory.oom_control"))
+	if err != nil {
+		return false
+	}
+	return cgroupCounter(string(data), "oom_kill") > 0
+}
+
+// Stats has no pressure on v1, which only reports PSI for the whole system
+func (m *cgroupV1) Stats(path string) (*CgroupStats, error) {
+	memory, err := os.ReadFile(filepath.Join(m.dir("memory", path), "memory.usage_in_bytes"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memory usage: %v", err)
+	}
+	stats := &CgroupStats{
+		MemoryUsage: parseCgroupValue(string(memory)),
+		MemoryLimit: readIntFile(filepath.Join(m.dir("memory", path), "memory.limit_in_bytes")),
+		MemoryPeak:  readIntFile(filepath.Join(m.dir("memory", path), "memory.max_usage_in_bytes")),
+		Pids:        readIntFile(filepath.Join(m.dir("pids", path), "pids.current")),
+	}
+	// An unlimited v1 memory cgroup reports the largest page-aligned value
+	if stats.MemoryLimit >= 1<<62 {
+		stats.MemoryLimit = 0
+	}
+	// memsw counts memory and swap together; it is missing unless the kernel
+	// was booted with swapaccount=1
+	if memsw := readIntFile(filepath.Join(m.dir("memory", path), "memory.memsw.usage_in_bytes")); memsw > stats.MemoryUsage {
+		stats.SwapUsage = memsw - stats.MemoryUsage
+	}
+	if data, err := os.ReadFile(filepath.Join(m.dir("memory", path), "memory.oom_control")); err == nil {
+		stats.MemoryEvents = &MemoryEvents{OOMKill: cgroupCounter(string(data), "oom_kill")}
+	}
+	// cpuacct is usually mounted together with cpu
+	stats.CPUUsageUsec = readIntFile(filepath.Join(m.dir("cpu", path), "cpuacct.usage")) / 1000
+	if data, err := os.ReadFile(filepath.Join(m.dir("cpu", path), "cpu.stat")); err == nil {
+		stats.CPUThrottling = cpuThrottling(string(data), "throttled_time", 1000)
+	}
+	return stats, nil
+}
+
+func (m *cgroupV1) Remove(path string) {
+	for _, controller := range cgroupV1Controllers {
+		os.Remove(m.dir(controller, path))
+	}
+}
+
+func (m *cgroupV1) RemoveTree() error {
+	for _, controller := range cgroupV1Controllers {
+		if err := removeCgroupDir(m.dir(controller, cgroupParent)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cgroupSystemd leaves the cgroup tree to systemd: each container is a
+// transient scope, gocker-<id>.scope, in a slice named after cgroupParent,
+// created over D-Bus with busctl
+// A scope cannot exist without a process, so limits set before the container
+// starts are kept until AddProcess creates the scope; once it exists the scope
+// is a plain v2 cgroup
+type cgroupSystemd struct {
+	cgroupV2
+	limits map[string][]unitProperty
+}
+
+// unitProperty is a systemd unit property in busctl's argument syntax: the
+// D-Bus signature followed by the value's arguments
+type unitProperty struct {
+	name      string
+	signature string
+	values    []string
+}
+
+func (m *cgroupSystemd) Mode() string { return "systemd" }
+
+func (m *cgroupSystemd) Path(containerID string) string {
+	return filepath.Join(cgroupRoot, systemdSliceDir(systemdSlice()), "gocker-"+containerID+".scope")
+}
+
+// Create does nothing: systemd creates the scope when the first process joins
+func (m *cgroupSystemd) Create(path string) error {
+	return nil
+}
+
+func (m *cgroupSystemd) SetLimits(path, cpuLimit, memoryLimit, memoryHigh string) error {
+	props := []unitProperty{
+		{"TasksAccounting", "b", []string{"true"}},
+		{"TasksMax", "t", []string{strconv.Itoa(pidsLimit)}},
+	}
+	if cpuLimit != "" && cpuLimit != "max" {
+		cpuMax, err := parseCPULimit(cpuLimit)
+		if err != nil {
+			return fmt.Errorf("failed to parse CPU limit: %v", err)
+		}
+		var quota, period int64
+		fmt.Sscanf(cpuMax, "%d %d", &quota, &period)
+		props = append(props,
+			unitProperty{"CPUAccounting", "b", []string{"true"}},
+			unitProperty{"CPUQuotaPerSecUSec", "t", []string{strconv.FormatInt(quota*1000000/period, 10)}})
+	}
+	memoryProps := []unitProperty{{"MemoryAccounting", "b", []string{"true"}}}
+	if memoryLimit != "" && memoryLimit != "max" {
+		memoryMax, err := parseMemoryLimit(memoryLimit)
+		if err != nil {
+			return fmt.Errorf("failed to parse memory limit: %v", err)
+		}
+		memoryProps = append(memoryProps, unitProperty{"MemoryMax", "t", []string{memoryMax}})
+	}
+	if memoryHigh != "" && memoryHigh != "max" {
+		high, err := parseMemoryLimit(memoryHigh)
+		if err != nil {
+			return fmt.Errorf("failed to parse memory high: %v", err)
+		}
+		memoryProps = append(memoryProps, unitProperty{"MemoryHigh", "t", []string{high}})
+	}
+	if len(memoryProps) > 1 {
+		props = append(props, memoryProps...)
+	}
+	m.limits[path] = props
+	return nil
+}
+
+// StartDir opens the scope if it exists (for exec); a new container is moved
+// into its scope by AddProcess after it starts
+func (m *cgroupSystemd) StartDir(path string) (*os.File, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return m.cgroupV2.StartDir(path)
+}
+
+func (m *cgroupSystemd) AddProcess(path string, pid int) error {
+	if _, err := os.Stat(path); err == nil {
+		return m.cgroupV2.AddProcess(path, pid)
+	}
+
+	unit := filepath.Base(path)
+	args := transientScopeArgs(unit, systemdSlice(), pid, m.limits[path])
+	if output, err := hostCommand("busctl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create systemd scope %s: %v: %s", unit, err, strings.TrimSpace(string(output)))
+	}
+
+	// StartTransientUnit queues a job; the scope exists once it has run
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+		time.Sleep(exitPollInterval)
+	}
+	return fmt.Errorf("systemd scope %s was not created", unit)
+}
+
+// Remove stops the scope; systemd removes it on its own once it is empty
+func (m *cgroupSystemd) Remove(path string) {
+	if _, err := os.Stat(path); err == nil {
+		hostCommand("systemctl", "stop", "--quiet", filepath.Base(path)).Run()
+	}
+}
+
+// RemoveTree stops the containers' slice, and with it any scopes left in it
+func (m *cgroupSystemd) RemoveTree() error {
+	if output, err := hostCommand("systemctl", "stop", "--quiet", systemdSlice()).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to stop %s: %v: %s", systemdSlice(), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// systemdSlice returns the slice containers are placed in, derived from
+// cgroupParent: "gocker" becomes gocker.slice
+func systemdSlice() string {
+	parent := strings.TrimPrefix(strings.TrimPrefix(cgroupParent, cgroupRoot), "/")
+	return strings.ReplaceAll(parent, "/", "-") + ".slice"
+}
+
+// systemdSliceDir returns the cgroup directory of a slice relative to the
+// root: a dash in a slice name nests it, so a-b.slice is a.slice/a-b.slice
+func systemdSliceDir(slice string) string {
+	parts := strings.Split(strings.TrimSuffix(slice, ".slice"), "-")
+	var dirs []string
+	for i := range parts {
+		dirs = append(dirs, strings.Join(parts[:i+1], "-")+".slice")
+	}
+	return filepath.Join(dirs...)
+}
+
+// transientScopeArgs returns the busctl arguments that create a scope for pid
+// in slice with the given properties
+func transientScopeArgs(unit, slice string, pid int, props []unitProperty) []string {
+	props = append([]unitProperty{
+		{"Description", "s", []string{"gocker container " + shortID(strings.TrimSuffix(strings.TrimPrefix(unit, "gocker-"), ".scope"))}},
+		{"Slice", "s", []string{slice}},
+		{"Delegate", "b", []string{"true"}},
+		{"PIDs", "au", []string{"1", strconv.Itoa(pid)}},
+	}, props...)
+
+	args := []string{"call", "org.freedesktop.systemd1", "/org/freedesktop/systemd1",
+		"org.freedesktop.systemd1.Manager", "StartTransientUnit", "ssa(sv)a(sa(sv))",
+		unit, "fail", strconv.Itoa(len(props))}
+	for _, prop := range props {
+		args = append(args, prop.name, prop.signature)
+		args = append(args, prop.values...)
+	}
+	return append(args, "0")
+}
+
+// readIntFile reads a single-value file such as pids.current or a sysfs counter,
+// returning 0 if it is missing or "max"
+func readIntFile(path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	return parseCgroupValue(string(data))
+}
+
+func parseCgroupValue(data string) int64 {
+	value, _ := strconv.ParseInt(strings.TrimSpace(data), 10, 64)
+	return value
+}
+
+// cpuThrottling reads the throttling counters of a cpu.stat file, whose
+// throttled time is in the named counter in units of perUsec per microsecond
+// It returns nil while no period has been counted, as without a CPU limit
+func cpuThrottling(data, timeKey string, perUsec int64) *CPUThrottling {
+	periods := cgroupCounter(data, "nr_periods")
+	if periods == 0 {
+		return nil
+	}
+	return &CPUThrottling{
+		Periods:       periods,
+		Throttled:     cgroupCounter(data, "nr_throttled"),
+		ThrottledUsec: cgroupCounter(data, timeKey) / perUsec,
+	}
+}
+
+// cgroupCounter returns a counter from a flat-keyed cgroup file such as
+// memory.events ("key value" per line), or 0 if it is missing
+func cgroupCounter(data, key string) int64 {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			value, _ := strconv.ParseInt(fields[1], 10, 64)
+			return value
+		}
+	}
+	return 0
+}
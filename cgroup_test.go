@@ -0,0 +1,181 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCgroupV1 checks the v1 backend maps a container's cgroup into each
+// controller hierarchy and writes the v1 limit files
+func TestCgroupV1(t *testing.T) {
+	restoreRuntimeSettings(t)
+	root := t.TempDir()
+	m := &cgroupV1{root: root}
+	path := filepath.Join(root, "gocker", "abc123")
+
+	if err := m.Create(path); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := m.SetLimits(path, "0.5", "256M", ""); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+	if err := m.SetLimits(path, "", "", "128M"); err == nil {
+		t.Error("Expected error for a memory high limit on v1")
+	}
+	if err := m.Freeze(path, true); err != nil {
+		t.Fatalf("Freeze failed: %v", err)
+	}
+	if err := m.AddProcess(path, 42); err != nil {
+		t.Fatalf("AddProcess failed: %v", err)
+	}
+
+	want := map[string]string{
+		"pids/gocker/abc123/pids.max":                "20",
+		"cpu/gocker/abc123/cpu.cfs_quota_us":         "50000",
+		"cpu/gocker/abc123/cpu.cfs_period_us":        "100000",
+		"memory/gocker/abc123/memory.limit_in_bytes": "268435456",
+		"freezer/gocker/abc123/freezer.state":        "FROZEN",
+		"freezer/gocker/abc123/cgroup.procs":         "42",
+	}
+	for file, value := range want {
+		data, err := os.ReadFile(filepath.Join(root, file))
+		if err != nil || string(data) != value {
+			t.Errorf("%s = %q (%v), want %q", file, data, err, value)
+		}
+	}
+
+	if dir, err := m.StartDir(path); dir != nil || err != nil {
+		t.Errorf("Expected v1 StartDir to return nil, got %v, %v", dir, err)
+	}
+}
+
+// TestCgroupV2Limits checks the v2 backend writes the hard and soft memory limits
+func TestCgroupV2Limits(t *testing.T) {
+	restoreRuntimeSettings(t)
+	path := t.TempDir()
+	if err := (&cgroupV2{}).SetLimits(path, "", "256M", "192M"); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+	want := map[string]string{"pids.max": "20", "memory.max": "268435456", "memory.high": "201326592"}
+	for file, value := range want {
+		data, err := os.ReadFile(filepath.Join(path, file))
+		if err != nil || string(data) != value {
+			t.Errorf("%s = %q (%v), want %q", file, data, err, value)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(path, "cpu.max")); !os.IsNotExist(err) {
+		t.Errorf("Expected no cpu.max without a CPU limit, got %v", err)
+	}
+}
+
+// TestCgroupRemoveTree checks the cgroup parent is removed along with the
+// container cgroups and nested cgroups left in it
+func TestCgroupRemoveTree(t *testing.T) {
+	restoreRuntimeSettings(t)
+	root := t.TempDir()
+	cgroupParent = filepath.Join(root, "gocker")
+	m := &cgroupV1{root: root}
+	for _, path := range []string{filepath.Join(cgroupParent, "abc123"), filepath.Join(cgroupParent, "def456", "nested")} {
+		if err := m.Create(path); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	if err := m.RemoveTree(); err != nil {
+		t.Fatalf("RemoveTree failed: %v", err)
+	}
+	for _, controller := range cgroupV1Controllers {
+		if _, err := os.Stat(filepath.Join(root, controller, "gocker")); !os.IsNotExist(err) {
+			t.Errorf("Expected %s/gocker to be removed, got %v", controller, err)
+		}
+	}
+	if err := (&cgroupV2{}).RemoveTree(); err != nil {
+		t.Errorf("Expected RemoveTree of a missing parent to succeed, got %v", err)
+	}
+}
+
+// TestCgroupOOMKilled checks OOM kill detection from v2 memory.events and v1 memory.oom_control
+func TestCgroupOOMKilled(t *testing.T) {
+	root := t.TempDir()
+	v2 := filepath.Join(root, "v2")
+	v1 := &cgroupV1{root: root}
+	v1Path := filepath.Join(root, "gocker", "abc123")
+	if err := os.MkdirAll(v2, 0755); err != nil {
+		t.Fatalf("Failed to create cgroup: %v", err)
+	}
+	if err := v1.Create(v1Path); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if (&cgroupV2{}).OOMKilled(v2) || v1.OOMKilled(v1Path) {
+		t.Error("Expected no OOM kill without counters")
+	}
+	os.WriteFile(filepath.Join(v2, "memory.events"), []byte("low 0\noom 1\noom_kill 1\n"), 0644)
+	os.WriteFile(filepath.Join(root, "memory/gocker/abc123/memory.oom_control"), []byte("oom_kill_disable 0\nunder_oom 0\noom_kill 2\n"), 0644)
+	if !(&cgroupV2{}).OOMKilled(v2) || !v1.OOMKilled(v1Path) {
+		t.Error("Expected OOM kill to be detected")
+	}
+}
+
+// TestCgroupSystemd checks scope paths, slice nesting, and the busctl call that creates a scope
+func TestCgroupSystemd(t *testing.T) {
+	restoreRuntimeSettings(t)
+	cgroupParent = filepath.Join(cgroupRoot, "gocker-ci")
+
+	if got := systemdSliceDir("gocker-ci.slice"); got != "gocker.slice/gocker-ci.slice" {
+		t.Errorf("systemdSliceDir = %q", got)
+	}
+	m := &cgroupSystemd{limits: make(map[string][]unitProperty)}
+	path := m.Path("abc123")
+	if path != "/sys/fs/cgroup/gocker.slice/gocker-ci.slice/gocker-abc123.scope" {
+		t.Errorf("Path = %q", path)
+	}
+
+	if err := m.SetLimits(path, "0.5", "256M", "192M"); err != nil {
+		t.Fatalf("SetLimits failed: %v", err)
+	}
+	got := strings.Join(transientScopeArgs("gocker-abc123.scope", systemdSlice(), 42, m.limits[path]), " ")
+	want := "call org.freedesktop.systemd1 /org/freedesktop/systemd1 org.freedesktop.systemd1.Manager StartTransientUnit ssa(sv)a(sa(sv)) " +
+		"gocker-abc123.scope fail 11 Description s gocker container abc123 Slice s gocker-ci.slice Delegate b true PIDs au 1 42 " +
+		"TasksAccounting b true TasksMax t 20 CPUAccounting b true CPUQuotaPerSecUSec t 500000 MemoryAccounting b true MemoryMax t 268435456 MemoryHigh t 201326592 0"
+	if got != want {
+		t.Errorf("transientScopeArgs =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestCgroupsUnavailable checks a host whose cgroups cannot be used only
+// stops the commands that need them
+func TestCgroupsUnavailable(t *testing.T) {
+	savedRoot, savedDriver := cgroupRoot, cgroupDriver
+	t.Cleanup(func() { cgroupRoot, cgroupDriver = savedRoot, savedDriver })
+	cgroupRoot, cgroupDriver = t.TempDir(), "systemd"
+
+	_, err := detectCgroupManager()
+	if err == nil {
+		t.Fatal("Expected the systemd driver to need cgroup v2")
+	}
+	manager := cgroupsUnavailable{err: err}
+	if got := manager.Create(manager.Path("abc123")); got != err {
+		t.Errorf("Create() = %v, want %v", got, err)
+	}
+	if _, got := manager.Stats("abc123"); got != err {
+		t.Errorf("Stats() = %v, want %v", got, err)
+	}
+
+	needs := make(map[string]bool)
+	for _, cmd := range commandTable() {
+		needs[cmd.name] = cmd.needsCgroups
+	}
+	for _, name := range []string{"run", "exec", "update", "stats"} {
+		if !needs[name] {
+			t.Errorf("Expected %s to need cgroups", name)
+		}
+	}
+	for _, name := range []string{"version", "help", "ps", "completion", "rm", "info"} {
+		if needs[name] {
+			t.Errorf("Expected %s to run without cgroups", name)
+		}
+	}
+}
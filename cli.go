@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// command describes a gocker subcommand
+type command struct {
+	name         string
+	description  string
+	hidden       bool
+	noState      bool // skip container state reconciliation before running
+	local        bool // run here even when the endpoint is a remote host
+	needsCgroups bool // fail up front when the host's cgroups cannot be used
+	run          func(args []string)
+}
+
+// requestsHelp reports whether a command's arguments ask for its help
+func requestsHelp(args []string) bool {
+	for _, arg := range args {
+		if arg == "-h" || arg == "--help" {
+			return true
+		}
+	}
+	return false
+}
+
+// commandFlags wraps flag.FlagSet with long/short flag aliases, Docker-style
+// help output, and optional parsing of flags after positional arguments
+type commandFlags struct {
+	fs          *flag.FlagSet
+	name        string
+	usage       string
+	description string
+	flags       []flagInfo
+
+	// interspersed allows flags to appear after positional arguments
+	// Commands that pass arguments through to a container (run) leave this off
+	interspersed bool
+}
+
+// flagInfo describes a registered flag for help output and completion
+type flagInfo struct {
+	long        string
+	short       string
+	placeholder string
+	usage       string
+	boolean     bool
+}
+
+// stringSliceValue is a repeatable string flag
+type stringSliceValue struct {
+	values *[]string
+}
+
+func (s *stringSliceValue) String() string {
+	if s.values == nil {
+		return ""
+	}
+	return strings.Join(*s.values, ",")
+}
+
+func (s *stringSliceValue) Set(value string) error {
+	*s.values = append(*s.values, value)
+	return nil
+}
+
+// newCommandFlags creates the flag set for a subcommand
+// usage is the argument synopsis shown after "gocker <name>"
+func newCommandFlags(name, usage, description string) *commandFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	c := &commandFlags{fs: fs, name: name, usage: usage, description: description}
+	c.flags = append(c.flags, flagInfo{long: "help", short: "h", usage: "Show help for this command", boolean: true})
+	return c
+}
+
+// StringVar registers a string flag with an optional short alias
+func (c *commandFlags) StringVar(p *string, long, short, placeholder, usage string) {
+	c.fs.StringVar(p, long, *p, usage)
+	if short != "" {
+		c.fs.StringVar(p, short, *p, usage)
+	}
+	c.flags = append(c.flags, flagInfo{long: long, short: short, placeholder: placeholder, usage: usage})
+}
+
+// BoolVar registers a boolean flag with an optional short alias
+func (c *commandFlags) BoolVar(p *bool, long, short, usage string) {
+	c.fs.BoolVar(p, long, *p, usage)
+	if short != "" {
+		c.fs.BoolVar(p, short, *p, usage)
+	}
+	c.flags = append(c.flags, flagInfo{long: long, short: short, usage: usage, boolean: true})
+}
+
+// StringSliceVar registers a repeatable string flag with an optional short alias
+func (c *commandFlags) StringSliceVar(p *[]string, long, short, placeholder, usage string) {
+	value := &stringSliceValue{values: p}
+	c.fs.Var(value, long, usage)
+	if short != "" {
+		c.fs.Var(value, short, usage)
+	}
+	c.flags = append(c.flags, flagInfo{long: long, short: short, placeholder: placeholder, usage: usage})
+}
+
+// FlagNames returns every registered flag spelling, e.g. "--volume" and "-v"
+func (c *commandFlags) FlagNames() []string {
+	var names []string
+	for _, f := range c.flags {
+		names = append(names, "--"+f.long)
+		if f.short != "" {
+			names = append(names, "-"+f.short)
+		}
+	}
+	return names
+}
+
+// Parse parses flags and returns the positional arguments
+// A "--" argument ends flag parsing; everything after it is positional
+func (c *commandFlags) Parse(args []string) ([]string, error) {
+	args = c.expandShortFlags(args)
+	var positional []string
+	for {
+		if err := c.fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := c.fs.Args()
+		consumed := args[:len(args)-len(rest)]
+		terminated := len(consumed) > 0 && consumed[len(consumed)-1] == "--"
+		if !c.interspersed || terminated || len(rest) == 0 {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// expandShortFlags splits combined boolean short flags, e.g. "-it" into "-i -t"
+// Only flag arguments are expanded, never a flag's value or anything after
+// "--" or (without interspersed) the first positional argument
+func (c *commandFlags) expandShortFlags(args []string) []string {
+	expanded := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || arg == "-" {
+			if arg == "--" || !c.interspersed {
+				return append(expanded, args[i:]...)
+			}
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if !strings.HasPrefix(arg, "--") && len(name) > 1 && !strings.Contains(name, "=") && c.allBoolShort(name) {
+			for _, r := range name {
+				expanded = append(expanded, "-"+string(r))
+			}
+			continue
+		}
+
+		expanded = append(expanded, arg)
+		// A value flag given as "-f value" consumes the next argument
+		if f := c.lookup(name); f != nil && !f.boolean && !strings.Contains(name, "=") && i+1 < len(args) {
+			i++
+			expanded = append(expanded, args[i])
+		}
+	}
+	return expanded
+}
+
+// allBoolShort reports whether every character of s is a boolean short flag
+func (c *commandFlags) allBoolShort(s string) bool {
+	for _, r := range s {
+		f := c.lookup(string(r))
+		if f == nil || !f.boolean || f.short != string(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup finds a registered flag by its long or short name
+func (c *commandFlags) lookup(name string) *flagInfo {
+	for i := range c.flags {
+		if c.flags[i].long == name || c.flags[i].short == name {
+			return &c.flags[i]
+		}
+	}
+	return nil
+}
+
+// MustParse parses flags, printing help and exiting on -h/--help or on errors
+func (c *commandFlags) MustParse(args []string) []string {
+	positional, err := c.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		c.PrintUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if err != nil {
+		c.Fail(normalizeFlagError(err))
+	}
+	return positional
+}
+
+// normalizeFlagError rewrites flag package errors to show long flags with
+// two dashes, matching how they are documented (e.g. "-cpu-limit" -> "--cpu-limit")
+func normalizeFlagError(err error) string {
+	message := err.Error()
+	idx := strings.LastIndex(message, ": -")
+	if idx == -1 {
+		return message
+	}
+	name := message[idx+3:]
+	if end := strings.IndexAny(name, " ="); end != -1 {
+		name = name[:end]
+	}
+	if len(name) > 1 && !strings.HasPrefix(name, "-") {
+		return message[:idx+2] + "-" + message[idx+2:]
+	}
+	return message
+}
+
+// Fail prints an error followed by the command usage and exits
+func (c *commandFlags) Fail(message string) {
+	fmt.Fprintf(os.Stderr, "Error: %s\n", message)
+	fmt.Fprintf(os.Stderr, "Usage: %s\n", c.synopsis())
+	fmt.Fprintf(os.Stderr, "Run 'gocker %s --help' for more information\n", c.name)
+	os.Exit(1)
+}
+
+// synopsis returns the one-line usage, e.g. "gocker stop <container-id>"
+func (c *commandFlags) synopsis() string {
+	return strings.TrimSpace("gocker " + c.name + " " + c.usage)
+}
+
+// PrintUsage prints the command synopsis, description, and options
+func (c *commandFlags) PrintUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s\n", c.synopsis())
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, c.description)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Options:")
+	c.PrintOptions(w)
+}
+
+// PrintOptions prints one aligned line per registered flag
+func (c *commandFlags) PrintOptions(w io.Writer) {
+	var names []string
+	width := 0
+	for _, f := range c.flags {
+		name := "    --" + f.long
+		if f.short != "" {
+			name = "-" + f.short + ", --" + f.long
+		}
+		if f.placeholder != "" {
+			name += " <" + f.placeholder + ">"
+		}
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	for i, f := range c.flags {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, names[i], f.usage)
+	}
+}
+
+// requireRoot exits unless the current process runs as root
+// Namespace, cgroup, and network operations all need root privileges
+func requireRoot() {
+	if os.Geteuid() != 0 {
+		fmt.Println("Error: This program must be run with sudo/root permissions")
+		os.Exit(1)
+	}
+}
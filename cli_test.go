@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+)
+
+// TestCommandFlagsInterspersed verifies flags may follow positional arguments
+func TestCommandFlagsInterspersed(t *testing.T) {
+	var force bool
+	flags := newCommandFlags("rm", "<container-id>", "Remove a container")
+	flags.interspersed = true
+	flags.BoolVar(&force, "force", "f", "Force removal")
+
+	args, err := flags.Parse([]string{"abc", "-f", "--", "-def"})
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if !force {
+		t.Errorf("Expected --force to be set")
+	}
+	if strings.Join(args, " ") != "abc -def" {
+		t.Errorf("Unexpected positional arguments: %v", args)
+	}
+}
+
+// TestCommandFlagsCombinedShort verifies combined boolean short flags are
+// split, while values and arguments after the flags are left alone
+func TestCommandFlagsCombinedShort(t *testing.T) {
+	tests := []struct {
+		args        []string
+		interactive bool
+		tty         bool
+		user        string
+		positional  string
+	}{
+		{[]string{"-it", "abc", "sh"}, true, true, "", "abc sh"},
+		{[]string{"-ti", "-u", "-it", "abc", "ls", "-it"}, true, true, "-it", "abc ls -it"},
+		{[]string{"-i", "abc", "-it"}, true, false, "", "abc -it"},
+		{[]string{"--", "-it"}, false, false, "", "-it"},
+	}
+
+	for _, tt := range tests {
+		var interactive, tty bool
+		var user string
+		flags := newCommandFlags("exec", "<container-id> <command>", "Run a command in a running container")
+		flags.BoolVar(&interactive, "interactive", "i", "Keep stdin open")
+		flags.BoolVar(&tty, "tty", "t", "Allocate a pseudo-terminal")
+		flags.StringVar(&user, "user", "u", "user", "Run as this user")
+
+		args, err := flags.Parse(tt.args)
+		if err != nil {
+			t.Errorf("Parse(%v): unexpected error: %v", tt.args, err)
+			continue
+		}
+		if interactive != tt.interactive || tty != tt.tty || user != tt.user {
+			t.Errorf("Parse(%v): got interactive=%v tty=%v user=%q", tt.args, interactive, tty, user)
+		}
+		if strings.Join(args, " ") != tt.positional {
+			t.Errorf("Parse(%v): unexpected positional arguments: %v", tt.args, args)
+		}
+	}
+
+	// A combination containing a value flag is left for the flag package to reject
+	var verbose bool
+	var name string
+	flags := newCommandFlags("test", "", "")
+	flags.BoolVar(&verbose, "verbose", "v", "")
+	flags.StringVar(&name, "name", "n", "name", "")
+	if _, err := flags.Parse([]string{"-vn", "x"}); err == nil {
+		t.Errorf("Expected error for -vn combining a value flag")
+	}
+}
+
+// TestCommandFlagsHelp verifies -h and --help are reported as help requests
+func TestCommandFlagsHelp(t *testing.T) {
+	for _, arg := range []string{"-h", "--help"} {
+		flags := newCommandFlags("ps", "", "List all containers")
+		if _, err := flags.Parse([]string{arg}); !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("Parse(%q): expected help error, got %v", arg, err)
+		}
+	}
+}
+
+// TestNormalizeFlagError verifies long flags are reported with two dashes
+func TestNormalizeFlagError(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"flag needs an argument: -cpu-limit", "flag needs an argument: --cpu-limit"},
+		{"flag provided but not defined: -x", "flag provided but not defined: -x"},
+		{"flag provided but not defined: --bogus", "flag provided but not defined: --bogus"},
+	}
+
+	for _, test := range tests {
+		if result := normalizeFlagError(errors.New(test.input)); result != test.expected {
+			t.Errorf("normalizeFlagError(%q): expected %q, got %q", test.input, test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+func cloneCommand(args []string) {
+	var name, copyRootfs string
+	configure := func(flags *commandFlags) {
+		flags.name = "clone"
+		flags.usage = "<container-id> [run options] [command] [args...]"
+		flags.description = "Run a new container with the configuration of an existing one"
+		flags.StringVar(&name, "name", "", "template", "Save the configuration as a template instead of running it")
+		flags.StringVar(&copyRootfs, "copy-rootfs", "", "dir", "Copy the container's root filesystem to dir and use the copy")
+	}
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		_, flags := parseRunFlags(args, configure)
+		flags.Fail("container ID required")
+	}
+
+	requireRoot()
+	state, err := loadContainerState(args[0])
+	must(err)
+	if state.Options == nil {
+		must(fmt.Errorf("container %s has no recorded run options", shortID(state.ID)))
+	}
+	opts, flags := parseCloneFlags(state, args[1:], configure)
+	if opts.ConfigFile != "" {
+		flags.Fail("--config cannot be used with clone")
+	}
+	if name != "" {
+		// Validate the name before copying anything
+		_, err := templatePath(name)
+		must(err)
+	}
+
+	if copyRootfs != "" {
+		fmt.Printf("Copying root filesystem of %s to %s...\n", shortID(state.ID), copyRootfs)
+		must(copyContainerRootfs(state.ID, copyRootfs))
+		opts.RootfsPath = copyRootfs
+	}
+
+	if name != "" {
+		must(saveTemplate(&ContainerTemplate{Name: name, CreatedAt: time.Now(), Options: opts}))
+		fmt.Printf("Template %s saved from container %s; start it with 'gocker template run %s'\n", name, shortID(state.ID), name)
+		return
+	}
+	runContainer(opts)
+}
+
+// parseCloneFlags parses run options on top of a container's recorded ones,
+// with the same rules as --config: values given replace the recorded ones,
+// repeatable options add to them, and a command replaces the recorded command
+func parseCloneFlags(state *ContainerState, args []string, configure func(*commandFlags)) (*RunOptions, *commandFlags) {
+	opts := *state.Options
+	// The recorded --rootfs may be relative to another working directory
+	if state.RootfsPath != "" {
+		opts.RootfsPath = state.RootfsPath
+	}
+	flags := newRunFlags(&opts)
+	configure(flags)
+	if command := flags.MustParse(args); len(command) > 0 {
+		opts.Command = command
+	}
+	return &opts, flags
+}
+
+// copyContainerRootfs copies a container's root filesystem into dst, which
+// must not exist; a running container is frozen during the copy, as for
+// snapshots, so the copy is consistent
+func copyContainerRootfs(containerID, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+
+	lock, err := lockContainer(containerID)
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
+	state, err := readContainerState(containerID)
+	if err != nil {
+		return err
+	}
+	if state.RootfsPath == "" {
+		return fmt.Errorf("container %s has no recorded rootfs", shortID(containerID))
+	}
+
+	if state.Status == statusRunning && isProcessAlive(state) {
+		if err := freezeCgroup(state.CgroupPath, true); err != nil {
+			return fmt.Errorf("failed to freeze container: %v", err)
+		}
+		defer freezeCgroup(state.CgroupPath, false)
+	}
+
+	if err := copyTree(state.RootfsPath, dst, "clone", state.ID); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return nil
+}
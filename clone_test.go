@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCloneFlags(t *testing.T) {
+	state := &ContainerState{
+		ID:         "abc123",
+		RootfsPath: "/srv/rootfs",
+		Options: &RunOptions{
+			MemoryLimit: "256M",
+			Env:         []string{"MODE=prod"},
+			RootfsPath:  "rootfs",
+			Command:     []string{"/bin/server"},
+		},
+	}
+
+	var name string
+	configure := func(flags *commandFlags) {
+		flags.StringVar(&name, "name", "", "template", "")
+	}
+	opts, _ := parseCloneFlags(state, []string{"--memory-limit", "1G", "-e", "DEBUG=1", "--name", "tuned"}, configure)
+	if opts.MemoryLimit != "1G" {
+		t.Errorf("MemoryLimit = %q, want 1G", opts.MemoryLimit)
+	}
+	if want := []string{"MODE=prod", "DEBUG=1"}; !reflect.DeepEqual(opts.Env, want) {
+		t.Errorf("Env = %v, want %v", opts.Env, want)
+	}
+	if opts.RootfsPath != "/srv/rootfs" {
+		t.Errorf("Expected the resolved rootfs, got %q", opts.RootfsPath)
+	}
+	if !reflect.DeepEqual(opts.Command, []string{"/bin/server"}) {
+		t.Errorf("Expected the recorded command, got %v", opts.Command)
+	}
+	if name != "tuned" {
+		t.Errorf("name = %q, want tuned", name)
+	}
+
+	opts, _ = parseCloneFlags(state, []string{"/bin/sh", "-c", "true"}, func(*commandFlags) {})
+	if !reflect.DeepEqual(opts.Command, []string{"/bin/sh", "-c", "true"}) {
+		t.Errorf("Expected the command to be replaced, got %v", opts.Command)
+	}
+}
+
+func TestCopyContainerRootfs(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	rootfs := filepath.Join(t.TempDir(), "rootfs")
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatalf("Failed to create rootfs: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "etc", "app.conf"), []byte("tuned\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := saveContainerState(&ContainerState{ID: "abc123", Status: statusStopped, RootfsPath: rootfs}); err != nil {
+		t.Fatalf("saveContainerState failed: %v", err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "clone")
+	if err := copyContainerRootfs("abc123", dst); err != nil {
+		t.Fatalf("copyContainerRootfs failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "etc", "app.conf")); err != nil || string(data) != "tuned\n" {
+		t.Errorf("Expected copied config, got %q (%v)", data, err)
+	}
+	if err := copyContainerRootfs("abc123", dst); err == nil {
+		t.Error("Expected error copying onto an existing directory")
+	}
+}
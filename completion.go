@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// completionCommand prints a completion script for the requested shell
+func completionCommand(args []string) {
+	flags := newCommandFlags("completion", "bash|zsh|fish", "Generate shell completion scripts")
+	args = flags.MustParse(args)
+	if len(args) != 1 {
+		flags.Fail("shell required")
+	}
+
+	script, err := completionScript(args[0], commandTable())
+	must(err)
+	fmt.Print(script)
+}
+
+// completionScript returns the completion script for a shell offering commands
+// Descriptions are quoted for the shell, so they may contain any character
+func completionScript(shell string, commands []*command) (string, error) {
+	var visible []*command
+	var names []string
+	for _, c := range commands {
+		if !c.hidden {
+			visible = append(visible, c)
+			names = append(names, c.name)
+		}
+	}
+	commandNames := strings.Join(names, " ")
+	runFlagNames := newRunFlags(&RunOptions{}).FlagNames()
+	runFlags := strings.Join(runFlagNames, " ")
+
+	var templateNames []string
+	for _, c := range templateCommands() {
+		templateNames = append(templateNames, c.name)
+	}
+	templateCommands := strings.Join(templateNames, " ")
+
+	var snapshotNames []string
+	for _, c := range snapshotCommands() {
+		snapshotNames = append(snapshotNames, c.name)
+	}
+	snapshotCommands := strings.Join(snapshotNames, " ")
+
+	var contextNames []string
+	for _, c := range contextCommands() {
+		contextNames = append(contextNames, c.name)
+	}
+	contextCommands := strings.Join(contextNames, " ")
+
+	switch shell {
+	case "bash":
+		return fmt.Sprintf(bashCompletion, commandNames, runFlags, snapshotCommands, templateCommands, contextCommands), nil
+	case "zsh":
+		var described []string
+		for _, c := range visible {
+			described = append(described, shellQuote(c.name+":"+c.description))
+		}
+		return fmt.Sprintf(zshCompletion, strings.Join(described, " "), runFlags, snapshotCommands, templateCommands, contextCommands), nil
+	case "fish":
+		var b strings.Builder
+		b.WriteString(fishCompletionHeader)
+		for _, c := range visible {
+			fmt.Fprintf(&b, "complete -c gocker -n '__fish_use_subcommand' -a %s -d %s\n", shellQuote(c.name), shellQuote(c.description))
+		}
+		for _, flag := range runFlagNames {
+			if strings.HasPrefix(flag, "--") {
+				fmt.Fprintf(&b, "complete -c gocker -n '__fish_seen_subcommand_from run' -l %s\n", strings.TrimPrefix(flag, "--"))
+			} else {
+				fmt.Fprintf(&b, "complete -c gocker -n '__fish_seen_subcommand_from run' -s %s\n", strings.TrimPrefix(flag, "-"))
+			}
+		}
+		fmt.Fprintf(&b, "complete -c gocker -n '__fish_seen_subcommand_from snapshot; and not __fish_seen_subcommand_from %s' -a '%s'\n", snapshotCommands, snapshotCommands)
+		fmt.Fprintf(&b, "complete -c gocker -n '__fish_seen_subcommand_from template; and not __fish_seen_subcommand_from %s' -a '%s'\n", templateCommands, templateCommands)
+		fmt.Fprintf(&b, "complete -c gocker -n '__fish_seen_subcommand_from context; and not __fish_seen_subcommand_from %s' -a '%s'\n", contextCommands, contextCommands)
+		b.WriteString(fishCompletionFooter)
+		return b.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported shell: %s (expected bash, zsh, or fish)", shell)
+	}
+}
+
+// completeCommand prints dynamic completion candidates, one per line
+// It is called by the generated completion scripts and must stay quiet on errors
+func completeCommand(args []string) {
+	if len(args) != 1 {
+		return
+	}
+
+	switch args[0] {
+	case "containers":
+		ids, err := listContainerIDs()
+		if err != nil {
+			return
+		}
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+	case "contexts":
+		contexts, err := loadAllContexts()
+		if err != nil {
+			return
+		}
+		for _, ctx := range contexts {
+			fmt.Println(ctx.Name)
+		}
+	case "templates":
+		templates, err := loadAllTemplates()
+		if err != nil {
+			return
+		}
+		for _, tmpl := range templates {
+			fmt.Println(tmpl.Name)
+		}
+	}
+}
+
+const bashCompletion = `# bash completion for gocker
+# Load with: source <(gocker completion bash)
+
+_gocker() {
+    local cur prev
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+
+    if [ "$COMP_CWORD" -eq 1 ]; then
+        COMPREPLY=( $(compgen -W "%s" -- "$cur") )
+        return
+    fi
+
+    case "${COMP_WORDS[1]}" in
+    run)
+        case "$prev" in
+        --rootfs|--volume|-v)
+            COMPREPLY=( $(compgen -f -- "$cur") )
+            return
+            ;;
+        --cpu-limit|--memory-limit)
+            return
+            ;;
+        esac
+        if [[ "$cur" == -* ]]; then
+            COMPREPLY=( $(compgen -W "%s" -- "$cur") )
+        else
+            COMPREPLY=( $(compgen -c -- "$cur") )
+        fi
+        ;;
+    stop|rm|update|pause|unpause|logs|stats|exec|inspect|clone)
+        COMPREPLY=( $(compgen -W "$("${COMP_WORDS[0]}" __complete containers 2>/dev/null)" -- "$cur") )
+        ;;
+    snapshot)
+        if [ "$COMP_CWORD" -eq 2 ]; then
+            COMPREPLY=( $(compgen -W "%s" -- "$cur") )
+        elif [ "$COMP_CWORD" -eq 3 ]; then
+            COMPREPLY=( $(compgen -W "$("${COMP_WORDS[0]}" __complete containers 2>/dev/null)" -- "$cur") )
+        fi
+        ;;
+    template)
+        if [ "$COMP_CWORD" -eq 2 ]; then
+            COMPREPLY=( $(compgen -W "%s" -- "$cur") )
+        elif [ "$COMP_CWORD" -eq 3 ]; then
+            case "${COMP_WORDS[2]}" in
+            run|rm)
+                COMPREPLY=( $(compgen -W "$("${COMP_WORDS[0]}" __complete templates 2>/dev/null)" -- "$cur") )
+                ;;
+            esac
+        fi
+        ;;
+    context)
+        if [ "$COMP_CWORD" -eq 2 ]; then
+            COMPREPLY=( $(compgen -W "%s" -- "$cur") )
+        elif [ "$COMP_CWORD" -eq 3 ]; then
+            case "${COMP_WORDS[2]}" in
+            use|rm)
+                COMPREPLY=( $(compgen -W "$("${COMP_WORDS[0]}" __complete contexts 2>/dev/null)" -- "$cur") )
+                ;;
+            esac
+        fi
+        ;;
+    completion)
+        COMPREPLY=( $(compgen -W "bash zsh fish" -- "$cur") )
+        ;;
+    esac
+}
+
+complete -F _gocker gocker
+`
+
+const zshCompletion = `#compdef gocker
+# zsh completion for gocker
+# Load with: source <(gocker completion zsh)
+
+_gocker() {
+    local -a commands
+    commands=(%s)
+
+    if (( CURRENT == 2 )); then
+        _describe 'command' commands
+        return
+    fi
+
+    case "${words[2]}" in
+    run)
+        if [[ "${words[CURRENT-1]}" == (--rootfs|--volume|-v) ]]; then
+            _files
+        elif [[ "${words[CURRENT]}" == -* ]]; then
+            compadd -- %s
+        else
+            _command_names
+        fi
+        ;;
+    stop|rm|update|pause|unpause|logs|stats|exec|inspect|clone)
+        compadd -- ${(f)"$(${words[1]} __complete containers 2>/dev/null)"}
+        ;;
+    snapshot)
+        if (( CURRENT == 3 )); then
+            compadd -- %s
+        elif (( CURRENT == 4 )); then
+            compadd -- ${(f)"$(${words[1]} __complete containers 2>/dev/null)"}
+        fi
+        ;;
+    template)
+        if (( CURRENT == 3 )); then
+            compadd -- %s
+        elif (( CURRENT == 4 )) && [[ "${words[3]}" == (run|rm) ]]; then
+            compadd -- ${(f)"$(${words[1]} __complete templates 2>/dev/null)"}
+        fi
+        ;;
+    context)
+        if (( CURRENT == 3 )); then
+            compadd -- %s
+        elif (( CURRENT == 4 )) && [[ "${words[3]}" == (use|rm) ]]; then
+            compadd -- ${(f)"$(${words[1]} __complete contexts 2>/dev/null)"}
+        fi
+        ;;
+    completion)
+        compadd -- bash zsh fish
+        ;;
+    esac
+}
+
+compdef _gocker gocker
+`
+
+const fishCompletionHeader = `# fish completion for gocker
+# Load with: gocker completion fish | source
+
+complete -c gocker -f
+`
+
+const fishCompletionFooter = `complete -c gocker -n '__fish_seen_subcommand_from stop rm update pause unpause logs stats exec inspect clone' -a '(gocker __complete containers 2>/dev/null)'
+complete -c gocker -n '__fish_seen_subcommand_from create ls restore rm; and __fish_seen_subcommand_from snapshot' -a '(gocker __complete containers 2>/dev/null)'
+complete -c gocker -n '__fish_seen_subcommand_from run rm; and __fish_seen_subcommand_from template' -a '(gocker __complete templates 2>/dev/null)'
+complete -c gocker -n '__fish_seen_subcommand_from use rm; and __fish_seen_subcommand_from context' -a '(gocker __complete contexts 2>/dev/null)'
+complete -c gocker -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'
+`
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCompletionScripts verifies completion scripts are generated for each supported shell
+func TestCompletionScripts(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		script, err := completionScript(shell, commandTable())
+		if err != nil {
+			t.Fatalf("completionScript(%q): unexpected error: %v", shell, err)
+		}
+		for _, c := range commandTable() {
+			if c.hidden {
+				continue
+			}
+			if !strings.Contains(script, c.name) {
+				t.Errorf("%s completion is missing command %q", shell, c.name)
+			}
+		}
+		if !strings.Contains(script, "__complete containers") {
+			t.Errorf("%s completion does not complete container IDs", shell)
+		}
+	}
+
+	if _, err := completionScript("powershell", commandTable()); err == nil {
+		t.Errorf("Expected error for unsupported shell, got nil")
+	}
+}
+
+// TestCompletionQuoting verifies descriptions with quotes leave the scripts parseable
+func TestCompletionQuoting(t *testing.T) {
+	commands := append(commandTable(), &command{name: "quoted", description: `Manage gocker's "odd" $settings`})
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		script, err := completionScript(shell, commands)
+		if err != nil {
+			t.Fatalf("completionScript(%q): unexpected error: %v", shell, err)
+		}
+		if quote := unclosedQuote(script); quote != 0 {
+			t.Errorf("%s completion leaves a %c quote open", shell, quote)
+		}
+		if shell != "bash" && !strings.Contains(script, `gocker'\''s`) {
+			t.Errorf("%s completion does not escape the quote in the description", shell)
+		}
+
+		// Let the shell itself check the syntax where it is installed
+		binary, err := exec.LookPath(shell)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(t.TempDir(), "gocker."+shell)
+		if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+			t.Fatalf("Failed to write script: %v", err)
+		}
+		if output, err := exec.Command(binary, "-n", path).CombinedOutput(); err != nil {
+			t.Errorf("%s -n rejected the completion script: %v: %s", shell, err, output)
+		}
+	}
+}
+
+// unclosedQuote returns the quote character left open at the end of a shell
+// script, or 0 when every quote is closed
+func unclosedQuote(script string) rune {
+	var quote rune
+	escaped := false
+	for _, c := range script {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			escaped = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		}
+	}
+	return quote
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultConfigFile = "/etc/gocker/daemon.json"
+	defaultCgroupRoot = "/sys/fs/cgroup"
+)
+
+// cgroupRoot is the cgroup mount gocker's cgroups live under; see
+// detectCgroupManager
+var cgroupRoot = defaultCgroupRoot
+
+// Runtime defaults that are not paths or network settings
+// These may be overridden by the config file and environment
+var (
+	defaultRootfs      = ""
+	defaultCPULimit    = ""
+	defaultMemoryLimit = ""
+	pidsLimit          = 20
+	logDriver          = "file"
+	cgroupDriver       = "cgroupfs"
+	proxyEnv           []string // proxy variables every container starts with (see ProxyConfig)
+)
+
+// Config holds settings loaded from /etc/gocker/daemon.json
+// Every field is optional; unset fields keep the built-in defaults
+type Config struct {
+	DataRoot             string          `json:"data_root,omitempty"`
+	BridgeName           string          `json:"bridge_name,omitempty"`
+	BridgeSubnet         string          `json:"bridge_subnet,omitempty"`
+	DefaultRootfs        string          `json:"default_rootfs,omitempty"`
+	LogDriver            string          `json:"log_driver,omitempty"`
+	DefaultCPULimit      string          `json:"default_cpu_limit,omitempty"`
+	DefaultMemoryLimit   string          `json:"default_memory_limit,omitempty"`
+	DefaultPidsLimit     int             `json:"default_pids_limit,omitempty"`
+	CgroupParent         string          `json:"cgroup_parent,omitempty"`
+	CgroupDriver         string          `json:"cgroup_driver,omitempty"`
+	Debug                bool            `json:"debug,omitempty"`
+	LogFormat            string          `json:"log_format,omitempty"`
+	Webhooks             []WebhookConfig `json:"webhooks,omitempty"`
+	Proxies              *ProxyConfig    `json:"proxies,omitempty"`
+	UsernsRemap          string          `json:"userns_remap,omitempty"`
+	AllowedVolumeSources []string        `json:"allowed_volume_sources,omitempty"`
+	PolicyFile           string          `json:"policy_file,omitempty"`
+	WasmRuntime          string          `json:"wasm_runtime,omitempty"`
+	DebugToolbox         string          `json:"debug_toolbox,omitempty"`
+	Progress             string          `json:"progress,omitempty"`
+
+	// Set only by global flags
+	Quiet   bool   `json:"-"`
+	Context string `json:"-"`
+}
+
+// ProxyConfig is the proxy setup of hosts behind a proxy
+// Containers get each value as both the upper and lower case variable, as
+// tools disagree on which they read; -e still overrides them
+type ProxyConfig struct {
+	HTTPProxy  string `json:"http_proxy,omitempty"`
+	HTTPSProxy string `json:"https_proxy,omitempty"`
+	NoProxy    string `json:"no_proxy,omitempty"`
+}
+
+// configEnvOverrides maps environment variables to the config fields they override
+var configEnvOverrides = []struct {
+	env   string
+	field func(cfg *Config) *string
+}{
+	{"GOCKER_DATA_ROOT", func(cfg *Config) *string { return &cfg.DataRoot }},
+	{"GOCKER_BRIDGE_NAME", func(cfg *Config) *string { return &cfg.BridgeName }},
+	{"GOCKER_BRIDGE_SUBNET", func(cfg *Config) *string { return &cfg.BridgeSubnet }},
+	{"GOCKER_DEFAULT_ROOTFS", func(cfg *Config) *string { return &cfg.DefaultRootfs }},
+	{"GOCKER_LOG_DRIVER", func(cfg *Config) *string { return &cfg.LogDriver }},
+	{"GOCKER_DEFAULT_CPU_LIMIT", func(cfg *Config) *string { return &cfg.DefaultCPULimit }},
+	{"GOCKER_DEFAULT_MEMORY_LIMIT", func(cfg *Config) *string { return &cfg.DefaultMemoryLimit }},
+	{"GOCKER_CGROUP_PARENT", func(cfg *Config) *string { return &cfg.CgroupParent }},
+	{"GOCKER_CGROUP_DRIVER", func(cfg *Config) *string { return &cfg.CgroupDriver }},
+	{"GOCKER_LOG_FORMAT", func(cfg *Config) *string { return &cfg.LogFormat }},
+	{"GOCKER_USERNS_REMAP", func(cfg *Config) *string { return &cfg.UsernsRemap }},
+	{"GOCKER_POLICY_FILE", func(cfg *Config) *string { return &cfg.PolicyFile }},
+	{"GOCKER_WASM_RUNTIME", func(cfg *Config) *string { return &cfg.WasmRuntime }},
+	{"GOCKER_DEBUG_TOOLBOX", func(cfg *Config) *string { return &cfg.DebugToolbox }},
+	{"GOCKER_PROGRESS", func(cfg *Config) *string { return &cfg.Progress }},
+}
+
+// loadConfig reads the config file, applies the active context's settings,
+// environment overrides and then command-line overrides (global flags), and
+// updates the runtime settings
+// The file is optional unless GOCKER_CONFIG points at it explicitly
+func loadConfig(flags *Config) error {
+	path := os.Getenv("GOCKER_CONFIG")
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+
+	cfg, err := readConfigFile(path)
+	if err != nil && (explicit || !os.IsNotExist(err)) {
+		return err
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	// Settings of the active context override the config file
+	contextName := ""
+	if flags != nil {
+		contextName = flags.Context
+	}
+	ctx, err := activeContext(contextName)
+	if err != nil {
+		return err
+	}
+	activeContextName = ctx.Name
+	if ctx.Settings != nil {
+		if err := mergeConfig(cfg, ctx.Settings); err != nil {
+			return err
+		}
+	}
+
+	for _, override := range configEnvOverrides {
+		if value := os.Getenv(override.env); value != "" {
+			*override.field(cfg) = value
+		}
+	}
+	if value := os.Getenv("GOCKER_DEFAULT_PIDS_LIMIT"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid GOCKER_DEFAULT_PIDS_LIMIT: %v", err)
+		}
+		cfg.DefaultPidsLimit = limit
+	}
+
+	if flags != nil {
+		if flags.DataRoot != "" {
+			root, err := filepath.Abs(flags.DataRoot)
+			if err != nil {
+				return fmt.Errorf("invalid --data-root: %v", err)
+			}
+			cfg.DataRoot = root
+		}
+		if flags.CgroupParent != "" {
+			cfg.CgroupParent = flags.CgroupParent
+		}
+		if flags.CgroupDriver != "" {
+			cfg.CgroupDriver = flags.CgroupDriver
+		}
+		if flags.LogFormat != "" {
+			cfg.LogFormat = flags.LogFormat
+		}
+		if flags.Progress != "" {
+			cfg.Progress = flags.Progress
+		}
+		cfg.Debug = cfg.Debug || flags.Debug
+		cfg.Quiet = flags.Quiet
+	}
+
+	return applyConfig(cfg)
+}
+
+// mergeConfig overwrites dst with every field that is set in src
+func mergeConfig(dst, src *Config) error {
+	// Unset fields are omitted from the JSON, so decoding only touches set ones
+	data, err := json.Marshal(src)
+	if err != nil {
+		return fmt.Errorf("failed to merge config: %v", err)
+	}
+	return json.Unmarshal(data, dst)
+}
+
+// readConfigFile parses a config file, rejecting unknown keys to catch typos
+func readConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	var cfg Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return &cfg, nil
+}
+
+// applyConfig validates a config and replaces the runtime settings with its values
+func applyConfig(cfg *Config) error {
+	if cfg.DataRoot != "" {
+		if !filepath.IsAbs(cfg.DataRoot) {
+			return fmt.Errorf("data_root must be an absolute path: %s", cfg.DataRoot)
+		}
+		setDataRoot(filepath.Clean(cfg.DataRoot))
+	}
+
+	if cfg.BridgeName != "" {
+		// veth and bridge names share the 15 character interface name limit
+		if len(cfg.BridgeName) > 15 {
+			return fmt.Errorf("bridge_name must be at most 15 characters: %s", cfg.BridgeName)
+		}
+		bridgeName = cfg.BridgeName
+	}
+
+	if cfg.BridgeSubnet != "" {
+		if err := setBridgeSubnet(cfg.BridgeSubnet); err != nil {
+			return err
+		}
+	}
+
+	if cfg.DefaultRootfs != "" {
+		defaultRootfs = cfg.DefaultRootfs
+	}
+
+	if cfg.LogDriver != "" {
+		if cfg.LogDriver != "file" && cfg.LogDriver != "none" {
+			return fmt.Errorf("unsupported log_driver: %s (expected 'file' or 'none')", cfg.LogDriver)
+		}
+		logDriver = cfg.LogDriver
+	}
+
+	if cfg.DefaultCPULimit != "" {
+		if _, err := parseCPULimit(cfg.DefaultCPULimit); err != nil {
+			return fmt.Errorf("invalid default_cpu_limit: %v", err)
+		}
+		defaultCPULimit = cfg.DefaultCPULimit
+	}
+
+	if cfg.DefaultMemoryLimit != "" {
+		if _, err := parseMemoryLimit(cfg.DefaultMemoryLimit); err != nil {
+			return fmt.Errorf("invalid default_memory_limit: %v", err)
+		}
+		defaultMemoryLimit = cfg.DefaultMemoryLimit
+	}
+
+	if cfg.DefaultPidsLimit < 0 {
+		return fmt.Errorf("default_pids_limit must be positive")
+	}
+	if cfg.DefaultPidsLimit > 0 {
+		pidsLimit = cfg.DefaultPidsLimit
+	}
+
+	if cfg.CgroupParent != "" {
+		parent := filepath.Clean(strings.TrimPrefix(cfg.CgroupParent, "/"))
+		if parent == "." || strings.HasPrefix(parent, "..") {
+			return fmt.Errorf("invalid cgroup_parent: %s", cfg.CgroupParent)
+		}
+		cgroupParent = filepath.Join(cgroupRoot, parent)
+	}
+
+	if cfg.CgroupDriver != "" {
+		if cfg.CgroupDriver != "cgroupfs" && cfg.CgroupDriver != "systemd" {
+			return fmt.Errorf("unsupported cgroup_driver: %s (expected 'cgroupfs' or 'systemd')", cfg.CgroupDriver)
+		}
+		cgroupDriver = cfg.CgroupDriver
+	}
+
+	if cfg.LogFormat != "" {
+		if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+			return fmt.Errorf("unsupported log_format: %s (expected 'text' or 'json')", cfg.LogFormat)
+		}
+		logFormat = cfg.LogFormat
+	}
+	setLogLevel(cfg.Debug, cfg.Quiet)
+
+	if cfg.Progress != "" {
+		if err := validateProgressMode(cfg.Progress); err != nil {
+			return err
+		}
+		progressMode = cfg.Progress
+	}
+
+	for _, hook := range cfg.Webhooks {
+		if err := validateWebhook(hook); err != nil {
+			return err
+		}
+	}
+	if len(cfg.Webhooks) > 0 {
+		webhooks = cfg.Webhooks
+	}
+
+	if cfg.UsernsRemap != "" {
+		usernsRemap = cfg.UsernsRemap
+	}
+
+	for _, path := range cfg.AllowedVolumeSources {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("allowed_volume_sources must be absolute paths: %s", path)
+		}
+	}
+	if len(cfg.AllowedVolumeSources) > 0 {
+		allowedVolumeSources = cfg.AllowedVolumeSources
+	}
+	if cfg.PolicyFile != "" {
+		if !filepath.IsAbs(cfg.PolicyFile) {
+			return fmt.Errorf("policy_file must be an absolute path: %s", cfg.PolicyFile)
+		}
+		policyFile = cfg.PolicyFile
+	}
+	if cfg.WasmRuntime != "" {
+		wasmRuntime = cfg.WasmRuntime
+	}
+	if cfg.DebugToolbox != "" {
+		if !filepath.IsAbs(cfg.DebugToolbox) {
+			return fmt.Errorf("debug_toolbox must be an absolute path: %s", cfg.DebugToolbox)
+		}
+		debugToolbox = cfg.DebugToolbox
+	}
+
+	if cfg.Proxies != nil {
+		env, err := cfg.Proxies.env()
+		if err != nil {
+			return err
+		}
+		proxyEnv = env
+	}
+
+	return nil
+}
+
+// setDataRoot points every state path at a new data root
+func setDataRoot(root string) {
+	stateDir = root
+	containersDir = filepath.Join(root, "containers")
+	ipamFile = filepath.Join(root, "ipam.json")
+	portsFile = filepath.Join(root, "ports.json")
+	historyFile = filepath.Join(root, "history.json")
+	templatesDir = filepath.Join(root, "templates")
+	pluginsDir = filepath.Join(root, "plugins")
+	volumeMountsFile = filepath.Join(root, "volume-mounts.json")
+	usageFile = filepath.Join(root, "usage.json")
+	schedulesFile = filepath.Join(root, "schedules.json")
+	jobsFile = filepath.Join(root, "jobs.json")
+}
+
+// setBridgeSubnet configures the bridge and container network from an IPv4 CIDR
+// The bridge takes the first host address; containers are allocated from the rest
+func setBridgeSubnet(subnet string) error {
+	ip, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("invalid bridge_subnet: %v", err)
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("bridge_subnet must be an IPv4 network: %s", subnet)
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits-ones < 2 {
+		return fmt.Errorf("bridge_subnet is too small: %s", subnet)
+	}
+
+	containerNet = ipNet.String()
+	gateway, err := subnetIP(1)
+	if err != nil {
+		return err
+	}
+	bridgeIP = gateway
+	bridgeCIDR = fmt.Sprintf("%s/%d", gateway, ones)
+	return nil
+}
+
+// InstanceInfo records the settings a data root was first used with
+// Two gocker instances must not share a data root with different cgroup
+// parents or networks, or their IPAM and state would conflict
+type InstanceInfo struct {
+	CgroupParent string `json:"cgroup_parent"`
+	BridgeName   string `json:"bridge_name"`
+	BridgeSubnet string `json:"bridge_subnet"`
+}
+
+// dataRootClaimed is set once this process has verified its data root
+var dataRootClaimed bool
+
+// claimDataRoot binds the data root to the current instance settings
+// It fails if the root is in use by an instance with different settings;
+// an unused root (no containers) is rebound to the new settings
+func claimDataRoot() error {
+	if dataRootClaimed {
+		return nil
+	}
+
+	lock, err := os.OpenFile(filepath.Join(stateDir, "gocker.lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open data root lock: %v", err)
+	}
+	defer lock.Close()
+
+	if err := lockFile(lock); err != nil {
+		return fmt.Errorf("failed to lock data root: %v", err)
+	}
+	defer unlockFile(lock)
+
+	current := InstanceInfo{CgroupParent: cgroupParent, BridgeName: bridgeName, BridgeSubnet: containerNet}
+	instanceFile := filepath.Join(stateDir, "instance.json")
+
+	data, err := os.ReadFile(instanceFile)
+	if err == nil {
+		var existing InstanceInfo
+		if err := json.Unmarshal(data, &existing); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", instanceFile, err)
+		}
+		if existing == current {
+			dataRootClaimed = true
+			return nil
+		}
+		if files, _ := os.ReadDir(containersDir); len(files) > 0 {
+			return fmt.Errorf("data root %s is in use by another instance (cgroup parent %s, bridge %s %s); use a different --data-root",
+				stateDir, existing.CgroupParent, existing.BridgeName, existing.BridgeSubnet)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %v", instanceFile, err)
+	}
+
+	data, err = json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal instance info: %v", err)
+	}
+	if err := os.WriteFile(instanceFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", instanceFile, err)
+	}
+
+	dataRootClaimed = true
+	return nil
+}
+
+// env validates the proxy URLs and returns the container environment entries
+func (p *ProxyConfig) env() ([]string, error) {
+	var env []string
+	for _, proxy := range []struct{ name, value string }{
+		{"http_proxy", p.HTTPProxy},
+		{"https_proxy", p.HTTPSProxy},
+		{"no_proxy", p.NoProxy},
+	} {
+		if proxy.value == "" {
+			continue
+		}
+		if proxy.name != "no_proxy" {
+			if u, err := url.Parse(proxy.value); err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("invalid proxies.%s: %s (expected a URL such as http://proxy:3128)", proxy.name, proxy.value)
+			}
+		}
+		env = append(env, strings.ToUpper(proxy.name)+"="+proxy.value, proxy.name+"="+proxy.value)
+	}
+	return env, nil
+}
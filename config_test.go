@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// restoreRuntimeSettings saves the configurable globals and restores them when the test ends
+func restoreRuntimeSettings(t *testing.T) {
+	saved := []string{stateDir, containersDir, ipamFile, templatesDir, bridgeName, bridgeIP, bridgeCIDR, containerNet, cgroupParent, defaultRootfs, defaultCPULimit, defaultMemoryLimit, logDriver, cgroupDriver}
+	savedPids := pidsLimit
+	savedClaimed := dataRootClaimed
+	savedLevel, savedFormat := logLevel, logFormat
+	savedContexts, savedContext := contextsDir, activeContextName
+	savedWebhooks, savedProxyEnv := webhooks, proxyEnv
+	savedPortsFile, savedHistoryFile := portsFile, historyFile
+	savedPluginsDir, savedVolumeMountsFile, savedUsageFile, savedSchedulesFile, savedJobsFile := pluginsDir, volumeMountsFile, usageFile, schedulesFile, jobsFile
+	savedUsernsRemap, savedVolumeSources, savedPolicyFile, savedWasmRuntime := usernsRemap, allowedVolumeSources, policyFile, wasmRuntime
+	savedDebugToolbox, savedProgress := debugToolbox, progressMode
+	contextsDir = filepath.Join(t.TempDir(), "contexts")
+	t.Cleanup(func() {
+		contextsDir, activeContextName = savedContexts, savedContext
+		webhooks, proxyEnv = savedWebhooks, savedProxyEnv
+		portsFile, historyFile = savedPortsFile, savedHistoryFile
+		pluginsDir, volumeMountsFile, usageFile, schedulesFile, jobsFile = savedPluginsDir, savedVolumeMountsFile, savedUsageFile, savedSchedulesFile, savedJobsFile
+		usernsRemap, allowedVolumeSources, policyFile, wasmRuntime = savedUsernsRemap, savedVolumeSources, savedPolicyFile, savedWasmRuntime
+		debugToolbox, progressMode = savedDebugToolbox, savedProgress
+		logLevel, logFormat = savedLevel, savedFormat
+		dataRootClaimed = savedClaimed
+		stateDir, containersDir, ipamFile, templatesDir = saved[0], saved[1], saved[2], saved[3]
+		bridgeName, bridgeIP, bridgeCIDR, containerNet = saved[4], saved[5], saved[6], saved[7]
+		cgroupParent, defaultRootfs, defaultCPULimit, defaultMemoryLimit, logDriver = saved[8], saved[9], saved[10], saved[11], saved[12]
+		cgroupDriver = saved[13]
+		pidsLimit = savedPids
+	})
+}
+
+// TestLoadConfig verifies config file values and environment overrides are applied
+func TestLoadConfig(t *testing.T) {
+	restoreRuntimeSettings(t)
+
+	configPath := filepath.Join(t.TempDir(), "daemon.json")
+	config := `{
+  "data_root": "/tmp/gocker-test",
+  "bridge_subnet": "172.28.5.0/24",
+  "default_memory_limit": "256M",
+  "default_pids_limit": 64,
+  "cgroup_parent": "gocker-test",
+  "log_driver": "none"
+}`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	t.Setenv("GOCKER_CONFIG", configPath)
+	t.Setenv("GOCKER_DEFAULT_MEMORY_LIMIT", "1G")
+
+	if err := loadConfig(nil); err != nil {
+		t.Fatalf("loadConfig: unexpected error: %v", err)
+	}
+
+	if containersDir != "/tmp/gocker-test/containers" {
+		t.Errorf("Expected containers dir under data root, got %s", containersDir)
+	}
+	if bridgeIP != "172.28.5.1" || bridgeCIDR != "172.28.5.1/24" {
+		t.Errorf("Unexpected bridge address: %s (%s)", bridgeIP, bridgeCIDR)
+	}
+	if defaultMemoryLimit != "1G" {
+		t.Errorf("Expected environment override 1G, got %s", defaultMemoryLimit)
+	}
+	if pidsLimit != 64 {
+		t.Errorf("Expected pids limit 64, got %d", pidsLimit)
+	}
+	if cgroupParent != "/sys/fs/cgroup/gocker-test" {
+		t.Errorf("Unexpected cgroup parent: %s", cgroupParent)
+	}
+	if logDriver != "none" {
+		t.Errorf("Expected log driver none, got %s", logDriver)
+	}
+}
+
+// TestLoadConfigErrors verifies invalid configuration is rejected
+func TestLoadConfigErrors(t *testing.T) {
+	restoreRuntimeSettings(t)
+
+	tests := []string{
+		`{"unknown_key": true}`,
+		`{"bridge_subnet": "not-a-subnet"}`,
+		`{"bridge_subnet": "10.0.0.0/31"}`,
+		`{"data_root": "relative/path"}`,
+		`{"log_driver": "syslog"}`,
+		`{"default_cpu_limit": "-1"}`,
+		`{"cgroup_parent": "../escape"}`,
+		`{"cgroup_driver": "docker"}`,
+		`{"log_format": "xml"}`,
+		`{"progress": "fancy"}`,
+		`{"webhooks": [{"url": "ftp://example.com/hook"}]}`,
+		`{"webhooks": [{"url": "https://example.com/hook", "events": ["explode"]}]}`,
+		`{"proxies": {"http_proxy": "proxy.corp:3128"}}`,
+	}
+
+	for _, config := range tests {
+		configPath := filepath.Join(t.TempDir(), "daemon.json")
+		if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		t.Setenv("GOCKER_CONFIG", configPath)
+		if err := loadConfig(nil); err == nil {
+			t.Errorf("loadConfig(%s): expected error, got nil", config)
+		}
+	}
+
+	t.Setenv("GOCKER_CONFIG", filepath.Join(t.TempDir(), "missing.json"))
+	if err := loadConfig(nil); err == nil {
+		t.Errorf("Expected error for explicitly configured missing file, got nil")
+	}
+}
+
+// TestSubnetIP verifies address calculation within the container network
+func TestSubnetIP(t *testing.T) {
+	restoreRuntimeSettings(t)
+
+	if err := setBridgeSubnet("192.168.100.0/23"); err != nil {
+		t.Fatalf("setBridgeSubnet: unexpected error: %v", err)
+	}
+	if subnetSize() != 512 {
+		t.Errorf("Expected subnet size 512, got %d", subnetSize())
+	}
+
+	ip, err := subnetIP(300)
+	if err != nil {
+		t.Fatalf("subnetIP: unexpected error: %v", err)
+	}
+	if ip != "192.168.101.44" {
+		t.Errorf("Expected 192.168.101.44, got %s", ip)
+	}
+
+	if _, err := subnetIP(512); err == nil {
+		t.Errorf("Expected error for offset outside subnet, got nil")
+	}
+}
+
+// TestClaimDataRoot verifies a data root cannot be shared by instances with different settings
+func TestClaimDataRoot(t *testing.T) {
+	restoreRuntimeSettings(t)
+
+	setDataRoot(t.TempDir())
+	dataRootClaimed = false
+	if err := ensureStateDir(); err != nil {
+		t.Fatalf("ensureStateDir: unexpected error: %v", err)
+	}
+
+	// Same settings claim the root again
+	dataRootClaimed = false
+	if err := claimDataRoot(); err != nil {
+		t.Errorf("claimDataRoot with same settings: unexpected error: %v", err)
+	}
+
+	// A different cgroup parent is rejected while containers exist
+	if err := os.WriteFile(filepath.Join(containersDir, "abc.json"), []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to write container state: %v", err)
+	}
+	cgroupParent = "/sys/fs/cgroup/other"
+	dataRootClaimed = false
+	if err := claimDataRoot(); err == nil {
+		t.Errorf("Expected error claiming a data root in use by another instance, got nil")
+	}
+
+	// An unused root is rebound to the new settings
+	if err := os.Remove(filepath.Join(containersDir, "abc.json")); err != nil {
+		t.Fatalf("Failed to remove container state: %v", err)
+	}
+	if err := claimDataRoot(); err != nil {
+		t.Errorf("claimDataRoot on unused root: unexpected error: %v", err)
+	}
+}
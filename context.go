@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// contextsDir holds one JSON file per context plus the current-context marker
+var contextsDir = "/etc/gocker/contexts"
+
+// defaultContextName is the built-in context that applies no settings
+const defaultContextName = "default"
+
+// activeContextName is the context this invocation resolved to (see loadConfig)
+var activeContextName = defaultContextName
+
+// localEndpoint is the gocker runtime on this host; the other endpoint kind
+// is ssh://, a remote host's gocker run over ssh (see remote.go)
+const localEndpoint = "local"
+
+// Context is a named target for gocker commands with its own default settings
+// Settings use the same keys as daemon.json and override it when the context is active
+type Context struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Endpoint    string    `json:"endpoint"`
+	CreatedAt   time.Time `json:"created_at"`
+	Settings    *Config   `json:"settings,omitempty"`
+}
+
+// contextCommands lists the 'gocker context' subcommands
+func contextCommands() []*command {
+	return []*command{
+		{name: "create", description: "Create a context", run: contextCreateCommand},
+		{name: "use", description: "Set the current context", run: contextUseCommand},
+		{name: "ls", description: "List contexts", run: contextListCommand},
+		{name: "rm", description: "Remove a context", run: contextRemoveCommand},
+	}
+}
+
+// contextCommand dispatches the 'gocker context' subcommands
+func contextCommand(args []string) {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		printContextUsage()
+		if len(args) == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, cmd := range contextCommands() {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	fmt.Printf("Unknown context command: %s\n", args[0])
+	printContextUsage()
+	os.Exit(1)
+}
+
+func printContextUsage() {
+	fmt.Println("Usage: gocker context <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range contextCommands() {
+		fmt.Printf("  %-7s %s\n", cmd.name, cmd.description)
+	}
+}
+
+func contextCreateCommand(args []string) {
+	ctx := &Context{Endpoint: localEndpoint, Settings: &Config{}}
+	flags := newCommandFlags("context create", "<name> [options]", "Create a context")
+	flags.interspersed = true
+	flags.StringVar(&ctx.Description, "description", "", "text", "Description of the context")
+	flags.StringVar(&ctx.Endpoint, "endpoint", "", "endpoint", "Endpoint the context targets: local or ssh://[user@]host[:port][/path/to/gocker] (default: local)")
+	flags.StringVar(&ctx.Settings.DataRoot, "data-root", "", "path", "Directory for container state")
+	flags.StringVar(&ctx.Settings.CgroupParent, "cgroup-parent", "", "name", "Parent cgroup for containers")
+	flags.StringVar(&ctx.Settings.BridgeName, "bridge-name", "", "name", "Name of the host bridge")
+	flags.StringVar(&ctx.Settings.BridgeSubnet, "bridge-subnet", "", "cidr", "IPv4 subnet for containers")
+	flags.StringVar(&ctx.Settings.DefaultRootfs, "default-rootfs", "", "path", "Rootfs used when --rootfs is not given")
+	names := flags.MustParse(args)
+	if len(names) != 1 {
+		flags.Fail("context name required")
+	}
+	ctx.Name = names[0]
+	ctx.CreatedAt = time.Now()
+
+	if ctx.Settings.DataRoot != "" {
+		root, err := filepath.Abs(ctx.Settings.DataRoot)
+		must(err)
+		ctx.Settings.DataRoot = root
+	}
+
+	// Validate the settings the same way daemon.json is validated; the runtime
+	// settings this changes are discarded when the command exits
+	must(applyConfig(ctx.Settings))
+
+	requireRoot()
+	must(saveContext(ctx))
+	fmt.Printf("Context %s created\n", ctx.Name)
+}
+
+func contextUseCommand(args []string) {
+	flags := newCommandFlags("context use", "<name>", "Set the current context")
+	flags.interspersed = true
+	names := flags.MustParse(args)
+	if len(names) != 1 {
+		flags.Fail("context name required")
+	}
+
+	requireRoot()
+	must(setCurrentContext(names[0]))
+	fmt.Printf("Current context is now %s\n", names[0])
+}
+
+func contextListCommand(args []string) {
+	flags := newCommandFlags("context ls", "", "List contexts")
+	if len(flags.MustParse(args)) != 0 {
+		flags.Fail("context ls does not accept arguments")
+	}
+	listContexts()
+}
+
+func contextRemoveCommand(args []string) {
+	flags := newCommandFlags("context rm", "<name>", "Remove a context")
+	flags.interspersed = true
+	names := flags.MustParse(args)
+	if len(names) != 1 {
+		flags.Fail("context name required")
+	}
+
+	requireRoot()
+	must(removeContext(names[0]))
+	fmt.Printf("Context %s removed\n", names[0])
+}
+
+// contextPath returns the file path for a context, validating its name
+func contextPath(name string) (string, error) {
+	if !templateNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid context name: %s (use letters, digits, '_', '.', '-')", name)
+	}
+	return filepath.Join(contextsDir, name+".json"), nil
+}
+
+// validateContext checks the endpoint and settings of a context
+func validateContext(ctx *Context) error {
+	if ctx.Name == defaultContextName {
+		return fmt.Errorf("context name %s is reserved", defaultContextName)
+	}
+	if ctx.Endpoint != localEndpoint {
+		if _, err := parseRemoteHost(ctx.Endpoint); err != nil {
+			return err
+		}
+		// Settings would apply to this host, not the remote one
+		if ctx.Settings != nil {
+			if data, _ := json.Marshal(ctx.Settings); string(data) != "{}" {
+				return fmt.Errorf("settings cannot be used with remote endpoint %s; set them in that host's daemon.json", ctx.Endpoint)
+			}
+		}
+	}
+	if ctx.Settings != nil && ctx.Settings.DataRoot != "" && !filepath.IsAbs(ctx.Settings.DataRoot) {
+		return fmt.Errorf("data root must be an absolute path: %s", ctx.Settings.DataRoot)
+	}
+	return nil
+}
+
+// saveContext writes a new context to disk
+func saveContext(ctx *Context) error {
+	path, err := contextPath(ctx.Name)
+	if err != nil {
+		return err
+	}
+	if err := validateContext(ctx); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("context already exists: %s", ctx.Name)
+	}
+
+	if err := os.MkdirAll(contextsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create contexts directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(ctx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal context: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write context: %v", err)
+	}
+	return nil
+}
+
+// loadContext reads a context from disk by name
+// The default context always exists and has no settings
+func loadContext(name string) (*Context, error) {
+	if name == defaultContextName {
+		return &Context{Name: defaultContextName, Endpoint: localEndpoint, Description: "Settings from daemon.json"}, nil
+	}
+
+	path, err := contextPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("context not found: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read context: %v", err)
+	}
+
+	var ctx Context
+	if err := json.Unmarshal(data, &ctx); err != nil {
+		return nil, fmt.Errorf("failed to parse context %s: %v", name, err)
+	}
+	if err := validateContext(&ctx); err != nil {
+		return nil, fmt.Errorf("context %s: %v", name, err)
+	}
+	return &ctx, nil
+}
+
+// removeContext deletes a context, switching back to the default if it was current
+func removeContext(name string) error {
+	if name == defaultContextName {
+		return fmt.Errorf("cannot remove the default context")
+	}
+	path, err := contextPath(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("context not found: %s", name)
+		}
+		return fmt.Errorf("failed to remove context: %v", err)
+	}
+
+	if currentContextName() == name {
+		return setCurrentContext(defaultContextName)
+	}
+	return nil
+}
+
+// currentContextName returns the context selected with 'gocker context use'
+func currentContextName() string {
+	data, err := os.ReadFile(filepath.Join(contextsDir, "current-context"))
+	if err != nil {
+		return defaultContextName
+	}
+	if name := strings.TrimSpace(string(data)); name != "" {
+		return name
+	}
+	return defaultContextName
+}
+
+// setCurrentContext makes a context the current one
+func setCurrentContext(name string) error {
+	if _, err := loadContext(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(contextsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create contexts directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(contextsDir, "current-context"), []byte(name+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to set current context: %v", err)
+	}
+	return nil
+}
+
+// activeContext returns the context for this invocation
+// Priority: 1) --context flag, 2) GOCKER_CONTEXT, 3) 'gocker context use'
+func activeContext(flagValue string) (*Context, error) {
+	name := flagValue
+	if name == "" {
+		name = os.Getenv("GOCKER_CONTEXT")
+	}
+	if name == "" {
+		name = currentContextName()
+	}
+	return loadContext(name)
+}
+
+// loadAllContexts returns the default context followed by all saved contexts
+func loadAllContexts() ([]*Context, error) {
+	defaultCtx, _ := loadContext(defaultContextName)
+	contexts := []*Context{defaultCtx}
+
+	files, err := os.ReadDir(contextsDir)
+	if os.IsNotExist(err) {
+		return contexts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contexts directory: %v", err)
+	}
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		ctx, err := loadContext(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		contexts = append(contexts, ctx)
+	}
+	return contexts, nil
+}
+
+func listContexts() {
+	contexts, err := loadAllContexts()
+	must(err)
+
+	current := currentContextName()
+	if env := os.Getenv("GOCKER_CONTEXT"); env != "" {
+		current = env
+	}
+
+	fmt.Printf("%-20s %-10s %-30s %s\n", "NAME", "ENDPOINT", "DATA ROOT", "DESCRIPTION")
+	fmt.Println(strings.Repeat("-", 100))
+
+	for _, ctx := range contexts {
+		name := ctx.Name
+		if name == current {
+			name += " *"
+		}
+		dataRoot := "-"
+		if ctx.Settings != nil && ctx.Settings.DataRoot != "" {
+			dataRoot = ctx.Settings.DataRoot
+		}
+		fmt.Printf("%-20s %-10s %-30s %s\n", name, ctx.Endpoint, dataRoot, ctx.Description)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestContextLifecycle verifies contexts can be created, selected, listed and removed
+func TestContextLifecycle(t *testing.T) {
+	restoreRuntimeSettings(t)
+
+	ctx := &Context{Name: "ci", Endpoint: localEndpoint, Settings: &Config{DataRoot: "/tmp/gocker-ci"}}
+	if err := saveContext(ctx); err != nil {
+		t.Fatalf("saveContext failed: %v", err)
+	}
+	if err := saveContext(ctx); err == nil {
+		t.Error("Expected error when creating a duplicate context")
+	}
+
+	if got := currentContextName(); got != defaultContextName {
+		t.Errorf("Expected current context %s, got %s", defaultContextName, got)
+	}
+	if err := setCurrentContext("ci"); err != nil {
+		t.Fatalf("setCurrentContext failed: %v", err)
+	}
+	if got := currentContextName(); got != "ci" {
+		t.Errorf("Expected current context ci, got %s", got)
+	}
+	if err := setCurrentContext("missing"); err == nil {
+		t.Error("Expected error when using a missing context")
+	}
+
+	contexts, err := loadAllContexts()
+	if err != nil {
+		t.Fatalf("loadAllContexts failed: %v", err)
+	}
+	if len(contexts) != 2 || contexts[0].Name != defaultContextName || contexts[1].Name != "ci" {
+		t.Errorf("Expected default and ci contexts, got %d", len(contexts))
+	}
+
+	// Removing the current context falls back to the default
+	if err := removeContext("ci"); err != nil {
+		t.Fatalf("removeContext failed: %v", err)
+	}
+	if got := currentContextName(); got != defaultContextName {
+		t.Errorf("Expected current context %s after removal, got %s", defaultContextName, got)
+	}
+	if err := removeContext(defaultContextName); err == nil {
+		t.Error("Expected error when removing the default context")
+	}
+}
+
+// TestContextValidation verifies reserved names and unsupported endpoints are rejected
+func TestContextValidation(t *testing.T) {
+	restoreRuntimeSettings(t)
+
+	tests := []*Context{
+		{Name: defaultContextName, Endpoint: localEndpoint},
+		{Name: "remote", Endpoint: "tcp://10.0.0.5:2376"},
+		{Name: "../escape", Endpoint: localEndpoint},
+		{Name: "relative", Endpoint: localEndpoint, Settings: &Config{DataRoot: "relative"}},
+		{Name: "remote-settings", Endpoint: "ssh://ops@build1", Settings: &Config{DataRoot: "/var/lib/gocker-ci"}},
+	}
+	for _, ctx := range tests {
+		if err := saveContext(ctx); err == nil {
+			t.Errorf("saveContext(%s, %s): expected error, got nil", ctx.Name, ctx.Endpoint)
+		}
+	}
+
+	// An ssh endpoint is accepted as long as it carries no local settings
+	if err := saveContext(&Context{Name: "build1", Endpoint: "ssh://ops@build1:2222", Settings: &Config{}}); err != nil {
+		t.Errorf("saveContext with ssh endpoint failed: %v", err)
+	}
+}
+
+// TestLoadConfigContext verifies context settings override the config file
+// and are themselves overridden by the environment and flags
+func TestLoadConfigContext(t *testing.T) {
+	restoreRuntimeSettings(t)
+
+	configPath := filepath.Join(t.TempDir(), "daemon.json")
+	config := `{"data_root": "/tmp/gocker-file", "bridge_name": "gockerfile", "default_rootfs": "/opt/file"}`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Setenv("GOCKER_CONFIG", configPath)
+	t.Setenv("GOCKER_BRIDGE_NAME", "gockerenv")
+
+	ctx := &Context{Name: "dev", Endpoint: localEndpoint, Settings: &Config{
+		DataRoot:      "/tmp/gocker-dev",
+		BridgeName:    "gockerdev",
+		DefaultRootfs: "/opt/dev",
+	}}
+	if err := saveContext(ctx); err != nil {
+		t.Fatalf("saveContext failed: %v", err)
+	}
+	if err := setCurrentContext("dev"); err != nil {
+		t.Fatalf("setCurrentContext failed: %v", err)
+	}
+
+	if err := loadConfig(&Config{DataRoot: "/tmp/gocker-flag"}); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if activeContextName != "dev" {
+		t.Errorf("Expected active context dev, got %s", activeContextName)
+	}
+	if defaultRootfs != "/opt/dev" {
+		t.Errorf("Expected context to override default_rootfs, got %s", defaultRootfs)
+	}
+	if bridgeName != "gockerenv" {
+		t.Errorf("Expected environment to override context bridge_name, got %s", bridgeName)
+	}
+	if stateDir != "/tmp/gocker-flag" {
+		t.Errorf("Expected --data-root to override context data_root, got %s", stateDir)
+	}
+
+	// --context selects a context for one invocation
+	if err := loadConfig(&Config{Context: defaultContextName}); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if stateDir != "/tmp/gocker-file" {
+		t.Errorf("Expected default context to use the config file data_root, got %s", stateDir)
+	}
+	if err := loadConfig(&Config{Context: "missing"}); err == nil {
+		t.Error("Expected error for a missing context")
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// corePatternFile is the kernel's core dump pattern; a pattern starting with
+// '|' pipes each dump to a helper program instead of writing a file
+var corePatternFile = "/proc/sys/kernel/core_pattern"
+
+// defaultCorePattern is the kernel's default, a file named core in the
+// crashed process's working directory
+const defaultCorePattern = "core"
+
+// corePatternMax is the longest pattern the kernel accepts
+const corePatternMax = 127
+
+// defaultCoreLimit is the largest core dump kept when --core-limit is not given
+const defaultCoreLimit = 1 << 30
+
+// coreKeep is how many core dumps are kept per container, newest first
+const coreKeep = 5
+
+// coresDirName is the directory under a container's directory holding its dumps
+const coresDirName = "cores"
+
+// coreNamePattern matches dump file names: core.<unix time>.<pid>.<signal>.<command>
+// with .truncated appended when the dump was cut off at the container's limit
+var coreNamePattern = regexp.MustCompile(`^core\.(\d+)\.(\d+)\.(\d+)\.([A-Za-z0-9_-]+)(\.truncated)?$`)
+
+// CoreDump is a core dump kept for a container
+type CoreDump struct {
+	Name      string    `json:"name"`
+	Time      time.Time `json:"time"`
+	PID       int       `json:"pid"` // host PID of the crashed process
+	Signal    int       `json:"signal"`
+	Command   string    `json:"command"`
+	Size      int64     `json:"size"`
+	Truncated bool      `json:"truncated,omitempty"`
+	Path      string    `json:"path"`
+}
+
+// corePattern returns the pattern that pipes dumps to this gocker binary
+func corePattern() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find gocker executable: %v", err)
+	}
+	pattern := fmt.Sprintf("|%s --data-root %s core-handler %%P %%s %%t %%e", executable, stateDir)
+	if len(pattern) > corePatternMax {
+		return "", fmt.Errorf("core pattern is longer than the kernel's %d byte limit: %s", corePatternMax, pattern)
+	}
+	return pattern, nil
+}
+
+// isGockerCorePattern reports whether a core pattern pipes to gocker
+func isGockerCorePattern(pattern string) bool {
+	return strings.HasPrefix(pattern, "|") && strings.Contains(pattern, " core-handler ")
+}
+
+// savedCorePatternFile holds the pattern that was in place before gocker's
+func savedCorePatternFile() string {
+	return filepath.Join(stateDir, "core_pattern.saved")
+}
+
+// readCorePattern returns the current core pattern
+func readCorePattern() (string, error) {
+	data, err := os.ReadFile(corePatternFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read core pattern: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// enableCoreCapture pipes core dumps to gocker, saving the previous pattern
+// gocker drops the dumps of host processes, so replacing any pattern but the
+// kernel default, such as systemd-coredump or /var/crash/core.%e.%p, needs force
+func enableCoreCapture(force bool) error {
+	current, err := readCorePattern()
+	if err != nil {
+		return err
+	}
+	if current != defaultCorePattern && !isGockerCorePattern(current) && !force {
+		return fmt.Errorf("core pattern %q would no longer receive the dumps of host processes; use --force to replace it", current)
+	}
+	pattern, err := corePattern()
+	if err != nil {
+		return err
+	}
+	if !isGockerCorePattern(current) {
+		if err := os.WriteFile(savedCorePatternFile(), []byte(current+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to save core pattern: %v", err)
+		}
+	}
+	if err := os.WriteFile(corePatternFile, []byte(pattern), 0644); err != nil {
+		return fmt.Errorf("failed to set core pattern: %v", err)
+	}
+	return nil
+}
+
+// disableCoreCapture restores the pattern saved by enableCoreCapture, or the
+// kernel default, if gocker's pattern is in place
+// It reports whether it changed anything
+func disableCoreCapture() (bool, error) {
+	current, err := readCorePattern()
+	if err != nil || !isGockerCorePattern(current) {
+		return false, err
+	}
+	previous := defaultCorePattern
+	if data, err := os.ReadFile(savedCorePatternFile()); err == nil {
+		previous = strings.TrimSpace(string(data))
+	}
+	if err := os.WriteFile(corePatternFile, []byte(previous), 0644); err != nil {
+		return false, fmt.Errorf("failed to restore core pattern: %v", err)
+	}
+	os.Remove(savedCorePatternFile())
+	return true, nil
+}
+
+func systemCoresCommand(args []string) {
+	var enable, disable, force bool
+	flags := newCommandFlags("system cores", "[options]", "Show or change whether core dumps of container processes are kept")
+	flags.BoolVar(&enable, "enable", "", "Pipe core dumps to gocker, which keeps those of container processes")
+	flags.BoolVar(&force, "force", "f", "With --enable, replace a core pattern other than the kernel default, such as systemd-coredump")
+	flags.BoolVar(&disable, "disable", "", "Restore the core pattern that was in place before --enable")
+	if len(flags.MustParse(args)) > 0 {
+		flags.Fail("system cores does not accept arguments")
+	}
+	if enable && disable {
+		flags.Fail("--enable and --disable cannot be combined")
+	}
+	if force && !enable {
+		flags.Fail("--force requires --enable")
+	}
+
+	switch {
+	case enable:
+		requireRoot()
+		must(enableCoreCapture(force))
+		fmt.Println("Core dumps of container processes are now kept under each container's directory")
+	case disable:
+		requireRoot()
+		changed, err := disableCoreCapture()
+		must(err)
+		if changed {
+			fmt.Println("Core pattern restored")
+		} else {
+			fmt.Println("Core dumps are not handled by gocker")
+		}
+	default:
+		pattern, err := readCorePattern()
+		must(err)
+		if isGockerCorePattern(pattern) {
+			fmt.Println("Core dumps of container processes are kept by gocker")
+		} else {
+			fmt.Println("Core dumps are not handled by gocker; enable with 'gocker system cores --enable'")
+		}
+		fmt.Printf("Core pattern: %s\n", pattern)
+	}
+}
+
+// parseCoreLimit parses --core-limit: a size, or 0 to keep no dumps
+func parseCoreLimit(value string) (int64, error) {
+	switch value {
+	case "":
+		return defaultCoreLimit, nil
+	case "0":
+		return 0, nil
+	}
+	limit, err := parseMemoryLimit(value)
+	if err != nil || limit == "max" {
+		return 0, fmt.Errorf("invalid --core-limit: %s (expected a size such as 512M, or 0)", value)
+	}
+	return strconv.ParseInt(limit, 10, 64)
+}
+
+// containerOfProcess returns the container whose cgroup a process is in,
+// given the process's /proc/<pid>/cgroup, or "" if it is in none of ids
+// Container IDs appear in every driver's cgroup paths
+func containerOfProcess(cgroupData string, ids []string) string {
+	for _, id := range ids {
+		if strings.Contains(cgroupData, id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// coreHandlerCommand is run by the kernel for each core dump, with the dump
+// on stdin and the crashed process's PID, signal, time and command as
+// arguments; it keeps the dumps of container processes and drops the rest
+func coreHandlerCommand(args []string) {
+	// Whatever happens, read the dump so the kernel is not left blocked
+	defer io.Copy(io.Discard, os.Stdin)
+	if len(args) < 4 {
+		return
+	}
+	pid, err := strconv.Atoi(args[0])
+	if err != nil {
+		return
+	}
+	cgroupData, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return
+	}
+	ids, err := listContainerIDs()
+	if err != nil {
+		return
+	}
+	containerID := containerOfProcess(string(cgroupData), ids)
+	if containerID == "" {
+		return
+	}
+	state, err := readContainerState(containerID)
+	if err != nil {
+		return
+	}
+	var limitValue string
+	if state.Options != nil {
+		limitValue = state.Options.CoreLimit
+	}
+	limit, err := parseCoreLimit(limitValue)
+	if err != nil || limit == 0 {
+		return
+	}
+	if err := saveCoreDump(containerID, os.Stdin, limit, pid, args[1:]); err != nil {
+		logger.Warn("Failed to save core dump", "container", shortID(containerID), "error", err)
+	}
+}
+
+// saveCoreDump writes a dump of at most limit bytes to the container's cores
+// directory, removing the oldest dumps beyond coreKeep
+// info is the signal, the unix time and the command of the crashed process
+func saveCoreDump(containerID string, r io.Reader, limit int64, pid int, info []string) error {
+	dir := filepath.Join(containerDir(containerID), coresDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create cores directory: %v", err)
+	}
+	return writeCoreDump(dir, coreDumpName(pid, info), r, limit)
+}
+
+// coreUnsafePattern matches characters not allowed in a dump file name
+var coreUnsafePattern = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// coreDumpName returns the file name of a dump, see coreNamePattern
+func coreDumpName(pid int, info []string) string {
+	command := coreUnsafePattern.ReplaceAllString(strings.Join(info[2:], " "), "_")
+	if command == "" {
+		command = "unknown"
+	}
+	return fmt.Sprintf("core.%s.%d.%s.%s", info[1], pid, info[0], command)
+}
+
+// writeCoreDump writes a dump to dir, marking it truncated if it was cut off
+func writeCoreDump(dir, name string, r io.Reader, limit int64) error {
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create core dump: %v", err)
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write core dump: %v", err)
+	}
+	// More to come means the dump did not fit
+	if n == limit {
+		if extra, _ := r.Read(make([]byte, 1)); extra > 0 {
+			if err := os.Rename(path, path+".truncated"); err != nil {
+				return fmt.Errorf("failed to mark core dump truncated: %v", err)
+			}
+		}
+	}
+	return pruneCoreDumps(dir)
+}
+
+// pruneCoreDumps removes the oldest dumps in dir beyond coreKeep
+func pruneCoreDumps(dir string) error {
+	dumps, err := readCoreDumps(dir)
+	if err != nil {
+		return err
+	}
+	for _, dump := range dumps[min(coreKeep, len(dumps)):] {
+		if err := os.Remove(dump.Path); err != nil {
+			return fmt.Errorf("failed to remove old core dump: %v", err)
+		}
+	}
+	return nil
+}
+
+// readCoreDumps lists the dumps in dir, newest first
+func readCoreDumps(dir string) ([]CoreDump, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cores directory: %v", err)
+	}
+
+	var dumps []CoreDump
+	for _, entry := range entries {
+		match := coreNamePattern.FindStringSubmatch(entry.Name())
+		info, err := entry.Info()
+		if match == nil || err != nil {
+			continue
+		}
+		unix, _ := strconv.ParseInt(match[1], 10, 64)
+		pid, _ := strconv.Atoi(match[2])
+		signal, _ := strconv.Atoi(match[3])
+		dumps = append(dumps, CoreDump{
+			Name:      entry.Name(),
+			Time:      time.Unix(unix, 0),
+			PID:       pid,
+			Signal:    signal,
+			Command:   match[4],
+			Size:      info.Size(),
+			Truncated: match[5] != "",
+			Path:      filepath.Join(dir, entry.Name()),
+		})
+	}
+	sort.SliceStable(dumps, func(i, j int) bool { return dumps[i].Time.After(dumps[j].Time) })
+	return dumps, nil
+}
+
+func debugCoresCommand(args []string) {
+	var jsonOutput bool
+	var output string
+	flags := newCommandFlags("debug cores", "<container-id> [core]", "List a container's core dumps, or write one to a file or stdout")
+	flags.interspersed = true
+	flags.BoolVar(&jsonOutput, "json", "", "Output the list as JSON")
+	flags.StringVar(&output, "output", "o", "file", "Write the core dump to a file instead of stdout")
+	positional := flags.MustParse(args)
+	if len(positional) == 0 || len(positional) > 2 {
+		flags.Fail("container ID and optionally a core dump name required")
+	}
+	requireRoot()
+
+	state, err := loadContainerState(positional[0])
+	must(err)
+	dumps, err := readCoreDumps(filepath.Join(containerDir(state.ID), coresDirName))
+	must(err)
+
+	if len(positional) == 2 {
+		for _, dump := range dumps {
+			if dump.Name == positional[1] {
+				must(copyCoreDump(dump, output))
+				return
+			}
+		}
+		must(fmt.Errorf("no core dump %s for container %s", positional[1], shortID(state.ID)))
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(dumps, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+		return
+	}
+	if len(dumps) == 0 {
+		fmt.Printf("No core dumps for container %s\n", shortID(state.ID))
+		return
+	}
+	fmt.Printf("%-44s %-20s %-8s %-24s %-8s %s\n", "CORE", "TIME", "PID", "SIGNAL", "SIZE", "COMMAND")
+	for _, dump := range dumps {
+		size := formatMemory(dump.Size)
+		if dump.Truncated {
+			size += " (truncated)"
+		}
+		fmt.Printf("%-44s %-20s %-8d %-24s %-8s %s\n", dump.Name, dump.Time.Format("2006-01-02 15:04:05"),
+			dump.PID, syscall.Signal(dump.Signal).String(), size, dump.Command)
+	}
+}
+
+// copyCoreDump writes a dump to path, or to stdout if path is empty
+func copyCoreDump(dump CoreDump, path string) error {
+	src, err := os.Open(dump.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open core dump: %v", err)
+	}
+	defer src.Close()
+
+	if path == "" {
+		if isTerminal(os.Stdout) {
+			return errors.New("refusing to write a core dump to a terminal; redirect stdout or use --output")
+		}
+		_, err = io.Copy(os.Stdout, src)
+		return err
+	}
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return dst.Close()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCoreCapturePattern verifies enabling saves the previous core pattern and
+// disabling restores it
+func TestCoreCapturePattern(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+	saved := corePatternFile
+	corePatternFile = filepath.Join(t.TempDir(), "core_pattern")
+	t.Cleanup(func() { corePatternFile = saved })
+
+	previous := "|/usr/lib/systemd/systemd-coredump %P %u %g %s %t %c %h"
+	if err := os.WriteFile(corePatternFile, []byte(previous+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write core pattern: %v", err)
+	}
+
+	// Another handler keeps host processes' dumps, so it is only replaced with force
+	if err := enableCoreCapture(false); err == nil || !strings.Contains(err.Error(), "systemd-coredump") {
+		t.Errorf("Expected enabling over systemd-coredump to be refused, got %v", err)
+	}
+	if pattern, _ := readCorePattern(); pattern != previous {
+		t.Errorf("Expected a refused enable to keep %q, got %q", previous, pattern)
+	}
+
+	// Enabling twice must not save gocker's own pattern as the previous one
+	for i := 0; i < 2; i++ {
+		if err := enableCoreCapture(true); err != nil {
+			t.Fatalf("enableCoreCapture failed: %v", err)
+		}
+	}
+	pattern, err := readCorePattern()
+	if err != nil || !isGockerCorePattern(pattern) || !strings.Contains(pattern, "--data-root "+stateDir) {
+		t.Errorf("Expected gocker's core pattern, got %q, %v", pattern, err)
+	}
+
+	changed, err := disableCoreCapture()
+	if err != nil || !changed {
+		t.Fatalf("disableCoreCapture = %v, %v", changed, err)
+	}
+	if pattern, _ := readCorePattern(); pattern != previous {
+		t.Errorf("Expected restored pattern %q, got %q", previous, pattern)
+	}
+	if changed, err := disableCoreCapture(); err != nil || changed {
+		t.Errorf("Expected disabling again to change nothing, got %v, %v", changed, err)
+	}
+}
+
+// TestCoreCaptureFilePattern verifies only the kernel default is replaced
+// without force, as gocker drops host processes' dumps a file pattern keeps
+func TestCoreCaptureFilePattern(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+	saved := corePatternFile
+	corePatternFile = filepath.Join(t.TempDir(), "core_pattern")
+	t.Cleanup(func() { corePatternFile = saved })
+
+	if err := os.WriteFile(corePatternFile, []byte("/var/crash/core.%e.%p\n"), 0644); err != nil {
+		t.Fatalf("Failed to write core pattern: %v", err)
+	}
+	if err := enableCoreCapture(false); err == nil || !strings.Contains(err.Error(), "/var/crash") {
+		t.Errorf("Expected enabling over a file pattern to be refused, got %v", err)
+	}
+
+	if err := os.WriteFile(corePatternFile, []byte("core\n"), 0644); err != nil {
+		t.Fatalf("Failed to write core pattern: %v", err)
+	}
+	if err := enableCoreCapture(false); err != nil {
+		t.Fatalf("enableCoreCapture failed: %v", err)
+	}
+	if pattern, _ := readCorePattern(); !isGockerCorePattern(pattern) {
+		t.Errorf("Expected gocker's core pattern, got %q", pattern)
+	}
+}
+
+// TestParseCoreLimit verifies --core-limit sizes, 0 and the default
+func TestParseCoreLimit(t *testing.T) {
+	tests := map[string]int64{"": defaultCoreLimit, "0": 0, "512M": 512 << 20, "2G": 2 << 30}
+	for value, want := range tests {
+		if got, err := parseCoreLimit(value); err != nil || got != want {
+			t.Errorf("parseCoreLimit(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"max", "lots", "-1"} {
+		if _, err := parseCoreLimit(value); err == nil {
+			t.Errorf("parseCoreLimit(%q): expected error, got nil", value)
+		}
+	}
+}
+
+// TestContainerOfProcess verifies a crashed process is matched to its
+// container with any cgroup driver
+func TestContainerOfProcess(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	other := strings.Repeat("cd", 32)
+	tests := map[string]string{
+		"0::/gocker/" + id + "\n":                                     id,
+		"0::/system.slice/gocker.slice/gocker-" + id + ".scope/app\n": id,
+		"12:memory:/gocker/" + id + "\n4:pids:/gocker/" + id + "\n":   id,
+		"0::/user.slice/user-1000.slice/session-2.scope\n":            "",
+	}
+	for data, want := range tests {
+		if got := containerOfProcess(data, []string{other, id}); got != want {
+			t.Errorf("containerOfProcess(%q) = %q, want %q", data, got, want)
+		}
+	}
+}
+
+// TestSaveCoreDump verifies dumps are named, truncated at the limit, listed
+// newest first and pruned to coreKeep
+func TestSaveCoreDump(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+	id := strings.Repeat("ab", 32)
+
+	if err := saveCoreDump(id, strings.NewReader("0123456789"), 4, 4242, []string{"11", "1700000000", "my app"}); err != nil {
+		t.Fatalf("saveCoreDump failed: %v", err)
+	}
+	dir := filepath.Join(containerDir(id), coresDirName)
+	dumps, err := readCoreDumps(dir)
+	if err != nil || len(dumps) != 1 {
+		t.Fatalf("Expected 1 core dump, got %v, %v", dumps, err)
+	}
+	dump := dumps[0]
+	if dump.Name != "core.1700000000.4242.11.my_app.truncated" || !dump.Truncated || dump.Size != 4 ||
+		dump.PID != 4242 || dump.Signal != 11 || dump.Command != "my_app" {
+		t.Errorf("Unexpected core dump %+v", dump)
+	}
+
+	// A dump that fits exactly is not truncated
+	if err := saveCoreDump(id, strings.NewReader("0123"), 4, 4243, []string{"6", "1700000001", "app"}); err != nil {
+		t.Fatalf("saveCoreDump failed: %v", err)
+	}
+	for i := 2; i <= coreKeep+1; i++ {
+		info := []string{"6", fmt.Sprint(1700000000 + i), "app"}
+		if err := saveCoreDump(id, strings.NewReader("core"), 1024, 5000+i, info); err != nil {
+			t.Fatalf("saveCoreDump failed: %v", err)
+		}
+	}
+	dumps, err = readCoreDumps(dir)
+	if err != nil || len(dumps) != coreKeep {
+		t.Fatalf("Expected %d core dumps after pruning, got %d, %v", coreKeep, len(dumps), err)
+	}
+	if dumps[0].PID != 5000+coreKeep+1 {
+		t.Errorf("Expected newest dump first, got PID %d", dumps[0].PID)
+	}
+	for _, dump := range dumps {
+		if dump.Truncated {
+			t.Errorf("Expected the oldest (truncated) dump to be pruned, found %s", dump.Name)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	valid := []string{"0 3 * * *", "*/15 * * * *", "0 9-17/2 * * 1-5", "30 4 1,15 * *", "0 0 * * 7", "5/10 * * * *", "@daily", "@hourly"}
+	for _, spec := range valid {
+		if _, err := parseCron(spec); err != nil {
+			t.Errorf("parseCron(%q) failed: %v", spec, err)
+		}
+	}
+	invalid := []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@sometimes"}
+	for _, spec := range invalid {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q): expected error", spec)
+		}
+	}
+}
+
+// TestCronNext verifies the next run time, including cron's rule that a day
+// matches either day field when both are restricted
+func TestCronNext(t *testing.T) {
+	// 2026-10-16 is a Friday
+	from := time.Date(2026, 10, 16, 10, 30, 45, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 3 * * *", time.Date(2026, 10, 17, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 16, 10, 45, 0, 0, time.UTC)},
+		{"31 10 * * *", time.Date(2026, 10, 16, 10, 31, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2026, 10, 17, 10, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 6", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		expr, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q) failed: %v", tt.spec, err)
+		}
+		if got, ok := expr.next(from); !ok || !got.Equal(tt.want) {
+			t.Errorf("next(%q) = %v, %v; want %v", tt.spec, got, ok, tt.want)
+		}
+	}
+
+	never, _ := parseCron("0 0 30 2 *")
+	if got, ok := never.next(from); ok {
+		t.Errorf("Expected no run on February 30, got %v", got)
+	}
+}
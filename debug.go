@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// debugToolbox is the root filesystem 'gocker debug' runs tools from, set
+// with debug_toolbox; empty means the default rootfs
+var debugToolbox = ""
+
+// toolboxTarget is where a debugged container's root filesystem appears in
+// the toolbox
+const toolboxTarget = "/target"
+
+// toolboxPath is the PATH of commands run in the toolbox
+const toolboxPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// debugCommands lists the 'gocker debug' subcommands
+func debugCommands() []*command {
+	return []*command{
+		{name: "cores", description: "List and retrieve a container's core dumps", run: debugCoresCommand},
+		{name: "trace", description: "Show the syscall trace of a container run with --trace", run: debugTraceCommand},
+	}
+}
+
+// debugCommand dispatches the 'gocker debug' subcommands; anything else is
+// a container to run a toolbox in
+func debugCommand(args []string) {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		printDebugUsage()
+		if len(args) == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, cmd := range debugCommands() {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	debugToolboxCommand(args)
+}
+
+func printDebugUsage() {
+	fmt.Println("Usage: gocker debug [options] <container-id> [command] [args...]")
+	fmt.Println("       gocker debug <command> [options]")
+	fmt.Println()
+	fmt.Println("Run tools from a toolbox root filesystem in a running container's network,")
+	fmt.Println("PID and UTS namespaces, with its root filesystem read-only at /target.")
+	fmt.Println()
+	fmt.Println("Options:")
+	newDebugToolboxFlags(new(string)).PrintOptions(os.Stdout)
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range debugCommands() {
+		fmt.Printf("  %-6s %s\n", cmd.name, cmd.description)
+	}
+}
+
+// newDebugToolboxFlags registers the flags of 'gocker debug <container-id>'
+func newDebugToolboxFlags(toolbox *string) *commandFlags {
+	flags := newCommandFlags("debug", "[options] <container-id> [command] [args...]", "Run tools in a running container's namespaces")
+	flags.StringVar(toolbox, "toolbox", "", "path", "Root filesystem with the tools (default: debug_toolbox, else the default rootfs)")
+	return flags
+}
+
+func debugToolboxCommand(args []string) {
+	var toolbox string
+	flags := newDebugToolboxFlags(&toolbox)
+	positional := flags.MustParse(args)
+	if len(positional) == 0 {
+		flags.Fail("container ID required")
+	}
+	requireRoot()
+
+	state, err := loadContainerState(positional[0])
+	must(err)
+	if !isActive(state.Status) || !isProcessAlive(state) {
+		must(fmt.Errorf("container %s is not running", shortID(state.ID)))
+	}
+	if toolbox == "" {
+		toolbox = debugToolbox
+	}
+	toolbox, err = resolveRootfsPath(toolbox)
+	must(err)
+	os.Exit(runToolbox(state, toolbox, positional[1:]))
+}
+
+// runToolbox runs a command from the toolbox in a container's network, PID
+// and UTS namespaces and returns its exit code
+// The toolbox gets a mount namespace of its own, so the container's mounts
+// are untouched; its processes stay outside the container's cgroup
+func runToolbox(state *ContainerState, toolbox string, command []string) int {
+	executable, err := os.Executable()
+	must(err)
+	args := []string{"--target", strconv.Itoa(state.PID), "--net", "--pid", "--uts", "--", executable}
+	args = append(append(args, logFlags()...), "debug-toolbox", toolbox, strconv.Itoa(state.PID))
+	cmd := exec.Command("nsenter", append(args, command...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	newMountNamespace(cmd.SysProcAttr)
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitStatus(exitErr.ProcessState)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to start toolbox: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// mountToolbox mounts the root filesystem of the container process pid
+// read-only at /target in the toolbox, and /proc and /dev
+// It runs in the toolbox's mount namespace and the container's PID namespace,
+// so /proc shows the container's processes
+func mountToolbox(toolbox string, pid int) error {
+	target := filepath.Join(toolbox, toolboxTarget)
+	proc := filepath.Join(toolbox, "proc")
+	dev := filepath.Join(toolbox, "dev")
+	for _, dir := range []string{target, proc, dev} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", dir, err)
+		}
+	}
+
+	root := filepath.Join("/proc", strconv.Itoa(pid), "root")
+	if err := mount(root, target, "", msBind, ""); err != nil {
+		return fmt.Errorf("failed to mount container root filesystem: %v", err)
+	}
+	if err := mount(target, target, "", msBind|msRemount|msRdonly, ""); err != nil {
+		return fmt.Errorf("failed to make container root filesystem read-only: %v", err)
+	}
+	if err := mount("proc", proc, "proc", msNosuid|msNodev|msNoexec, ""); err != nil {
+		return fmt.Errorf("failed to mount /proc: %v", err)
+	}
+	if err := mount("/dev", dev, "", msBind|msRec, ""); err != nil {
+		return fmt.Errorf("failed to mount /dev: %v", err)
+	}
+	return nil
+}
+
+// debugToolboxInitCommand is run by nsenter in the container's namespaces to
+// set up the toolbox and replace itself with the command
+func debugToolboxInitCommand(args []string) {
+	if len(args) < 2 {
+		must(fmt.Errorf("debug-toolbox: toolbox and container PID required"))
+	}
+	toolbox, command := args[0], args[2:]
+	pid, err := strconv.Atoi(args[1])
+	must(err)
+
+	must(mountToolbox(toolbox, pid))
+	must(chroot(toolbox))
+	must(os.Chdir("/"))
+
+	if len(command) == 0 {
+		command = []string{"/bin/sh"}
+	}
+	os.Setenv("PATH", toolboxPath)
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCodeNotFound)
+	}
+	env := []string{"PATH=" + toolboxPath, "HOME=/root", "TARGET=" + toolboxTarget}
+	if term := os.Getenv("TERM"); term != "" {
+		env = append(env, "TERM="+term)
+	}
+	err = syscall.Exec(path, command, env)
+	fmt.Fprintf(os.Stderr, "Error: failed to execute %s: %v\n", command[0], err)
+	os.Exit(exitCodeNotExecutable)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestMountToolbox verifies the container's root filesystem is mounted
+// read-only at /target, next to /proc and /dev
+func TestMountToolbox(t *testing.T) {
+	type mountCall struct {
+		source, target, fstype string
+		flags                  uintptr
+	}
+	var mounts []mountCall
+	saved := mount
+	t.Cleanup(func() { mount = saved })
+	mount = func(source, target, fstype string, flags uintptr, data string) error {
+		mounts = append(mounts, mountCall{source, target, fstype, flags})
+		return nil
+	}
+
+	toolbox := t.TempDir()
+	if err := mountToolbox(toolbox, 4242); err != nil {
+		t.Fatalf("mountToolbox failed: %v", err)
+	}
+
+	target := filepath.Join(toolbox, "target")
+	want := []mountCall{
+		{"/proc/4242/root", target, "", uintptr(msBind)},
+		{target, target, "", uintptr(msBind | msRemount | msRdonly)},
+		{"proc", filepath.Join(toolbox, "proc"), "proc", uintptr(msNosuid | msNodev | msNoexec)},
+		{"/dev", filepath.Join(toolbox, "dev"), "", uintptr(msBind | msRec)},
+	}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("mounts = %+v, want %+v", mounts, want)
+	}
+	if info, err := os.Stat(target); err != nil || !info.IsDir() {
+		t.Errorf("Expected %s to be created: %v", target, err)
+	}
+}
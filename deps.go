@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// HostDependency is an external program gocker runs, for 'gocker info --check-deps'
+// Network interfaces, addresses, routes and sysctls are configured with
+// syscalls and netlink and need none
+type HostDependency struct {
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"` // empty when not found in PATH
+	UsedFor string `json:"used_for"`
+}
+
+// hostDependencies lists every program gocker may run on the host; none is
+// needed to run a container on a host with nftables or iptables available
+func hostDependencies() []HostDependency {
+	return []HostDependency{
+		{Name: "iptables", UsedFor: "NAT (else nft), published ports, --internal, --expose and --allow-from"},
+		{Name: "nft", UsedFor: "NAT on hosts without iptables"},
+		{Name: "nsenter", UsedFor: "gocker exec, gocker debug, the probes of gocker network doctor"},
+		{Name: "firewall-cmd", UsedFor: "registering the bridge on hosts running firewalld"},
+		{Name: "systemctl", UsedFor: "cgroup_driver systemd"},
+		{Name: "busctl", UsedFor: "cgroup_driver systemd"},
+		{Name: "ldconfig", UsedFor: "--gpus, to find the driver libraries"},
+		{Name: "cp", UsedFor: "gocker snapshot"},
+		{Name: straceBinary, UsedFor: "--trace"},
+		{Name: tcpdumpBinary, UsedFor: "gocker network capture"},
+		{Name: wasmRuntime, UsedFor: "--runtime wasm"},
+		{Name: "ssh", UsedFor: "contexts and GOCKER_HOST with ssh:// endpoints"},
+	}
+}
+
+// checkDependencies looks each dependency up in PATH
+func checkDependencies() []HostDependency {
+	deps := hostDependencies()
+	for i := range deps {
+		deps[i].Path, _ = exec.LookPath(deps[i].Name)
+	}
+	return deps
+}
+
+// printDependencies prints the dependencies as a table, or as JSON
+func printDependencies(deps []HostDependency, jsonOutput bool) error {
+	if jsonOutput {
+		data, err := json.MarshalIndent(deps, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	fmt.Printf("%-14s %-26s %s\n", "DEPENDENCY", "PATH", "USED FOR")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, dep := range deps {
+		path := dep.Path
+		if path == "" {
+			path = "not found"
+		}
+		fmt.Printf("%-14s %-26s %s\n", dep.Name, path, dep.UsedFor)
+	}
+	return nil
+}
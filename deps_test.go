@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHostDependencies(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, dep := range hostDependencies() {
+		if dep.UsedFor == "" {
+			t.Errorf("dependency %s does not say what it is used for", dep.Name)
+		}
+		if seen[dep.Name] {
+			t.Errorf("dependency %s is listed twice", dep.Name)
+		}
+		seen[dep.Name] = true
+	}
+	// Networking is configured with netlink and /proc/sys
+	for _, name := range []string{"ip", "sysctl"} {
+		if seen[name] {
+			t.Errorf("%s is listed as a dependency", name)
+		}
+	}
+}
+
+func TestCheckDependencies(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "nsenter"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	for _, dep := range checkDependencies() {
+		want := ""
+		if dep.Name == "nsenter" {
+			want = filepath.Join(dir, "nsenter")
+		}
+		if dep.Path != want {
+			t.Errorf("checkDependencies() found %s at %q, want %q", dep.Name, dep.Path, want)
+		}
+	}
+}
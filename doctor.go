@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Network doctor check results
+const (
+	doctorOK   = "ok"
+	doctorWarn = "warn" // may be fine, but worth a look
+	doctorFail = "fail" // containers cannot reach the internet
+)
+
+// probeTimeout bounds each connectivity probe run in a container
+const probeTimeout = 3 * time.Second
+
+// Defaults of 'gocker network doctor': an address reached by IP, so a DNS
+// failure is told apart from a routing one, and a name to resolve
+const (
+	defaultProbeAddress = "1.1.1.1:443"
+	defaultProbeName    = "example.com"
+)
+
+// DoctorCheck is the result of one 'gocker network doctor' check
+type DoctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"` // what to run or change when not ok
+}
+
+func networkDoctorCommand(args []string) {
+	var jsonOutput bool
+	probeAddress, probeName := defaultProbeAddress, defaultProbeName
+	flags := newCommandFlags("network doctor", "[options] [container-id]", "Diagnose why containers, or one container, cannot reach the internet")
+	flags.interspersed = true
+	flags.StringVar(&probeAddress, "connect", "", "host:port", "Address the container connects to by IP (default: "+defaultProbeAddress+")")
+	flags.StringVar(&probeName, "lookup", "", "name", "Name the container resolves (default: "+defaultProbeName+")")
+	flags.BoolVar(&jsonOutput, "json", "", "Print the checks as JSON")
+	positional := flags.MustParse(args)
+	if len(positional) > 1 {
+		flags.Fail("at most one container ID may be given")
+	}
+	requireRoot()
+
+	checks := hostNetworkChecks()
+	if len(positional) == 1 {
+		state, err := loadContainerState(positional[0])
+		must(err)
+		checks = append(checks, containerNetworkChecks(state, probeAddress, probeName)...)
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(checks, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+	} else {
+		printDoctorChecks(checks)
+	}
+	for _, check := range checks {
+		if check.Status == doctorFail {
+			os.Exit(1)
+		}
+	}
+}
+
+// printDoctorChecks prints the checks followed by the fixes for any problems
+func printDoctorChecks(checks []DoctorCheck) {
+	fmt.Printf("%-18s %-6s %s\n", "CHECK", "STATUS", "DETAIL")
+	fmt.Println(strings.Repeat("-", 80))
+	var fixes []DoctorCheck
+	for _, check := range checks {
+		fmt.Printf("%-18s %-6s %s\n", check.Name, check.Status, check.Detail)
+		if check.Status != doctorOK && check.Fix != "" {
+			fixes = append(fixes, check)
+		}
+	}
+
+	fmt.Println()
+	if len(fixes) == 0 {
+		fmt.Println("No problems found")
+		return
+	}
+	fmt.Println("Suggested fixes:")
+	for _, check := range fixes {
+		fmt.Printf("  %s: %s\n", check.Name, check.Fix)
+	}
+}
+
+// hostNetworkChecks checks the host side: the bridge, forwarding, the
+// default route and the NAT rules
+func hostNetworkChecks() []DoctorCheck {
+	bridge := checkBridge()
+	checks := []DoctorCheck{bridge, checkIPForwarding(readProcSetting("sys/net/ipv4/ip_forward"))}
+
+	defaultInterface, err := getDefaultInterface()
+	if err != nil {
+		checks = append(checks, DoctorCheck{Name: "default route", Status: doctorFail,
+			Detail: fmt.Sprintf("no default interface: %v", err),
+			Fix:    "the host itself has no route to the internet; check 'ip route show default'"})
+		return checks
+	}
+	checks = append(checks, DoctorCheck{Name: "default route", Status: doctorOK, Detail: "via " + defaultInterface})
+	if bridge.Status == doctorWarn {
+		// The rules are added together with the bridge
+		return append(checks, DoctorCheck{Name: "nat rules", Status: doctorWarn, Detail: "not set up until the bridge is created"})
+	}
+	checks = append(checks, checkNATRules(defaultInterface))
+	if firewalldRunning() {
+		output, _ := hostCommand("firewall-cmd", "--get-zone-of-interface="+bridgeName).Output()
+		checks = append(checks, checkFirewalldZone(strings.TrimSpace(string(output))))
+	}
+	return checks
+}
+
+// checkFirewalldZone judges the firewalld zone of the bridge; in any zone but
+// gocker's, firewalld may drop the traffic it forwards
+func checkFirewalldZone(zone string) DoctorCheck {
+	check := DoctorCheck{Name: "firewalld", Status: doctorOK, Detail: bridgeName + " in zone " + firewalldZone}
+	if zone != firewalldZone {
+		check.Status = doctorFail
+		check.Detail = bridgeName + " is not in zone " + firewalldZone
+		if zone != "" {
+			check.Detail = bridgeName + " is in zone " + zone + ", not " + firewalldZone
+		}
+		check.Fix = "the next 'gocker run' registers it, or: firewall-cmd --permanent --new-zone=" + firewalldZone +
+			" && firewall-cmd --permanent --zone=" + firewalldZone + " --set-target=ACCEPT" +
+			" && firewall-cmd --permanent --zone=" + firewalldZone + " --change-interface=" + bridgeName + " && firewall-cmd --reload"
+	}
+	return check
+}
+
+// checkBridge checks that the bridge exists, is up and has the gateway address
+// The bridge is only created by the first 'gocker run', so its absence is
+// not a failure on its own
+func checkBridge() DoctorCheck {
+	check := DoctorCheck{Name: "bridge", Status: doctorOK}
+	bridge, err := net.InterfaceByName(bridgeName)
+	if err != nil {
+		check.Status = doctorWarn
+		check.Detail = bridgeName + " does not exist"
+		check.Fix = "it is created by the next 'gocker run'"
+		return check
+	}
+	var addrs []string
+	if list, err := bridge.Addrs(); err == nil {
+		for _, addr := range list {
+			addrs = append(addrs, addr.String())
+		}
+	}
+	return diagnoseBridge(bridge.Flags&net.FlagUp != 0, addrs)
+}
+
+// diagnoseBridge judges an existing bridge by its state and addresses
+func diagnoseBridge(up bool, addrs []string) DoctorCheck {
+	check := DoctorCheck{Name: "bridge", Status: doctorOK, Detail: fmt.Sprintf("%s up with %s", bridgeName, bridgeCIDR)}
+	hasGateway := false
+	for _, addr := range addrs {
+		if addr == bridgeCIDR {
+			hasGateway = true
+		}
+	}
+	switch {
+	case !up:
+		check.Status = doctorFail
+		check.Detail = bridgeName + " is down"
+		check.Fix = "ip link set " + bridgeName + " up"
+	case !hasGateway:
+		check.Status = doctorFail
+		check.Detail = fmt.Sprintf("%s has no address %s, so containers have no gateway", bridgeName, bridgeCIDR)
+		check.Fix = "ip addr add " + bridgeCIDR + " dev " + bridgeName
+	}
+	return check
+}
+
+// checkIPForwarding judges the value of net.ipv4.ip_forward
+func checkIPForwarding(value string) DoctorCheck {
+	check := DoctorCheck{Name: "ip forwarding", Status: doctorOK, Detail: "enabled"}
+	if value != "1" {
+		check.Status = doctorFail
+		check.Detail = "disabled, so the host does not route container traffic"
+		check.Fix = "sysctl -w net.ipv4.ip_forward=1, and set it in /etc/sysctl.d/ to keep it across reboots"
+	}
+	return check
+}
+
+// natRules returns the iptables rules setupNATRules adds, without the -A
+func natRules(defaultInterface string) [][]string {
+	return [][]string{
+		{"-t", "nat", "POSTROUTING", "-s", containerNet, "-o", defaultInterface, "-j", "MASQUERADE"},
+		{"FORWARD", "-i", bridgeName, "-o", defaultInterface, "-j", "ACCEPT"},
+		{"FORWARD", "-i", defaultInterface, "-o", bridgeName, "-j", "ACCEPT"},
+	}
+}
+
+// checkNATRules checks that the NAT and forwarding rules are in place; they
+// are added with the bridge, so a firewall reload since then removes them
+// for good
+func checkNATRules(defaultInterface string) DoctorCheck {
+	check := DoctorCheck{Name: "nat rules", Status: doctorOK, Detail: "masquerading " + containerNet + " out of " + defaultInterface}
+	if !hasHostTool("iptables") {
+		switch {
+		case !hasHostTool("nft"):
+			check.Status = doctorFail
+			check.Detail = "neither iptables nor nft is installed"
+			check.Fix = "install iptables or nftables"
+		case !hasNftNATRules():
+			check.Status = doctorFail
+			check.Detail = "nftables table " + nftTable + " missing"
+			check.Fix = "the rules are loaded with the bridge; with no containers running, 'ip link delete " + bridgeName + "' and the next 'gocker run' recreates both"
+		default:
+			check.Detail += " (nftables)"
+		}
+		return check
+	}
+
+	var missing []string
+	for _, rule := range natRules(defaultInterface) {
+		if err := hostCommand("iptables", ruleCommand("-C", rule)...).Run(); err != nil {
+			missing = append(missing, "iptables "+strings.Join(ruleCommand("-A", rule), " "))
+		}
+	}
+	if len(missing) > 0 {
+		check.Status = doctorFail
+		check.Detail = fmt.Sprintf("%d of %d rules missing", len(missing), len(natRules(defaultInterface)))
+		check.Fix = strings.Join(missing, "; ")
+	}
+	return check
+}
+
+// ruleCommand inserts an iptables command such as -C before the chain of rule
+func ruleCommand(op string, rule []string) []string {
+	if rule[0] == "-t" {
+		return append([]string{rule[0], rule[1], op}, rule[2:]...)
+	}
+	return append([]string{op}, rule...)
+}
+
+// containerNetworkChecks checks one container: its veth, route, DNS
+// configuration, and whether it can reach the internet and resolve names
+func containerNetworkChecks(state *ContainerState, probeAddress, probeName string) []DoctorCheck {
+	id := shortID(state.ID)
+	if !isActive(state.Status) || !isProcessAlive(state) {
+		return []DoctorCheck{{Name: "container", Status: doctorFail, Detail: fmt.Sprintf("%s is not running", id),
+			Fix: "start it again; the network of a stopped container cannot be checked"}}
+	}
+	veth, err := captureInterface(state, "")
+	if err != nil {
+		return []DoctorCheck{{Name: "container", Status: doctorFail, Detail: fmt.Sprintf("%s has no network", id),
+			Fix: "it was started without networking, e.g. as a rootless container; run it as root"}}
+	}
+
+	checks := []DoctorCheck{checkVeth(veth)}
+	// /proc/<pid>/net shows the network namespace of pid
+	routes, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(state.PID), "net", "route"))
+	if err != nil {
+		checks = append(checks, DoctorCheck{Name: "container route", Status: doctorFail, Detail: fmt.Sprintf("cannot read routes: %v", err)})
+	} else {
+		checks = append(checks, checkContainerRoute(string(routes)))
+	}
+
+	resolvConf, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(state.PID), "root", "etc", "resolv.conf"))
+	dns := checkResolvConf(string(resolvConf), err)
+	checks = append(checks, dns)
+
+	connect := DoctorCheck{Name: "connectivity", Status: doctorOK, Detail: "connected to " + probeAddress}
+	if err := runNetworkProbe(state.PID, "connect", probeAddress); err != nil {
+		connect.Status = doctorFail
+		connect.Detail = fmt.Sprintf("cannot connect to %s: %v", probeAddress, err)
+		connect.Fix = "fix the failed checks above; otherwise a host firewall may drop forwarded traffic (see 'iptables -S FORWARD')"
+		if state.Options != nil && state.Options.Internal {
+			connect.Status = doctorOK
+			connect.Detail = "blocked, as the container runs with --internal"
+			connect.Fix = ""
+		}
+	}
+	checks = append(checks, connect)
+
+	if dns.Status == doctorFail {
+		return checks
+	}
+	lookup := DoctorCheck{Name: "name lookup", Status: doctorOK, Detail: "resolved " + probeName}
+	if err := runNetworkProbe(state.PID, "lookup", probeName, strings.Join(nameservers(string(resolvConf)), ",")); err != nil {
+		lookup.Status = doctorFail
+		lookup.Detail = fmt.Sprintf("cannot resolve %s: %v", probeName, err)
+		lookup.Fix = "check the nameservers in the container's /etc/resolv.conf are reachable from the container"
+		if connect.Status == doctorOK {
+			lookup.Fix = "the container reaches the internet but its nameservers do not answer; put a public resolver in its /etc/resolv.conf"
+		}
+	}
+	return append(checks, lookup)
+}
+
+// checkVeth checks that the container's host veth is up and on the bridge
+func checkVeth(veth string) DoctorCheck {
+	check := DoctorCheck{Name: "veth", Status: doctorOK, Detail: veth + " attached to " + bridgeName}
+	iface, err := net.InterfaceByName(veth)
+	if err != nil {
+		check.Status = doctorFail
+		check.Detail = veth + " does not exist"
+		check.Fix = "restart the container to recreate its network"
+		return check
+	}
+	master, err := os.Readlink(filepath.Join(sysClassNet, veth, "master"))
+	if err != nil || filepath.Base(master) != bridgeName {
+		check.Status = doctorFail
+		check.Detail = veth + " is not attached to " + bridgeName
+		check.Fix = "ip link set " + veth + " master " + bridgeName
+	} else if iface.Flags&net.FlagUp == 0 {
+		check.Status = doctorFail
+		check.Detail = veth + " is down"
+		check.Fix = "ip link set " + veth + " up"
+	}
+	return check
+}
+
+// checkContainerRoute judges a container's /proc/net/route, whose default
+// route should go through the bridge
+func checkContainerRoute(table string) DoctorCheck {
+	check := DoctorCheck{Name: "container route", Status: doctorOK, Detail: "default via " + bridgeIP}
+	route, err := parseDefaultRoute(table)
+	if err != nil {
+		check.Status = doctorFail
+		check.Detail = "no default route"
+		check.Fix = "restart the container to restore its default route"
+	} else if gateway := route.gateway.String(); gateway != bridgeIP {
+		check.Status = doctorWarn
+		check.Detail = "default via " + gateway + ", not the bridge"
+		check.Fix = "the container's default route was changed; restart it to restore it"
+	}
+	return check
+}
+
+// nameservers returns the nameserver addresses of a resolv.conf
+func nameservers(resolvConf string) []string {
+	var servers []string
+	for _, line := range strings.Split(resolvConf, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// checkResolvConf judges a container's /etc/resolv.conf, which gocker leaves
+// as the root filesystem has it
+func checkResolvConf(resolvConf string, readErr error) DoctorCheck {
+	check := DoctorCheck{Name: "dns config", Status: doctorOK}
+	if readErr != nil {
+		check.Status = doctorFail
+		check.Detail = "no /etc/resolv.conf"
+		check.Fix = "add /etc/resolv.conf to the root filesystem, e.g. 'echo nameserver 1.1.1.1 > <rootfs>/etc/resolv.conf'"
+		return check
+	}
+	servers := nameservers(resolvConf)
+	if len(servers) == 0 {
+		check.Status = doctorFail
+		check.Detail = "no nameservers in /etc/resolv.conf"
+		check.Fix = "add a nameserver line to the root filesystem's /etc/resolv.conf"
+		return check
+	}
+	check.Detail = "nameservers " + strings.Join(servers, ", ")
+	for _, server := range servers {
+		if ip := net.ParseIP(server); ip == nil || !ip.IsLoopback() {
+			return check
+		}
+	}
+	// Copied from a host running systemd-resolved or dnsmasq, which listen on
+	// the host's loopback, not the container's
+	check.Status = doctorFail
+	check.Detail = "only loopback nameservers (" + strings.Join(servers, ", ") + "), which the container cannot reach"
+	check.Fix = "replace them in the root filesystem's /etc/resolv.conf with a reachable resolver, e.g. 'nameserver 1.1.1.1'"
+	return check
+}
+
+// runNetworkProbe runs a network-probe in the network namespace of pid
+func runNetworkProbe(pid int, args ...string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	output, err := netnsCommand(pid, executable, append([]string{"network-probe"}, args...)...).CombinedOutput()
+	if err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return fmt.Errorf("%s", message)
+		}
+		return err
+	}
+	return nil
+}
+
+// networkProbeCommand is run by runNetworkProbe inside a container's network
+// namespace, with the host's filesystem: 'connect host:port' or
+// 'lookup name nameserver,...'
+func networkProbeCommand(args []string) {
+	var err error
+	switch {
+	case len(args) == 2 && args[0] == "connect":
+		var conn net.Conn
+		if conn, err = net.DialTimeout("tcp", args[1], probeTimeout); err == nil {
+			conn.Close()
+		}
+	case len(args) == 3 && args[0] == "lookup":
+		err = probeLookup(args[1], strings.Split(args[2], ","))
+	default:
+		err = fmt.Errorf("usage: network-probe connect <host:port> | lookup <name> <nameserver,...>")
+	}
+	if err != nil {
+		// The error is the whole output, shown by the doctor as the detail
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// probeLookup resolves name with the given nameservers rather than the
+// host's /etc/resolv.conf, which the probe would otherwise read
+func probeLookup(name string, servers []string) error {
+	var lastErr error
+	for _, server := range servers {
+		resolver := &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, net.JoinHostPort(server, "53"))
+		}}
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		_, err := resolver.LookupHost(ctx, name)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s: %v", server, err)
+	}
+	return lastErr
+}
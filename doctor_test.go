@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiagnoseBridge(t *testing.T) {
+	tests := []struct {
+		name  string
+		up    bool
+		addrs []string
+		want  string
+		fix   string
+	}{
+		{"healthy", true, []string{"fe80::1/64", bridgeCIDR}, doctorOK, ""},
+		{"down", false, []string{bridgeCIDR}, doctorFail, "ip link set gocker0 up"},
+		{"no gateway", true, nil, doctorFail, "ip addr add " + bridgeCIDR + " dev gocker0"},
+	}
+	for _, tt := range tests {
+		check := diagnoseBridge(tt.up, tt.addrs)
+		if check.Status != tt.want || check.Fix != tt.fix {
+			t.Errorf("%s: diagnoseBridge() = %s %q, want %s %q", tt.name, check.Status, check.Fix, tt.want, tt.fix)
+		}
+	}
+}
+
+func TestCheckIPForwarding(t *testing.T) {
+	if check := checkIPForwarding("1"); check.Status != doctorOK {
+		t.Errorf("checkIPForwarding(1) = %s, want ok", check.Status)
+	}
+	for _, value := range []string{"0", ""} {
+		if check := checkIPForwarding(value); check.Status != doctorFail || !strings.Contains(check.Fix, "ip_forward=1") {
+			t.Errorf("checkIPForwarding(%q) = %s %q, want a failure suggesting ip_forward=1", value, check.Status, check.Fix)
+		}
+	}
+}
+
+func TestCheckNATRules(t *testing.T) {
+	host := useFakeHost(t)
+	if check := checkNATRules("eth0"); check.Status != doctorOK {
+		t.Errorf("checkNATRules() with all rules = %s %q, want ok", check.Status, check.Detail)
+	}
+	if got := len(host.ran("iptables -t nat -C")) + len(host.ran("iptables -C")); got != 3 {
+		t.Errorf("checkNATRules() checked %d rules, want 3", got)
+	}
+
+	host.failures = []string{"iptables -t nat -C POSTROUTING"}
+	check := checkNATRules("eth0")
+	want := "iptables -t nat -A POSTROUTING -s " + containerNet + " -o eth0 -j MASQUERADE"
+	if check.Status != doctorFail || check.Fix != want {
+		t.Errorf("checkNATRules() without MASQUERADE = %s %q, want fail %q", check.Status, check.Fix, want)
+	}
+	if !strings.HasPrefix(check.Detail, "1 of 3") {
+		t.Errorf("checkNATRules() detail = %q, want 1 of 3 missing", check.Detail)
+	}
+}
+
+func TestRuleCommand(t *testing.T) {
+	rules := natRules("eth0")
+	if got, want := ruleCommand("-C", rules[0])[:4], []string{"-t", "nat", "-C", "POSTROUTING"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ruleCommand(-C, nat rule) = %v, want prefix %v", got, want)
+	}
+	if got, want := ruleCommand("-A", rules[1])[:2], []string{"-A", "FORWARD"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ruleCommand(-A, filter rule) = %v, want prefix %v", got, want)
+	}
+}
+
+func TestCheckContainerRoute(t *testing.T) {
+	tests := []struct {
+		routes string
+		want   string
+	}{
+		{routeTableHeader + "eth0\t00000000\t0100000A\t0003\t0\t0\t0\t00000000\t0\t0\t0\n", doctorOK},
+		{routeTableHeader + "eth0\t00000000\t0101A8C0\t0003\t0\t0\t0\t00000000\t0\t0\t0\n", doctorWarn},
+		{routeTableHeader + "eth0\t0000000A\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n", doctorFail},
+	}
+	for _, tt := range tests {
+		if check := checkContainerRoute(tt.routes); check.Status != tt.want {
+			t.Errorf("checkContainerRoute(%q) = %s, want %s", tt.routes, check.Status, tt.want)
+		}
+	}
+}
+
+func TestCheckResolvConf(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     error
+		want    string
+	}{
+		{"public", "# generated\nnameserver 1.1.1.1\nsearch lan\n", nil, doctorOK},
+		{"stub and public", "nameserver 127.0.0.53\nnameserver 8.8.8.8\n", nil, doctorOK},
+		{"stub only", "nameserver 127.0.0.53\noptions edns0\n", nil, doctorFail},
+		{"empty", "search lan\n", nil, doctorFail},
+		{"missing", "", errors.New("no such file"), doctorFail},
+	}
+	for _, tt := range tests {
+		if check := checkResolvConf(tt.content, tt.err); check.Status != tt.want {
+			t.Errorf("%s: checkResolvConf() = %s %q, want %s", tt.name, check.Status, check.Detail, tt.want)
+		}
+	}
+}
+
+func TestNameservers(t *testing.T) {
+	got := nameservers("nameserver 10.0.0.2\n#nameserver 9.9.9.9\nnameserver  ::1 \ndomain example\n")
+	if want := []string{"10.0.0.2", "::1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nameservers() = %v, want %v", got, want)
+	}
+}
+
+func TestCheckFirewalldZone(t *testing.T) {
+	if check := checkFirewalldZone(firewalldZone); check.Status != doctorOK {
+		t.Errorf("checkFirewalldZone(gocker) = %s, want ok", check.Status)
+	}
+	check := checkFirewalldZone("public")
+	if check.Status != doctorFail || !strings.Contains(check.Detail, "public") || !strings.Contains(check.Fix, "--change-interface=gocker0") {
+		t.Errorf("checkFirewalldZone(public) = %s %q %q, want a failure naming the zone", check.Status, check.Detail, check.Fix)
+	}
+}
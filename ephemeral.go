@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Ephemeral containers ('run --ephemeral' or '--tmpdir') keep their directory
+// on a tmpfs, or on a bind-mounted scratch directory, and are removed as soon
+// as they exit, so nothing about them is left on the data root's disk
+
+// ephemeralScratchDir returns the scratch directory for a container under tmpDir
+func ephemeralScratchDir(tmpDir, containerID string) string {
+	return filepath.Join(tmpDir, "gocker-"+shortID(containerID))
+}
+
+// mountEphemeralDir mounts a tmpfs, or the container's scratch directory under
+// tmpDir if it is set, on the container's directory
+func mountEphemeralDir(containerID, tmpDir string) error {
+	if err := ensureStateDir(); err != nil {
+		return err
+	}
+	dir := containerDir(containerID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create container directory: %v", err)
+	}
+
+	if tmpDir == "" {
+		if err := mount("tmpfs", dir, "tmpfs", msNosuid|msNodev, "mode=0755"); err != nil {
+			os.Remove(dir)
+			return fmt.Errorf("failed to mount tmpfs for ephemeral container: %v", err)
+		}
+		return nil
+	}
+
+	scratch := ephemeralScratchDir(tmpDir, containerID)
+	if err := os.MkdirAll(scratch, 0755); err != nil {
+		os.Remove(dir)
+		return fmt.Errorf("failed to create scratch directory: %v", err)
+	}
+	if err := mount(scratch, dir, "", msBind, ""); err != nil {
+		os.Remove(dir)
+		os.Remove(scratch)
+		return fmt.Errorf("failed to mount scratch directory %s: %v", scratch, err)
+	}
+	return nil
+}
+
+// unmountEphemeralDir undoes mountEphemeralDir, deleting the scratch directory
+func unmountEphemeralDir(containerID, tmpDir string) {
+	dir := containerDir(containerID)
+	if err := unmount(dir, mntDetach); err != nil && err != syscall.EINVAL {
+		logger.Warn("Failed to unmount ephemeral container directory", "path", dir, "error", err)
+	}
+	os.Remove(dir)
+	if tmpDir != "" {
+		if err := os.RemoveAll(ephemeralScratchDir(tmpDir, containerID)); err != nil {
+			logger.Warn("Failed to remove scratch directory", "error", err)
+		}
+	}
+}
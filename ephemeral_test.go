@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestEphemeralScratchDir checks an ephemeral container's files live in its
+// scratch directory and are gone after the container is destroyed
+func TestEphemeralScratchDir(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+	tmpDir := t.TempDir()
+
+	if err := mountEphemeralDir("abc123", tmpDir); err != nil {
+		t.Skipf("cannot mount scratch directory: %v", err)
+	}
+	state := &ContainerState{ID: "abc123", Status: statusExited, Options: &RunOptions{Ephemeral: true, TmpDir: tmpDir}}
+	if err := saveContainerState(state); err != nil {
+		unmountEphemeralDir("abc123", tmpDir)
+		t.Fatalf("saveContainerState failed: %v", err)
+	}
+
+	scratch := ephemeralScratchDir(tmpDir, "abc123")
+	if _, err := os.Stat(filepath.Join(scratch, containerStateName)); err != nil {
+		t.Errorf("Expected state file in scratch directory: %v", err)
+	}
+
+	if _, err := destroyContainer("abc123", false); err != nil {
+		t.Fatalf("destroyContainer failed: %v", err)
+	}
+	for _, path := range []string{scratch, containerDir("abc123")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", path)
+		}
+	}
+}
+
+// TestReconcileEphemeral checks ephemeral containers found dead are removed
+func TestReconcileEphemeral(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run 'true': %v", err)
+	}
+	states := []*ContainerState{
+		{ID: "ephemeral", PID: cmd.Process.Pid, Status: statusRunning, Options: &RunOptions{Ephemeral: true}},
+		{ID: "durable", PID: cmd.Process.Pid, Status: statusRunning, Options: &RunOptions{}},
+	}
+	for _, state := range states {
+		if err := saveContainerState(state); err != nil {
+			t.Fatalf("saveContainerState failed: %v", err)
+		}
+	}
+
+	reconcileContainers()
+
+	if _, err := loadContainerState("ephemeral"); err == nil {
+		t.Error("Expected the dead ephemeral container to be removed")
+	}
+	if state, err := loadContainerState("durable"); err != nil || state.Status != statusExited {
+		t.Errorf("Expected the durable container to be kept as exited, got %+v (%v)", state, err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Container lifecycle events delivered to webhooks
+const (
+	eventStart    = "start"
+	eventPause    = "pause"
+	eventUnpause  = "unpause"
+	eventStop     = "stop"
+	eventDie      = "die"
+	eventOOM      = "oom"
+	eventThrottle = "throttle"
+	eventDestroy  = "destroy"
+)
+
+var eventNames = []string{eventStart, eventPause, eventUnpause, eventStop, eventDie, eventOOM, eventThrottle, eventDestroy}
+
+// webhookAttempts and webhookBackoff control delivery retries
+// The delay doubles after each failed attempt
+var (
+	webhookAttempts = 3
+	webhookBackoff  = 500 * time.Millisecond
+	webhookClient   = &http.Client{Timeout: 5 * time.Second}
+)
+
+// webhooks are the endpoints events are posted to (see Config.Webhooks)
+var webhooks []WebhookConfig
+
+// WebhookConfig is a URL that receives container events as JSON
+// If Secret is set, each request carries an HMAC-SHA256 signature of the body
+// in the X-Gocker-Signature header; Events limits delivery to the listed actions
+type WebhookConfig struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// Event describes a container lifecycle change
+// Container holds the container's full state, labels, and annotations
+type Event struct {
+	Action      string         `json:"action"`
+	ContainerID string         `json:"container_id"`
+	Status      string         `json:"status"`
+	Command     []string       `json:"command,omitempty"`
+	Time        time.Time      `json:"time"`
+	Container   *ContainerInfo `json:"container"`
+}
+
+// validateWebhook checks a webhook's URL and event filter
+func validateWebhook(hook WebhookConfig) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url: %s (expected http:// or https://)", hook.URL)
+	}
+	for _, event := range hook.Events {
+		if !containsString(eventNames, event) {
+			return fmt.Errorf("unknown webhook event: %s (expected one of %s)", event, strings.Join(eventNames, ", "))
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// statusEvents returns the events for a status transition
+// A container that dies after running out of memory also produces an oom event
+func statusEvents(from string, state *ContainerState) []string {
+	switch state.Status {
+	case statusRunning:
+		if from == statusPaused {
+			return []string{eventUnpause}
+		}
+		return []string{eventStart}
+	case statusPaused:
+		return []string{eventPause}
+	case statusStopped:
+		return []string{eventStop}
+	case statusExited:
+		if cgroupOOMKilled(state.CgroupPath) {
+			return []string{eventOOM, eventDie}
+		}
+		return []string{eventDie}
+	}
+	return nil
+}
+
+// cgroupOOMKilled reports whether the OOM killer killed a process in the cgroup
+func cgroupOOMKilled(cgroupPath string) bool {
+	if cgroupPath == "" {
+		return false
+	}
+	return cgroups.OOMKilled(cgroupPath)
+}
+
+// emitEvent posts an event to every webhook subscribed to it and waits for delivery
+// Failures are logged; they never fail the command that caused the event
+func emitEvent(action string, state *ContainerState) {
+	if len(webhooks) == 0 {
+		return
+	}
+
+	event := Event{Action: action, ContainerID: state.ID, Status: state.Status, Command: state.Command, Time: time.Now().UTC(), Container: newContainerInfo(state)}
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Warn("Failed to marshal event", "error", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, hook := range webhooks {
+		if len(hook.Events) > 0 && !containsString(hook.Events, action) {
+			continue
+		}
+		wg.Add(1)
+		go func(hook WebhookConfig) {
+			defer wg.Done()
+			if err := deliverWebhook(hook, action, body); err != nil {
+				logger.Warn("Failed to deliver webhook", "url", hook.URL, "event", action, "error", err)
+			}
+		}(hook)
+	}
+	wg.Wait()
+}
+
+// deliverWebhook posts body to a webhook, retrying on errors and non-2xx responses
+func deliverWebhook(hook WebhookConfig, action string, body []byte) error {
+	var lastErr error
+	delay := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gocker-Event", action)
+		if hook.Secret != "" {
+			req.Header.Set("X-Gocker-Signature", "sha256="+signPayload(hook.Secret, body))
+		}
+
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return fmt.Errorf("giving up after %d attempts: %v", webhookAttempts, lastErr)
+}
+
+// signPayload returns the hex HMAC-SHA256 of body keyed with secret
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestEmitEventWebhook verifies events are signed, retried, and filtered per webhook
+func TestEmitEventWebhook(t *testing.T) {
+	restoreRuntimeSettings(t)
+	savedBackoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	t.Cleanup(func() { webhookBackoff = savedBackoff })
+
+	var mu sync.Mutex
+	var requests int
+	var received []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		// Fail the first attempt to exercise retries
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get("X-Gocker-Signature"), "sha256="+signPayload("s3cret", body); got != want {
+			t.Errorf("Expected signature %s, got %s", want, got)
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("Failed to parse event: %v", err)
+		}
+		if r.Header.Get("X-Gocker-Event") != event.Action {
+			t.Errorf("X-Gocker-Event %q does not match action %q", r.Header.Get("X-Gocker-Event"), event.Action)
+		}
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	webhooks = []WebhookConfig{{URL: server.URL, Secret: "s3cret", Events: []string{eventStart, eventDie}}}
+
+	state := &ContainerState{ID: "abc123", Status: statusRunning, Command: []string{"/bin/sh"}, Options: &RunOptions{Annotations: []string{"owner=ops"}}}
+	emitEvent(eventStart, state)
+	emitEvent(eventPause, state) // filtered out
+
+	if requests != 2 {
+		t.Errorf("Expected 2 requests (one retry), got %d", requests)
+	}
+	if len(received) != 1 || received[0].Action != eventStart || received[0].ContainerID != "abc123" {
+		t.Errorf("Expected one start event for abc123, got %+v", received)
+	} else if info := received[0].Container; info == nil || info.SchemaVersion != containerInfoVersion || info.Annotations["owner"] != "ops" {
+		t.Errorf("Expected the container's info in the event, got %+v", info)
+	}
+}
+
+// TestDeliverWebhookGivesUp verifies delivery stops after the configured attempts
+func TestDeliverWebhookGivesUp(t *testing.T) {
+	savedBackoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	t.Cleanup(func() { webhookBackoff = savedBackoff })
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	if err := deliverWebhook(WebhookConfig{URL: server.URL}, eventDie, []byte("{}")); err == nil {
+		t.Error("Expected error after repeated failures")
+	}
+	if requests != webhookAttempts {
+		t.Errorf("Expected %d attempts, got %d", webhookAttempts, requests)
+	}
+}
+
+// TestStatusEvents verifies state transitions map to lifecycle events
+func TestStatusEvents(t *testing.T) {
+	cgroup := t.TempDir()
+	if err := os.WriteFile(filepath.Join(cgroup, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write memory.events: %v", err)
+	}
+
+	tests := []struct {
+		from  string
+		state *ContainerState
+		want  []string
+	}{
+		{statusCreated, &ContainerState{Status: statusRunning}, []string{eventStart}},
+		{statusRunning, &ContainerState{Status: statusPaused}, []string{eventPause}},
+		{statusPaused, &ContainerState{Status: statusRunning}, []string{eventUnpause}},
+		{statusRunning, &ContainerState{Status: statusStopped}, []string{eventStop}},
+		{statusRunning, &ContainerState{Status: statusExited}, []string{eventDie}},
+		{statusRunning, &ContainerState{Status: statusExited, CgroupPath: cgroup}, []string{eventOOM, eventDie}},
+	}
+
+	for _, tt := range tests {
+		got := statusEvents(tt.from, tt.state)
+		if len(got) != len(tt.want) {
+			t.Errorf("statusEvents(%s -> %s) = %v, want %v", tt.from, tt.state.Status, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("statusEvents(%s -> %s) = %v, want %v", tt.from, tt.state.Status, got, tt.want)
+			}
+		}
+	}
+}
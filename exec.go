@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ExecOptions holds the options for 'gocker exec'
+type ExecOptions struct {
+	User        string
+	Env         []string
+	WorkDir     string
+	Detached    bool
+	Interactive bool
+	TTY         bool
+	Command     []string
+}
+
+// ExecSession is a process started in a running container with 'gocker exec'
+type ExecSession struct {
+	ID        string    `json:"id"`
+	PID       int       `json:"pid"`
+	StartTime uint64    `json:"start_time,omitempty"`
+	Command   []string  `json:"command"`
+	User      string    `json:"user,omitempty"`
+	WorkDir   string    `json:"workdir,omitempty"`
+	TTY       bool      `json:"tty"`
+	Detached  bool      `json:"detached"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// execUser is a user resolved from the container's /etc/passwd and /etc/group
+type execUser struct {
+	UID, GID int
+	Home     string
+}
+
+func execCommand(args []string) {
+	opts := &ExecOptions{}
+	flags := newCommandFlags("exec", "[options] <container-id> <command> [args...]", "Run a command in a running container")
+	flags.StringVar(&opts.User, "user", "u", "user[:group]", "Run as this user (name or UID, optionally with group)")
+	flags.StringSliceVar(&opts.Env, "env", "e", "KEY=VALUE", "Set an environment variable (repeatable; KEY alone copies it from the host)")
+	flags.StringVar(&opts.WorkDir, "workdir", "w", "dir", "Working directory inside the container (default: /)")
+	flags.BoolVar(&opts.Detached, "detach", "d", "Run the command in the background")
+	flags.BoolVar(&opts.Interactive, "interactive", "i", "Keep stdin open")
+	flags.BoolVar(&opts.TTY, "tty", "t", "Allocate a pseudo-terminal")
+	args = flags.MustParse(args)
+	if len(args) < 2 {
+		flags.Fail("container ID and command required")
+	}
+	if opts.Detached && (opts.Interactive || opts.TTY) {
+		flags.Fail("--detach cannot be combined with --interactive or --tty")
+	}
+	if opts.TTY && !isTerminal(os.Stdin) {
+		flags.Fail("the input device is not a TTY")
+	}
+	opts.Command = args[1:]
+
+	requireRoot()
+	os.Exit(execInContainer(args[0], opts))
+}
+
+// execInContainer runs a command inside a running container's namespaces,
+// root filesystem and cgroup, and returns its exit code
+func execInContainer(containerID string, opts *ExecOptions) int {
+	state, err := loadContainerState(containerID)
+	must(err)
+	if state.Status != statusRunning || !isProcessAlive(state) {
+		must(fmt.Errorf("container %s is not running", shortID(state.ID)))
+	}
+
+	user, err := resolveExecUser(filepath.Join("/proc", strconv.Itoa(state.PID), "root"), opts.User)
+	must(err)
+
+	userns := state.Options != nil && state.Options.UsernsRemap != ""
+	cmd := exec.Command("nsenter", nsenterArgs(state.PID, user, userns, opts)...)
+	cmd.Env = containerEnv(state.ID, user.Home, opts.TTY, opts.Env)
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+
+	// Start in the container's cgroup so its limits apply
+	var cgroup *os.File
+	if state.CgroupPath != "" {
+		var err error
+		cgroup, err = cgroups.StartDir(state.CgroupPath)
+		must(err)
+	}
+	if cgroup != nil {
+		defer cgroup.Close()
+		startInCgroup(cmd.SysProcAttr, cgroup)
+	}
+
+	var master, slave *os.File
+	switch {
+	case opts.TTY:
+		master, slave, err = openPTY()
+		must(err)
+		defer master.Close()
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+		newSession(cmd.SysProcAttr, true)
+		copyWindowSize(os.Stdin, master)
+	case opts.Detached:
+		// Detached output goes to the container log, if it has one
+		if state.LogFile != "" {
+			logFile, err := os.OpenFile(state.LogFile, os.O_WRONLY|os.O_APPEND, 0644)
+			if err == nil {
+				defer logFile.Close()
+				cmd.Stdout, cmd.Stderr = logFile, logFile
+			}
+		}
+		newSession(cmd.SysProcAttr, false)
+	default:
+		if opts.Interactive {
+			cmd.Stdin = os.Stdin
+		}
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	}
+
+	err = cmd.Start()
+	if slave != nil {
+		// Only the exec process may hold the slave, so reads from the master
+		// end when it exits
+		slave.Close()
+	}
+	if err != nil {
+		must(fmt.Errorf("failed to start exec: %v", err))
+	}
+	// Without cgroup v2, nsenter joins the cgroup just after starting, which
+	// is normally before it has entered the namespaces and forked the command
+	if cgroup == nil && state.CgroupPath != "" {
+		if err := cgroups.AddProcess(state.CgroupPath, cmd.Process.Pid); err != nil {
+			logger.Warn("Failed to add exec process to container cgroup", "error", err)
+		}
+	}
+
+	session := ExecSession{
+		ID:        generateExecID(),
+		PID:       cmd.Process.Pid,
+		Command:   opts.Command,
+		User:      opts.User,
+		WorkDir:   opts.WorkDir,
+		TTY:       opts.TTY,
+		Detached:  opts.Detached,
+		StartedAt: time.Now(),
+	}
+	if startTime, err := processStartTime(session.PID); err == nil {
+		session.StartTime = startTime
+	}
+	if err := addExecSession(state.ID, session); err != nil {
+		logger.Warn("Failed to record exec session", "error", err)
+	}
+
+	if opts.Detached {
+		fmt.Println(session.ID)
+		return 0
+	}
+
+	if opts.TTY {
+		restore, err := makeRaw(os.Stdin)
+		if err == nil {
+			defer restore()
+		}
+
+		resize := make(chan os.Signal, 1)
+		notifyResize(resize)
+		defer signal.Stop(resize)
+		go func() {
+			for range resize {
+				copyWindowSize(os.Stdin, master)
+			}
+		}()
+
+		if opts.Interactive {
+			go io.Copy(master, os.Stdin)
+		}
+		// Reading the master fails with EIO once the session's last process exits
+		io.Copy(os.Stdout, master)
+	}
+
+	waitErr := cmd.Wait()
+	if err := removeExecSession(state.ID, session.ID); err != nil && err != errStateUnchanged {
+		logger.Warn("Failed to remove exec session", "error", err)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(waitErr, &exitErr) {
+		return exitStatus(exitErr.ProcessState)
+	}
+	if waitErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", waitErr)
+		return 1
+	}
+	return 0
+}
+
+// nsenterArgs returns the nsenter arguments that enter a container's namespaces
+// and root filesystem and run the exec command as the resolved user
+// With userns the user namespace is entered too, so the user's IDs are those
+// of the container's mapping rather than the host's
+func nsenterArgs(pid int, user *execUser, userns bool, opts *ExecOptions) []string {
+	workDir := opts.WorkDir
+	if workDir == "" {
+		workDir = "/"
+	}
+	args := []string{
+		"--target", strconv.Itoa(pid),
+		"--mount", "--uts", "--net", "--pid", "--cgroup",
+	}
+	if userns {
+		args = append(args, "--user")
+	}
+	args = append(args,
+		"--root", "--wd="+workDir,
+		"--setgid", strconv.Itoa(user.GID),
+		"--setuid", strconv.Itoa(user.UID),
+		"--",
+	)
+	return append(args, opts.Command...)
+}
+
+// resolveExecUser resolves a user[:group] spec against the container's
+// /etc/passwd and /etc/group; numeric IDs need not exist in those files
+func resolveExecUser(rootDir, spec string) (*execUser, error) {
+	user := &execUser{Home: "/"}
+	if spec == "" {
+		spec = "0"
+	}
+	userPart, groupPart, hasGroup := strings.Cut(spec, ":")
+
+	passwd, _ := readColonFile(filepath.Join(rootDir, "etc/passwd"))
+	uid, numeric := parseID(userPart)
+	found := false
+	for _, fields := range passwd {
+		if len(fields) < 6 {
+			continue
+		}
+		entryUID, _ := parseID(fields[2])
+		if (numeric && entryUID == uid) || (!numeric && fields[0] == userPart) {
+			user.UID = entryUID
+			user.GID, _ = parseID(fields[3])
+			user.Home = fields[5]
+			found = true
+			break
+		}
+	}
+	if !found {
+		if !numeric {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+		user.UID = uid
+	}
+
+	if hasGroup {
+		gid, numeric := parseID(groupPart)
+		if !numeric {
+			groups, _ := readColonFile(filepath.Join(rootDir, "etc/group"))
+			found := false
+			for _, fields := range groups {
+				if len(fields) >= 3 && fields[0] == groupPart {
+					gid, _ = parseID(fields[2])
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupPart)
+			}
+		}
+		user.GID = gid
+	}
+	return user, nil
+}
+
+// parseID parses a non-negative numeric user or group ID
+func parseID(s string) (int, bool) {
+	id, err := strconv.Atoi(s)
+	return id, err == nil && id >= 0
+}
+
+// readColonFile reads a colon-separated file such as /etc/passwd
+func readColonFile(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	return entries, scanner.Err()
+}
+
+// generateExecID returns a random exec session ID
+func generateExecID() string {
+	randomBytes := make([]byte, 6)
+	rand.Read(randomBytes)
+	return hex.EncodeToString(randomBytes)
+}
+
+// addExecSession records an exec session in the container state
+func addExecSession(containerID string, session ExecSession) error {
+	return updateContainerState(containerID, func(state *ContainerState) error {
+		state.Execs = append(state.Execs, session)
+		return nil
+	})
+}
+
+// removeExecSession drops an exec session from the container state
+func removeExecSession(containerID, execID string) error {
+	return updateContainerState(containerID, func(state *ContainerState) error {
+		for i, session := range state.Execs {
+			if session.ID == execID {
+				state.Execs = append(state.Execs[:i], state.Execs[i+1:]...)
+				return nil
+			}
+		}
+		return errStateUnchanged
+	})
+}
+
+// liveExecSessions returns the exec sessions whose process is still running
+func liveExecSessions(execs []ExecSession) []ExecSession {
+	var live []ExecSession
+	for _, session := range execs {
+		if processAlive(session.PID, session.StartTime) {
+			live = append(live, session)
+		}
+	}
+	return live
+}
+
+func inspectCommand(args []string) {
+	var security bool
+	flags := newCommandFlags("inspect", "<container-id> [options]", "Show detailed container information")
+	flags.interspersed = true
+	flags.BoolVar(&security, "security", "", "Show only the running container's effective security configuration")
+	ids := flags.MustParse(args)
+	if len(ids) != 1 {
+		flags.Fail("container ID required")
+	}
+	requireRoot()
+
+	state, err := loadContainerState(ids[0])
+	must(err)
+
+	// Drop sessions that ended without being removed, e.g. detached execs
+	if live := liveExecSessions(state.Execs); len(live) != len(state.Execs) {
+		err := updateContainerState(state.ID, func(s *ContainerState) error {
+			s.Execs = liveExecSessions(s.Execs)
+			return nil
+		})
+		if err != nil {
+			logger.Warn("Failed to prune exec sessions", "error", err)
+		}
+		state.Execs = live
+	}
+
+	// A running container also shows its init process's live security context
+	output := struct {
+		*ContainerState
+		Process  *ProcessInfo  `json:"process,omitempty"`
+		Security *SecurityInfo `json:"security,omitempty"`
+	}{ContainerState: state}
+	running := isActive(state.Status) && isProcessAlive(state)
+	if running {
+		info, err := readProcessInfo(state.PID)
+		if err != nil {
+			logger.Warn("Failed to read container process", "error", err)
+		} else if output.Security, err = readSecurityInfo(state, info); err != nil {
+			logger.Warn("Failed to read container security configuration", "error", err)
+		}
+		output.Process = info
+	}
+
+	if security {
+		if !running {
+			must(fmt.Errorf("container %s is not running", shortID(state.ID)))
+		}
+		if output.Security == nil {
+			must(fmt.Errorf("failed to read the security configuration of container %s", shortID(state.ID)))
+		}
+		data, err := json.MarshalIndent(output.Security, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+		return
+	}
+
+	data, err := json.MarshalIndent(output, "", "  ")
+	must(err)
+	fmt.Println(string(data))
+}
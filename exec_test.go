@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestResolveExecUser checks user[:group] specs against a container's passwd and group files
+func TestResolveExecUser(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatalf("Failed to create etc: %v", err)
+	}
+	passwd := "root:x:0:0:root:/root:/bin/sh\n# comment\nwww:x:33:33:www:/var/www:/bin/false\n"
+	group := "root:x:0:\nwww:x:33:\nstaff:x:50:www\n"
+	if err := os.WriteFile(filepath.Join(root, "etc/passwd"), []byte(passwd), 0644); err != nil {
+		t.Fatalf("Failed to write passwd: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "etc/group"), []byte(group), 0644); err != nil {
+		t.Fatalf("Failed to write group: %v", err)
+	}
+
+	tests := []struct {
+		spec    string
+		want    execUser
+		wantErr bool
+	}{
+		{"", execUser{UID: 0, GID: 0, Home: "/root"}, false},
+		{"www", execUser{UID: 33, GID: 33, Home: "/var/www"}, false},
+		{"33", execUser{UID: 33, GID: 33, Home: "/var/www"}, false},
+		{"www:staff", execUser{UID: 33, GID: 50, Home: "/var/www"}, false},
+		{"1000:1000", execUser{UID: 1000, GID: 1000, Home: "/"}, false},
+		{"nobody", execUser{}, true},
+		{"www:nogroup", execUser{}, true},
+	}
+
+	for _, tt := range tests {
+		user, err := resolveExecUser(root, tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolveExecUser(%q): expected error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveExecUser(%q): unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if *user != tt.want {
+			t.Errorf("resolveExecUser(%q) = %+v, want %+v", tt.spec, *user, tt.want)
+		}
+	}
+}
+
+// TestNsenterArgs checks the namespaces, user and working directory passed to nsenter
+func TestNsenterArgs(t *testing.T) {
+	user := &execUser{UID: 33, GID: 50}
+	got := strings.Join(nsenterArgs(42, user, false, &ExecOptions{WorkDir: "/srv", Command: []string{"ls", "-l"}}), " ")
+	want := "--target 42 --mount --uts --net --pid --cgroup --root --wd=/srv --setgid 50 --setuid 33 -- ls -l"
+	if got != want {
+		t.Errorf("nsenterArgs = %q, want %q", got, want)
+	}
+
+	got = strings.Join(nsenterArgs(42, user, false, &ExecOptions{Command: []string{"sh"}}), " ")
+	if !strings.Contains(got, "--wd=/ ") {
+		t.Errorf("Expected default working directory /, got %q", got)
+	}
+
+	// A remapped container's user namespace is entered so IDs are mapped
+	got = strings.Join(nsenterArgs(42, user, true, &ExecOptions{Command: []string{"sh"}}), " ")
+	if !strings.Contains(got, "--cgroup --user --root") {
+		t.Errorf("Expected --user for a remapped container, got %q", got)
+	}
+}
+
+// TestExecSessions checks exec sessions are recorded, removed, and pruned when dead
+func TestExecSessions(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	if err := saveContainerState(&ContainerState{ID: "abc123", Status: statusRunning}); err != nil {
+		t.Fatalf("saveContainerState failed: %v", err)
+	}
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run 'true': %v", err)
+	}
+	startTime, _ := processStartTime(os.Getpid())
+
+	sessions := []ExecSession{
+		{ID: "live", PID: os.Getpid(), StartTime: startTime},
+		{ID: "dead", PID: cmd.Process.Pid},
+		{ID: "done", PID: os.Getpid(), StartTime: startTime},
+	}
+	for _, session := range sessions {
+		if err := addExecSession("abc123", session); err != nil {
+			t.Fatalf("addExecSession failed: %v", err)
+		}
+	}
+	if err := removeExecSession("abc123", "done"); err != nil {
+		t.Fatalf("removeExecSession failed: %v", err)
+	}
+	if err := removeExecSession("abc123", "missing"); err != errStateUnchanged {
+		t.Errorf("Expected errStateUnchanged for a missing session, got %v", err)
+	}
+
+	state, err := loadContainerState("abc123")
+	if err != nil {
+		t.Fatalf("loadContainerState failed: %v", err)
+	}
+	if len(state.Execs) != 2 {
+		t.Fatalf("Expected 2 recorded sessions, got %+v", state.Execs)
+	}
+	live := liveExecSessions(state.Execs)
+	if len(live) != 1 || live[0].ID != "live" {
+		t.Errorf("Expected only the live session, got %+v", live)
+	}
+}
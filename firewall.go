@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// firewallChainPrefix names the per-container filter chains
+const firewallChainPrefix = "GOCKER-"
+
+// exposedPort is a container port that accepts inbound traffic
+type exposedPort struct {
+	port     int
+	protocol string
+}
+
+// firewallPolicy is the inbound policy of a container run with --expose or
+// --allow-from: exposed ports accept any source, published ports accept the
+// --allow-from sources (any, if there are none), and everything else is dropped
+type firewallPolicy struct {
+	exposed   []exposedPort
+	published []exposedPort
+	allowFrom []string
+}
+
+// newFirewallPolicy validates --expose and --allow-from values
+// It returns nil when neither is given and the container is not firewalled
+func newFirewallPolicy(expose, allowFrom []string, published []PortMapping) (*firewallPolicy, error) {
+	if len(expose) == 0 && len(allowFrom) == 0 {
+		return nil, nil
+	}
+	if len(allowFrom) > 0 && len(published) == 0 {
+		return nil, fmt.Errorf("--allow-from restricts published ports and requires -p")
+	}
+
+	policy := &firewallPolicy{}
+	for _, spec := range expose {
+		port, protocol, hasProtocol := strings.Cut(spec, "/")
+		if !hasProtocol {
+			protocol = "tcp"
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil || !validPort(n) || (protocol != "tcp" && protocol != "udp") {
+			return nil, fmt.Errorf("invalid exposed port: %s (expected port[/tcp|udp])", spec)
+		}
+		policy.exposed = append(policy.exposed, exposedPort{port: n, protocol: protocol})
+	}
+	for _, mapping := range published {
+		policy.published = append(policy.published, exposedPort{port: mapping.ContainerPort, protocol: mapping.Protocol})
+	}
+	for _, source := range allowFrom {
+		// A bare address allows just that host
+		if ip := net.ParseIP(source); ip != nil {
+			if ip.To4() == nil {
+				return nil, fmt.Errorf("invalid allow-from: %s (only IPv4 is supported)", source)
+			}
+			source += "/32"
+		}
+		_, ipNet, err := net.ParseCIDR(source)
+		if err != nil || ipNet.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid allow-from: %s (expected an IPv4 address or CIDR)", source)
+		}
+		policy.allowFrom = append(policy.allowFrom, ipNet.String())
+	}
+	return policy, nil
+}
+
+// rules returns the container chain's rules in order
+// Replies to the container's own connections are always let through
+func (p *firewallPolicy) rules() [][]string {
+	rules := [][]string{{"-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "RETURN"}}
+	for _, port := range p.exposed {
+		rules = append(rules, port.match("-j", "RETURN"))
+	}
+	for _, port := range p.published {
+		if len(p.allowFrom) == 0 {
+			rules = append(rules, port.match("-j", "RETURN"))
+			continue
+		}
+		for _, source := range p.allowFrom {
+			rules = append(rules, port.match("-s", source, "-j", "RETURN"))
+		}
+	}
+	return append(rules, []string{"-j", "DROP"})
+}
+
+// match returns the rule matching traffic to the port, followed by extra
+func (e exposedPort) match(extra ...string) []string {
+	return append([]string{"-p", e.protocol, "--dport", strconv.Itoa(e.port)}, extra...)
+}
+
+// firewallChain returns the name of a container's filter chain
+func firewallChain(containerID string) string {
+	return firewallChainPrefix + shortID(containerID)
+}
+
+// firewallJumpArgs returns the FORWARD rule sending traffic bound for the
+// container through its chain
+func firewallJumpArgs(containerID, containerIP string) []string {
+	return []string{"FORWARD", "-o", bridgeName, "-d", containerIP, "-j", firewallChain(containerID)}
+}
+
+// setupFirewall creates a container's chain and hooks it into FORWARD
+func setupFirewall(containerID, containerIP string, policy *firewallPolicy) error {
+	chain := firewallChain(containerID)
+	// A chain left behind by a crash is reused
+	if hostCommand("iptables", "-N", chain).Run() != nil {
+		if output, err := hostCommand("iptables", "-F", chain).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to create firewall chain %s: %v: %s", chain, err, strings.TrimSpace(string(output)))
+		}
+	}
+	commands := [][]string{}
+	for _, rule := range policy.rules() {
+		commands = append(commands, append([]string{"-A", chain}, rule...))
+	}
+	commands = append(commands, append([]string{"-I"}, firewallJumpArgs(containerID, containerIP)...))
+	for _, args := range commands {
+		if output, err := hostCommand("iptables", args...).CombinedOutput(); err != nil {
+			removeFirewall(containerID, containerIP)
+			return fmt.Errorf("failed to add firewall rule: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// removeFirewall unhooks and deletes a container's chain, if it has one
+func removeFirewall(containerID, containerIP string) {
+	chain := firewallChain(containerID)
+	jump := firewallJumpArgs(containerID, containerIP)
+	if hostCommand("iptables", append([]string{"-C"}, jump...)...).Run() == nil {
+		hostCommand("iptables", append([]string{"-D"}, jump...)...).Run()
+	}
+	if hostCommand("iptables", "-F", chain).Run() != nil {
+		return
+	}
+	if err := hostCommand("iptables", "-X", chain).Run(); err != nil {
+		logger.Warn("Failed to remove firewall chain", "chain", chain, "error", err)
+	}
+}
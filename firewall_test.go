@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewFirewallPolicy(t *testing.T) {
+	if policy, err := newFirewallPolicy(nil, nil, nil); policy != nil || err != nil {
+		t.Errorf("Expected no policy without --expose or --allow-from, got %+v (%v)", policy, err)
+	}
+
+	published := []PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}}
+	policy, err := newFirewallPolicy([]string{"9000", "53/udp"}, []string{"192.168.1.0/24", "10.1.2.3", "172.16.5.9/16"}, published)
+	if err != nil {
+		t.Fatalf("newFirewallPolicy failed: %v", err)
+	}
+	if want := []exposedPort{{9000, "tcp"}, {53, "udp"}}; !reflect.DeepEqual(policy.exposed, want) {
+		t.Errorf("exposed = %+v, want %+v", policy.exposed, want)
+	}
+	if want := []string{"192.168.1.0/24", "10.1.2.3/32", "172.16.0.0/16"}; !reflect.DeepEqual(policy.allowFrom, want) {
+		t.Errorf("allowFrom = %v, want %v", policy.allowFrom, want)
+	}
+
+	tests := []struct {
+		expose, allowFrom []string
+		published         []PortMapping
+	}{
+		{[]string{"http"}, nil, nil},
+		{[]string{"80/sctp"}, nil, nil},
+		{[]string{"70000"}, nil, nil},
+		{nil, []string{"10.0.0.0/8"}, nil},
+		{nil, []string{"not-a-cidr"}, published},
+		{nil, []string{"fd00::/8"}, published},
+	}
+	for _, tt := range tests {
+		if _, err := newFirewallPolicy(tt.expose, tt.allowFrom, tt.published); err == nil {
+			t.Errorf("newFirewallPolicy(%v, %v): expected error", tt.expose, tt.allowFrom)
+		}
+	}
+}
+
+func TestFirewallRules(t *testing.T) {
+	policy := &firewallPolicy{
+		exposed:   []exposedPort{{9000, "tcp"}},
+		published: []exposedPort{{80, "tcp"}},
+		allowFrom: []string{"192.168.1.0/24", "10.1.2.3/32"},
+	}
+	var got []string
+	for _, rule := range policy.rules() {
+		got = append(got, strings.Join(rule, " "))
+	}
+	want := []string{
+		"-m conntrack --ctstate ESTABLISHED,RELATED -j RETURN",
+		"-p tcp --dport 9000 -j RETURN",
+		"-p tcp --dport 80 -s 192.168.1.0/24 -j RETURN",
+		"-p tcp --dport 80 -s 10.1.2.3/32 -j RETURN",
+		"-j DROP",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rules =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Without --allow-from published ports accept any source
+	policy.allowFrom = nil
+	if rule := strings.Join(policy.rules()[2], " "); rule != "-p tcp --dport 80 -j RETURN" {
+		t.Errorf("Expected unrestricted published port, got %q", rule)
+	}
+
+	if jump := strings.Join(firewallJumpArgs("abcdef0123456789", "10.0.0.7"), " "); jump != "FORWARD -o gocker0 -d 10.0.0.7 -j GOCKER-abcdef012345" {
+		t.Errorf("firewallJumpArgs = %q", jump)
+	}
+}
+
+func TestSetupFirewall(t *testing.T) {
+	host := useFakeHost(t)
+	policy := &firewallPolicy{exposed: []exposedPort{{9000, "tcp"}}}
+
+	if err := setupFirewall("abcdef0123456789", "10.0.0.7", policy); err != nil {
+		t.Fatalf("setupFirewall failed: %v", err)
+	}
+	want := []string{
+		"iptables -N GOCKER-abcdef012345",
+		"iptables -A GOCKER-abcdef012345 -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN",
+		"iptables -A GOCKER-abcdef012345 -p tcp --dport 9000 -j RETURN",
+		"iptables -A GOCKER-abcdef012345 -j DROP",
+		"iptables -I FORWARD -o gocker0 -d 10.0.0.7 -j GOCKER-abcdef012345",
+	}
+	if got := host.ran("iptables"); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A failed rule removes the half-built chain
+	host = useFakeHost(t)
+	host.failures = []string{"iptables -I FORWARD"}
+	if err := setupFirewall("abcdef0123456789", "10.0.0.7", policy); err == nil {
+		t.Fatal("Expected error when the jump rule cannot be added")
+	}
+	if got := host.ran("iptables -X"); len(got) != 1 {
+		t.Errorf("Expected the chain to be deleted after a failure, got %v", got)
+	}
+}
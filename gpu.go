@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// devDir is where the host's device nodes are looked up
+var devDir = "/dev"
+
+// nvidiaContainerCLI is the NVIDIA container toolkit's tool for making GPUs
+// and their driver libraries available in a container
+const nvidiaContainerCLI = "nvidia-container-cli"
+
+// nvidiaControlDevices are the driver's device nodes shared by every GPU; only
+// nvidiactl is required, the others exist once their modules are loaded
+var nvidiaControlDevices = []string{"nvidiactl", "nvidia-uvm", "nvidia-uvm-tools", "nvidia-modeset"}
+
+// nvidiaLibraries are the driver libraries compute and monitoring workloads
+// load, matched by name up to ".so"
+var nvidiaLibraries = []string{"libcuda", "libcudadebugger", "libnvidia-ml", "libnvidia-ptxjitcompiler", "libnvidia-nvvm", "libnvidia-opencl", "libnvidia-cfg", "libnvidia-allocator"}
+
+// nvidiaTools are the driver utilities made available alongside the libraries
+var nvidiaTools = []string{"nvidia-smi"}
+
+// parseGPUs parses --gpus, 'all' or 'device=0[,1...]', into the device list
+// nvidia-container-cli takes: "all" or comma-separated GPU indexes
+func parseGPUs(spec string) (string, error) {
+	if spec == "" || spec == "all" {
+		return spec, nil
+	}
+	list, ok := strings.CutPrefix(spec, "device=")
+	if !ok || list == "" {
+		return "", fmt.Errorf("invalid --gpus: %s (expected 'all' or 'device=0[,1...]')", spec)
+	}
+	for _, index := range strings.Split(list, ",") {
+		if n, err := strconv.Atoi(index); err != nil || n < 0 {
+			return "", fmt.Errorf("invalid GPU index in --gpus: %q", index)
+		}
+	}
+	return list, nil
+}
+
+// gpuDeviceNodes returns the device nodes for devices (as from parseGPUs)
+func gpuDeviceNodes(devices string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(devDir, "nvidiactl")); err != nil {
+		return nil, fmt.Errorf("no NVIDIA driver found: %v", err)
+	}
+	var nodes []string
+	for _, name := range nvidiaControlDevices {
+		path := filepath.Join(devDir, name)
+		if _, err := os.Stat(path); err == nil {
+			nodes = append(nodes, path)
+		}
+	}
+
+	if devices == "all" {
+		gpus, _ := filepath.Glob(filepath.Join(devDir, "nvidia[0-9]*"))
+		if len(gpus) == 0 {
+			return nil, fmt.Errorf("no NVIDIA GPUs found in %s", devDir)
+		}
+		sort.Strings(gpus)
+		return append(nodes, gpus...), nil
+	}
+	for _, index := range strings.Split(devices, ",") {
+		path := filepath.Join(devDir, "nvidia"+index)
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("GPU %s not found: %v", index, err)
+		}
+		nodes = append(nodes, path)
+	}
+	return nodes, nil
+}
+
+// nvidiaLibraryPaths finds the driver libraries in the host's ld.so cache
+func nvidiaLibraryPaths() ([]string, error) {
+	output, err := hostCommand("ldconfig", "-p").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list host libraries: %v", err)
+	}
+	var paths []string
+	for _, line := range strings.Split(string(output), "\n") {
+		// e.g. "	libcuda.so.1 (libc6,x86-64) => /usr/lib/x86_64-linux-gnu/libcuda.so.1"
+		name, path, ok := strings.Cut(strings.TrimSpace(line), " => ")
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(name, " ")
+		base, _, _ := strings.Cut(name, ".so")
+		if containsString(nvidiaLibraries, base) && !containsString(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// setupGPUs makes the GPUs in devices available to the container whose child
+// process is pid, before it chroots into rootfs
+// With nvidia-container-cli (and root) the toolkit mounts the devices and
+// libraries itself; otherwise the device nodes, driver libraries, and tools
+// are returned as volumes to bind mount at their host paths, with
+// LD_LIBRARY_PATH pointing at the libraries
+// gocker installs no device cgroup rules, so the container may already open
+// the device nodes once they are there
+func setupGPUs(devices string, pid int, rootfs string) (volumes, env []string, err error) {
+	env = []string{"NVIDIA_VISIBLE_DEVICES=" + devices, "NVIDIA_DRIVER_CAPABILITIES=compute,utility"}
+
+	if _, err := exec.LookPath(nvidiaContainerCLI); err == nil && os.Geteuid() == 0 {
+		output, err := hostCommand(nvidiaContainerCLI, "--load-kmods", "configure", "--no-cgroups",
+			"--ldconfig=@/sbin/ldconfig", "--device="+devices, "--compute", "--utility",
+			"--pid="+strconv.Itoa(pid), rootfs).CombinedOutput()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s failed: %v: %s", nvidiaContainerCLI, err, strings.TrimSpace(string(output)))
+		}
+		return nil, env, nil
+	}
+
+	logger.Debug("Mounting GPU devices and libraries directly", "tool", nvidiaContainerCLI)
+	nodes, err := gpuDeviceNodes(devices)
+	if err != nil {
+		return nil, nil, err
+	}
+	libraries, err := nvidiaLibraryPaths()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(libraries) == 0 {
+		return nil, nil, fmt.Errorf("no NVIDIA driver libraries found in the ld.so cache")
+	}
+
+	var libDirs []string
+	for _, path := range append(nodes, libraries...) {
+		volumes = append(volumes, path+":"+path)
+	}
+	for _, path := range libraries {
+		if dir := filepath.Dir(path); !containsString(libDirs, dir) {
+			libDirs = append(libDirs, dir)
+		}
+	}
+	for _, tool := range nvidiaTools {
+		if path, err := exec.LookPath(tool); err == nil {
+			volumes = append(volumes, path+":"+path)
+		}
+	}
+	env = append(env, "LD_LIBRARY_PATH="+strings.Join(libDirs, ":"))
+	return volumes, env, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseGPUs(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"all", "all", false},
+		{"device=0", "0", false},
+		{"device=0,2", "0,2", false},
+		{"device=", "", true},
+		{"device=a", "", true},
+		{"device=-1", "", true},
+		{"2", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseGPUs(tt.spec)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseGPUs(%q) = %q, %v; want %q, error=%v", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// useFakeDevices points devDir at a directory holding the named files
+func useFakeDevices(t *testing.T, names ...string) string {
+	saved := devDir
+	t.Cleanup(func() { devDir = saved })
+	devDir = t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(devDir, name), nil, 0644); err != nil {
+			t.Fatalf("Failed to create fake device: %v", err)
+		}
+	}
+	return devDir
+}
+
+func TestGPUDeviceNodes(t *testing.T) {
+	dev := useFakeDevices(t, "nvidiactl", "nvidia-uvm", "nvidia0", "nvidia1")
+
+	nodes, err := gpuDeviceNodes("all")
+	if err != nil {
+		t.Fatalf("gpuDeviceNodes(all) failed: %v", err)
+	}
+	want := []string{filepath.Join(dev, "nvidiactl"), filepath.Join(dev, "nvidia-uvm"), filepath.Join(dev, "nvidia0"), filepath.Join(dev, "nvidia1")}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("gpuDeviceNodes(all) = %v, want %v", nodes, want)
+	}
+
+	nodes, err = gpuDeviceNodes("1")
+	if err != nil {
+		t.Fatalf("gpuDeviceNodes(1) failed: %v", err)
+	}
+	if want := []string{filepath.Join(dev, "nvidiactl"), filepath.Join(dev, "nvidia-uvm"), filepath.Join(dev, "nvidia1")}; !reflect.DeepEqual(nodes, want) {
+		t.Errorf("gpuDeviceNodes(1) = %v, want %v", nodes, want)
+	}
+	if _, err := gpuDeviceNodes("3"); err == nil {
+		t.Error("Expected error for a missing GPU")
+	}
+
+	useFakeDevices(t)
+	if _, err := gpuDeviceNodes("all"); err == nil {
+		t.Error("Expected error without an NVIDIA driver")
+	}
+}
+
+func TestSetupGPUsManual(t *testing.T) {
+	if _, err := exec.LookPath(nvidiaContainerCLI); err == nil {
+		t.Skip(nvidiaContainerCLI + " is installed")
+	}
+	dev := useFakeDevices(t, "nvidiactl", "nvidia0")
+	host := useFakeHost(t)
+	host.outputs["ldconfig -p"] = "3 libs found in cache `/etc/ld.so.cache'\n" +
+		"\tlibcuda.so.1 (libc6,x86-64) => /usr/lib/x86_64-linux-gnu/libcuda.so.1\n" +
+		"\tlibcuda.so (libc6,x86-64) => /usr/lib/x86_64-linux-gnu/libcuda.so\n" +
+		"\tlibc.so.6 (libc6,x86-64) => /lib/x86_64-linux-gnu/libc.so.6\n"
+
+	volumes, env, err := setupGPUs("0", 1234, t.TempDir())
+	if err != nil {
+		t.Fatalf("setupGPUs failed: %v", err)
+	}
+	ctl, gpu := filepath.Join(dev, "nvidiactl"), filepath.Join(dev, "nvidia0")
+	want := []string{
+		ctl + ":" + ctl,
+		gpu + ":" + gpu,
+		"/usr/lib/x86_64-linux-gnu/libcuda.so.1:/usr/lib/x86_64-linux-gnu/libcuda.so.1",
+		"/usr/lib/x86_64-linux-gnu/libcuda.so:/usr/lib/x86_64-linux-gnu/libcuda.so",
+	}
+	// nvidia-smi is added only when the host has it
+	if len(volumes) < len(want) || !reflect.DeepEqual(volumes[:len(want)], want) {
+		t.Errorf("volumes = %v, want %v", volumes, want)
+	}
+	if value, _ := lookupEnv(env, "LD_LIBRARY_PATH"); value != "/usr/lib/x86_64-linux-gnu" {
+		t.Errorf("LD_LIBRARY_PATH = %q", value)
+	}
+	if value, _ := lookupEnv(env, "NVIDIA_VISIBLE_DEVICES"); value != "0" {
+		t.Errorf("NVIDIA_VISIBLE_DEVICES = %q", value)
+	}
+
+	host.outputs["ldconfig -p"] = "0 libs found\n"
+	if _, _, err := setupGPUs("0", 1234, t.TempDir()); err == nil {
+		t.Error("Expected error without driver libraries")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyLimit bounds how many removed containers are remembered
+const historyLimit = 200
+
+// statusRemoved is the status shown for containers that only exist in history
+const statusRemoved = "removed"
+
+// HistoryEntry records a container after it is gone, for 'gocker history' and
+// 'gocker ps --last'
+type HistoryEntry struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Rootfs     string     `json:"rootfs,omitempty"`
+	Command    []string   `json:"command"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// newHistoryEntry summarizes a container's state
+func newHistoryEntry(state *ContainerState) HistoryEntry {
+	return HistoryEntry{
+		ID:         state.ID,
+		Status:     state.Status,
+		Rootfs:     state.RootfsPath,
+		Command:    state.Command,
+		ExitCode:   state.ExitCode,
+		CreatedAt:  state.CreatedAt,
+		FinishedAt: state.FinishedAt,
+	}
+}
+
+// duration is how long the container ran: until it finished, or until now if
+// it is still running
+func (e HistoryEntry) duration() time.Duration {
+	end := time.Now()
+	if e.FinishedAt != nil {
+		end = *e.FinishedAt
+	} else if !isActive(e.Status) {
+		return 0
+	}
+	return end.Sub(e.CreatedAt)
+}
+
+// loadHistory reads the removed containers, oldest first
+func loadHistory() ([]HistoryEntry, error) {
+	data, err := os.ReadFile(historyFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+	var entries []HistoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse history: %v", err)
+	}
+	return entries, nil
+}
+
+// recordHistory appends a removed container to the history, dropping the
+// oldest entries beyond historyLimit
+func recordHistory(state *ContainerState) error {
+	lock, err := lockGlobal("history")
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	entry := newHistoryEntry(state)
+	if entry.FinishedAt == nil {
+		now := time.Now()
+		entry.FinishedAt = &now
+	}
+	entry.Status = statusRemoved
+	entries = append(entries, entry)
+	if len(entries) > historyLimit {
+		entries = entries[len(entries)-historyLimit:]
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %v", err)
+	}
+	if err := writeFileAtomic(historyFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write history: %v", err)
+	}
+	return nil
+}
+
+func historyCommand(args []string) {
+	var last string
+	var jsonOutput bool
+	flags := newCommandFlags("history", "[options]", "Show recently removed containers, newest first")
+	flags.StringVar(&last, "last", "n", "N", "Show only the N most recently removed containers")
+	flags.BoolVar(&jsonOutput, "json", "", "Print the history as JSON")
+	if len(flags.MustParse(args)) > 0 {
+		flags.Fail("history does not accept arguments")
+	}
+	limit, err := parseLastFlag(last)
+	if err != nil {
+		flags.Fail(err.Error())
+	}
+	requireRoot()
+
+	entries, err := loadHistory()
+	must(err)
+	// Newest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	printHistory(entries, jsonOutput)
+}
+
+// parseLastFlag parses a --last value; an empty value means no limit
+func parseLastFlag(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid --last value: %s (expected a positive number)", value)
+	}
+	return n, nil
+}
+
+// recentContainers returns the n most recently created containers, whether
+// they still exist or have been removed, newest first
+func recentContainers(n int) ([]HistoryEntry, error) {
+	entries, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := listContainerIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		state, err := readContainerState(id)
+		if err != nil {
+			continue
+		}
+		entry := newHistoryEntry(state)
+		if isActive(entry.Status) && !isProcessAlive(state) {
+			entry.Status = statusExited
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries, nil
+}
+
+// printHistory prints containers with how they ended
+func printHistory(entries []HistoryEntry, jsonOutput bool) {
+	if jsonOutput {
+		if entries == nil {
+			entries = []HistoryEntry{}
+		}
+		data, err := json.MarshalIndent(entries, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No containers found")
+		return
+	}
+
+	fmt.Printf("%-14s %-10s %-6s %-10s %-20s %s\n", "CONTAINER ID", "STATUS", "EXIT", "DURATION", "CREATED", "COMMAND")
+	fmt.Println(strings.Repeat("-", 100))
+	for _, entry := range entries {
+		exitCode := "-"
+		if entry.ExitCode != nil {
+			exitCode = strconv.Itoa(*entry.ExitCode)
+		}
+		duration := "-"
+		if d := entry.duration(); d > 0 {
+			duration = d.Round(time.Second).String()
+		}
+		command := strings.Join(entry.Command, " ")
+		if len(command) > 40 {
+			command = command[:37] + "..."
+		}
+		fmt.Printf("%-14s %-10s %-6s %-10s %-20s %s\n", shortID(entry.ID), entry.Status, exitCode, duration, entry.CreatedAt.Format("2006-01-02 15:04:05"), command)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecordHistory(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	if entries, err := loadHistory(); err != nil || len(entries) != 0 {
+		t.Fatalf("Expected empty history, got %+v (%v)", entries, err)
+	}
+
+	created := time.Now().Add(-time.Minute)
+	finished := created.Add(30 * time.Second)
+	code := 3
+	state := &ContainerState{ID: "aaaa0123456789", Status: statusExited, Command: []string{"/bin/false"}, ExitCode: &code, CreatedAt: created, FinishedAt: &finished}
+	if err := recordHistory(state); err != nil {
+		t.Fatalf("recordHistory failed: %v", err)
+	}
+	entries, err := loadHistory()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected 1 history entry, got %+v (%v)", entries, err)
+	}
+	entry := entries[0]
+	if entry.ID != state.ID || entry.Status != statusRemoved || entry.ExitCode == nil || *entry.ExitCode != 3 {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	if d := entry.duration(); d != 30*time.Second {
+		t.Errorf("duration = %v, want 30s", d)
+	}
+
+	// Only the newest historyLimit entries are kept
+	for i := 0; i < historyLimit+5; i++ {
+		if err := recordHistory(&ContainerState{ID: fmt.Sprintf("%014d", i), Status: statusExited}); err != nil {
+			t.Fatalf("recordHistory failed: %v", err)
+		}
+	}
+	entries, _ = loadHistory()
+	if len(entries) != historyLimit {
+		t.Fatalf("Expected %d entries, got %d", historyLimit, len(entries))
+	}
+	if last := entries[len(entries)-1].ID; last != fmt.Sprintf("%014d", historyLimit+4) {
+		t.Errorf("Expected newest entry last, got %s", last)
+	}
+}
+
+func TestRecentContainers(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	now := time.Now()
+	if err := recordHistory(&ContainerState{ID: "aaaa0123456789", Status: statusExited, CreatedAt: now.Add(-3 * time.Hour)}); err != nil {
+		t.Fatalf("recordHistory failed: %v", err)
+	}
+	if err := recordHistory(&ContainerState{ID: "cccc0123456789", Status: statusExited, CreatedAt: now.Add(-time.Hour)}); err != nil {
+		t.Fatalf("recordHistory failed: %v", err)
+	}
+	if err := saveContainerState(&ContainerState{ID: "bbbb0123456789", Status: statusExited, CreatedAt: now.Add(-2 * time.Hour)}); err != nil {
+		t.Fatalf("saveContainerState failed: %v", err)
+	}
+
+	entries, err := recentContainers(2)
+	if err != nil {
+		t.Fatalf("recentContainers failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ID != "cccc0123456789" || entries[1].ID != "bbbb0123456789" {
+		t.Fatalf("Expected cccc then bbbb, got %+v", entries)
+	}
+	if entries[0].Status != statusRemoved || entries[1].Status != statusExited {
+		t.Errorf("Unexpected statuses: %s, %s", entries[0].Status, entries[1].Status)
+	}
+}
+
+func TestParseLastFlag(t *testing.T) {
+	if n, err := parseLastFlag(""); n != 0 || err != nil {
+		t.Errorf("parseLastFlag(\"\") = %d, %v", n, err)
+	}
+	if n, err := parseLastFlag("5"); n != 5 || err != nil {
+		t.Errorf("parseLastFlag(\"5\") = %d, %v", n, err)
+	}
+	for _, value := range []string{"0", "-1", "five"} {
+		if _, err := parseLastFlag(value); err == nil {
+			t.Errorf("parseLastFlag(%q): expected error", value)
+		}
+	}
+}
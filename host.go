@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// Host operations go through these variables rather than os/exec and syscall
+// directly, so tests can replace them with fakes and exercise network,
+// firewall, and mount logic without root
+// Linux-only operations live in host_linux.go, with stubs for other systems
+// in host_other.go
+
+// errNotLinux is returned by host operations on systems other than Linux
+var errNotLinux = fmt.Errorf("gocker requires Linux: %w", errors.ErrUnsupported)
+
+// hostCommand builds the commands gocker runs to configure the host: ip,
+// iptables, sysctl, nsenter, and the systemd tools
+var hostCommand = exec.Command
+
+// fsStats is the part of statfs(2) gocker uses
+type fsStats struct {
+	Type     int64 // filesystem magic number
+	Free     int64 // bytes available to unprivileged users
+	ReadOnly bool
+}
@@ -0,0 +1,272 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// mount and unmount attach and detach filesystems
+var (
+	mount   = syscall.Mount
+	unmount = syscall.Unmount
+)
+
+// Mount flags, named after their syscall constants
+const (
+	msBind    = syscall.MS_BIND
+	msRec     = syscall.MS_REC
+	msPrivate = syscall.MS_PRIVATE
+	msNosuid  = syscall.MS_NOSUID
+	msNodev   = syscall.MS_NODEV
+	msNoexec  = syscall.MS_NOEXEC
+	msRdonly  = syscall.MS_RDONLY
+	msRemount = syscall.MS_REMOUNT
+	mntDetach = syscall.MNT_DETACH
+)
+
+// stRdonly is ST_RDONLY in statfs flags
+const stRdonly = 0x1
+
+// sethostname and chroot set up the container's view of the system
+var (
+	sethostname = syscall.Sethostname
+	chroot      = syscall.Chroot
+)
+
+// lockFile acquires an exclusive lock on a file
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// unlockFile releases the lock on a file
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// signalProcess sends sig to pid; signal 0 only checks the process exists
+func signalProcess(pid int, sig syscall.Signal) error {
+	return syscall.Kill(pid, sig)
+}
+
+// statFS reports the filesystem holding path
+func statFS(path string) (fsStats, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return fsStats{}, err
+	}
+	return fsStats{
+		Type:     int64(fs.Type),
+		Free:     int64(fs.Bavail) * fs.Bsize,
+		ReadOnly: fs.Flags&stRdonly != 0,
+	}, nil
+}
+
+// inheritedFile returns the file open at fd, inherited from the parent, or nil
+// if fd is not open; the file is not passed on to programs this process runs
+func inheritedFile(fd int, name string) *os.File {
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		return nil
+	}
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), name)
+}
+
+// namespaceAttr returns the process attributes that start the container in
+// new namespaces
+// The cgroup namespace makes the container's cgroup the root of its
+// /sys/fs/cgroup view, so runtimes detect the container's limits; with userns
+// the container also gets a user namespace with its ID mappings
+func namespaceAttr(userns *userNamespace) *syscall.SysProcAttr {
+	cloneFlags := syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWCGROUP
+	if userns == nil {
+		return &syscall.SysProcAttr{Cloneflags: uintptr(cloneFlags)}
+	}
+	return &syscall.SysProcAttr{
+		Cloneflags: uintptr(cloneFlags | syscall.CLONE_NEWUSER),
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: userns.UIDs.HostID, Size: userns.UIDs.Size},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: userns.GIDs.HostID, Size: userns.GIDs.Size},
+		},
+	}
+}
+
+// shareCgroupNamespace makes a command stay in gocker's cgroup namespace
+// (--cgroupns host)
+func shareCgroupNamespace(attr *syscall.SysProcAttr) {
+	attr.Cloneflags &^= syscall.CLONE_NEWCGROUP
+}
+
+// startInCgroup makes a command start directly in the cgroup open at dir
+func startInCgroup(attr *syscall.SysProcAttr, dir *os.File) {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(dir.Fd())
+}
+
+// newMountNamespace makes a command start in a private copy of gocker's mount
+// namespace, so its mounts are invisible to the host
+func newMountNamespace(attr *syscall.SysProcAttr) {
+	attr.Unshareflags |= syscall.CLONE_NEWNS
+}
+
+// newSession makes a command start in its own session, detached from gocker's
+// terminal; with ctty its stdin becomes the session's controlling terminal
+func newSession(attr *syscall.SysProcAttr, ctty bool) {
+	attr.Setsid = true
+	attr.Setctty = ctty
+}
+
+// sysPidfdOpen is the pidfd_open(2) syscall number, missing from package syscall
+const sysPidfdOpen = 434
+
+// waitForExitPidfd waits up to timeout for a process to exit through a pidfd
+// and reports whether it did; ok is false if the kernel has no pidfds
+// (before 5.3) and the caller must poll instead
+func waitForExitPidfd(pid int, startTime uint64, timeout time.Duration) (exited, ok bool) {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno == syscall.ESRCH {
+		return true, true
+	}
+	if errno != 0 {
+		return false, false
+	}
+	pidfd := int(fd)
+	defer syscall.Close(pidfd)
+	// The PID may have been recycled before the pidfd was opened
+	if !processAlive(pid, startTime) {
+		return true, true
+	}
+	exited, err := waitPidfd(pidfd, timeout)
+	return exited, err == nil
+}
+
+// waitPidfd waits for a pidfd to become readable, which happens when the
+// process exits, and reports whether it did so within timeout
+func waitPidfd(pidfd int, timeout time.Duration) (bool, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return false, err
+	}
+	defer syscall.Close(epfd)
+
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(pidfd)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, pidfd, &event); err != nil {
+		return false, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	events := make([]syscall.EpollEvent, 1)
+	for {
+		remaining := time.Until(deadline)
+		if remaining < 0 {
+			remaining = 0
+		}
+		n, err := syscall.EpollWait(epfd, events, int(remaining.Milliseconds()))
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return n > 0, nil
+	}
+}
+
+// Idmapped mount syscalls and flags (Linux 5.12), missing from package
+// syscall; the syscall numbers are the same on every architecture
+const (
+	sysOpenTree         = 428
+	sysMoveMount        = 429
+	sysMountSetattr     = 442
+	atFdcwd             = -100
+	atEmptyPath         = 0x1000
+	atRecursive         = 0x8000
+	openTreeClone       = 0x1
+	moveMountFEmptyPath = 0x4
+	mountAttrIdmap      = 0x100000
+)
+
+// mountAttr is struct mount_attr from linux/mount.h
+type mountAttr struct {
+	attrSet     uint64
+	attrClr     uint64
+	propagation uint64
+	usernsFd    uint64
+}
+
+// idmappedMount mounts source at target with file ownership shifted by the
+// userns mappings, so host root's files belong to the container's root
+// The mappings are taken from a short-lived helper process in a user
+// namespace with the same mappings; the container's own namespace does not
+// exist yet, and its mount namespace must be copied after the mount is made
+func idmappedMount(source, target string, userns *userNamespace) error {
+	holder := exec.Command("/proc/self/exe", "userns-holder")
+	holder.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: userns.UIDs.HostID, Size: userns.UIDs.Size}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: userns.GIDs.HostID, Size: userns.GIDs.Size}},
+	}
+	stdin, err := holder.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := holder.Start(); err != nil {
+		return fmt.Errorf("failed to create user namespace: %v", err)
+	}
+	defer func() {
+		stdin.Close()
+		holder.Wait()
+	}()
+
+	nsFile, err := os.Open("/proc/" + strconv.Itoa(holder.Process.Pid) + "/ns/user")
+	if err != nil {
+		return err
+	}
+	defer nsFile.Close()
+
+	sourcePtr, err := syscall.BytePtrFromString(source)
+	if err != nil {
+		return err
+	}
+	targetPtr, err := syscall.BytePtrFromString(target)
+	if err != nil {
+		return err
+	}
+	empty, _ := syscall.BytePtrFromString("")
+	cwd := atFdcwd
+
+	fd, _, errno := syscall.Syscall(sysOpenTree, uintptr(cwd), uintptr(unsafe.Pointer(sourcePtr)), openTreeClone|syscall.O_CLOEXEC|atRecursive)
+	if errno != 0 {
+		return fmt.Errorf("open_tree %s: %v", source, errno)
+	}
+	defer syscall.Close(int(fd))
+
+	attr := mountAttr{attrSet: mountAttrIdmap, usernsFd: uint64(nsFile.Fd())}
+	_, _, errno = syscall.Syscall6(sysMountSetattr, fd, uintptr(unsafe.Pointer(empty)), atEmptyPath|atRecursive, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("mount_setattr %s: %v", source, errno)
+	}
+	_, _, errno = syscall.Syscall6(sysMoveMount, fd, uintptr(unsafe.Pointer(empty)), uintptr(cwd), uintptr(unsafe.Pointer(targetPtr)), moveMountFEmptyPath, 0)
+	if errno != 0 {
+		return fmt.Errorf("move_mount %s: %v", target, errno)
+	}
+	return nil
+}
+
+// fileOwner returns the owner of the file info describes
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
@@ -0,0 +1,85 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Containers need Linux namespaces, cgroups, and mounts; elsewhere these stubs
+// let gocker build so its command-line handling can be developed and tested
+
+var (
+	mount = func(source, target, fstype string, flags uintptr, data string) error {
+		return errNotLinux
+	}
+	unmount = func(target string, flags int) error {
+		return errNotLinux
+	}
+)
+
+const (
+	msBind    = 0
+	msRec     = 0
+	msPrivate = 0
+	msNosuid  = 0
+	msNodev   = 0
+	msNoexec  = 0
+	msRdonly  = 0
+	msRemount = 0
+	mntDetach = 0
+)
+
+func sethostname(name []byte) error {
+	return errNotLinux
+}
+
+func chroot(path string) error {
+	return errNotLinux
+}
+
+func lockFile(f *os.File) error {
+	return errNotLinux
+}
+
+func unlockFile(f *os.File) error {
+	return errNotLinux
+}
+
+func signalProcess(pid int, sig syscall.Signal) error {
+	return errNotLinux
+}
+
+func statFS(path string) (fsStats, error) {
+	return fsStats{}, errNotLinux
+}
+
+func inheritedFile(fd int, name string) *os.File {
+	return nil
+}
+
+func namespaceAttr(userns *userNamespace) *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{}
+}
+
+func shareCgroupNamespace(attr *syscall.SysProcAttr) {}
+
+func startInCgroup(attr *syscall.SysProcAttr, dir *os.File) {}
+
+func newMountNamespace(attr *syscall.SysProcAttr) {}
+
+func newSession(attr *syscall.SysProcAttr, ctty bool) {}
+
+func waitForExitPidfd(pid int, startTime uint64, timeout time.Duration) (exited, ok bool) {
+	return false, false
+}
+
+func idmappedMount(source, target string, userns *userNamespace) error {
+	return errNotLinux
+}
+
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
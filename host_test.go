@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeHost records host commands instead of running them
+// Commands matching a prefix in outputs print that output; commands matching
+// a prefix in failures exit 1, as 'iptables -C' does for a missing rule;
+// programs in missing are not found, as if they were not installed
+type fakeHost struct {
+	mu       sync.Mutex
+	commands []string
+	outputs  map[string]string
+	failures []string
+	missing  []string
+}
+
+// useFakeHost replaces hostCommand with a fakeHost for the rest of the test
+func useFakeHost(t *testing.T) *fakeHost {
+	saved := hostCommand
+	t.Cleanup(func() { hostCommand = saved })
+
+	host := &fakeHost{outputs: make(map[string]string)}
+	hostCommand = func(name string, args ...string) *exec.Cmd {
+		line := strings.Join(append([]string{name}, args...), " ")
+		host.mu.Lock()
+		defer host.mu.Unlock()
+		host.commands = append(host.commands, line)
+		for _, program := range host.missing {
+			if name == program {
+				return exec.Command("gocker-test-missing-" + name)
+			}
+		}
+
+		output, exit := "", "0"
+		for prefix, out := range host.outputs {
+			if strings.HasPrefix(line, prefix) {
+				output = out
+			}
+		}
+		for _, prefix := range host.failures {
+			if strings.HasPrefix(line, prefix) {
+				exit = "1"
+			}
+		}
+		cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+		cmd.Env = append(os.Environ(), "GOCKER_HELPER_PROCESS=1", "GOCKER_HELPER_OUTPUT="+output, "GOCKER_HELPER_EXIT="+exit)
+		return cmd
+	}
+	return host
+}
+
+// ran returns the recorded commands starting with prefix
+func (h *fakeHost) ran(prefix string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var matched []string
+	for _, line := range h.commands {
+		if strings.HasPrefix(line, prefix) {
+			matched = append(matched, line)
+		}
+	}
+	return matched
+}
+
+// TestHelperProcess stands in for host commands run through a fakeHost
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GOCKER_HELPER_PROCESS") != "1" {
+		return
+	}
+	fmt.Print(os.Getenv("GOCKER_HELPER_OUTPUT"))
+	if os.Getenv("GOCKER_HELPER_EXIT") != "0" {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestSetupNATRules(t *testing.T) {
+	host := useFakeHost(t)
+	fakeProc(t, nil, map[string]string{"net/route": routeTableHeader + "eth0\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n"})
+	// The MASQUERADE rule is missing, the FORWARD rules exist
+	host.failures = []string{"iptables -t nat -C POSTROUTING"}
+
+	if err := setupNATRules(); err != nil {
+		t.Fatalf("setupNATRules failed: %v", err)
+	}
+	want := []string{"iptables -t nat -A POSTROUTING -s " + containerNet + " -o eth0 -j MASQUERADE"}
+	if got := host.ran("iptables -t nat -A"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Added NAT rules %v, want %v", got, want)
+	}
+	if got := host.ran("iptables -A"); len(got) != 0 {
+		t.Errorf("Expected existing FORWARD rules to be kept, got %v", got)
+	}
+}
+
+func TestMountVolumes(t *testing.T) {
+	type mountCall struct {
+		source, target string
+		flags          uintptr
+	}
+	var mounts []mountCall
+	saved := mount
+	t.Cleanup(func() { mount = saved })
+	mount = func(source, target, fstype string, flags uintptr, data string) error {
+		mounts = append(mounts, mountCall{source, target, flags})
+		return nil
+	}
+
+	hostDir := t.TempDir()
+	hostFile := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(hostFile, nil, 0644); err != nil {
+		t.Fatalf("Failed to write host file: %v", err)
+	}
+	rootfs := t.TempDir()
+
+	if err := mountVolumes([]string{hostDir + ":/data", hostFile + ":/etc/app.conf"}, rootfs); err != nil {
+		t.Fatalf("mountVolumes failed: %v", err)
+	}
+	bind := uintptr(msBind | msRec)
+	private := uintptr(msPrivate | msRec)
+	want := []mountCall{
+		{hostDir, filepath.Join(rootfs, "data"), bind},
+		{"", filepath.Join(rootfs, "data"), private},
+		{hostFile, filepath.Join(rootfs, "etc", "app.conf"), bind},
+		{"", filepath.Join(rootfs, "etc", "app.conf"), private},
+	}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("mounts = %+v, want %+v", mounts, want)
+	}
+	// Mount points are created to match the host path's type
+	if info, err := os.Stat(filepath.Join(rootfs, "data")); err != nil || !info.IsDir() {
+		t.Errorf("Expected directory mount point, got %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(rootfs, "etc", "app.conf")); err != nil || info.IsDir() {
+		t.Errorf("Expected file mount point, got %v", err)
+	}
+
+	if err := mountVolumes([]string{"/does/not/exist:/data"}, rootfs); err == nil {
+		t.Error("Expected error for missing host path")
+	}
+}
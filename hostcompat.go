@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WSL 2 and hosts without systemd differ from the distributions gocker is
+// developed on: cgroup2 may be mounted somewhere other than /sys/fs/cgroup,
+// the uplink may have a small MTU, and iptables may not work with the kernel
+
+// mountInfoFile lists this process's mounts
+var mountInfoFile = "/proc/self/mountinfo"
+
+// systemdRunDir exists while systemd is the init system
+var systemdRunDir = "/run/systemd/system"
+
+// defaultMTU is the MTU of a new veth pair
+const defaultMTU = 1500
+
+// wslVersion returns 1 or 2 when the kernel release is a WSL kernel, such as
+// "5.15.153.1-microsoft-standard-WSL2", or 0 on other hosts
+// WSL 1 reports a release like "4.4.0-19041-Microsoft" but has no Linux kernel
+func wslVersion(osrelease string) int {
+	lower := strings.ToLower(osrelease)
+	switch {
+	case strings.Contains(lower, "microsoft-standard"), strings.Contains(lower, "wsl2"):
+		return 2
+	case strings.Contains(lower, "microsoft"):
+		return 1
+	}
+	return 0
+}
+
+// cgroup2Mounts returns the mount points of cgroup2 filesystems in a
+// /proc/self/mountinfo, whose lines end with " - <fstype> <source> <options>"
+func cgroup2Mounts(mountinfo string) []string {
+	var mounts []string
+	for _, line := range strings.Split(mountinfo, "\n") {
+		fields, fs, ok := strings.Cut(line, " - ")
+		if !ok || !strings.HasPrefix(fs, "cgroup2 ") {
+			continue
+		}
+		if parts := strings.Fields(fields); len(parts) >= 5 {
+			mounts = append(mounts, unescapeMountPath(parts[4]))
+		}
+	}
+	return mounts
+}
+
+// unescapeMountPath undoes the octal escapes of spaces and the like in
+// mountinfo paths
+func unescapeMountPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// findCgroup2Mount returns where cgroup2 is mounted when it is not at
+// cgroupRoot, as on WSL 2 without systemd, which mounts it at
+// /sys/fs/cgroup/unified; "" when there is no such mount
+func findCgroup2Mount() string {
+	data, err := os.ReadFile(mountInfoFile)
+	if err != nil {
+		return ""
+	}
+	for _, mount := range cgroup2Mounts(string(data)) {
+		if mount != cgroupRoot {
+			return mount
+		}
+	}
+	return ""
+}
+
+// useCgroupRoot moves gocker's cgroups under a cgroup2 mount at root
+func useCgroupRoot(root string) {
+	logger.Debug("Using cgroup2 mount", "path", root)
+	cgroupParent = filepath.Join(root, strings.TrimPrefix(cgroupParent, cgroupRoot))
+	cgroupRoot = root
+}
+
+// uplinkMTU returns the host's default interface and its MTU, 0 if unknown
+func uplinkMTU() (string, int) {
+	name, err := getDefaultInterface()
+	if err != nil {
+		return "", 0
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return name, 0
+	}
+	return name, iface.MTU
+}
+
+// containerMTU returns the MTU for container interfaces: the uplink's when it
+// is below the default, as on WSL 2 and VPNs, since larger packets would be
+// dropped on the way out; 0 keeps the default
+func containerMTU() int {
+	if _, mtu := uplinkMTU(); mtu > 0 && mtu < defaultMTU {
+		return mtu
+	}
+	return 0
+}
+
+// hostFacts are what hostWarnings judges a host by
+type hostFacts struct {
+	wsl            int    // WSL version, 0 when not WSL
+	systemd        bool   // systemd is the init system
+	cgroupRoot     string // where gocker's cgroups live
+	uplink         string // default interface
+	uplinkMTU      int
+	iptablesBroken string // why iptables cannot list rules, "" when it can or is not installed
+}
+
+// gatherHostFacts probes this host
+func gatherHostFacts() hostFacts {
+	facts := hostFacts{
+		wsl:        wslVersion(readProcSetting("sys/kernel/osrelease")),
+		cgroupRoot: cgroupRoot,
+	}
+	if _, err := os.Stat(systemdRunDir); err == nil {
+		facts.systemd = true
+	}
+	facts.uplink, facts.uplinkMTU = uplinkMTU()
+	// Listing rules needs root; without it iptables fails everywhere
+	if os.Geteuid() == 0 && hasHostTool("iptables") {
+		if output, err := hostCommand("iptables", "-t", "nat", "-S").CombinedOutput(); err != nil {
+			facts.iptablesBroken = strings.TrimSpace(firstLine(string(output)))
+			if facts.iptablesBroken == "" {
+				facts.iptablesBroken = err.Error()
+			}
+		}
+	}
+	return facts
+}
+
+// firstLine returns s up to its first newline
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// hostWarnings returns what will not work, or works differently, on a host,
+// for 'gocker info'
+func hostWarnings(facts hostFacts) []string {
+	var warnings []string
+	if facts.wsl == 1 {
+		warnings = append(warnings, "WSL 1 has no Linux kernel and cannot run containers; convert the distribution with 'wsl --set-version <distro> 2'")
+	}
+	if !facts.systemd {
+		warning := "systemd is not running: cgroup_driver \"systemd\" and the units of 'gocker generate' cannot be used"
+		if facts.wsl == 2 {
+			warning += "; enable it with \"[boot] systemd=true\" in /etc/wsl.conf and 'wsl --shutdown'"
+		}
+		warnings = append(warnings, warning)
+	}
+	if facts.cgroupRoot != defaultCgroupRoot {
+		warnings = append(warnings, fmt.Sprintf("cgroup2 is mounted at %s rather than %s; containers' cgroups are created there", facts.cgroupRoot, defaultCgroupRoot))
+	}
+	if facts.uplinkMTU > 0 && facts.uplinkMTU < defaultMTU {
+		warnings = append(warnings, fmt.Sprintf("%s has MTU %d; container interfaces get the same MTU so large packets are not dropped", facts.uplink, facts.uplinkMTU))
+	}
+	if facts.iptablesBroken != "" {
+		warning := "iptables does not work with this kernel (" + facts.iptablesBroken + "): no NAT, published ports or firewall rules"
+		if facts.wsl == 2 {
+			warning += "; switch backends with 'update-alternatives --set iptables /usr/sbin/iptables-legacy' (or iptables-nft)"
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
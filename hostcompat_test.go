@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWSLVersion(t *testing.T) {
+	tests := map[string]int{
+		"5.15.153.1-microsoft-standard-WSL2": 2,
+		"6.6.36.3-microsoft-standard-WSL2+":  2,
+		"4.19.128-microsoft-standard":        2,
+		"4.4.0-19041-Microsoft":              1,
+		"6.8.0-45-generic":                   0,
+		"":                                   0,
+	}
+	for release, want := range tests {
+		if got := wslVersion(release); got != want {
+			t.Errorf("wslVersion(%q) = %d, want %d", release, got, want)
+		}
+	}
+}
+
+// wslMountinfo is an excerpt of /proc/self/mountinfo on WSL 2 without systemd
+const wslMountinfo = `62 45 0:26 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime - tmpfs tmpfs rw,mode=755
+63 62 0:27 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime - cgroup2 cgroup2 rw,nsdelegate
+64 62 0:28 / /sys/fs/cgroup/cpu rw,nosuid,nodev,noexec,relatime - cgroup cgroup rw,cpu
+70 45 0:30 / /mnt/my\040cgroup rw,relatime - cgroup2 none rw
+`
+
+func TestCgroup2Mounts(t *testing.T) {
+	want := []string{"/sys/fs/cgroup/unified", "/mnt/my cgroup"}
+	if got := cgroup2Mounts(wslMountinfo); !reflect.DeepEqual(got, want) {
+		t.Errorf("cgroup2Mounts() = %q, want %q", got, want)
+	}
+	if got := cgroup2Mounts("25 1 0:22 / /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n"); !reflect.DeepEqual(got, []string{"/sys/fs/cgroup"}) {
+		t.Errorf("cgroup2Mounts(unified) = %q, want /sys/fs/cgroup", got)
+	}
+}
+
+func TestFindCgroup2Mount(t *testing.T) {
+	savedFile, savedRoot, savedParent := mountInfoFile, cgroupRoot, cgroupParent
+	t.Cleanup(func() { mountInfoFile, cgroupRoot, cgroupParent = savedFile, savedRoot, savedParent })
+
+	mountInfoFile = filepath.Join(t.TempDir(), "mountinfo")
+	if err := os.WriteFile(mountInfoFile, []byte(wslMountinfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mount := findCgroup2Mount()
+	if mount != "/sys/fs/cgroup/unified" {
+		t.Fatalf("findCgroup2Mount() = %q, want /sys/fs/cgroup/unified", mount)
+	}
+
+	cgroupRoot, cgroupParent = defaultCgroupRoot, defaultCgroupRoot+"/gocker"
+	useCgroupRoot(mount)
+	if cgroupRoot != mount || cgroupParent != mount+"/gocker" {
+		t.Errorf("useCgroupRoot() set root %q, parent %q, want %q and %q", cgroupRoot, cgroupParent, mount, mount+"/gocker")
+	}
+}
+
+func TestHostWarnings(t *testing.T) {
+	healthy := hostFacts{systemd: true, cgroupRoot: defaultCgroupRoot, uplink: "eth0", uplinkMTU: 1500}
+	if got := hostWarnings(healthy); len(got) != 0 {
+		t.Errorf("hostWarnings(healthy) = %q, want none", got)
+	}
+
+	wsl := hostFacts{wsl: 2, cgroupRoot: "/sys/fs/cgroup/unified", uplink: "eth0", uplinkMTU: 1280, iptablesBroken: "can't initialize iptables table `nat'"}
+	got := strings.Join(hostWarnings(wsl), "\n")
+	for _, want := range []string{"/etc/wsl.conf", "/sys/fs/cgroup/unified", "MTU 1280", "iptables-legacy"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("hostWarnings(WSL 2) missing %q:\n%s", want, got)
+		}
+	}
+
+	if got := hostWarnings(hostFacts{wsl: 1, systemd: true, cgroupRoot: defaultCgroupRoot}); len(got) != 1 || !strings.Contains(got[0], "--set-version") {
+		t.Errorf("hostWarnings(WSL 1) = %q, want the conversion hint", got)
+	}
+}
+
+// TestWSLHost checks gocker's view of a real WSL 2 host; it runs only there
+func TestWSLHost(t *testing.T) {
+	if wslVersion(readProcSetting("sys/kernel/osrelease")) != 2 {
+		t.Skip("not running on WSL 2")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	savedRoot, savedParent := cgroupRoot, cgroupParent
+	t.Cleanup(func() { cgroupRoot, cgroupParent = savedRoot, savedParent })
+
+	manager, err := detectCgroupManager()
+	if err != nil {
+		t.Fatalf("detectCgroupManager() error = %v", err)
+	}
+	if check := checkCgroups(cgroupRoot); check.Status == featureMissing {
+		t.Errorf("cgroups on WSL 2 (%s at %s): %s", manager.Mode(), cgroupRoot, check.Detail)
+	}
+	if name, mtu := uplinkMTU(); mtu == 0 {
+		t.Errorf("uplinkMTU() found no MTU for the default interface %q", name)
+	} else if got := containerMTU(); mtu < defaultMTU && got != mtu {
+		t.Errorf("containerMTU() = %d, want the uplink's %d", got, mtu)
+	}
+}
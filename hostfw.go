@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Hosts whose firewall is not plain iptables: firewalld, which drops traffic
+// forwarded between interfaces it has not been told about, and hosts with
+// nft but no iptables binary
+
+// firewalldZone is the firewalld zone the bridge is put in
+const firewalldZone = "gocker"
+
+// nftTable is the nftables table holding the NAT rules on hosts without iptables
+const nftTable = "gocker"
+
+// hasHostTool reports whether a host tool is installed
+func hasHostTool(name string) bool {
+	err := hostCommand(name, "--version").Run()
+	return !errors.Is(err, exec.ErrNotFound)
+}
+
+// requireIptables refuses run options whose rules exist only for iptables:
+// on hosts without it, NAT falls back to nft but --internal, published ports
+// and container firewalls would fail halfway through starting the container
+func requireIptables(opts *RunOptions) error {
+	var flags []string
+	if opts.Internal {
+		flags = append(flags, "--internal")
+	}
+	if len(opts.Publish) > 0 {
+		flags = append(flags, "-p")
+	}
+	if len(opts.Expose) > 0 {
+		flags = append(flags, "--expose")
+	}
+	if len(opts.AllowFrom) > 0 {
+		flags = append(flags, "--allow-from")
+	}
+	if len(flags) == 0 || hasHostTool("iptables") {
+		return nil
+	}
+	return fmt.Errorf("%s requires iptables, which is not installed (only NAT is set up through nft); install iptables or iptables-nft", strings.Join(flags, ", "))
+}
+
+// firewalldRunning reports whether firewalld is managing the host firewall
+func firewalldRunning() bool {
+	output, err := hostCommand("firewall-cmd", "--state").Output()
+	return err == nil && strings.TrimSpace(string(output)) == "running"
+}
+
+// registerFirewalldZone puts the bridge in a zone of its own whose target is
+// ACCEPT, so firewalld forwards container traffic
+// The zone is permanent, so it survives firewalld reloads and reboots;
+// firewall-cmd is firewalld's D-Bus client
+func registerFirewalldZone() error {
+	if !firewalldRunning() {
+		return nil
+	}
+	output, _ := hostCommand("firewall-cmd", "--get-zone-of-interface="+bridgeName).Output()
+	if strings.TrimSpace(string(output)) == firewalldZone {
+		return nil
+	}
+
+	logger.Info("Registering bridge with firewalld", "bridge", bridgeName, "zone", firewalldZone)
+	zones, err := hostCommand("firewall-cmd", "--permanent", "--get-zones").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list firewalld zones: %v", err)
+	}
+	var steps [][]string
+	if !slices.Contains(strings.Fields(string(zones)), firewalldZone) {
+		steps = append(steps, []string{"--permanent", "--new-zone=" + firewalldZone})
+	}
+	steps = append(steps,
+		[]string{"--permanent", "--zone=" + firewalldZone, "--set-target=ACCEPT"},
+		[]string{"--permanent", "--zone=" + firewalldZone, "--change-interface=" + bridgeName},
+		[]string{"--reload"},
+	)
+	for _, args := range steps {
+		if output, err := hostCommand("firewall-cmd", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("firewall-cmd %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// removeFirewalldZone deletes the zone registerFirewalldZone created
+func removeFirewalldZone() error {
+	if !firewalldRunning() {
+		return nil
+	}
+	zones, err := hostCommand("firewall-cmd", "--permanent", "--get-zones").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list firewalld zones: %v", err)
+	}
+	if !slices.Contains(strings.Fields(string(zones)), firewalldZone) {
+		return nil
+	}
+	for _, args := range [][]string{{"--permanent", "--delete-zone=" + firewalldZone}, {"--reload"}} {
+		if output, err := hostCommand("firewall-cmd", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("firewall-cmd %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+		}
+	}
+	fmt.Printf("Removed firewalld zone %s\n", firewalldZone)
+	return nil
+}
+
+// nftNATRuleset returns the nftables equivalent of the iptables rules
+// setupNATRules adds; adding and deleting the table first makes loading it
+// replace any previous version
+func nftNATRuleset(defaultInterface string) string {
+	return fmt.Sprintf(`add table ip %[1]s
+delete table ip %[1]s
+table ip %[1]s {
+	chain postrouting {
+		type nat hook postrouting priority srcnat; policy accept;
+		ip saddr %[2]s oifname %[3]q masquerade
+	}
+	chain forward {
+		type filter hook forward priority filter; policy accept;
+		iifname %[4]q oifname %[3]q accept
+		iifname %[3]q oifname %[4]q accept
+	}
+}
+`, nftTable, containerNet, defaultInterface, bridgeName)
+}
+
+// setupNftNATRules loads the NAT rules with nft
+func setupNftNATRules(defaultInterface string) error {
+	cmd := hostCommand("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(nftNATRuleset(defaultInterface))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to load nftables rules: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// hasNftNATRules reports whether the nftables NAT table is loaded
+func hasNftNATRules() bool {
+	return hostCommand("nft", "list", "table", "ip", nftTable).Run() == nil
+}
+
+// removeNftNATRules deletes the nftables NAT table, if there is one
+func removeNftNATRules() error {
+	if !hasHostTool("nft") || !hasNftNATRules() {
+		return nil
+	}
+	if output, err := hostCommand("nft", "delete", "table", "ip", nftTable).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete nftables table %s: %v: %s", nftTable, err, strings.TrimSpace(string(output)))
+	}
+	fmt.Printf("Removed nftables table %s\n", nftTable)
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegisterFirewalldZone(t *testing.T) {
+	host := useFakeHost(t)
+	host.outputs["firewall-cmd --state"] = "running\n"
+	host.outputs["firewall-cmd --permanent --get-zones"] = "block dmz public trusted\n"
+	host.outputs["firewall-cmd --get-zone-of-interface"] = "public\n"
+
+	if err := registerFirewalldZone(); err != nil {
+		t.Fatalf("registerFirewalldZone() error = %v", err)
+	}
+	want := []string{
+		"firewall-cmd --permanent --new-zone=gocker",
+		"firewall-cmd --permanent --zone=gocker --set-target=ACCEPT",
+		"firewall-cmd --permanent --zone=gocker --change-interface=gocker0",
+		"firewall-cmd --reload",
+	}
+	var got []string
+	for _, line := range host.ran("firewall-cmd ") {
+		if !strings.Contains(line, "--get-") && !strings.Contains(line, "--state") {
+			got = append(got, line)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("registerFirewalldZone() ran %v, want %v", got, want)
+	}
+}
+
+func TestRegisterFirewalldZoneRegistered(t *testing.T) {
+	host := useFakeHost(t)
+	host.outputs["firewall-cmd --state"] = "running\n"
+	host.outputs["firewall-cmd --get-zone-of-interface"] = "gocker\n"
+
+	if err := registerFirewalldZone(); err != nil {
+		t.Fatalf("registerFirewalldZone() error = %v", err)
+	}
+	if got := host.ran("firewall-cmd --permanent"); len(got) != 0 {
+		t.Errorf("registerFirewalldZone() changed a registered bridge: %v", got)
+	}
+}
+
+func TestRegisterFirewalldZoneNotRunning(t *testing.T) {
+	host := useFakeHost(t)
+	host.failures = []string{"firewall-cmd --state"}
+
+	if err := registerFirewalldZone(); err != nil {
+		t.Fatalf("registerFirewalldZone() error = %v", err)
+	}
+	if got := host.ran("firewall-cmd"); len(got) != 1 {
+		t.Errorf("registerFirewalldZone() without firewalld ran %v, want only the state check", got)
+	}
+}
+
+func TestNftNATRuleset(t *testing.T) {
+	ruleset := nftNATRuleset("eth0")
+	for _, want := range []string{
+		"add table ip gocker\ndelete table ip gocker\n",
+		"ip saddr " + containerNet + ` oifname "eth0" masquerade`,
+		`iifname "gocker0" oifname "eth0" accept`,
+		`iifname "eth0" oifname "gocker0" accept`,
+	} {
+		if !strings.Contains(ruleset, want) {
+			t.Errorf("nftNATRuleset() missing %q:\n%s", want, ruleset)
+		}
+	}
+}
+
+func TestRequireIptables(t *testing.T) {
+	host := useFakeHost(t)
+	host.missing = []string{"iptables"}
+
+	tests := []struct {
+		opts RunOptions
+		flag string
+	}{
+		{RunOptions{Internal: true}, "--internal"},
+		{RunOptions{Publish: []string{"8080:80"}}, "-p"},
+		{RunOptions{Expose: []string{"80"}}, "--expose"},
+		{RunOptions{AllowFrom: []string{"10.1.0.0/16"}}, "--allow-from"},
+	}
+	for _, tt := range tests {
+		err := requireIptables(&tt.opts)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.flag+" requires iptables") {
+			t.Errorf("requireIptables(%s) without iptables = %v, want a %s error", tt.flag, err, tt.flag)
+		}
+	}
+	if err := requireIptables(&RunOptions{}); err != nil {
+		t.Errorf("requireIptables() without rule flags = %v, want nil", err)
+	}
+
+	host.missing = nil
+	for _, tt := range tests {
+		if err := requireIptables(&tt.opts); err != nil {
+			t.Errorf("requireIptables(%s) with iptables = %v, want nil", tt.flag, err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// containerHostsName is the managed hosts file in the container's directory,
+// bind mounted at /etc/hosts
+const containerHostsName = "hosts"
+
+// hostnamePattern restricts network aliases and link aliases to valid host names
+var hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?$`)
+
+// hostEntry is an /etc/hosts line, from 'run --add-host name:ip' or --link
+type hostEntry struct {
+	ip    string
+	names []string
+}
+
+// containerLink is a running container made reachable with 'run --link'
+type containerLink struct {
+	alias string
+	state *ContainerState
+}
+
+// parseAddHosts validates --add-host values of the form name:ip
+// The name ends at the first colon, so IPv6 addresses need no brackets
+func parseAddHosts(hosts []string) ([]hostEntry, error) {
+	var entries []hostEntry
+	for _, host := range hosts {
+		name, ip, ok := strings.Cut(host, ":")
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid add-host: %s (expected name:ip)", host)
+		}
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid add-host: %s (invalid IP address %s)", host, ip)
+		}
+		entries = append(entries, hostEntry{ip: ip, names: []string{name}})
+	}
+	return entries, nil
+}
+
+// validateNetworkAliases checks --network-alias values
+func validateNetworkAliases(aliases []string) error {
+	for _, alias := range aliases {
+		if !hostnamePattern.MatchString(alias) {
+			return fmt.Errorf("invalid network alias: %s (use letters, digits, '.', '-')", alias)
+		}
+	}
+	return nil
+}
+
+// resolveLinks resolves --link values of the form container[:alias] to
+// running containers; the alias defaults to the linked container's short ID
+func resolveLinks(links []string) ([]containerLink, error) {
+	var resolved []containerLink
+	for _, link := range links {
+		id, alias, hasAlias := strings.Cut(link, ":")
+		if hasAlias && !hostnamePattern.MatchString(alias) {
+			return nil, fmt.Errorf("invalid link alias: %s (use letters, digits, '.', '-')", alias)
+		}
+		state, err := loadContainerState(id)
+		if err != nil {
+			return nil, err
+		}
+		if !isActive(state.Status) || !isProcessAlive(state) || state.ContainerIP == "" {
+			return nil, fmt.Errorf("cannot link to %s: container is not running or has no network", shortID(state.ID))
+		}
+		if !hasAlias {
+			alias = shortID(state.ID)
+		}
+		resolved = append(resolved, containerLink{alias: alias, state: state})
+	}
+	return resolved, nil
+}
+
+// linkHostEntries returns the hosts entries for linked containers: the link
+// alias, the container's short ID, and its own network aliases
+func linkHostEntries(links []containerLink) []hostEntry {
+	var entries []hostEntry
+	for _, link := range links {
+		names := []string{link.alias}
+		if id := shortID(link.state.ID); id != link.alias {
+			names = append(names, id)
+		}
+		if link.state.Options != nil {
+			names = append(names, link.state.Options.NetworkAliases...)
+		}
+		entries = append(entries, hostEntry{ip: link.state.ContainerIP, names: names})
+	}
+	return entries
+}
+
+// linkEnv returns the legacy link environment variables: <ALIAS>_NAME, and
+// <ALIAS>_ENV_<KEY> for each variable set with -e on the linked container
+func linkEnv(containerID string, links []containerLink) []string {
+	var env []string
+	for _, link := range links {
+		prefix := envName(link.alias)
+		env = append(env, fmt.Sprintf("%s_NAME=/%s/%s", prefix, shortID(containerID), link.alias))
+		if link.state.Options == nil {
+			continue
+		}
+		for _, variable := range link.state.Options.Env {
+			if key, value, ok := strings.Cut(variable, "="); ok {
+				env = append(env, fmt.Sprintf("%s_ENV_%s=%s", prefix, key, value))
+			}
+		}
+	}
+	return env
+}
+
+// envName turns an alias into an environment variable prefix: "my-db" is MY_DB
+func envName(alias string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, alias)
+}
+
+// hostsFileContent returns the managed /etc/hosts of a container: localhost,
+// the container's hostname and network aliases on its IP, then entries from
+// --add-host and --link
+func hostsFileContent(containerIP string, aliases []string, entries []hostEntry) string {
+	var b strings.Builder
+	b.WriteString("127.0.0.1\tlocalhost\n")
+	b.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	if containerIP == "" {
+		containerIP = "127.0.1.1"
+	}
+	fmt.Fprintf(&b, "%s\t%s\n", containerIP, strings.Join(append([]string{containerHostname}, aliases...), " "))
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "%s\t%s\n", entry.ip, strings.Join(entry.names, " "))
+	}
+	return b.String()
+}
+
+// writeHostsFile writes a container's managed hosts file and returns its path
+func writeHostsFile(containerID, containerIP string, aliases []string, entries []hostEntry) (string, error) {
+	path := filepath.Join(containerDir(containerID), containerHostsName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create container directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(hostsFileContent(containerIP, aliases, entries)), 0644); err != nil {
+		return "", fmt.Errorf("failed to write hosts file: %v", err)
+	}
+	return path, nil
+}
+
+// mountsEtcHosts reports whether a volume already provides /etc/hosts, in
+// which case gocker leaves it alone
+func mountsEtcHosts(volumes []string) bool {
+	for _, volume := range volumes {
+		if _, containerPath, ok := strings.Cut(volume, ":"); ok && filepath.Clean(strings.TrimSpace(containerPath)) == "/etc/hosts" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestParseAddHosts checks --add-host parsing, including IPv6 addresses
+func TestParseAddHosts(t *testing.T) {
+	entries, err := parseAddHosts([]string{"db:10.0.0.5", "api.local:fd00::1"})
+	if err != nil {
+		t.Fatalf("parseAddHosts failed: %v", err)
+	}
+	want := []hostEntry{{ip: "10.0.0.5", names: []string{"db"}}, {ip: "fd00::1", names: []string{"api.local"}}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("parseAddHosts = %+v", entries)
+	}
+
+	for _, host := range []string{"db", ":10.0.0.5", "db:", "db:not-an-ip", "my db:10.0.0.5"} {
+		if _, err := parseAddHosts([]string{host}); err == nil {
+			t.Errorf("parseAddHosts(%q): expected error", host)
+		}
+	}
+}
+
+// TestWriteHostsFile checks the managed hosts file contents
+func TestWriteHostsFile(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	entries, _ := parseAddHosts([]string{"db:10.0.0.5"})
+	path, err := writeHostsFile("abc123", "10.0.0.7", []string{"api"}, entries)
+	if err != nil {
+		t.Fatalf("writeHostsFile failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read hosts file: %v", err)
+	}
+	want := "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n10.0.0.7\tgocker-container api\n10.0.0.5\tdb\n"
+	if string(data) != want {
+		t.Errorf("hosts file =\n%s\nwant\n%s", data, want)
+	}
+
+	// Without a network the hostname still resolves
+	if got := hostsFileContent("", nil, nil); got != "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n127.0.1.1\tgocker-container\n" {
+		t.Errorf("hostsFileContent without IP = %q", got)
+	}
+
+	if !mountsEtcHosts([]string{"/srv/hosts:/etc/hosts"}) || mountsEtcHosts([]string{"/srv/data:/data"}) {
+		t.Error("mountsEtcHosts did not detect a user-provided /etc/hosts")
+	}
+}
+
+// TestLinks checks --link resolution, hosts entries, and legacy link variables
+func TestLinks(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	db := &ContainerState{
+		ID:          "db0123456789abcdef",
+		PID:         os.Getpid(),
+		Status:      statusRunning,
+		ContainerIP: "10.0.0.5",
+		Options:     &RunOptions{NetworkAliases: []string{"postgres"}, Env: []string{"POSTGRES_DB=app", "HTTP_PROXY"}},
+	}
+	if startTime, err := processStartTime(db.PID); err == nil {
+		db.StartTime = startTime
+	}
+	if err := saveContainerState(db); err != nil {
+		t.Fatalf("saveContainerState failed: %v", err)
+	}
+
+	links, err := resolveLinks([]string{"db01:my-db"})
+	if err != nil {
+		t.Fatalf("resolveLinks failed: %v", err)
+	}
+	entries := linkHostEntries(links)
+	want := []hostEntry{{ip: "10.0.0.5", names: []string{"my-db", "db0123456789", "postgres"}}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("linkHostEntries = %+v, want %+v", entries, want)
+	}
+	env := linkEnv("web0123456789abc", links)
+	if wantEnv := []string{"MY_DB_NAME=/web012345678/my-db", "MY_DB_ENV_POSTGRES_DB=app"}; !reflect.DeepEqual(env, wantEnv) {
+		t.Errorf("linkEnv = %v, want %v", env, wantEnv)
+	}
+
+	// Without an alias the link is named after the container's short ID
+	if links, err := resolveLinks([]string{"db01"}); err != nil || links[0].alias != "db0123456789" {
+		t.Errorf("Expected default alias db0123456789, got %+v (%v)", links, err)
+	}
+	for _, link := range []string{"db01:bad alias", "missing:db"} {
+		if _, err := resolveLinks([]string{link}); err == nil {
+			t.Errorf("resolveLinks(%q): expected error", link)
+		}
+	}
+
+	db.Status = statusExited
+	saveContainerState(db)
+	if _, err := resolveLinks([]string{"db01"}); err == nil {
+		t.Error("Expected error linking to a container that is not running")
+	}
+
+	if err := validateNetworkAliases([]string{"api", "api.v2"}); err != nil {
+		t.Errorf("validateNetworkAliases: unexpected error: %v", err)
+	}
+	if err := validateNetworkAliases([]string{"-api"}); err == nil {
+		t.Error("Expected error for an invalid network alias")
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Feature check results
+const (
+	featureOK       = "ok"
+	featureDegraded = "degraded" // some gocker features will not work
+	featureMissing  = "missing"  // containers cannot run if the feature is required
+)
+
+// procRoot and lockdownFile are read by the feature checks
+var (
+	procRoot     = "/proc"
+	lockdownFile = "/sys/kernel/security/lockdown"
+)
+
+// FeatureCheck is the result of probing one kernel feature or host tool
+type FeatureCheck struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Detail   string `json:"detail"`
+	Required bool   `json:"required"` // containers cannot run without it
+}
+
+// hostTools are the host binaries gocker runs, and what fails without each
+var hostTools = []struct{ name, without string }{
+	{"iptables", "no published ports, --internal, or firewall rules, and no NAT without nft"},
+	{"nsenter", "gocker exec and gocker debug fail"},
+}
+
+// SystemInfo summarizes the runtime and host for 'gocker info', e.g. for bug reports
+type SystemInfo struct {
+	Version       string         `json:"version"`
+	Commit        string         `json:"commit,omitempty"`
+	GoVersion     string         `json:"go_version"`
+	Kernel        string         `json:"kernel"`
+	Context       string         `json:"context"`
+	DataRoot      string         `json:"data_root"`
+	DataRootFree  int64          `json:"data_root_free"` // bytes available, 0 if unknown
+	CgroupDriver  string         `json:"cgroup_driver"`
+	CgroupVersion string         `json:"cgroup_version"`
+	CgroupParent  string         `json:"cgroup_parent"`
+	Containers    map[string]int `json:"containers"` // status -> count
+	Templates     int            `json:"templates"`
+	DefaultRootfs string         `json:"default_rootfs,omitempty"`
+	Bridge        string         `json:"bridge"`
+	BridgeIP      string         `json:"bridge_ip"`
+	Subnet        string         `json:"subnet"`
+	BridgeState   string         `json:"bridge_state"` // up, down, or absent
+	Security      []string       `json:"security"`
+	Warnings      []string       `json:"warnings,omitempty"` // see hostWarnings
+}
+
+func infoCommand(args []string) {
+	var jsonOutput, checkDeps bool
+	flags := newCommandFlags("info", "[options]", "Show system information and check the kernel features and host tools gocker needs")
+	flags.BoolVar(&jsonOutput, "json", "", "Print the information and checks as JSON")
+	flags.BoolVar(&checkDeps, "check-deps", "", "List the external programs gocker can run, whether each is installed, and what needs it")
+	if len(flags.MustParse(args)) > 0 {
+		flags.Fail("info does not accept arguments")
+	}
+	if checkDeps {
+		must(printDependencies(checkDependencies(), jsonOutput))
+		return
+	}
+
+	info := systemInfo()
+	checks := featureChecks()
+	if jsonOutput {
+		data, err := json.MarshalIndent(struct {
+			System   *SystemInfo    `json:"system"`
+			Features []FeatureCheck `json:"features"`
+		}{info, checks}, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+	} else {
+		printSystemInfo(info)
+		fmt.Println()
+		fmt.Printf("%-20s %-9s %s\n", "FEATURE", "STATUS", "DETAIL")
+		fmt.Println(strings.Repeat("-", 80))
+		for _, check := range checks {
+			fmt.Printf("%-20s %-9s %s\n", check.Name, check.Status, check.Detail)
+		}
+	}
+
+	if err := requiredFeaturesError(checks); err != nil {
+		if !jsonOutput {
+			fmt.Println()
+			fmt.Printf("Containers cannot run on this host: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// systemInfo gathers the summary; anything that cannot be read is left empty
+func systemInfo() *SystemInfo {
+	build := buildInfo()
+	info := &SystemInfo{
+		Version:       build.Version,
+		Commit:        build.Commit,
+		GoVersion:     build.GoVersion,
+		Kernel:        readProcSetting("sys/kernel/osrelease"),
+		Context:       activeContextName,
+		DataRoot:      stateDir,
+		CgroupDriver:  cgroupDriver,
+		CgroupVersion: "v2",
+		CgroupParent:  cgroupParent,
+		Containers:    make(map[string]int),
+		DefaultRootfs: defaultRootfs,
+		Bridge:        bridgeName,
+		BridgeIP:      bridgeIP,
+		Subnet:        containerNet,
+	}
+	if cgroups.Mode() == "v1" {
+		info.CgroupVersion = "v1"
+	}
+	if fs, err := statFS(stateDir); err == nil {
+		info.DataRootFree = fs.Free
+	}
+
+	ids, _ := listContainerIDs()
+	for _, id := range ids {
+		if state, err := readContainerState(id); err == nil {
+			info.Containers[state.Status]++
+		}
+	}
+	templates, _ := loadAllTemplates()
+	info.Templates = len(templates)
+	info.BridgeState = "absent"
+	if iface, err := net.InterfaceByName(bridgeName); err == nil {
+		info.BridgeState = "down"
+		if iface.Flags&net.FlagUp != 0 {
+			info.BridgeState = "up"
+		}
+	}
+
+	info.Security = []string{"namespaces (uts, pid, mnt, net, cgroup)"}
+	if os.Geteuid() != 0 {
+		info.Security = append(info.Security, "user namespace (rootless)")
+	}
+	if lockdown := checkLockdown(); lockdown.Status != featureOK {
+		info.Security = append(info.Security, "kernel lockdown ("+strings.Fields(lockdown.Detail)[0]+")")
+	}
+	info.Warnings = hostWarnings(gatherHostFacts())
+	return info
+}
+
+// printSystemInfo prints the summary as "Key: value" lines
+func printSystemInfo(info *SystemInfo) {
+	versionLine := info.Version
+	if info.Commit != "" {
+		versionLine += " (commit " + info.Commit + ")"
+	}
+	free := "unknown"
+	if info.DataRootFree > 0 {
+		free = formatMemory(info.DataRootFree)
+	}
+	total := 0
+	var statuses []string
+	for status := range info.Containers {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	var counts []string
+	for _, status := range statuses {
+		total += info.Containers[status]
+		counts = append(counts, fmt.Sprintf("%s %d", status, info.Containers[status]))
+	}
+	containers := strconv.Itoa(total)
+	if len(counts) > 0 {
+		containers += " (" + strings.Join(counts, ", ") + ")"
+	}
+	rootfs := info.DefaultRootfs
+	if rootfs == "" {
+		rootfs = "./rootfs"
+	}
+
+	lines := [][2]string{
+		{"Version", versionLine},
+		{"Go version", info.GoVersion},
+		{"Kernel version", info.Kernel},
+		{"Context", info.Context},
+		{"Data root", fmt.Sprintf("%s (%s free)", info.DataRoot, free)},
+		{"Cgroup driver", info.CgroupDriver},
+		{"Cgroup version", info.CgroupVersion},
+		{"Cgroup parent", info.CgroupParent},
+		{"Containers", containers},
+		{"Templates", strconv.Itoa(info.Templates)},
+		{"Default rootfs", rootfs},
+		{"Bridge", fmt.Sprintf("%s, %s on %s (%s)", info.Bridge, info.BridgeIP, info.Subnet, info.BridgeState)},
+		{"Security", strings.Join(info.Security, ", ")},
+	}
+	for _, line := range lines {
+		fmt.Printf("%-16s %s\n", line[0]+":", line[1])
+	}
+	if len(info.Warnings) > 0 {
+		fmt.Println()
+		for _, warning := range info.Warnings {
+			fmt.Printf("WARNING: %s\n", warning)
+		}
+	}
+}
+
+// checkRequiredFeatures fails with the first missing feature containers need,
+// so 'gocker run' reports it instead of a cryptic write error
+func checkRequiredFeatures() error {
+	if err := requiredFeaturesError(featureChecks()); err != nil {
+		return fmt.Errorf("%v (run 'gocker info' for details)", err)
+	}
+	return nil
+}
+
+// requiredFeaturesError describes the first required feature that is missing
+func requiredFeaturesError(checks []FeatureCheck) error {
+	for _, check := range checks {
+		if check.Required && check.Status == featureMissing {
+			return fmt.Errorf("%s: %s", check.Name, check.Detail)
+		}
+	}
+	return nil
+}
+
+// featureChecks probes the host
+func featureChecks() []FeatureCheck {
+	checks := []FeatureCheck{
+		checkNamespaces(),
+		checkUserNamespaces(),
+		checkCgroups(cgroupRoot),
+		checkPressure(cgroupRoot),
+	}
+	checks = append(checks, checkFilesystems()...)
+	checks = append(checks, checkLockdown(), checkEmulation())
+	for _, tool := range hostTools {
+		check := FeatureCheck{Name: tool.name, Status: featureOK}
+		if path, err := exec.LookPath(tool.name); err == nil {
+			check.Detail = path
+		} else {
+			check.Status = featureDegraded
+			check.Detail = "not found in PATH; " + tool.without
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// checkNamespaces checks for the namespaces every container is created in
+func checkNamespaces() FeatureCheck {
+	namespaces := []string{"uts", "pid", "mnt", "net", "cgroup"}
+	var missing []string
+	for _, ns := range namespaces {
+		if _, err := os.Stat(filepath.Join(procRoot, "self", "ns", ns)); err != nil {
+			missing = append(missing, ns)
+		}
+	}
+	if len(missing) > 0 {
+		return FeatureCheck{Name: "namespaces", Status: featureMissing, Required: true,
+			Detail: "kernel lacks " + strings.Join(missing, ", ") + " namespace support"}
+	}
+	return FeatureCheck{Name: "namespaces", Status: featureOK, Required: true, Detail: strings.Join(namespaces, ", ")}
+}
+
+// checkUserNamespaces checks user namespaces, which only gocker run without root uses
+func checkUserNamespaces() FeatureCheck {
+	check := FeatureCheck{Name: "user namespaces", Status: featureOK, Required: os.Geteuid() != 0, Detail: "available"}
+	var reason string
+	if _, err := os.Stat(filepath.Join(procRoot, "self", "ns", "user")); err != nil {
+		reason = "kernel lacks user namespace support"
+	} else if readProcSetting("sys/user/max_user_namespaces") == "0" {
+		reason = "disabled (user.max_user_namespaces is 0)"
+	} else if check.Required && readProcSetting("sys/kernel/unprivileged_userns_clone") == "0" {
+		reason = "disabled for unprivileged users (kernel.unprivileged_userns_clone is 0)"
+	} else if check.Required && readProcSetting("sys/kernel/apparmor_restrict_unprivileged_userns") == "1" {
+		reason = "restricted by AppArmor (kernel.apparmor_restrict_unprivileged_userns is 1)"
+	}
+	if reason == "" {
+		return check
+	}
+	if check.Required {
+		check.Status = featureMissing
+		check.Detail = reason + "; run gocker as root"
+	} else {
+		check.Status = featureDegraded
+		check.Detail = reason + "; only gocker run without root needs them"
+	}
+	return check
+}
+
+// readProcSetting reads a value under procRoot, or "" if it does not exist
+func readProcSetting(path string) string {
+	data, err := os.ReadFile(filepath.Join(procRoot, path))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// checkCgroups checks the cgroup hierarchy at root is writable and has the
+// controllers gocker sets limits with; pids is required since every container
+// gets pids.max
+func checkCgroups(root string) FeatureCheck {
+	check := FeatureCheck{Name: "cgroups (" + cgroups.Mode() + ")", Status: featureOK, Required: true}
+	if unavailable, ok := cgroups.(cgroupsUnavailable); ok {
+		check.Status = featureMissing
+		check.Detail = unavailable.err.Error()
+		return check
+	}
+	fs, err := statFS(root)
+	if err != nil {
+		check.Status = featureMissing
+		check.Detail = fmt.Sprintf("no cgroup filesystem at %s", root)
+		return check
+	}
+	if fs.ReadOnly {
+		check.Status = featureMissing
+		check.Detail = fmt.Sprintf("%s is mounted read-only", root)
+		return check
+	}
+
+	available := make(map[string]bool)
+	if cgroups.Mode() == "v1" {
+		for _, controller := range cgroupV1Controllers {
+			if info, err := os.Stat(filepath.Join(root, controller)); err == nil && info.IsDir() {
+				available[controller] = true
+			}
+		}
+	} else {
+		data, err := os.ReadFile(filepath.Join(root, "cgroup.controllers"))
+		if err != nil {
+			check.Status = featureMissing
+			check.Detail = fmt.Sprintf("no cgroup v2 hierarchy at %s", root)
+			return check
+		}
+		for _, controller := range strings.Fields(string(data)) {
+			available[controller] = true
+		}
+		// cgroup v2 freezes through cgroup.freeze rather than a controller
+		available["freezer"] = true
+	}
+
+	without := map[string]string{
+		"cpu":     "--cpu-limit fails",
+		"memory":  "--memory-limit fails",
+		"freezer": "gocker pause fails",
+	}
+	var degraded []string
+	for _, controller := range cgroupV1Controllers {
+		if available[controller] {
+			continue
+		}
+		if controller == "pids" {
+			check.Status = featureMissing
+			check.Detail = "pids controller not available; every container needs it"
+			return check
+		}
+		check.Status = featureDegraded
+		degraded = append(degraded, fmt.Sprintf("no %s controller (%s)", controller, without[controller]))
+	}
+	if len(degraded) > 0 {
+		check.Detail = strings.Join(degraded, "; ")
+	} else {
+		check.Detail = "cpu, memory, pids, and freezing available"
+	}
+	return check
+}
+
+// checkPressure checks for pressure stall information, shown by gocker stats
+func checkPressure(root string) FeatureCheck {
+	check := FeatureCheck{Name: "pressure (PSI)", Status: featureOK, Detail: "available"}
+	if cgroups.Mode() == "v1" {
+		check.Status = featureDegraded
+		check.Detail = "needs cgroup v2; gocker stats shows no pressure"
+	} else if _, err := os.Stat(filepath.Join(root, "cpu.pressure")); err != nil {
+		check.Status = featureDegraded
+		check.Detail = "kernel built without PSI or booted with psi=0; gocker stats shows no pressure"
+	}
+	return check
+}
+
+// checkFilesystems checks /proc/filesystems for tmpfs, which --ephemeral
+// mounts, and overlay, which gocker does not use yet: containers run directly
+// on their rootfs and snapshots are copies
+func checkFilesystems() []FeatureCheck {
+	data, _ := os.ReadFile(filepath.Join(procRoot, "filesystems"))
+	supported := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			supported[fields[len(fields)-1]] = true
+		}
+	}
+
+	tmpfs := FeatureCheck{Name: "tmpfs", Status: featureOK, Detail: "available"}
+	if !supported["tmpfs"] {
+		tmpfs.Status = featureDegraded
+		tmpfs.Detail = "not supported; --ephemeral fails"
+	}
+	overlay := FeatureCheck{Name: "overlayfs", Status: featureOK, Detail: "available"}
+	if !supported["overlay"] {
+		overlay.Detail = "not supported (try 'modprobe overlay'); not needed by gocker"
+	}
+	return []FeatureCheck{tmpfs, overlay}
+}
+
+// checkEmulation lists the platforms binfmt_misc can emulate for --platform
+func checkEmulation() FeatureCheck {
+	check := FeatureCheck{Name: "emulation (binfmt_misc)", Status: featureOK}
+	handlers, err := loadBinfmtHandlers()
+	if err != nil {
+		check.Status = featureDegraded
+		check.Detail = "binfmt_misc not mounted; --platform only runs the host's architecture"
+		return check
+	}
+	var platforms []string
+	for _, name := range platformNames() {
+		if runsNatively(name) {
+			continue
+		}
+		for _, handler := range handlers {
+			if handler.Enabled && handler.handles(platformArchs[name]) {
+				platforms = append(platforms, "linux/"+name)
+				break
+			}
+		}
+	}
+	check.Detail = strings.Join(platforms, ", ")
+	if len(platforms) == 0 {
+		check.Detail = "no QEMU handlers registered (install qemu-user-static)"
+	}
+	return check
+}
+
+// checkLockdown reports the kernel lockdown mode, from a file such as
+// "none [integrity] confidentiality"
+func checkLockdown() FeatureCheck {
+	check := FeatureCheck{Name: "kernel lockdown", Status: featureOK, Detail: "none"}
+	data, err := os.ReadFile(lockdownFile)
+	if err != nil {
+		check.Detail = "not supported by this kernel"
+		return check
+	}
+	text := string(data)
+	start, end := strings.Index(text, "["), strings.Index(text, "]")
+	if start < 0 || end < start {
+		return check
+	}
+	if mode := text[start+1 : end]; mode != "none" {
+		check.Status = featureDegraded
+		check.Detail = mode + " mode; unsigned kernel modules such as br_netfilter cannot be loaded"
+	}
+	return check
+}
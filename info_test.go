@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeProc builds a /proc fixture with the given namespaces and files
+func fakeProc(t *testing.T, namespaces []string, files map[string]string) {
+	root := t.TempDir()
+	savedProc, savedLockdown := procRoot, lockdownFile
+	procRoot, lockdownFile = root, filepath.Join(root, "lockdown")
+	t.Cleanup(func() { procRoot, lockdownFile = savedProc, savedLockdown })
+
+	os.MkdirAll(filepath.Join(root, "self", "ns"), 0755)
+	for _, ns := range namespaces {
+		os.WriteFile(filepath.Join(root, "self", "ns", ns), nil, 0644)
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755)
+		os.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+	}
+}
+
+func TestCheckNamespaces(t *testing.T) {
+	fakeProc(t, []string{"uts", "pid", "mnt", "net", "cgroup"}, nil)
+	if check := checkNamespaces(); check.Status != featureOK {
+		t.Errorf("Expected namespaces ok, got %+v", check)
+	}
+
+	fakeProc(t, []string{"uts", "pid", "mnt", "net"}, nil)
+	check := checkNamespaces()
+	if check.Status != featureMissing || !strings.Contains(check.Detail, "cgroup") {
+		t.Errorf("Expected missing cgroup namespace, got %+v", check)
+	}
+	if err := requiredFeaturesError([]FeatureCheck{check}); err == nil || !strings.Contains(err.Error(), "namespaces") {
+		t.Errorf("Expected required feature error, got %v", err)
+	}
+}
+
+func TestCheckUserNamespaces(t *testing.T) {
+	fakeProc(t, []string{"user"}, map[string]string{"sys/user/max_user_namespaces": "0\n"})
+	check := checkUserNamespaces()
+	if check.Status == featureOK || !strings.Contains(check.Detail, "max_user_namespaces") {
+		t.Errorf("Expected user namespaces to be reported disabled, got %+v", check)
+	}
+	// Only required when gocker runs without root
+	if check.Required != (os.Geteuid() != 0) {
+		t.Errorf("Expected Required to be %v, got %+v", os.Geteuid() != 0, check)
+	}
+
+	fakeProc(t, []string{"user"}, map[string]string{"sys/user/max_user_namespaces": "1024\n"})
+	if check := checkUserNamespaces(); check.Status != featureOK {
+		t.Errorf("Expected user namespaces ok, got %+v", check)
+	}
+}
+
+func TestCheckCgroupsV1(t *testing.T) {
+	saved := cgroups
+	t.Cleanup(func() { cgroups = saved })
+	root := t.TempDir()
+	cgroups = &cgroupV1{root: root}
+	for _, controller := range []string{"cpu", "pids"} {
+		os.MkdirAll(filepath.Join(root, controller), 0755)
+	}
+
+	check := checkCgroups(root)
+	if check.Status != featureDegraded || !strings.Contains(check.Detail, "memory") || !strings.Contains(check.Detail, "freezer") {
+		t.Errorf("Expected degraded cgroups without memory and freezer, got %+v", check)
+	}
+
+	os.Remove(filepath.Join(root, "pids"))
+	if check := checkCgroups(root); check.Status != featureMissing {
+		t.Errorf("Expected cgroups missing without pids, got %+v", check)
+	}
+	if check := checkPressure(root); check.Status != featureDegraded {
+		t.Errorf("Expected no pressure on cgroup v1, got %+v", check)
+	}
+}
+
+func TestCheckFilesystemsAndLockdown(t *testing.T) {
+	fakeProc(t, nil, map[string]string{
+		"filesystems": "nodev\tsysfs\nnodev\ttmpfs\n\text4\n",
+		"lockdown":    "none [integrity] confidentiality\n",
+	})
+	checks := checkFilesystems()
+	if checks[0].Name != "tmpfs" || checks[0].Status != featureOK {
+		t.Errorf("Expected tmpfs ok, got %+v", checks[0])
+	}
+	if checks[1].Name != "overlayfs" || !strings.Contains(checks[1].Detail, "not supported") {
+		t.Errorf("Expected overlayfs unsupported, got %+v", checks[1])
+	}
+	if check := checkLockdown(); check.Status != featureDegraded || !strings.HasPrefix(check.Detail, "integrity") {
+		t.Errorf("Expected integrity lockdown, got %+v", check)
+	}
+}
+
+func TestSystemInfo(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+	for id, status := range map[string]string{"aaa": statusRunning, "bbb": statusExited, "ccc": statusExited} {
+		if err := saveContainerState(&ContainerState{ID: id, Status: status}); err != nil {
+			t.Fatalf("saveContainerState failed: %v", err)
+		}
+	}
+	if err := saveTemplate(&ContainerTemplate{Name: "web", Options: &RunOptions{Command: []string{"/bin/sh"}}}); err != nil {
+		t.Fatalf("saveTemplate failed: %v", err)
+	}
+
+	info := systemInfo()
+	if info.Containers[statusRunning] != 1 || info.Containers[statusExited] != 2 {
+		t.Errorf("Containers = %v, want running 1 and exited 2", info.Containers)
+	}
+	if info.Templates != 1 {
+		t.Errorf("Templates = %d, want 1", info.Templates)
+	}
+	if info.DataRoot != stateDir || info.DataRootFree <= 0 {
+		t.Errorf("Expected data root %s with free space, got %s (%d)", stateDir, info.DataRoot, info.DataRootFree)
+	}
+	if info.Version != version || info.Bridge != bridgeName || info.Subnet != containerNet {
+		t.Errorf("Unexpected summary %+v", info)
+	}
+}
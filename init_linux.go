@@ -0,0 +1,57 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// runInit runs the container command under a minimal init for 'run --init'
+// The init stays PID 1, forwards the signals it receives to the command, and
+// reaps orphaned processes; it returns the command's exit status
+func runInit(path string, argv, env []string) int {
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals)
+	defer signal.Stop(signals)
+
+	cmd := exec.Command(path)
+	cmd.Args = argv
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = env
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to execute %s: %v\n", argv[0], err)
+		return exitCodeNotExecutable
+	}
+
+	go func() {
+		for sig := range signals {
+			// SIGCHLD is handled by the reaping loop; SIGURG is the Go runtime's own
+			if sig == syscall.SIGCHLD || sig == syscall.SIGURG {
+				continue
+			}
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	// Reap every child, not just the command: orphans are reparented to PID 1
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to wait for %s: %v\n", argv[0], err)
+			return exitCodeNotExecutable
+		}
+		if pid == cmd.Process.Pid {
+			return waitStatusCode(status)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+// TestRunInit checks that the init returns the command's exit status
+func TestRunInit(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skipf("sh not found: %v", err)
+	}
+
+	tests := []struct {
+		script string
+		want   int
+	}{
+		{"exit 0", 0},
+		{"sleep 0.1 & exit 5", 5},
+		{"kill -KILL $$", 128 + 9},
+	}
+	for _, tt := range tests {
+		if got := runInit(sh, []string{"sh", "-c", tt.script}, nil); got != tt.want {
+			t.Errorf("runInit(sh -c %q) = %d, want %d", tt.script, got, tt.want)
+		}
+	}
+
+	if got := runInit("/nonexistent/command", []string{"command"}, nil); got != exitCodeNotExecutable {
+		t.Errorf("Expected exit code %d for a command that cannot start, got %d", exitCodeNotExecutable, got)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func runInit(path string, argv, env []string) int {
+	fmt.Fprintf(os.Stderr, "Error: failed to execute %s: %v\n", argv[0], errNotLinux)
+	return exitCodeNotExecutable
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// namespaceTypes are the entries of /proc/<pid>/ns reported by inspect
+var namespaceTypes = []string{"cgroup", "ipc", "mnt", "net", "pid", "user", "uts"}
+
+// capabilityNames maps capability bit numbers to names, as in <linux/capability.h>
+var capabilityNames = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER",
+	"CAP_FSETID", "CAP_KILL", "CAP_SETGID", "CAP_SETUID",
+	"CAP_SETPCAP", "CAP_LINUX_IMMUTABLE", "CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_IPC_LOCK", "CAP_IPC_OWNER",
+	"CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT", "CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE", "CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD",
+	"CAP_LEASE", "CAP_AUDIT_WRITE", "CAP_AUDIT_CONTROL", "CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG", "CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// seccompModes are the values of the Seccomp field in /proc/<pid>/status
+var seccompModes = []string{"disabled", "strict", "filter"}
+
+// ProcessInfo is the live security context of a container's init process,
+// read from /proc for 'gocker inspect'
+type ProcessInfo struct {
+	PID          int               `json:"pid"`
+	Namespaces   map[string]uint64 `json:"namespaces"` // namespace type -> inode
+	CapEff       string            `json:"cap_eff"`    // effective capability mask, as in /proc/<pid>/status
+	Capabilities []string          `json:"capabilities"`
+	Seccomp      string            `json:"seccomp"`
+	NoNewPrivs   bool              `json:"no_new_privs"`
+	Cgroups      []string          `json:"cgroups"` // lines of /proc/<pid>/cgroup
+}
+
+// readProcessInfo reads the namespaces, capabilities, seccomp mode, and
+// cgroup membership of a process
+func readProcessInfo(pid int) (*ProcessInfo, error) {
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+	info := &ProcessInfo{PID: pid, Namespaces: make(map[string]uint64)}
+
+	for _, ns := range namespaceTypes {
+		link, err := os.Readlink(filepath.Join(procDir, "ns", ns))
+		if err != nil {
+			continue
+		}
+		if inode, ok := parseNamespaceLink(link); ok {
+			info.Namespaces[ns] = inode
+		}
+	}
+
+	status, err := readProcStatus(filepath.Join(procDir, "status"))
+	if err != nil {
+		return nil, err
+	}
+	info.CapEff = status["CapEff"]
+	info.Capabilities, err = capabilityList(info.CapEff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CapEff: %v", err)
+	}
+	info.Seccomp = "unknown"
+	if mode, err := strconv.Atoi(status["Seccomp"]); err == nil && mode >= 0 && mode < len(seccompModes) {
+		info.Seccomp = seccompModes[mode]
+	}
+	info.NoNewPrivs = status["NoNewPrivs"] == "1"
+
+	data, err := os.ReadFile(filepath.Join(procDir, "cgroup"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cgroups: %v", err)
+	}
+	info.Cgroups = strings.Split(strings.TrimSpace(string(data)), "\n")
+	return info, nil
+}
+
+// parseNamespaceLink parses a /proc/<pid>/ns link target such as "net:[4026531840]"
+func parseNamespaceLink(link string) (uint64, bool) {
+	_, rest, ok := strings.Cut(link, ":[")
+	if !ok || !strings.HasSuffix(rest, "]") {
+		return 0, false
+	}
+	inode, err := strconv.ParseUint(strings.TrimSuffix(rest, "]"), 10, 64)
+	return inode, err == nil
+}
+
+// readProcStatus reads the "Key:\tvalue" fields of /proc/<pid>/status
+func readProcStatus(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process status: %v", err)
+	}
+	defer f.Close()
+
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	return fields, scanner.Err()
+}
+
+// capabilityList returns the names of the capabilities set in a hex mask
+// Bits newer than capabilityNames are reported as CAP_<bit>
+func capabilityList(mask string) ([]string, error) {
+	bits, err := strconv.ParseUint(mask, 16, 64)
+	if err != nil {
+		return nil, err
+	}
+	caps := []string{}
+	for bit := 0; bit < 64; bit++ {
+		if bits&(1<<bit) == 0 {
+			continue
+		}
+		if bit < len(capabilityNames) {
+			caps = append(caps, capabilityNames[bit])
+		} else {
+			caps = append(caps, "CAP_"+strconv.Itoa(bit))
+		}
+	}
+	return caps, nil
+}
+
+// SecurityInfo summarizes the effective security configuration of a running
+// container for 'gocker inspect --security'
+// Lists are in a fixed order, so the output of two containers can be diffed
+// gocker installs no seccomp profile, so only the seccomp mode is reported
+type SecurityInfo struct {
+	Capabilities     []string     `json:"capabilities"` // effective
+	BoundingSet      []string     `json:"bounding_set"`
+	Seccomp          string       `json:"seccomp"`
+	NoNewPrivs       bool         `json:"no_new_privs"`
+	LSMLabel         string       `json:"lsm_label"` // AppArmor profile or SELinux context
+	UsernsRemap      string       `json:"userns_remap,omitempty"`
+	UIDMap           []IDMapEntry `json:"uid_map"`
+	GIDMap           []IDMapEntry `json:"gid_map"`
+	SharedNamespaces []string     `json:"shared_namespaces"` // namespaces shared with the host
+	MaskedPaths      []string     `json:"masked_paths"`
+	ReadonlyPaths    []string     `json:"readonly_paths"`
+}
+
+// IDMapEntry is a line of /proc/<pid>/uid_map or gid_map
+type IDMapEntry struct {
+	ContainerID uint32 `json:"container_id"`
+	HostID      uint32 `json:"host_id"`
+	Size        uint32 `json:"size"`
+}
+
+// readSecurityInfo reads the security configuration of a container's init
+// process, given the process info already read for it
+func readSecurityInfo(state *ContainerState, process *ProcessInfo) (*SecurityInfo, error) {
+	procDir := filepath.Join("/proc", strconv.Itoa(process.PID))
+	info := &SecurityInfo{
+		Capabilities:     process.Capabilities,
+		Seccomp:          process.Seccomp,
+		NoNewPrivs:       process.NoNewPrivs,
+		LSMLabel:         "none",
+		SharedNamespaces: []string{},
+		MaskedPaths:      maskedPaths,
+		ReadonlyPaths:    readonlyPaths,
+	}
+	if state.Options != nil {
+		info.UsernsRemap = state.Options.UsernsRemap
+	}
+
+	status, err := readProcStatus(filepath.Join(procDir, "status"))
+	if err != nil {
+		return nil, err
+	}
+	if info.BoundingSet, err = capabilityList(status["CapBnd"]); err != nil {
+		return nil, fmt.Errorf("failed to parse CapBnd: %v", err)
+	}
+
+	// attr/current is missing or empty without an LSM that labels processes
+	if data, err := os.ReadFile(filepath.Join(procDir, "attr", "current")); err == nil {
+		if label := strings.TrimRight(string(data), "\x00\n"); label != "" {
+			info.LSMLabel = label
+		}
+	}
+
+	if info.UIDMap, err = readIDMap(filepath.Join(procDir, "uid_map")); err != nil {
+		return nil, err
+	}
+	if info.GIDMap, err = readIDMap(filepath.Join(procDir, "gid_map")); err != nil {
+		return nil, err
+	}
+
+	for _, ns := range namespaceTypes {
+		link, err := os.Readlink(filepath.Join("/proc", "self", "ns", ns))
+		if err != nil {
+			continue
+		}
+		if inode, ok := parseNamespaceLink(link); ok && process.Namespaces[ns] == inode {
+			info.SharedNamespaces = append(info.SharedNamespaces, ns)
+		}
+	}
+	return info, nil
+}
+
+// readIDMap parses a uid_map or gid_map file
+func readIDMap(path string) ([]IDMapEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ID map: %v", err)
+	}
+	entries := []IDMapEntry{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		var ids [3]uint32
+		for i, field := range fields {
+			n, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ID map line %q in %s", line, path)
+			}
+			ids[i] = uint32(n)
+		}
+		entries = append(entries, IDMapEntry{ContainerID: ids[0], HostID: ids[1], Size: ids[2]})
+	}
+	return entries, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestCapabilityList checks capability masks decode to names
+func TestCapabilityList(t *testing.T) {
+	tests := map[string][]string{
+		"0000000000000000": {},
+		"0000000000003000": {"CAP_NET_ADMIN", "CAP_NET_RAW"},
+		"0000000000200001": {"CAP_CHOWN", "CAP_SYS_ADMIN"},
+		"0000080000000000": {"CAP_43"},
+	}
+	for mask, want := range tests {
+		got, err := capabilityList(mask)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("capabilityList(%s) = %v (%v), want %v", mask, got, err, want)
+		}
+	}
+	if _, err := capabilityList("not-hex"); err == nil {
+		t.Error("Expected error for an invalid mask")
+	}
+	if got, _ := capabilityList("000001ffffffffff"); len(got) != len(capabilityNames) {
+		t.Errorf("Expected every known capability, got %d", len(got))
+	}
+}
+
+// TestParseNamespaceLink checks /proc/<pid>/ns link targets are parsed
+func TestParseNamespaceLink(t *testing.T) {
+	if inode, ok := parseNamespaceLink("net:[4026531840]"); !ok || inode != 4026531840 {
+		t.Errorf("parseNamespaceLink = %d, %v", inode, ok)
+	}
+	for _, link := range []string{"net", "net:[abc]", "net:4026531840"} {
+		if _, ok := parseNamespaceLink(link); ok {
+			t.Errorf("parseNamespaceLink(%q): expected failure", link)
+		}
+	}
+}
+
+// TestReadProcessInfo checks the test process's own security context can be read
+func TestReadProcessInfo(t *testing.T) {
+	info, err := readProcessInfo(os.Getpid())
+	if err != nil {
+		t.Fatalf("readProcessInfo failed: %v", err)
+	}
+	if info.Namespaces["mnt"] == 0 || info.Namespaces["pid"] == 0 {
+		t.Errorf("Expected mnt and pid namespaces, got %v", info.Namespaces)
+	}
+	if info.CapEff == "" || info.Seccomp == "" || len(info.Cgroups) == 0 {
+		t.Errorf("Incomplete process info: %+v", info)
+	}
+	if _, err := readProcessInfo(-1); err == nil {
+		t.Error("Expected error for a missing process")
+	}
+}
+
+// TestReadSecurityInfo checks the test process's security configuration can be
+// read; it shares every namespace with itself
+func TestReadSecurityInfo(t *testing.T) {
+	process, err := readProcessInfo(os.Getpid())
+	if err != nil {
+		t.Fatalf("readProcessInfo failed: %v", err)
+	}
+	state := &ContainerState{Options: &RunOptions{UsernsRemap: "gocker"}}
+	info, err := readSecurityInfo(state, process)
+	if err != nil {
+		t.Fatalf("readSecurityInfo failed: %v", err)
+	}
+	if len(info.UIDMap) == 0 || len(info.GIDMap) == 0 || len(info.BoundingSet) == 0 {
+		t.Errorf("Incomplete security info: %+v", info)
+	}
+	if !reflect.DeepEqual(info.SharedNamespaces, namespaceTypesOf(process)) {
+		t.Errorf("SharedNamespaces = %v, want %v", info.SharedNamespaces, namespaceTypesOf(process))
+	}
+	if info.UsernsRemap != "gocker" || info.LSMLabel == "" {
+		t.Errorf("Unexpected security info: %+v", info)
+	}
+}
+
+// namespaceTypesOf returns the namespace types readProcessInfo found, in order
+func namespaceTypesOf(process *ProcessInfo) []string {
+	types := []string{}
+	for _, ns := range namespaceTypes {
+		if _, ok := process.Namespaces[ns]; ok {
+			types = append(types, ns)
+		}
+	}
+	return types
+}
+
+func TestReadIDMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uid_map")
+	if err := os.WriteFile(path, []byte("         0     100000      65536\n 65536 1000 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write map: %v", err)
+	}
+	got, err := readIDMap(path)
+	if err != nil {
+		t.Fatalf("readIDMap failed: %v", err)
+	}
+	want := []IDMapEntry{{0, 100000, 65536}, {65536, 1000, 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readIDMap = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// jobsFile holds the jobs and the attempts of their instances
+var jobsFile = "/var/lib/gocker/jobs.json"
+
+// jobLimit bounds how many finished jobs are remembered
+const jobLimit = 100
+
+// jobLabel is the label a job's containers carry, set to the job ID
+const jobLabel = "gocker.job"
+
+// jobIndexEnv tells each instance which of the job's completions it is
+const jobIndexEnv = "GOCKER_JOB_INDEX"
+
+// Job statuses
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobStopped   = "stopped" // interrupted before it finished
+)
+
+// Job runs a command to completion a number of times, in parallel
+// Each instance, numbered 0 to Completions-1, must succeed once; a failed
+// instance is retried up to Retries times, and the job fails when one runs
+// out of retries
+type Job struct {
+	ID          string        `json:"id"`
+	Command     []string      `json:"command"`
+	Completions int           `json:"completions"`
+	Parallelism int           `json:"parallelism"`
+	Retries     int           `json:"retries"`
+	Status      string        `json:"status"`
+	PID         int           `json:"pid"`       // the 'gocker job run' process
+	Succeeded   int           `json:"succeeded"` // instances that succeeded
+	Failed      int           `json:"failed"`    // failed attempts, retried or not
+	Instances   []JobInstance `json:"instances"`
+	CreatedAt   time.Time     `json:"created_at"`
+	FinishedAt  *time.Time    `json:"finished_at,omitempty"`
+}
+
+// JobInstance is one of a job's completions and its attempts at it
+type JobInstance struct {
+	Index    int          `json:"index"`
+	Attempts []JobAttempt `json:"attempts,omitempty"`
+}
+
+// JobAttempt is one container run of an instance
+// Error is set when the container could not be started
+type JobAttempt struct {
+	ContainerID string    `json:"container_id,omitempty"`
+	ExitCode    *int      `json:"exit_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// succeeded reports whether the attempt's container exited with status 0
+func (a JobAttempt) succeeded() bool {
+	return a.ExitCode != nil && *a.ExitCode == 0
+}
+
+// describe says how an attempt ended
+func (a JobAttempt) describe() string {
+	switch {
+	case a.Error != "":
+		return "failed to start: " + a.Error
+	case a.succeeded():
+		return "succeeded in " + shortID(a.ContainerID)
+	case a.ExitCode != nil:
+		return "failed in " + shortID(a.ContainerID) + " with exit status " + strconv.Itoa(*a.ExitCode)
+	}
+	return "failed"
+}
+
+// jobRunner runs a job's instances and records their attempts
+type jobRunner struct {
+	mu       sync.Mutex
+	job      *Job
+	stopping bool
+}
+
+// run starts the job's instances, Parallelism at a time, and waits for them
+// No instance is started once the job has failed or is being stopped; those
+// running are left to finish
+func (r *jobRunner) run(start func(index int) JobAttempt) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(r.job.Parallelism, r.job.Completions); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				r.runInstance(index, start)
+			}
+		}()
+	}
+	for index := 0; index < r.job.Completions && r.active(); index++ {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.job.FinishedAt = &now
+	switch {
+	case r.job.Status != jobRunning:
+	case r.job.Succeeded == r.job.Completions:
+		r.job.Status = jobSucceeded
+	default:
+		r.job.Status = jobStopped
+	}
+	r.save()
+}
+
+// runInstance runs an instance until it succeeds or runs out of retries
+func (r *jobRunner) runInstance(index int, start func(index int) JobAttempt) {
+	for attempt := 0; attempt <= r.job.Retries && r.active(); attempt++ {
+		result := start(index)
+
+		r.mu.Lock()
+		instance := &r.job.Instances[index]
+		instance.Attempts = append(instance.Attempts, result)
+		fmt.Printf("Instance %d attempt %d %s\n", index, attempt+1, result.describe())
+		if result.succeeded() {
+			r.job.Succeeded++
+		} else {
+			r.job.Failed++
+			if attempt == r.job.Retries && !r.stopping {
+				r.job.Status = jobFailed
+			}
+		}
+		r.save()
+		r.mu.Unlock()
+
+		if result.succeeded() {
+			return
+		}
+	}
+}
+
+// active reports whether instances may still be started
+func (r *jobRunner) active() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.job.Status == jobRunning && !r.stopping
+}
+
+// stop keeps further instances from starting
+func (r *jobRunner) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopping = true
+}
+
+// save records the job's progress; failures are logged, as the job goes on
+// Callers hold r.mu
+func (r *jobRunner) save() {
+	if err := saveJob(r.job); err != nil {
+		logger.Warn("Failed to record job", "job", shortID(r.job.ID), "error", err)
+	}
+}
+
+// startJobInstance runs one attempt of an instance: a foreground 'gocker run'
+// in a new process with the job's options, whose exit status is the
+// container's; its output goes only to the container's log
+var startJobInstance = func(job *Job, configPath string, index int) (attempt JobAttempt) {
+	attempt.StartedAt = time.Now()
+	defer func() { attempt.FinishedAt = time.Now() }()
+
+	dir, err := os.MkdirTemp("", "gocker-job-")
+	if err != nil {
+		attempt.Error = fmt.Sprintf("failed to create container ID file: %v", err)
+		return attempt
+	}
+	defer os.RemoveAll(dir)
+	cidFile := filepath.Join(dir, "cid")
+
+	executable, err := os.Executable()
+	if err != nil {
+		attempt.Error = fmt.Sprintf("failed to find gocker executable: %v", err)
+		return attempt
+	}
+	var args []string
+	if activeContextName != defaultContextName {
+		args = append(args, "--context", activeContextName)
+	}
+	args = append(args, "--data-root", stateDir, "run", "--config", configPath, "--quiet", "--cidfile", cidFile,
+		"--label", jobLabel+"="+job.ID, "--env", jobIndexEnv+"="+strconv.Itoa(index))
+	var stderr bytes.Buffer
+	cmd := exec.Command(executable, args...)
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	// Without a container ID the container never started, and the exit
+	// status is gocker's
+	if id, err := os.ReadFile(cidFile); err == nil && len(id) > 0 {
+		attempt.ContainerID = string(id)
+		code := 0
+		if runErr != nil {
+			code = -1
+			if cmd.ProcessState != nil {
+				code = exitStatus(cmd.ProcessState)
+			}
+		}
+		attempt.ExitCode = &code
+		return attempt
+	}
+	attempt.Error = strings.TrimPrefix(strings.TrimSpace(stderr.String()), "Error: ")
+	if attempt.Error == "" && runErr != nil {
+		attempt.Error = runErr.Error()
+	}
+	return attempt
+}
+
+// loadJobs reads the jobs, keyed by ID
+func loadJobs() (map[string]*Job, error) {
+	jobs := make(map[string]*Job)
+	data, err := os.ReadFile(jobsFile)
+	if os.IsNotExist(err) {
+		return jobs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jobs: %v", err)
+	}
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to parse jobs: %v", err)
+	}
+	return jobs, nil
+}
+
+// saveJob records a job, dropping the oldest finished jobs beyond jobLimit
+func saveJob(job *Job) error {
+	lock, err := lockGlobal("jobs")
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
+	jobs, err := loadJobs()
+	if err != nil {
+		return err
+	}
+	jobs[job.ID] = job
+	var finished []*Job
+	for _, j := range jobs {
+		if j.FinishedAt != nil {
+			finished = append(finished, j)
+		}
+	}
+	sort.Slice(finished, func(i, k int) bool { return finished[i].FinishedAt.Before(*finished[k].FinishedAt) })
+	for _, j := range finished {
+		if len(jobs) <= jobLimit {
+			break
+		}
+		delete(jobs, j.ID)
+	}
+
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal jobs: %v", err)
+	}
+	if err := writeFileAtomic(jobsFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write jobs: %v", err)
+	}
+	return nil
+}
+
+// findJob looks a job up by ID or unique ID prefix
+func findJob(id string) (*Job, error) {
+	jobs, err := loadJobs()
+	if err != nil {
+		return nil, err
+	}
+	var found *Job
+	for jobID, job := range jobs {
+		if strings.HasPrefix(jobID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("job ID %s is ambiguous", id)
+			}
+			found = job
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("job not found: %s", id)
+	}
+	return found, nil
+}
+
+// jobCommands lists the 'gocker job' subcommands
+func jobCommands() []*command {
+	return []*command{
+		{name: "run", description: "Run a command to completion in parallel containers", run: jobRunCommand},
+		{name: "ls", description: "List jobs", run: jobListCommand},
+		{name: "inspect", description: "Show a job's instances and attempts", run: jobInspectCommand},
+	}
+}
+
+// jobCommand dispatches the 'gocker job' subcommands
+func jobCommand(args []string) {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		printJobUsage()
+		if len(args) == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, cmd := range jobCommands() {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	fmt.Printf("Unknown job command: %s\n", args[0])
+	printJobUsage()
+	os.Exit(1)
+}
+
+func printJobUsage() {
+	fmt.Println("Usage: gocker job <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range jobCommands() {
+		fmt.Printf("  %-8s %s\n", cmd.name, cmd.description)
+	}
+}
+
+// parseJobCount parses a positive (or, with allowZero, non-negative) job option
+func parseJobCount(name, value string, allowZero bool) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || (n == 0 && !allowZero) {
+		return 0, fmt.Errorf("invalid --%s: %s", name, value)
+	}
+	return n, nil
+}
+
+func jobRunCommand(args []string) {
+	completionsFlag, parallelismFlag, retriesFlag := "1", "1", "0"
+	configure := func(flags *commandFlags) {
+		flags.name = "job run"
+		flags.usage = "[job options] [run options] <command> [args...]"
+		flags.description = "Run a command to completion in parallel containers"
+		flags.StringVar(&completionsFlag, "completions", "", "N", "Instances that must succeed (default 1); each gets its index in "+jobIndexEnv)
+		flags.StringVar(&parallelismFlag, "parallelism", "", "N", "Instances to run at once (default 1)")
+		flags.StringVar(&retriesFlag, "retries", "", "N", "Times to retry a failed instance before the job fails (default 0)")
+	}
+	opts, flags := parseRunFlags(args, configure)
+	if len(opts.Command) == 0 {
+		flags.Fail("command required")
+	}
+	if opts.Detached || opts.CIDFile != "" {
+		flags.Fail("--detach and --cidfile cannot be used with job run")
+	}
+	completions, err := parseJobCount("completions", completionsFlag, false)
+	if err != nil {
+		flags.Fail(err.Error())
+	}
+	parallelism, err := parseJobCount("parallelism", parallelismFlag, false)
+	if err != nil {
+		flags.Fail(err.Error())
+	}
+	retries, err := parseJobCount("retries", retriesFlag, true)
+	if err != nil {
+		flags.Fail(err.Error())
+	}
+	requireRoot()
+
+	config, err := os.CreateTemp("", "gocker-job-*.json")
+	must(err)
+	defer os.Remove(config.Name())
+	err = json.NewEncoder(config).Encode(opts)
+	config.Close()
+	must(err)
+
+	job := &Job{
+		ID: generateContainerID(), Command: opts.Command,
+		Completions: completions, Parallelism: parallelism, Retries: retries,
+		Status: jobRunning, PID: os.Getpid(), CreatedAt: time.Now(),
+	}
+	for index := 0; index < completions; index++ {
+		job.Instances = append(job.Instances, JobInstance{Index: index})
+	}
+	must(saveJob(job))
+	fmt.Printf("Job %s: %d completions, %d at a time\n", shortID(job.ID), completions, parallelism)
+
+	// Ctrl-C reaches the running instances too, which clean up their containers
+	runner := &jobRunner{job: job}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		fmt.Println("Stopping: no more instances will be started")
+		runner.stop()
+	}()
+	runner.run(func(index int) JobAttempt { return startJobInstance(job, config.Name(), index) })
+	signal.Stop(sigChan)
+
+	fmt.Printf("Job %s %s: %d of %d completions succeeded, %d failed attempts\n", shortID(job.ID), job.Status, job.Succeeded, job.Completions, job.Failed)
+	if job.Status != jobSucceeded {
+		os.Remove(config.Name())
+		os.Exit(1)
+	}
+}
+
+func jobListCommand(args []string) {
+	flags := newCommandFlags("job ls", "", "List jobs, newest first")
+	if len(flags.MustParse(args)) != 0 {
+		flags.Fail("job ls does not accept arguments")
+	}
+	requireRoot()
+
+	jobs, err := loadJobs()
+	must(err)
+	if len(jobs) == 0 {
+		fmt.Println("No jobs found")
+		return
+	}
+	list := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		list = append(list, job)
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].CreatedAt.After(list[k].CreatedAt) })
+
+	fmt.Printf("%-14s %-10s %-12s %-8s %-20s %s\n", "JOB ID", "STATUS", "COMPLETIONS", "FAILED", "CREATED", "COMMAND")
+	fmt.Println(strings.Repeat("-", 100))
+	for _, job := range list {
+		// A job whose 'gocker job run' was killed cannot finish
+		if job.Status == jobRunning && !processAlive(job.PID, 0) {
+			job.Status = jobStopped
+		}
+		command := strings.Join(job.Command, " ")
+		if len(command) > 30 {
+			command = command[:27] + "..."
+		}
+		fmt.Printf("%-14s %-10s %-12s %-8d %-20s %s\n", shortID(job.ID), job.Status,
+			fmt.Sprintf("%d/%d", job.Succeeded, job.Completions), job.Failed, job.CreatedAt.Format("2006-01-02 15:04:05"), command)
+	}
+}
+
+func jobInspectCommand(args []string) {
+	flags := newCommandFlags("job inspect", "<job-id>", "Show a job's instances and attempts as JSON")
+	ids := flags.MustParse(args)
+	if len(ids) != 1 {
+		flags.Fail("job ID required")
+	}
+	requireRoot()
+
+	job, err := findJob(ids[0])
+	must(err)
+	data, err := json.MarshalIndent(job, "", "  ")
+	must(err)
+	fmt.Println(string(data))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func exitAttempt(code int) JobAttempt {
+	return JobAttempt{ContainerID: "abc", ExitCode: &code}
+}
+
+func newTestJob(completions, parallelism, retries int) *Job {
+	job := &Job{ID: "0123456789abcdef", Completions: completions, Parallelism: parallelism, Retries: retries, Status: jobRunning}
+	for index := 0; index < completions; index++ {
+		job.Instances = append(job.Instances, JobInstance{Index: index})
+	}
+	return job
+}
+
+// TestJobRunner verifies instances are retried up to the limit and the job's
+// status and counts follow from their attempts
+func TestJobRunner(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	// Instance 2 fails once, then succeeds on its retry
+	var mu sync.Mutex
+	calls := make(map[int]int)
+	job := newTestJob(4, 2, 1)
+	(&jobRunner{job: job}).run(func(index int) JobAttempt {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[index]++
+		if index == 2 && calls[index] == 1 {
+			return exitAttempt(3)
+		}
+		return exitAttempt(0)
+	})
+	if job.Status != jobSucceeded || job.Succeeded != 4 || job.Failed != 1 || len(job.Instances[2].Attempts) != 2 || job.FinishedAt == nil {
+		t.Errorf("Unexpected job: %+v", job)
+	}
+	saved, err := findJob("01234")
+	if err != nil || saved.Status != jobSucceeded {
+		t.Errorf("findJob = %+v, %v", saved, err)
+	}
+
+	// An instance that never starts exhausts its retries and fails the job;
+	// with one instance at a time, no later instance is started
+	job = newTestJob(3, 1, 2)
+	(&jobRunner{job: job}).run(func(index int) JobAttempt {
+		return JobAttempt{Error: "rootfs not found"}
+	})
+	if job.Status != jobFailed || job.Succeeded != 0 || job.Failed != 3 || len(job.Instances[1].Attempts) != 0 {
+		t.Errorf("Unexpected failed job: %+v", job)
+	}
+
+	// Stopping keeps further instances and retries from starting
+	job = newTestJob(3, 1, 2)
+	runner := &jobRunner{job: job}
+	runner.run(func(index int) JobAttempt {
+		runner.stop()
+		return exitAttempt(130)
+	})
+	if job.Status != jobStopped || job.Failed != 1 {
+		t.Errorf("Unexpected stopped job: %+v", job)
+	}
+}
+
+func TestParseJobCount(t *testing.T) {
+	if n, err := parseJobCount("retries", "0", true); n != 0 || err != nil {
+		t.Errorf("parseJobCount(0) = %d, %v", n, err)
+	}
+	for _, value := range []string{"0", "-1", "two", ""} {
+		if _, err := parseJobCount("completions", value, false); err == nil {
+			t.Errorf("parseJobCount(%q): expected error", value)
+		}
+	}
+}
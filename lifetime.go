@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// lifetimeCheckInterval is how often a container with --stop-idle is checked
+// for activity, and a detached one for having exited
+const lifetimeCheckInterval = 10 * time.Second
+
+// idleCPUShare is the CPU use, as a share of one CPU, below which a container
+// counts as idle; processes waiting for input still wake up now and then
+const idleCPUShare = 0.001
+
+// containerLifetime holds the limits set with --max-lifetime and --stop-idle,
+// zero when not set
+type containerLifetime struct {
+	maxLifetime time.Duration
+	stopIdle    time.Duration
+}
+
+// parseLifetime parses the --max-lifetime and --stop-idle of a container
+func parseLifetime(opts *RunOptions) (containerLifetime, error) {
+	var lifetime containerLifetime
+	for _, limit := range []struct {
+		flag, value string
+		field       *time.Duration
+	}{
+		{"--max-lifetime", opts.MaxLifetime, &lifetime.maxLifetime},
+		{"--stop-idle", opts.StopIdle, &lifetime.stopIdle},
+	} {
+		if limit.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(limit.value)
+		if err != nil || d <= 0 {
+			return containerLifetime{}, fmt.Errorf("invalid %s: %s (expected a duration such as 30m or 2h)", limit.flag, limit.value)
+		}
+		*limit.field = d
+	}
+	return lifetime, nil
+}
+
+// enabled reports whether the container needs supervising
+func (l containerLifetime) enabled() bool {
+	return l.maxLifetime > 0 || l.stopIdle > 0
+}
+
+// activitySample is a container's cumulative CPU time and network traffic
+type activitySample struct {
+	cpuUsageUsec int64
+	netBytes     int64
+	at           time.Time
+}
+
+// sampleActivity reads a container's activity counters
+func sampleActivity(state *ContainerState) (activitySample, error) {
+	sample := activitySample{at: time.Now()}
+	if state.CgroupPath == "" {
+		return sample, fmt.Errorf("container %s has no cgroup", shortID(state.ID))
+	}
+	stats, err := cgroups.Stats(state.CgroupPath)
+	if err != nil {
+		return sample, err
+	}
+	sample.cpuUsageUsec = stats.CPUUsageUsec
+	for _, iface := range networkStats(state) {
+		sample.netBytes += iface.RxBytes + iface.TxBytes
+	}
+	return sample, nil
+}
+
+// idleTracker keeps track of when a container was last active: when it used
+// more than idleCPUShare of a CPU or sent or received anything
+type idleTracker struct {
+	last     *activitySample
+	activeAt time.Time
+}
+
+// observe records a sample and returns how long the container has been idle
+func (t *idleTracker) observe(sample activitySample) time.Duration {
+	last := t.last
+	t.last = &sample
+	if last == nil {
+		t.activeAt = sample.at
+		return 0
+	}
+	cpu := time.Duration(sample.cpuUsageUsec-last.cpuUsageUsec) * time.Microsecond
+	if sample.netBytes != last.netBytes || float64(cpu) > idleCPUShare*float64(sample.at.Sub(last.at)) {
+		t.activeAt = sample.at
+	}
+	return sample.at.Sub(t.activeAt)
+}
+
+// superviseLifetime stops a container once it has run for its max lifetime or
+// been idle for its idle timeout, until stop is closed or the container exits
+// A paused container does not count as idle; its idle time starts over when
+// it is unpaused
+func superviseLifetime(containerID string, lifetime containerLifetime, createdAt time.Time, stop <-chan struct{}) {
+	var deadline <-chan time.Time
+	if lifetime.maxLifetime > 0 {
+		timer := time.NewTimer(time.Until(createdAt.Add(lifetime.maxLifetime)))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(lifetimeCheckInterval)
+	defer ticker.Stop()
+
+	var tracker idleTracker
+	for {
+		select {
+		case <-stop:
+			return
+		case <-deadline:
+			stopForLifetime(containerID, fmt.Sprintf("reached its max lifetime of %s", lifetime.maxLifetime))
+			return
+		case <-ticker.C:
+			state, err := readContainerState(containerID)
+			if err != nil || !isActive(state.Status) || !isProcessAlive(state) {
+				return
+			}
+			if lifetime.stopIdle == 0 {
+				continue
+			}
+			if state.Status == statusPaused {
+				tracker = idleTracker{}
+				continue
+			}
+			sample, err := sampleActivity(state)
+			if err != nil {
+				continue
+			}
+			if idle := tracker.observe(sample); idle >= lifetime.stopIdle {
+				stopForLifetime(containerID, fmt.Sprintf("was idle for %s", idle.Round(time.Second)))
+				return
+			}
+		}
+	}
+}
+
+// stopForLifetime records why a container is being stopped and stops it
+func stopForLifetime(containerID, reason string) {
+	logger.Warn("Stopping container", "container", shortID(containerID), "reason", reason)
+	err := updateContainerState(containerID, func(state *ContainerState) error {
+		state.StopReason = reason
+		return nil
+	})
+	if err == nil {
+		err = stopContainer(containerID, defaultStopTimeout)
+	}
+	if err != nil {
+		logger.Warn("Failed to stop container", "container", shortID(containerID), "error", err)
+	}
+}
+
+// startLifetimeSupervisor starts a process that supervises the lifetime of a
+// detached container; like the syslog relay it runs in its own session so it
+// outlives 'gocker run --detach'
+func startLifetimeSupervisor(containerID string) error {
+	args := append([]string{"--context", activeContextName, "--data-root", stateDir}, logFlags()...)
+	cmd := exec.Command("/proc/self/exe", append(args, "lifetime-supervisor", containerID)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	newSession(cmd.SysProcAttr, false)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start lifetime supervisor: %v", err)
+	}
+	cmd.Process.Release()
+	return nil
+}
+
+// lifetimeSupervisorCommand is the process started by startLifetimeSupervisor
+func lifetimeSupervisorCommand(args []string) {
+	if len(args) != 1 {
+		must(fmt.Errorf("lifetime-supervisor: container ID required"))
+	}
+	state, err := readContainerState(args[0])
+	must(err)
+	if state.Options == nil {
+		must(fmt.Errorf("lifetime-supervisor: container %s has no recorded run options", shortID(state.ID)))
+	}
+	lifetime, err := parseLifetime(state.Options)
+	must(err)
+	superviseLifetime(state.ID, lifetime, state.CreatedAt, nil)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseLifetime verifies --max-lifetime and --stop-idle are parsed and checked
+func TestParseLifetime(t *testing.T) {
+	lifetime, err := parseLifetime(&RunOptions{MaxLifetime: "2h", StopIdle: "30m"})
+	if err != nil {
+		t.Fatalf("parseLifetime failed: %v", err)
+	}
+	if lifetime.maxLifetime != 2*time.Hour || lifetime.stopIdle != 30*time.Minute {
+		t.Errorf("parseLifetime = %+v, want 2h and 30m", lifetime)
+	}
+	if !lifetime.enabled() {
+		t.Error("Expected lifetime to be enabled")
+	}
+
+	lifetime, err = parseLifetime(&RunOptions{})
+	if err != nil || lifetime.enabled() {
+		t.Errorf("Expected no lifetime without options, got %+v, %v", lifetime, err)
+	}
+
+	for _, opts := range []*RunOptions{{MaxLifetime: "2"}, {StopIdle: "-5m"}, {MaxLifetime: "0s"}} {
+		if _, err := parseLifetime(opts); err == nil {
+			t.Errorf("parseLifetime(%+v): expected error, got nil", *opts)
+		}
+	}
+}
+
+// TestIdleTracker verifies CPU use above the idle share and any traffic count as activity
+func TestIdleTracker(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	var tracker idleTracker
+	steps := []struct {
+		sample activitySample
+		want   time.Duration
+	}{
+		{activitySample{cpuUsageUsec: 1000, at: at(0)}, 0},
+		// 5ms of CPU over 10s is below 0.1% of a CPU
+		{activitySample{cpuUsageUsec: 6000, at: at(10)}, 10 * time.Second},
+		{activitySample{cpuUsageUsec: 6000, at: at(20)}, 20 * time.Second},
+		// Traffic is activity however small
+		{activitySample{cpuUsageUsec: 6000, netBytes: 60, at: at(30)}, 0},
+		{activitySample{cpuUsageUsec: 6000, netBytes: 60, at: at(40)}, 10 * time.Second},
+		// 50ms of CPU over 10s is activity
+		{activitySample{cpuUsageUsec: 56000, netBytes: 60, at: at(50)}, 0},
+	}
+	for i, step := range steps {
+		if got := tracker.observe(step.sample); got != step.want {
+			t.Errorf("step %d: idle = %s, want %s", i, got, step.want)
+		}
+	}
+}
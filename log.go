@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Runtime diagnostics (namespace setup, networking, cgroups) go through logger
+// Container stdout/stderr never pass through it, so runtime messages stay out
+// of container logs
+
+// runtimeLogFD is the file descriptor the child process writes runtime logs to,
+// so they are not mixed into the container's stderr
+const runtimeLogFD = 3
+
+// Logging settings, set from the global --debug, --quiet and --log-format flags
+var (
+	logLevel  = slog.LevelInfo
+	logFormat = "text"
+)
+
+var logger = slog.New(newConsoleHandler(os.Stderr, slog.LevelInfo))
+
+// setLogLevel selects the runtime log level; --debug wins over --quiet
+func setLogLevel(debug, quiet bool) {
+	switch {
+	case debug:
+		logLevel = slog.LevelDebug
+	case quiet:
+		logLevel = slog.LevelWarn
+	default:
+		logLevel = slog.LevelInfo
+	}
+}
+
+// quietLogging drops runtime messages below warnings for 'run --quiet'
+// An explicit --debug still wins
+func quietLogging() {
+	if logLevel == slog.LevelInfo {
+		logLevel = slog.LevelWarn
+		setupLogging(os.Stderr)
+	}
+}
+
+// setupLogging points the runtime logger at w using the configured level and format
+func setupLogging(w io.Writer) {
+	if logFormat == "json" {
+		logger = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel}))
+		return
+	}
+	logger = slog.New(newConsoleHandler(w, logLevel))
+}
+
+// setupChildLogging sends the child's runtime logs to the descriptor the
+// parent passed in, falling back to stderr if there is none
+func setupChildLogging() {
+	// The container command does not inherit the runtime log descriptor
+	f := inheritedFile(runtimeLogFD, "runtime-log")
+	if f == nil {
+		setupLogging(os.Stderr)
+		return
+	}
+	setupLogging(f)
+}
+
+// logFlags returns the global flags that reproduce the current logging settings
+// in a re-executed gocker process
+func logFlags() []string {
+	args := []string{"--log-format", logFormat}
+	switch logLevel {
+	case slog.LevelDebug:
+		args = append(args, "--debug")
+	case slog.LevelWarn:
+		args = append(args, "--quiet")
+	}
+	return args
+}
+
+// consoleHandler is a slog.Handler for terminals: it prints the message followed
+// by key=value attributes, prefixing warnings and errors like the rest of the CLI
+type consoleHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func newConsoleHandler(w io.Writer, level slog.Level) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString("debug: ")
+	}
+	b.WriteString(r.Message)
+
+	writeAttr := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+	b.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+// WithGroup is not used by gocker; groups are flattened
+func (h *consoleHandler) WithGroup(string) slog.Handler {
+	return h
+}
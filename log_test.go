@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestConsoleHandler verifies the text log format and level filtering
+func TestConsoleHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(newConsoleHandler(&buf, slog.LevelInfo))
+
+	log.Debug("hidden")
+	log.Info("Creating bridge", "bridge", "gocker0")
+	log.Warn("Failed to set up NAT", "error", "no route")
+	log.With("id", "abc").Error("Failed")
+
+	want := "Creating bridge bridge=gocker0\n" +
+		"Warning: Failed to set up NAT error=no route\n" +
+		"Error: Failed id=abc\n"
+	if buf.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+// TestSetupLoggingJSON verifies JSON output and that --quiet drops info messages
+func TestSetupLoggingJSON(t *testing.T) {
+	restoreRuntimeSettings(t)
+	saved := logger
+	t.Cleanup(func() { logger = saved })
+
+	logFormat = "json"
+	setLogLevel(false, true)
+
+	var buf bytes.Buffer
+	setupLogging(&buf)
+	logger.Info("Creating isolated namespaces")
+	logger.Warn("Failed to set up network", "error", "boom")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 log line with --quiet, got %d: %q", len(lines), buf.String())
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Expected JSON log line: %v", err)
+	}
+	if entry["level"] != "WARN" || entry["error"] != "boom" {
+		t.Errorf("unexpected log entry: %v", entry)
+	}
+}
+
+// TestLogFlags verifies logging settings are forwarded to the child process
+func TestLogFlags(t *testing.T) {
+	restoreRuntimeSettings(t)
+
+	tests := []struct {
+		debug, quiet bool
+		format       string
+		want         []string
+	}{
+		{false, false, "text", []string{"--log-format", "text"}},
+		{true, false, "json", []string{"--log-format", "json", "--debug"}},
+		{false, true, "text", []string{"--log-format", "text", "--quiet"}},
+		{true, true, "text", []string{"--log-format", "text", "--debug"}},
+	}
+
+	for _, tt := range tests {
+		logFormat = tt.format
+		setLogLevel(tt.debug, tt.quiet)
+		if got := logFlags(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("logFlags() with debug=%v quiet=%v = %v, want %v", tt.debug, tt.quiet, got, tt.want)
+		}
+	}
+}
+
+// TestQuietLogging verifies 'run --quiet' raises the level but leaves --debug alone
+func TestQuietLogging(t *testing.T) {
+	restoreRuntimeSettings(t)
+	saved := logger
+	t.Cleanup(func() { logger = saved })
+
+	setLogLevel(false, false)
+	quietLogging()
+	if logLevel != slog.LevelWarn {
+		t.Errorf("Expected warn level after quietLogging, got %v", logLevel)
+	}
+
+	setLogLevel(true, false)
+	quietLogging()
+	if logLevel != slog.LevelDebug {
+		t.Errorf("Expected --debug to win over run --quiet, got %v", logLevel)
+	}
+}
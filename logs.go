@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// logPollInterval is how often 'gocker logs --follow' checks for new output
+const logPollInterval = 200 * time.Millisecond
+
+// logColors are the ANSI colors cycled through for container prefixes
+var logColors = []string{"36", "33", "32", "35", "34", "31"}
+
+// labelFilter selects containers by label: KEY=VALUE, or just KEY for any value
+type labelFilter struct {
+	key, value string
+	anyValue   bool
+}
+
+// normalizeLabels validates 'run --label' values; KEY alone becomes KEY=
+func normalizeLabels(labels []string) ([]string, error) {
+	return normalizeKeyValues("label", labels)
+}
+
+// normalizeAnnotations validates 'run --annotation' values like labels
+func normalizeAnnotations(annotations []string) ([]string, error) {
+	return normalizeKeyValues("annotation", annotations)
+}
+
+func normalizeKeyValues(kind string, pairs []string) ([]string, error) {
+	var normalized []string
+	for _, pair := range pairs {
+		key, value, _ := strings.Cut(pair, "=")
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("invalid %s: %q (expected KEY=VALUE)", kind, pair)
+		}
+		normalized = append(normalized, key+"="+value)
+	}
+	return normalized, nil
+}
+
+// parseLabelFilters parses --filter values of the form label=KEY[=VALUE]
+func parseLabelFilters(filters []string) ([]labelFilter, error) {
+	var parsed []labelFilter
+	for _, filter := range filters {
+		kind, spec, _ := strings.Cut(filter, "=")
+		if kind != "label" {
+			return nil, fmt.Errorf("unsupported filter: %s (expected label=KEY[=VALUE])", filter)
+		}
+		key, value, hasValue := strings.Cut(spec, "=")
+		if key == "" {
+			return nil, fmt.Errorf("invalid filter: %s (label key cannot be empty)", filter)
+		}
+		parsed = append(parsed, labelFilter{key: key, value: value, anyValue: !hasValue})
+	}
+	return parsed, nil
+}
+
+// matchLabels reports whether a container's labels satisfy every filter
+func matchLabels(state *ContainerState, filters []labelFilter) bool {
+	var labels []string
+	if state.Options != nil {
+		labels = state.Options.Labels
+	}
+	for _, filter := range filters {
+		matched := false
+		for _, label := range labels {
+			key, value, _ := strings.Cut(label, "=")
+			if key == filter.key && (filter.anyValue || value == filter.value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// streamLogs writes the logs of several containers to stdout, each line
+// prefixed with its container's short ID; with follow it keeps reading until
+// every container has exited
+// It reports whether all logs could be read
+func streamLogs(ids []string, follow bool) bool {
+	color := isTerminal(os.Stdout)
+	var mu sync.Mutex
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		state, err := loadContainerState(id)
+		if err == nil && state.LogFile == "" {
+			err = fmt.Errorf("no log file found for container %s", shortID(state.ID))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed.Store(true)
+			continue
+		}
+
+		prefix := shortID(state.ID) + " | "
+		if color {
+			prefix = "\x1b[" + logColors[i%len(logColors)] + "m" + prefix + "\x1b[0m"
+		}
+		wg.Add(1)
+		go func(state *ContainerState) {
+			defer wg.Done()
+			err := tailLog(state, follow, func(line string) {
+				mu.Lock()
+				defer mu.Unlock()
+				io.WriteString(os.Stdout, prefix+line)
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				failed.Store(true)
+			}
+		}(state)
+	}
+	wg.Wait()
+	return !failed.Load()
+}
+
+// tailLog passes each line of a container's log to emit
+// With follow, it waits for new output while the container is running and
+// returns once it has exited and the rest of the log has been read
+func tailLog(state *ContainerState, follow bool, emit func(line string)) error {
+	f, err := os.Open(state.LogFile)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	running := follow && containerRunning(state.ID)
+	var partial string
+	for {
+		line, err := reader.ReadString('\n')
+		partial += line
+		if err == nil {
+			emit(partial)
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return fmt.Errorf("failed to read log file: %v", err)
+		}
+		// Output written just before the container exited is read on the
+		// pass after running turns false
+		if !running {
+			if partial != "" {
+				emit(partial + "\n")
+			}
+			return nil
+		}
+		time.Sleep(logPollInterval)
+		running = containerRunning(state.ID)
+	}
+}
+
+// containerRunning reports whether a container still has a live process
+// A removed container, such as an ephemeral one, is no longer running
+func containerRunning(fullID string) bool {
+	state, err := readContainerState(fullID)
+	return err == nil && isActive(state.Status) && isProcessAlive(state)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestLabelFilters checks label validation and matching containers with --filter
+func TestLabelFilters(t *testing.T) {
+	labels, err := normalizeLabels([]string{"app=web", "tier", "version=1=beta"})
+	if err != nil {
+		t.Fatalf("normalizeLabels failed: %v", err)
+	}
+	if want := []string{"app=web", "tier=", "version=1=beta"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("normalizeLabels = %v, want %v", labels, want)
+	}
+	for _, label := range []string{"=web", "my app=web"} {
+		if _, err := normalizeLabels([]string{label}); err == nil {
+			t.Errorf("normalizeLabels(%q): expected error", label)
+		}
+	}
+
+	state := &ContainerState{Options: &RunOptions{Labels: labels}}
+	tests := []struct {
+		filters []string
+		want    bool
+	}{
+		{[]string{"label=app=web"}, true},
+		{[]string{"label=app"}, true},
+		{[]string{"label=app=db"}, false},
+		{[]string{"label=app=web", "label=tier"}, true},
+		{[]string{"label=app=web", "label=env"}, false},
+		{[]string{"label=version=1=beta"}, true},
+	}
+	for _, tt := range tests {
+		filters, err := parseLabelFilters(tt.filters)
+		if err != nil {
+			t.Errorf("parseLabelFilters(%v): unexpected error: %v", tt.filters, err)
+			continue
+		}
+		if got := matchLabels(state, filters); got != tt.want {
+			t.Errorf("matchLabels(%v) = %v, want %v", tt.filters, got, tt.want)
+		}
+	}
+	if filters, _ := parseLabelFilters([]string{"label=app"}); matchLabels(&ContainerState{}, filters) {
+		t.Error("Expected a container without options not to match")
+	}
+
+	for _, filter := range []string{"status=running", "label=", "label==web"} {
+		if _, err := parseLabelFilters([]string{filter}); err == nil {
+			t.Errorf("parseLabelFilters(%q): expected error", filter)
+		}
+	}
+}
+
+// TestTailLog checks that every line of an exited container's log is emitted,
+// including a final line without a newline
+func TestTailLog(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	logFile := filepath.Join(t.TempDir(), "container.log")
+	if err := os.WriteFile(logFile, []byte("one\ntwo\nthree"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	state := &ContainerState{ID: "abc123", Status: statusExited, LogFile: logFile}
+	if err := saveContainerState(state); err != nil {
+		t.Fatalf("saveContainerState failed: %v", err)
+	}
+
+	// The container has exited, so following returns at the end of the log
+	for _, follow := range []bool{false, true} {
+		var lines []string
+		if err := tailLog(state, follow, func(line string) { lines = append(lines, line) }); err != nil {
+			t.Fatalf("tailLog failed: %v", err)
+		}
+		if want := []string{"one\n", "two\n", "three\n"}; !reflect.DeepEqual(lines, want) {
+			t.Errorf("tailLog(follow=%v) = %q, want %q", follow, lines, want)
+		}
+	}
+
+	missing := &ContainerState{ID: "def456", LogFile: filepath.Join(t.TempDir(), "missing.log")}
+	if err := tailLog(missing, false, func(string) {}); err == nil {
+		t.Error("Expected error for a missing log file")
+	}
+}
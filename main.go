@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net"
@@ -13,40 +16,65 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
-const (
+// Runtime paths, network, and cgroup settings
+// These are defaults; the config file and environment may override them (see config.go)
+var (
 	stateDir      = "/var/lib/gocker"
 	containersDir = "/var/lib/gocker/containers"
 	ipamFile      = "/var/lib/gocker/ipam.json"
+	portsFile     = "/var/lib/gocker/ports.json"
+	historyFile   = "/var/lib/gocker/history.json"
 	bridgeName    = "gocker0"
 	bridgeIP      = "10.0.0.1"
 	bridgeCIDR    = "10.0.0.1/24"
 	containerNet  = "10.0.0.0/24"
+	cgroupParent  = "/sys/fs/cgroup/gocker"
 )
 
 // ContainerState represents the state of a container
 type ContainerState struct {
-	ID          string    `json:"id"`
-	PID         int       `json:"pid"`
-	Status      string    `json:"status"` // "running", "stopped", "exited"
-	CreatedAt   time.Time `json:"created_at"`
-	Command     []string  `json:"command"`
-	VethHost    string    `json:"veth_host,omitempty"`
-	VethPeer    string    `json:"veth_peer,omitempty"`
-	ContainerIP string    `json:"container_ip,omitempty"`
-	LogFile     string    `json:"log_file"`
-	Detached    bool      `json:"detached"`
-	CgroupPath  string    `json:"cgroup_path,omitempty"`
-	RootfsPath  string    `json:"rootfs_path,omitempty"`
+	ID          string             `json:"id"`
+	PID         int                `json:"pid"`
+	Status      string             `json:"status"`               // see the state machine in state.go
+	StartTime   uint64             `json:"start_time,omitempty"` // process start time, guards against PID reuse
+	BootID      string             `json:"boot_id,omitempty"`    // kernel boot the process was started in
+	CreatedAt   time.Time          `json:"created_at"`
+	FinishedAt  *time.Time         `json:"finished_at,omitempty"` // when the container last stopped running
+	Command     []string           `json:"command"`
+	VethHost    string             `json:"veth_host,omitempty"`
+	VethPeer    string             `json:"veth_peer,omitempty"` // host-side name of the container end before it is renamed
+	ContainerIP string             `json:"container_ip,omitempty"`
+	Interfaces  []NetworkInterface `json:"interfaces,omitempty"` // network interfaces as seen inside the container
+	LogFile     string             `json:"log_file"`
+	Detached    bool               `json:"detached"`
+	CgroupPath  string             `json:"cgroup_path,omitempty"`
+	RootfsPath  string             `json:"rootfs_path,omitempty"`
+	ExitCode    *int               `json:"exit_code,omitempty"`   // exit status of the container process once it has exited
+	Owner       string             `json:"owner,omitempty"`       // user who created the container, behind sudo if any
+	Options     *RunOptions        `json:"options,omitempty"`     // run configuration the container was created with
+	Execs       []ExecSession      `json:"execs,omitempty"`       // running 'gocker exec' sessions
+	Protected   bool               `json:"protected,omitempty"`   // rm and system reset refuse to remove it (see protect.go)
+	StopReason  string             `json:"stop_reason,omitempty"` // why gocker stopped it, e.g. its --max-lifetime was reached
+}
+
+// NetworkInterface is one of a container's network interfaces
+type NetworkInterface struct {
+	Name     string `json:"name"`      // name inside the container, e.g. eth0
+	HostVeth string `json:"host_veth"` // host end of the veth pair
+	IP       string `json:"ip"`
+	Bridge   string `json:"bridge"`
 }
 
 // IPAMState tracks allocated IPs for containers
 type IPAMState struct {
 	AllocatedIPs map[string]string `json:"allocated_ips"` // containerID -> IP
-	NextIP       int               `json:"next_ip"`       // last octet for next allocation (2-254)
+	NextIP       int               `json:"next_ip"`       // host offset in the subnet for next allocation (2 and up)
 }
 
 // must is a helper function that exits the program if an error occurs
@@ -58,85 +86,394 @@ func must(err error) {
 }
 
 func main() {
-	if len(os.Args) < 2 {
+	global := &Config{}
+	args, err := newGlobalFlags(global).Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
 		printUsage()
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", normalizeFlagError(err))
 		os.Exit(1)
 	}
-
-	// Skip root check for "child" command
-	// "child" runs in a user namespace where it appears as non-root
-	if os.Args[1] != "child" {
-		// Check for root permissions (required for namespace operations)
-		if os.Geteuid() != 0 {
-			fmt.Println("Error: This program must be run with sudo/root permissions")
-			os.Exit(1)
-		}
-	}
-
-	switch os.Args[1] {
-	case "run":
-		run()
-	case "child":
-		child()
-	case "ps":
-		listContainers()
-	case "stop":
-		if len(os.Args) < 3 {
-			fmt.Println("Error: container ID required")
-			fmt.Println("Usage: gocker stop <container-id>")
-			os.Exit(1)
-		}
-		stopContainer(os.Args[2])
-	case "rm":
-		if len(os.Args) < 3 {
-			fmt.Println("Error: container ID required")
-			fmt.Println("Usage: gocker rm <container-id>")
-			os.Exit(1)
-		}
-		removeContainer(os.Args[2])
-	case "logs":
-		if len(os.Args) < 3 {
-			fmt.Println("Error: container ID required")
-			fmt.Println("Usage: gocker logs <container-id>")
-			os.Exit(1)
-		}
-		showLogs(os.Args[2])
-	default:
-		fmt.Printf("Unknown command: %s\n", os.Args[1])
+	if len(args) == 0 {
 		printUsage()
 		os.Exit(1)
 	}
+
+	// Commands for a remote host are run there by its own gocker
+	remote, err := activeRemoteHost(global.Context)
+	must(err)
+	if remote != nil && !isLocalCommand(args[0]) {
+		code, err := runRemote(remote, append(remoteGlobalArgs(global), args...))
+		must(err)
+		os.Exit(code)
+	}
+
+	must(loadConfig(global))
+	setupLogging(os.Stderr)
+	// Only commands that create or change cgroups fail on a host whose
+	// cgroups cannot be used; the others get a backend reporting why
+	var cgroupErr error
+	cgroups, cgroupErr = detectCgroupManager()
+	if cgroupErr != nil {
+		cgroups = cgroupsUnavailable{err: cgroupErr}
+	}
+
+	name := args[0]
+	for _, cmd := range commandTable() {
+		if cmd.name == name {
+			if cmd.needsCgroups && !requestsHelp(args[1:]) {
+				must(cgroupErr)
+			}
+			// Bring recorded states in line with reality before acting on them
+			if !cmd.noState && os.Geteuid() == 0 {
+				migrateContainerLayout()
+				reconcileContainers()
+			}
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	fmt.Printf("Unknown command: %s\n", name)
+	printUsage()
+	os.Exit(1)
+}
+
+// commandTable lists every gocker subcommand in the order shown by help
+// Commands check for root themselves after parsing flags so --help works unprivileged
+func commandTable() []*command {
+	return []*command{
+		{name: "run", description: "Run a new container", needsCgroups: true, run: run},
+		{name: "ps", description: "List all containers", run: psCommand},
+		{name: "stop", description: "Stop a running container", run: stopCommand},
+		{name: "rm", description: "Remove a container", run: rmCommand},
+		{name: "update", description: "Update the settings of containers", needsCgroups: true, run: updateCommand},
+		{name: "pause", description: "Pause all processes in a container", needsCgroups: true, run: pauseCommand},
+		{name: "unpause", description: "Resume a paused container", needsCgroups: true, run: unpauseCommand},
+		{name: "exec", description: "Run a command in a running container", needsCgroups: true, run: execCommand},
+		{name: "inspect", description: "Show detailed container information", run: inspectCommand},
+		{name: "logs", description: "Show container logs", run: logsCommand},
+		{name: "history", description: "Show recently removed containers", run: historyCommand},
+		{name: "stats", description: "Show resource usage and pressure of running containers", needsCgroups: true, run: statsCommand},
+		{name: "usage", description: "Show and export the resources containers have used", run: usageCommand},
+		{name: "snapshot", description: "Manage container filesystem snapshots", run: snapshotCommand},
+		{name: "clone", description: "Run a new container with the configuration of an existing one", needsCgroups: true, run: cloneCommand},
+		{name: "template", description: "Manage saved run configurations", run: templateCommand},
+		{name: "schedule", description: "Run templates on a cron schedule", run: scheduleCommand},
+		{name: "job", description: "Run batch jobs of parallel containers", needsCgroups: true, run: jobCommand},
+		{name: "plugin", description: "Manage volume plugins", run: pluginCommand},
+		{name: "context", description: "Manage contexts", noState: true, local: true, run: contextCommand},
+		{name: "generate", description: "Generate systemd unit files", run: generateCommand},
+		{name: "network", description: "Inspect container networking", run: networkCommand},
+		{name: "debug", description: "Debug a running container with a toolbox, or inspect its core dumps", needsCgroups: true, run: debugCommand},
+		{name: "system", description: "Manage the host setup gocker created", run: systemCommand},
+		{name: "info", description: "Show system information and check host support", noState: true, run: infoCommand},
+		{name: "version", description: "Show the gocker version and build information", noState: true, run: versionCommand},
+		{name: "self-update", description: "Update gocker to the latest release", noState: true, local: true, run: selfUpdateCommand},
+		{name: "completion", description: "Generate shell completion scripts", noState: true, local: true, run: completionCommand},
+		{name: "help", description: "Show help for a command", noState: true, local: true, run: helpCommand},
+		// "child" runs in a user namespace where it appears as non-root
+		{name: "child", hidden: true, noState: true, local: true, run: child},
+		{name: "__complete", hidden: true, noState: true, local: true, run: completeCommand},
+		{name: "syslog-relay", hidden: true, noState: true, local: true, run: syslogRelayCommand},
+		{name: "lifetime-supervisor", hidden: true, noState: true, local: true, run: lifetimeSupervisorCommand},
+		{name: "network-probe", hidden: true, noState: true, local: true, run: networkProbeCommand},
+		{name: "debug-toolbox", hidden: true, noState: true, local: true, run: debugToolboxInitCommand},
+		// "core-handler" is run by the kernel for core dumps (see cores.go)
+		{name: "core-handler", hidden: true, noState: true, local: true, run: coreHandlerCommand},
+		{name: "userns-holder", hidden: true, noState: true, local: true, run: usernsHolderCommand},
+	}
+}
+
+// isLocalCommand reports whether a command always runs on this host, such as
+// managing contexts, rather than on the remote host of the endpoint
+func isLocalCommand(name string) bool {
+	for _, cmd := range commandTable() {
+		if cmd.name == name {
+			return cmd.local
+		}
+	}
+	return false
+}
+
+// newGlobalFlags registers the options accepted before the command name
+func newGlobalFlags(cfg *Config) *commandFlags {
+	flags := newCommandFlags("", "[global options] <command> [options]", "")
+	flags.StringVar(&cfg.Context, "context", "", "name", "Context to use for this command (overrides GOCKER_CONTEXT and 'gocker context use')")
+	flags.StringVar(&cfg.DataRoot, "data-root", "", "path", "Directory for container state (default: /var/lib/gocker)")
+	flags.StringVar(&cfg.CgroupParent, "cgroup-parent", "", "name", "Parent cgroup for containers under /sys/fs/cgroup (default: gocker)")
+	flags.StringVar(&cfg.CgroupDriver, "cgroup-driver", "", "driver", "How container cgroups are created: cgroupfs or systemd (default: cgroupfs)")
+	flags.BoolVar(&cfg.Debug, "debug", "D", "Enable debug logging of runtime operations")
+	flags.BoolVar(&cfg.Quiet, "quiet", "q", "Only log runtime warnings and errors")
+	flags.StringVar(&cfg.LogFormat, "log-format", "", "format", "Runtime log format: text or json (default: text)")
+	flags.StringVar(&cfg.Progress, "progress", "", "mode", "Progress of long operations: auto, plain, tty, json or none (default: auto)")
+	return flags
 }
 
 func printUsage() {
-	fmt.Println("Usage: gocker <command> [options]")
+	fmt.Println("Usage: gocker [global options] <command> [options]")
 	fmt.Println()
 	fmt.Println("Commands:")
-	fmt.Println("  run     Run a new container")
-	fmt.Println("  ps      List all containers")
-	fmt.Println("  stop    Stop a running container")
-	fmt.Println("  rm      Remove a container")
-	fmt.Println("  logs    Show container logs")
+	for _, cmd := range commandTable() {
+		if !cmd.hidden {
+			fmt.Printf("  %-12s %s\n", cmd.name, cmd.description)
+		}
+	}
+	fmt.Println()
+	fmt.Println("Global options:")
+	newGlobalFlags(&Config{}).PrintOptions(os.Stdout)
 	fmt.Println()
-	fmt.Println("Run options:")
-	fmt.Println("  --cpu-limit <limit>       CPU limit (e.g., '1' for 1 CPU, '0.5' for 50% of one CPU, 'max' for unlimited)")
-	fmt.Println("  --memory-limit <limit>    Memory limit (e.g., '512M', '1G', 'max' for unlimited)")
-	fmt.Println("  --volume, -v <host:container>  Mount a host directory into the container")
-	fmt.Println("  --detach, -d              Run container in background")
-	fmt.Println("  --rootfs <path>           Path to rootfs directory (default: ./rootfs)")
+	fmt.Println("Run 'gocker <command> --help' for more information on a command.")
 }
 
-// generateContainerID generates a unique container ID
-// Uses random bytes at the start to ensure unique veth interface names
+func helpCommand(args []string) {
+	if len(args) == 0 {
+		printUsage()
+		return
+	}
+	for _, cmd := range commandTable() {
+		if cmd.name == args[0] && !cmd.hidden {
+			cmd.run([]string{"--help"})
+			return
+		}
+	}
+	fmt.Printf("Unknown command: %s\n", args[0])
+	os.Exit(1)
+}
+
+func psCommand(args []string) {
+	var last string
+	flags := newCommandFlags("ps", "[options]", "List all containers")
+	flags.StringVar(&last, "last", "n", "N", "Show the N most recently created containers, including removed ones")
+	if len(flags.MustParse(args)) != 0 {
+		flags.Fail("ps does not accept arguments")
+	}
+	limit, err := parseLastFlag(last)
+	if err != nil {
+		flags.Fail(err.Error())
+	}
+	requireRoot()
+	if limit > 0 {
+		entries, err := recentContainers(limit)
+		must(err)
+		printHistory(entries, false)
+		return
+	}
+	listContainers()
+}
+
+func stopCommand(args []string) {
+	var all bool
+	seconds := strconv.Itoa(int(defaultStopTimeout.Seconds()))
+	flags := newCommandFlags("stop", "<container-id>... | --all", "Stop one or more running containers")
+	flags.interspersed = true
+	flags.BoolVar(&all, "all", "a", "Stop all running containers")
+	flags.StringVar(&seconds, "time", "t", "seconds", "Seconds to wait for the container to exit before killing it (default: 10)")
+	ids := flags.MustParse(args)
+	timeout, err := parseStopTimeout(seconds)
+	if err != nil {
+		flags.Fail(err.Error())
+	}
+	if all && len(ids) > 0 {
+		flags.Fail("--all cannot be combined with container IDs")
+	}
+	if !all && len(ids) == 0 {
+		flags.Fail("container ID required")
+	}
+	requireRoot()
+	if all {
+		ids = matchingContainerIDs(func(state *ContainerState) bool { return isActive(state.Status) })
+	}
+	stop := func(id string) error { return stopContainer(id, timeout) }
+	if !forEachContainer(ids, stop) {
+		os.Exit(1)
+	}
+}
+
+// defaultStopTimeout is how long stop waits after SIGTERM before sending SIGKILL
+// killTimeout bounds the wait for a killed container to go away
+const (
+	defaultStopTimeout = 10 * time.Second
+	killTimeout        = 5 * time.Second
+)
+
+// parseStopTimeout parses the stop --time value in whole seconds
+func parseStopTimeout(seconds string) (time.Duration, error) {
+	n, err := strconv.Atoi(seconds)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid stop timeout: %s (expected a non-negative number of seconds)", seconds)
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+func rmCommand(args []string) {
+	var all, forceProtected bool
+	flags := newCommandFlags("rm", "<container-id>... | --all", "Remove one or more containers")
+	flags.interspersed = true
+	flags.BoolVar(&all, "all", "a", "Remove all containers that are not running, except protected ones")
+	flags.BoolVar(&forceProtected, "force-protected", "", "Also remove containers protected with 'gocker update --protect'")
+	ids := flags.MustParse(args)
+	if all && len(ids) > 0 {
+		flags.Fail("--all cannot be combined with container IDs")
+	}
+	if !all && len(ids) == 0 {
+		flags.Fail("container ID required")
+	}
+	requireRoot()
+	if all {
+		skipped := 0
+		ids = matchingContainerIDs(func(state *ContainerState) bool {
+			if isActive(state.Status) && isProcessAlive(state) {
+				return false
+			}
+			if checkRemovable(state, forceProtected) != nil {
+				skipped++
+				return false
+			}
+			return true
+		})
+		if skipped > 0 {
+			fmt.Fprintf(os.Stderr, "Skipped %d protected container(s); use --force-protected to remove them\n", skipped)
+		}
+	}
+	ok := forEachContainer(ids, func(containerID string) error {
+		return removeContainer(containerID, forceProtected)
+	})
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// bulkWorkers bounds how many containers a bulk stop or rm handles at once
+const bulkWorkers = 8
+
+// forEachContainer runs op on each container concurrently with at most
+// bulkWorkers at a time, printing an error for each failure
+// It reports whether every operation succeeded
+func forEachContainer(ids []string, op func(containerID string) error) bool {
+	jobs := make(chan string)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < min(bulkWorkers, len(ids)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				if err := op(id); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+	for _, id := range ids {
+		jobs <- id
+	}
+	close(jobs)
+	wg.Wait()
+	return !failed.Load()
+}
+
+// matchingContainerIDs returns the IDs of all containers for which match is true
+func matchingContainerIDs(match func(*ContainerState) bool) []string {
+	all, err := listContainerIDs()
+	must(err)
+
+	var ids []string
+	for _, id := range all {
+		state, err := readContainerState(id)
+		if err == nil && match(state) {
+			ids = append(ids, state.ID)
+		}
+	}
+	return ids
+}
+
+func pauseCommand(args []string) {
+	flags := newCommandFlags("pause", "<container-id>", "Pause all processes in a container")
+	flags.interspersed = true
+	ids := flags.MustParse(args)
+	if len(ids) != 1 {
+		flags.Fail("container ID required")
+	}
+	requireRoot()
+	pauseContainer(ids[0], true)
+}
+
+func unpauseCommand(args []string) {
+	flags := newCommandFlags("unpause", "<container-id>", "Resume a paused container")
+	flags.interspersed = true
+	ids := flags.MustParse(args)
+	if len(ids) != 1 {
+		flags.Fail("container ID required")
+	}
+	requireRoot()
+	pauseContainer(ids[0], false)
+}
+
+func logsCommand(args []string) {
+	var follow bool
+	var filters []string
+	flags := newCommandFlags("logs", "[options] <container-id>... | --filter label=KEY[=VALUE]", "Show container logs")
+	flags.interspersed = true
+	flags.BoolVar(&follow, "follow", "f", "Keep printing new output until the containers exit")
+	flags.StringSliceVar(&filters, "filter", "", "label=KEY[=VALUE]", "Show logs of all containers with a matching label (repeatable; all must match)")
+	ids := flags.MustParse(args)
+	if len(filters) > 0 && len(ids) > 0 {
+		flags.Fail("--filter cannot be combined with container IDs")
+	}
+	if len(filters) == 0 && len(ids) == 0 {
+		flags.Fail("container ID required")
+	}
+	labels, err := parseLabelFilters(filters)
+	if err != nil {
+		flags.Fail(err.Error())
+	}
+	requireRoot()
+
+	// A single container's log is printed as is; several are interleaved
+	// line by line with a prefix saying which container wrote each line
+	if len(filters) == 0 && len(ids) == 1 {
+		showLogs(ids[0], follow)
+		return
+	}
+	if len(filters) > 0 {
+		ids = matchingContainerIDs(func(state *ContainerState) bool { return matchLabels(state, labels) })
+		if len(ids) == 0 {
+			must(fmt.Errorf("no containers match %s", strings.Join(filters, ", ")))
+		}
+	}
+	if !streamLogs(ids, follow) {
+		os.Exit(1)
+	}
+}
+
+// generateContainerID generates a random 64 character hex container ID
+// Output shows the first 12 characters; veth names use the first 8
 func generateContainerID() string {
-	randomBytes := make([]byte, 4)
-	rand.Read(randomBytes)
-	return hex.EncodeToString(randomBytes) + fmt.Sprintf("%d", time.Now().UnixNano())
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		must(fmt.Errorf("failed to generate container ID: %v", err))
+	}
+	return hex.EncodeToString(randomBytes)
+}
+
+// shortID returns the 12 character form of a container ID used in output
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
 }
 
 // resolveRootfsPath resolves the rootfs path to an absolute path
-// Priority: 1) explicit --rootfs flag, 2) ./rootfs relative to executable, 3) ./rootfs relative to cwd
+// Priority: 1) explicit --rootfs flag, 2) configured default_rootfs, 3) ./rootfs relative to executable, 4) ./rootfs relative to cwd
 func resolveRootfsPath(explicitPath string) (string, error) {
+	if explicitPath == "" {
+		explicitPath = defaultRootfs
+	}
 	if explicitPath != "" {
 		absPath, err := filepath.Abs(explicitPath)
 		if err != nil {
@@ -174,88 +511,146 @@ func resolveRootfsPath(explicitPath string) (string, error) {
 // State management with file locking
 // ============================================================================
 
-// lockFile acquires an exclusive lock on a file
-func lockFile(f *os.File) error {
-	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
-}
-
-// unlockFile releases the lock on a file
-func unlockFile(f *os.File) error {
-	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
-}
-
-// ensureStateDir ensures the state directory exists
+// ensureStateDir ensures the state directory exists and belongs to this instance
 func ensureStateDir() error {
 	if err := os.MkdirAll(containersDir, 0755); err != nil {
 		return fmt.Errorf("failed to create state directory: %v", err)
 	}
-	return nil
+	return claimDataRoot()
+}
+
+// lockContainer takes the exclusive per-container lock that serializes state
+// transactions; the lock lives outside the state file because writes replace it
+func lockContainer(containerID string) (*os.File, error) {
+	locksDir := filepath.Join(stateDir, "locks")
+	if err := os.MkdirAll(locksDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create locks directory: %v", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(locksDir, containerID+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container lock: %v", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock container state: %v", err)
+	}
+	return f, nil
+}
+
+// lockGlobal takes a lock on state shared by all containers, such as the IPAM
+// file; it lives with the container locks, and as container IDs are hex, a
+// name such as "ipam" cannot clash with one
+// Release it with unlockContainer
+func lockGlobal(name string) (*os.File, error) {
+	return lockContainer(name)
+}
+
+// unlockContainer releases a lock taken by lockContainer or lockGlobal
+func unlockContainer(f *os.File) {
+	unlockFile(f)
+	f.Close()
 }
 
-// saveContainerState saves container state to disk with file locking
+// saveContainerState saves container state to disk under the container lock
+// Use updateContainerState to modify an existing container
 func saveContainerState(state *ContainerState) error {
 	if err := ensureStateDir(); err != nil {
 		return err
 	}
 
-	stateFile := filepath.Join(containersDir, state.ID+".json")
-
-	// Open file with exclusive lock
-	f, err := os.OpenFile(stateFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	lock, err := lockContainer(state.ID)
 	if err != nil {
-		return fmt.Errorf("failed to open state file: %v", err)
+		return err
 	}
-	defer f.Close()
+	defer unlockContainer(lock)
 
-	if err := lockFile(f); err != nil {
-		return fmt.Errorf("failed to lock state file: %v", err)
-	}
-	defer unlockFile(f)
+	return writeContainerState(state)
+}
 
+// writeContainerState replaces the state file atomically so a crash never
+// leaves a truncated file; the caller must hold the container lock
+func writeContainerState(state *ContainerState) error {
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal container state: %v", err)
 	}
 
-	if _, err := f.Write(data); err != nil {
+	if err := os.MkdirAll(containerDir(state.ID), 0755); err != nil {
+		return fmt.Errorf("failed to create container directory: %v", err)
+	}
+	if err := writeFileAtomic(containerStateFile(state.ID), data, 0644); err != nil {
 		return fmt.Errorf("failed to write container state: %v", err)
 	}
-
 	return nil
 }
 
-// loadContainerState loads container state from disk with file locking
+// loadContainerState loads container state from disk
+// Writes are atomic renames, so reading needs no lock
 func loadContainerState(containerID string) (*ContainerState, error) {
 	// Support partial container ID matching
 	fullID, err := resolveContainerID(containerID)
 	if err != nil {
 		return nil, err
 	}
+	return readContainerState(fullID)
+}
 
-	stateFile := filepath.Join(containersDir, fullID+".json")
-
-	f, err := os.Open(stateFile)
+// readContainerState reads the state file for a full container ID
+func readContainerState(fullID string) (*ContainerState, error) {
+	data, err := os.ReadFile(containerStateFile(fullID))
 	if err != nil {
-		return nil, fmt.Errorf("container not found: %s", containerID)
+		return nil, fmt.Errorf("container not found: %s", fullID)
 	}
-	defer f.Close()
 
-	if err := lockFile(f); err != nil {
-		return nil, fmt.Errorf("failed to lock state file: %v", err)
+	var state ContainerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse container state: %v", err)
 	}
-	defer unlockFile(f)
 
-	data, err := io.ReadAll(f)
+	return &state, nil
+}
+
+// updateContainerState runs a read-modify-write transaction on a container's
+// state while holding its lock, so concurrent updates cannot interleave
+// If update returns an error, the state on disk is left unchanged
+func updateContainerState(containerID string, update func(state *ContainerState) error) error {
+	fullID, err := resolveContainerID(containerID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read state file: %v", err)
+		return err
 	}
 
-	var state ContainerState
-	if err := json.Unmarshal(data, &state); err != nil {
-		return nil, fmt.Errorf("failed to parse container state: %v", err)
+	lock, err := lockContainer(fullID)
+	if err != nil {
+		return err
 	}
+	defer unlockContainer(lock)
 
-	return &state, nil
+	// Re-read under the lock; the container may have been removed meanwhile
+	state, err := readContainerState(fullID)
+	if err != nil {
+		return err
+	}
+	previous := state.Status
+	if err := update(state); err != nil {
+		return err
+	}
+	if isActive(previous) && !isActive(state.Status) {
+		now := time.Now()
+		state.FinishedAt = &now
+	} else if state.Status == statusRunning && !isActive(previous) {
+		state.FinishedAt = nil
+	}
+	if err := writeContainerState(state); err != nil {
+		return err
+	}
+
+	if state.Status != previous {
+		for _, action := range statusEvents(previous, state) {
+			emitEvent(action, state)
+		}
+	}
+	return nil
 }
 
 // resolveContainerID resolves a partial container ID to the full ID
@@ -264,17 +659,13 @@ func resolveContainerID(partialID string) (string, error) {
 		return "", err
 	}
 
-	files, err := os.ReadDir(containersDir)
+	ids, err := listContainerIDs()
 	if err != nil {
-		return "", fmt.Errorf("failed to read containers directory: %v", err)
+		return "", err
 	}
 
 	var matches []string
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".json") {
-			continue
-		}
-		fullID := strings.TrimSuffix(file.Name(), ".json")
+	for _, fullID := range ids {
 		if strings.HasPrefix(fullID, partialID) {
 			matches = append(matches, fullID)
 		}
@@ -289,15 +680,15 @@ func resolveContainerID(partialID string) (string, error) {
 	return matches[0], nil
 }
 
-// updateContainerStatus updates the container status
+// updateContainerStatus moves the container to a new status, rejecting invalid transitions
 func updateContainerStatus(containerID string, status string) error {
-	state, err := loadContainerState(containerID)
-	if err != nil {
-		return err
-	}
-
-	state.Status = status
-	return saveContainerState(state)
+	return updateContainerState(containerID, func(state *ContainerState) error {
+		if err := validateTransition(state.Status, status); err != nil {
+			return err
+		}
+		state.Status = status
+		return nil
+	})
 }
 
 // ============================================================================
@@ -315,7 +706,7 @@ func loadIPAM() (*IPAMState, error) {
 		// Initialize new IPAM state
 		return &IPAMState{
 			AllocatedIPs: make(map[string]string),
-			NextIP:       2, // Start at 10.0.0.2
+			NextIP:       2, // Start after the bridge address (e.g. 10.0.0.2)
 		}, nil
 	}
 	if err != nil {
@@ -343,60 +734,120 @@ func saveIPAM(state *IPAMState) error {
 		return fmt.Errorf("failed to marshal IPAM state: %v", err)
 	}
 
-	if err := os.WriteFile(ipamFile, data, 0644); err != nil {
+	if err := writeFileAtomic(ipamFile, data, 0644); err != nil {
 		return fmt.Errorf("failed to write IPAM file: %v", err)
 	}
 	return nil
 }
 
-// allocateIP allocates an IP address for a container
-func allocateIP(containerID string) (string, error) {
-	ipam, err := loadIPAM()
+// updateIPAM runs fn on the IPAM state under the IPAM lock and saves it, so
+// concurrent runs cannot hand out the same address
+func updateIPAM(fn func(state *IPAMState) error) error {
+	if err := ensureStateDir(); err != nil {
+		return err
+	}
+	lock, err := lockGlobal("ipam")
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer unlockContainer(lock)
 
-	// Check if container already has an IP
-	if ip, exists := ipam.AllocatedIPs[containerID]; exists {
-		return ip, nil
+	state, err := loadIPAM()
+	if err != nil {
+		return err
 	}
+	if err := fn(state); err != nil {
+		return err
+	}
+	return saveIPAM(state)
+}
 
-	// Find next available IP
-	for ipam.NextIP <= 254 {
-		ip := fmt.Sprintf("10.0.0.%d", ipam.NextIP)
+// allocateIP allocates an IP address for a container
+func allocateIP(containerID string) (string, error) {
+	var ip string
+	err := updateIPAM(func(ipam *IPAMState) error {
+		// Check if container already has an IP
+		if allocated, exists := ipam.AllocatedIPs[containerID]; exists {
+			ip = allocated
+			return nil
+		}
 
-		// Check if IP is already allocated
-		inUse := false
-		for _, allocatedIP := range ipam.AllocatedIPs {
-			if allocatedIP == ip {
-				inUse = true
-				break
+		// Find next available IP (the last address is the broadcast address)
+		for ; ipam.NextIP < subnetSize()-1; ipam.NextIP++ {
+			candidate, err := subnetIP(ipam.NextIP)
+			if err != nil {
+				return err
+			}
+
+			// Check if IP is already allocated
+			inUse := false
+			for _, allocatedIP := range ipam.AllocatedIPs {
+				if allocatedIP == candidate {
+					inUse = true
+					break
+				}
 			}
-		}
 
-		if !inUse {
-			ipam.AllocatedIPs[containerID] = ip
-			ipam.NextIP++
-			if err := saveIPAM(ipam); err != nil {
-				return "", err
+			if !inUse {
+				ipam.AllocatedIPs[containerID] = candidate
+				ipam.NextIP++
+				ip = candidate
+				return nil
 			}
-			return ip, nil
 		}
-		ipam.NextIP++
+		return fmt.Errorf("no available IP addresses in pool")
+	})
+	if err != nil {
+		return "", err
+	}
+	return ip, nil
+}
+
+// subnetIP returns the address at the given host offset within containerNet
+func subnetIP(offset int) (string, error) {
+	_, ipNet, err := net.ParseCIDR(containerNet)
+	if err != nil {
+		return "", fmt.Errorf("invalid container network %s: %v", containerNet, err)
+	}
+	base := ipNet.IP.To4()
+	if base == nil {
+		return "", fmt.Errorf("container network must be IPv4: %s", containerNet)
+	}
+	if offset < 0 || offset >= subnetSize() {
+		return "", fmt.Errorf("offset %d is outside container network %s", offset, containerNet)
 	}
 
-	return "", fmt.Errorf("no available IP addresses in pool")
+	value := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+	value += uint32(offset)
+	return net.IPv4(byte(value>>24), byte(value>>16), byte(value>>8), byte(value)).String(), nil
 }
 
-// releaseIP releases an IP address for a container
-func releaseIP(containerID string) error {
-	ipam, err := loadIPAM()
+// subnetSize returns the number of addresses in containerNet
+func subnetSize() int {
+	_, ipNet, err := net.ParseCIDR(containerNet)
 	if err != nil {
-		return err
+		return 0
 	}
+	ones, bits := ipNet.Mask.Size()
+	return 1 << (bits - ones)
+}
 
-	delete(ipam.AllocatedIPs, containerID)
-	return saveIPAM(ipam)
+// subnetPrefixLength returns the prefix length of containerNet (e.g. 24)
+func subnetPrefixLength() int {
+	_, ipNet, err := net.ParseCIDR(containerNet)
+	if err != nil {
+		return 24
+	}
+	ones, _ := ipNet.Mask.Size()
+	return ones
+}
+
+// releaseIP releases an IP address for a container
+func releaseIP(containerID string) error {
+	return updateIPAM(func(ipam *IPAMState) error {
+		delete(ipam.AllocatedIPs, containerID)
+		return nil
+	})
 }
 
 // ============================================================================
@@ -404,48 +855,59 @@ func releaseIP(containerID string) error {
 // ============================================================================
 
 // ensureBridge ensures the gocker0 bridge exists and is configured
+// Concurrent runs serialize on the network lock so only one creates the
+// bridge and its NAT rules
 func ensureBridge() error {
+	lock, err := lockGlobal("network")
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
 	// Check if bridge already exists
 	if _, err := net.InterfaceByName(bridgeName); err == nil {
 		// Bridge exists, verify it's up
-		cmd := exec.Command("ip", "link", "set", bridgeName, "up")
-		cmd.Run() // Ignore error, bridge might already be up
+		links.SetUp(bridgeName) // Ignore error, bridge might already be up
+		// firewalld may have been installed or started since the bridge was created
+		if err := registerFirewalldZone(); err != nil {
+			logger.Warn("Failed to register bridge with firewalld", "error", err)
+		}
 		return nil
 	}
 
-	fmt.Fprintln(os.Stderr, "  - Creating bridge gocker0...")
+	logger.Info("Creating bridge", "bridge", bridgeName)
 
 	// Create bridge
-	cmd := exec.Command("ip", "link", "add", "name", bridgeName, "type", "bridge")
-	if err := cmd.Run(); err != nil {
+	if err := links.AddBridge(bridgeName); err != nil {
 		return fmt.Errorf("failed to create bridge: %v", err)
 	}
 
 	// Set bridge IP
-	cmd = exec.Command("ip", "addr", "add", bridgeCIDR, "dev", bridgeName)
-	if err := cmd.Run(); err != nil {
+	if err := links.AddAddr(bridgeName, bridgeCIDR); err != nil {
 		// IP might already be set, continue
-		fmt.Fprintf(os.Stderr, "  - Note: Bridge IP configuration: %v\n", err)
+		logger.Debug("Bridge IP configuration failed", "error", err)
 	}
 
 	// Bring bridge up
-	cmd = exec.Command("ip", "link", "set", bridgeName, "up")
-	if err := cmd.Run(); err != nil {
+	if err := links.SetUp(bridgeName); err != nil {
 		return fmt.Errorf("failed to bring up bridge: %v", err)
 	}
 
 	// Enable IP forwarding
-	cmd = exec.Command("sysctl", "-w", "net.ipv4.ip_forward=1")
-	if err := cmd.Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "  - Warning: Failed to enable IP forwarding: %v\n", err)
+	if err := writeSysctl("net.ipv4.ip_forward", "1"); err != nil {
+		logger.Warn("Failed to enable IP forwarding", "error", err)
 	}
 
 	// Setup NAT (idempotent)
 	if err := setupNATRules(); err != nil {
-		fmt.Fprintf(os.Stderr, "  - Warning: Failed to set up NAT: %v\n", err)
+		logger.Warn("Failed to set up NAT", "error", err)
 	}
 
-	fmt.Fprintln(os.Stderr, "  - Bridge gocker0 created and configured")
+	if err := registerFirewalldZone(); err != nil {
+		logger.Warn("Failed to register bridge with firewalld", "error", err)
+	}
+
+	logger.Info("Bridge created and configured", "bridge", bridgeName)
 	return nil
 }
 
@@ -456,29 +918,34 @@ func setupNATRules() error {
 		return fmt.Errorf("could not determine default interface: %v", err)
 	}
 
+	// Hosts with nftables but no iptables binary get the same rules through nft
+	if !hasHostTool("iptables") && hasHostTool("nft") {
+		return setupNftNATRules(defaultInterface)
+	}
+
 	// Check if MASQUERADE rule exists
-	checkCmd := exec.Command("iptables", "-t", "nat", "-C", "POSTROUTING", "-s", containerNet, "-o", defaultInterface, "-j", "MASQUERADE")
+	checkCmd := hostCommand("iptables", "-t", "nat", "-C", "POSTROUTING", "-s", containerNet, "-o", defaultInterface, "-j", "MASQUERADE")
 	if checkCmd.Run() != nil {
 		// Rule doesn't exist, add it
-		cmd := exec.Command("iptables", "-t", "nat", "-A", "POSTROUTING", "-s", containerNet, "-o", defaultInterface, "-j", "MASQUERADE")
+		cmd := hostCommand("iptables", "-t", "nat", "-A", "POSTROUTING", "-s", containerNet, "-o", defaultInterface, "-j", "MASQUERADE")
 		if err := cmd.Run(); err != nil {
 			return fmt.Errorf("failed to add MASQUERADE rule: %v", err)
 		}
 	}
 
 	// Check if FORWARD rules exist (gocker0 -> default interface)
-	checkCmd = exec.Command("iptables", "-C", "FORWARD", "-i", bridgeName, "-o", defaultInterface, "-j", "ACCEPT")
+	checkCmd = hostCommand("iptables", "-C", "FORWARD", "-i", bridgeName, "-o", defaultInterface, "-j", "ACCEPT")
 	if checkCmd.Run() != nil {
-		cmd := exec.Command("iptables", "-A", "FORWARD", "-i", bridgeName, "-o", defaultInterface, "-j", "ACCEPT")
+		cmd := hostCommand("iptables", "-A", "FORWARD", "-i", bridgeName, "-o", defaultInterface, "-j", "ACCEPT")
 		if err := cmd.Run(); err != nil {
 			return fmt.Errorf("failed to add FORWARD rule (out): %v", err)
 		}
 	}
 
 	// Check if FORWARD rules exist (default interface -> gocker0)
-	checkCmd = exec.Command("iptables", "-C", "FORWARD", "-i", defaultInterface, "-o", bridgeName, "-j", "ACCEPT")
+	checkCmd = hostCommand("iptables", "-C", "FORWARD", "-i", defaultInterface, "-o", bridgeName, "-j", "ACCEPT")
 	if checkCmd.Run() != nil {
-		cmd := exec.Command("iptables", "-A", "FORWARD", "-i", defaultInterface, "-o", bridgeName, "-j", "ACCEPT")
+		cmd := hostCommand("iptables", "-A", "FORWARD", "-i", defaultInterface, "-o", bridgeName, "-j", "ACCEPT")
 		if err := cmd.Run(); err != nil {
 			return fmt.Errorf("failed to add FORWARD rule (in): %v", err)
 		}
@@ -488,14 +955,15 @@ func setupNATRules() error {
 }
 
 // setupContainerNetwork creates a veth pair and connects it to the bridge
-func setupContainerNetwork(containerID string, childPid int, quiet bool) (vethHost, vethPeer, containerIP string, err error) {
+func setupContainerNetwork(containerID string, childPid int) (vethHost, vethPeer, containerIP string, err error) {
 	// Allocate IP for this container
 	containerIP, err = allocateIP(containerID)
 	if err != nil {
 		return "", "", "", fmt.Errorf("failed to allocate IP: %v", err)
 	}
 
-	// Generate unique interface names (truncate to avoid >15 char limit)
+	// Name the pair after the ID's random prefix (interface names are limited
+	// to 15 characters)
 	shortID := containerID
 	if len(shortID) > 8 {
 		shortID = shortID[:8]
@@ -512,179 +980,226 @@ func setupContainerNetwork(containerID string, childPid int, quiet bool) (vethHo
 	}
 
 	// Create veth pair
-	if !quiet {
-		fmt.Fprintf(os.Stderr, "  - Creating veth pair: %s <-> %s\n", vethHost, vethPeer)
-	}
-	cmd := exec.Command("ip", "link", "add", vethHost, "type", "veth", "peer", "name", vethPeer)
-	if err := cmd.Run(); err != nil {
+	logger.Debug("Creating veth pair", "host", vethHost, "peer", vethPeer)
+	if err := links.AddVeth(vethHost, vethPeer, containerMTU()); err != nil {
 		releaseIP(containerID)
 		return "", "", "", fmt.Errorf("failed to create veth pair: %v", err)
 	}
 
 	// Attach host end to bridge
-	cmd = exec.Command("ip", "link", "set", vethHost, "master", bridgeName)
-	if err := cmd.Run(); err != nil {
+	if err := links.SetMaster(vethHost, bridgeName); err != nil {
 		cleanupVeth(vethHost)
 		releaseIP(containerID)
 		return "", "", "", fmt.Errorf("failed to attach veth to bridge: %v", err)
 	}
 
 	// Bring up the host end
-	cmd = exec.Command("ip", "link", "set", vethHost, "up")
-	if err := cmd.Run(); err != nil {
+	if err := links.SetUp(vethHost); err != nil {
 		cleanupVeth(vethHost)
 		releaseIP(containerID)
 		return "", "", "", fmt.Errorf("failed to bring up host veth: %v", err)
 	}
 
 	// Move peer end into the container's network namespace
-	if !quiet {
-		fmt.Fprintf(os.Stderr, "  - Moving %s into container namespace (IP: %s)\n", vethPeer, containerIP)
-	}
-	netnsPath := fmt.Sprintf("/proc/%d/ns/net", childPid)
-	cmd = exec.Command("ip", "link", "set", vethPeer, "netns", netnsPath)
-	if err := cmd.Run(); err != nil {
+	logger.Debug("Moving veth into container namespace", "interface", vethPeer, "ip", containerIP)
+	if err := links.SetNetns(vethPeer, childPid); err != nil {
 		cleanupVeth(vethHost)
 		releaseIP(containerID)
 		return "", "", "", fmt.Errorf("failed to move veth into container namespace: %v", err)
 	}
 
-	if !quiet {
-		fmt.Fprintln(os.Stderr, "  - Network setup complete")
+	// Configure the interface from here, inside the container's namespace, so
+	// the container's rootfs needs no networking tools of its own
+	if err := configureContainerInterface(childPid, vethPeer, primaryInterface(vethHost, containerIP)); err != nil {
+		cleanupVeth(vethHost)
+		releaseIP(containerID)
+		return "", "", "", fmt.Errorf("failed to configure container interface: %v", err)
 	}
+
+	logger.Debug("Network setup complete")
 	return vethHost, vethPeer, containerIP, nil
 }
 
+// containerInterfaceName returns the in-container name of the container's
+// index-th network interface: eth0, then eth1, eth2, ... for additional networks
+func containerInterfaceName(index int) string {
+	return fmt.Sprintf("eth%d", index)
+}
+
+// primaryInterface describes the container's eth0 on the gocker bridge
+func primaryInterface(vethHost, containerIP string) NetworkInterface {
+	return NetworkInterface{Name: containerInterfaceName(0), HostVeth: vethHost, IP: containerIP, Bridge: bridgeName}
+}
+
+// configureContainerInterface renames the container's end of a veth pair to
+// iface.Name and assigns its IP from inside the container's network
+// namespace; the first interface also gets the default route
+func configureContainerInterface(pid int, vethPeer string, iface NetworkInterface) error {
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"bring up lo", func() error { return links.SetUp("lo") }},
+		{"rename " + vethPeer, func() error { return links.SetName(vethPeer, iface.Name) }},
+		{"add address", func() error { return links.AddAddr(iface.Name, fmt.Sprintf("%s/%d", iface.IP, subnetPrefixLength())) }},
+		{"bring up " + iface.Name, func() error { return links.SetUp(iface.Name) }},
+	}
+	if iface.Name == containerInterfaceName(0) {
+		steps = append(steps, struct {
+			name string
+			run  func() error
+		}{"add default route", func() error { return links.AddDefaultRoute(bridgeIP, iface.Name) }})
+	}
+	refreshNeighbors(pid, vethPeer, iface)
+	err := links.InNetns(pid, func() error {
+		for _, step := range steps {
+			if err := step.run(); err != nil {
+				return fmt.Errorf("%s: %v", step.name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Debug("Configured container interface", "interface", iface.Name, "ip", iface.IP)
+	return nil
+}
+
+// refreshNeighbors keeps stale neighbor entries from dropping a new
+// container's first packets when its IP was recently someone else's: the
+// bridge's entry for the IP is flushed, and the kernel is told to announce the
+// interface (gratuitous ARP, unsolicited NA for IPv6) when it comes up
+// Neither step is fatal; older kernels may lack the sysctls
+func refreshNeighbors(pid int, vethPeer string, iface NetworkInterface) {
+	links.DeleteNeighbor(iface.IP, iface.Bridge)
+	// The sysctls follow the device when it is renamed to iface.Name
+	links.InNetns(pid, func() error {
+		for _, name := range addressNotifySysctls(vethPeer) {
+			if err := writeSysctl(name, "1"); err != nil {
+				logger.Debug("Failed to enable address announcements", "interface", iface.Name, "error", err)
+			}
+		}
+		return nil
+	})
+}
+
+// netnsCommand returns a command that runs a host binary in the network
+// namespace of pid
+func netnsCommand(pid int, name string, args ...string) *exec.Cmd {
+	return hostCommand("nsenter", append([]string{"--target", strconv.Itoa(pid), "--net", "--", name}, args...)...)
+}
+
 // cleanupVeth removes a veth interface
 func cleanupVeth(vethHost string) {
 	if vethHost == "" {
 		return
 	}
-	exec.Command("ip", "link", "delete", vethHost).Run()
+	links.Delete(vethHost)
 }
 
 // cleanupContainerNetwork cleans up networking for a container
 func cleanupContainerNetwork(containerID, vethHost string) {
 	cleanupVeth(vethHost)
+	if ipam, err := loadIPAM(); err == nil {
+		if ip := ipam.AllocatedIPs[containerID]; ip != "" {
+			unblockOutbound(ip)
+			removeFirewall(containerID, ip)
+		}
+	}
+	releasePorts(containerID)
 	releaseIP(containerID)
 }
 
+// internalRuleArgs returns the iptables FORWARD rule for an --internal
+// container: anything it sends that the host would route off the bridge is
+// rejected; traffic to other containers stays on the bridge and traffic to
+// the host is not forwarded, so neither is affected
+func internalRuleArgs(containerIP string) []string {
+	return []string{"FORWARD", "-i", bridgeName, "-s", containerIP, "!", "-o", bridgeName, "-j", "REJECT"}
+}
+
+// blockOutbound cuts an --internal container off from everything beyond the bridge
+func blockOutbound(containerIP string) error {
+	args := append([]string{"-I"}, internalRuleArgs(containerIP)...)
+	if output, err := hostCommand("iptables", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add internal network rule: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// unblockOutbound removes an --internal container's rule, if there is one
+func unblockOutbound(containerIP string) {
+	if hostCommand("iptables", append([]string{"-C"}, internalRuleArgs(containerIP)...)...).Run() != nil {
+		return
+	}
+	if err := hostCommand("iptables", append([]string{"-D"}, internalRuleArgs(containerIP)...)...).Run(); err != nil {
+		logger.Warn("Failed to remove internal network rule", "ip", containerIP, "error", err)
+	}
+}
+
 // getDefaultInterface finds the default network interface
 func getDefaultInterface() (string, error) {
-	cmd := exec.Command("ip", "route", "show", "default")
-	output, err := cmd.Output()
+	route, err := readDefaultRoute(filepath.Join(procRoot, "net", "route"))
 	if err != nil {
 		return "", err
 	}
-
-	// Parse output like "default via 192.168.1.1 dev eth0"
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "default") && strings.Contains(line, "dev") {
-			parts := strings.Fields(line)
-			for i, part := range parts {
-				if part == "dev" && i+1 < len(parts) {
-					return parts[i+1], nil
-				}
-			}
-		}
-	}
-
-	return "", fmt.Errorf("could not find default interface")
+	return route.iface, nil
 }
 
 // ============================================================================
 // Per-container Cgroups
 // ============================================================================
 
-// createContainerCgroup creates a per-container cgroup
+// createContainerCgroup creates a per-container cgroup under cgroupParent
+// The cgroup lock keeps concurrent runs from racing to set up the parent
 func createContainerCgroup(containerID string) (string, error) {
-	cgroupPath := fmt.Sprintf("/sys/fs/cgroup/gocker/%s", containerID)
-
-	// Ensure parent directory exists
-	if err := os.MkdirAll("/sys/fs/cgroup/gocker", 0755); err != nil {
-		return "", fmt.Errorf("failed to create parent cgroup directory: %v", err)
-	}
-
-	// Enable controllers on parent
-	if err := enableCgroupControllers("/sys/fs/cgroup/gocker"); err != nil {
-		// Non-fatal, controllers might already be enabled or not available
-		fmt.Fprintf(os.Stderr, "  - Note: Could not enable cgroup controllers: %v\n", err)
+	lock, err := lockGlobal("cgroup")
+	if err != nil {
+		return "", err
 	}
+	defer unlockContainer(lock)
 
-	// Create container-specific cgroup
-	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
-		return "", fmt.Errorf("failed to create container cgroup directory: %v", err)
+	cgroupPath := cgroups.Path(containerID)
+	if err := cgroups.Create(cgroupPath); err != nil {
+		return "", err
 	}
-
 	return cgroupPath, nil
 }
 
-// enableCgroupControllers enables cpu, memory, pids controllers on a cgroup
-func enableCgroupControllers(cgroupPath string) error {
-	controllersFile := filepath.Join(cgroupPath, "cgroup.subtree_control")
-	return os.WriteFile(controllersFile, []byte("+cpu +memory +pids"), 0644)
-}
-
 // setupContainerCgroup configures cgroup limits for a container
-func setupContainerCgroup(cgroupPath string, cpuLimit, memoryLimit string) error {
-	// Set maximum processes limit to 20
-	pidsMaxPath := filepath.Join(cgroupPath, "pids.max")
-	if err := os.WriteFile(pidsMaxPath, []byte("20"), 0644); err != nil {
-		return fmt.Errorf("failed to set pids.max: %v", err)
+func setupContainerCgroup(cgroupPath string, cpuLimit, memoryLimit, memoryHigh string) error {
+	if err := cgroups.SetLimits(cgroupPath, cpuLimit, memoryLimit, memoryHigh); err != nil {
+		return err
 	}
-	fmt.Fprintln(os.Stderr, "  - Process limit set to 20")
-
-	// Set CPU limit if specified
+	logger.Info("Process limit set", "pids", pidsLimit)
 	if cpuLimit != "" && cpuLimit != "max" {
-		cpuMax, err := parseCPULimit(cpuLimit)
-		if err != nil {
-			return fmt.Errorf("failed to parse CPU limit: %v", err)
-		}
-
-		cpuMaxPath := filepath.Join(cgroupPath, "cpu.max")
-		if err := os.WriteFile(cpuMaxPath, []byte(cpuMax), 0644); err != nil {
-			return fmt.Errorf("failed to set cpu.max: %v", err)
-		}
-		fmt.Fprintf(os.Stderr, "  - CPU limit: %s\n", cpuLimit)
+		logger.Info("CPU limit set", "cpus", cpuLimit)
 	}
-
-	// Set memory limit if specified
 	if memoryLimit != "" && memoryLimit != "max" {
-		memoryMax, err := parseMemoryLimit(memoryLimit)
-		if err != nil {
-			return fmt.Errorf("failed to parse memory limit: %v", err)
-		}
-
-		memoryMaxPath := filepath.Join(cgroupPath, "memory.max")
-		if err := os.WriteFile(memoryMaxPath, []byte(memoryMax), 0644); err != nil {
-			return fmt.Errorf("failed to set memory.max: %v", err)
-		}
-		fmt.Fprintf(os.Stderr, "  - Memory limit: %s\n", memoryLimit)
+		logger.Info("Memory limit set", "memory", memoryLimit)
+	}
+	if memoryHigh != "" && memoryHigh != "max" {
+		logger.Info("Memory high limit set", "memory_high", memoryHigh)
 	}
-
 	return nil
 }
 
-// addToCgroup adds a PID to a cgroup
-func addToCgroup(cgroupPath string, pid int) error {
-	cgroupProcsPath := filepath.Join(cgroupPath, "cgroup.procs")
-	return os.WriteFile(cgroupProcsPath, []byte(strconv.Itoa(pid)), 0644)
+// freezeCgroup freezes or thaws every process in a cgroup
+func freezeCgroup(cgroupPath string, frozen bool) error {
+	if cgroupPath == "" {
+		return fmt.Errorf("container has no cgroup")
+	}
+	return cgroups.Freeze(cgroupPath, frozen)
 }
 
 // cleanupContainerCgroup removes a container's cgroup
+// This only succeeds once no processes are left in it, which is not an error
 func cleanupContainerCgroup(cgroupPath string) error {
 	if cgroupPath == "" {
 		return nil
 	}
-
-	// Try to remove the cgroup directory
-	// This will only succeed if there are no processes in it
-	err := os.Remove(cgroupPath)
-	if err != nil && !os.IsNotExist(err) {
-		// Non-fatal, cgroup might still have processes
-		return nil
-	}
+	cgroups.Remove(cgroupPath)
 	return nil
 }
 
@@ -746,58 +1261,286 @@ func parseMemoryLimit(memoryLimit string) (string, error) {
 	return strconv.FormatInt(bytes, 10), nil
 }
 
+// validateMemoryHigh checks a --memory-high value against the hard limit:
+// a soft limit at or above memory.max would never throttle
+func validateMemoryHigh(memoryHigh, memoryLimit string) error {
+	if memoryHigh == "" || memoryHigh == "max" {
+		return nil
+	}
+	high, err := parseMemoryLimit(memoryHigh)
+	if err != nil {
+		return fmt.Errorf("invalid --memory-high: %s (expected a size such as 512M or 1G)", memoryHigh)
+	}
+	limit, err := parseMemoryLimit(memoryLimit)
+	if err != nil || limit == "max" {
+		return nil
+	}
+	highBytes, _ := strconv.ParseInt(high, 10, 64)
+	limitBytes, _ := strconv.ParseInt(limit, 10, 64)
+	if highBytes >= limitBytes {
+		return fmt.Errorf("--memory-high %s must be below --memory-limit %s", memoryHigh, memoryLimit)
+	}
+	return nil
+}
+
 // ============================================================================
 // Main run/child logic
 // ============================================================================
 
-func run() {
-	// Parse flags for resource limits, volumes, and detached mode
-	var cpuLimit, memoryLimit, rootfsPath string
-	var volumes []string
-	var detached bool
-	args := os.Args[2:]
-	var remainingArgs []string
-
-	for i := 0; i < len(args); i++ {
-		arg := args[i]
-		if arg == "--cpu-limit" {
-			if i+1 < len(args) {
-				cpuLimit = args[i+1]
-				i++
-			}
-		} else if arg == "--memory-limit" {
-			if i+1 < len(args) {
-				memoryLimit = args[i+1]
-				i++
-			}
-		} else if arg == "--volume" || arg == "-v" {
-			if i+1 < len(args) {
-				volumes = append(volumes, args[i+1])
-				i++
-			}
-		} else if arg == "--detach" || arg == "-d" {
-			detached = true
-		} else if arg == "--rootfs" {
-			if i+1 < len(args) {
-				rootfsPath = args[i+1]
-				i++
-			}
-		} else {
-			remainingArgs = append(remainingArgs, arg)
+// RunOptions holds the options accepted by 'gocker run'
+type RunOptions struct {
+	CPULimit       string   `json:"cpu_limit,omitempty"`
+	MemoryLimit    string   `json:"memory_limit,omitempty"`
+	MemoryHigh     string   `json:"memory_high,omitempty"`
+	ReserveCPU     string   `json:"reserve_cpu,omitempty"`
+	ReserveMemory  string   `json:"reserve_memory,omitempty"`
+	Volumes        []string `json:"volumes,omitempty"`
+	Detached       bool     `json:"detached,omitempty"`
+	Init           bool     `json:"init,omitempty"`
+	Ephemeral      bool     `json:"ephemeral,omitempty"`
+	TmpDir         string   `json:"tmpdir,omitempty"`
+	Env            []string `json:"env,omitempty"`
+	Labels         []string `json:"labels,omitempty"`
+	Annotations    []string `json:"annotations,omitempty"`
+	Syslog         string   `json:"syslog,omitempty"`
+	AddHosts       []string `json:"add_hosts,omitempty"`
+	Links          []string `json:"links,omitempty"`
+	NetworkAliases []string `json:"network_aliases,omitempty"`
+	Internal       bool     `json:"internal,omitempty"`
+	Publish        []string `json:"publish,omitempty"`
+	Expose         []string `json:"expose,omitempty"`
+	AllowFrom      []string `json:"allow_from,omitempty"`
+	GPUs           string   `json:"gpus,omitempty"`
+	UsernsRemap    string   `json:"userns_remap,omitempty"`
+	CgroupNS       string   `json:"cgroupns,omitempty"`
+	Runtime        string   `json:"runtime,omitempty"`
+	Platform       string   `json:"platform,omitempty"`
+	MaxLifetime    string   `json:"max_lifetime,omitempty"`
+	StopIdle       string   `json:"stop_idle,omitempty"`
+	CoreLimit      string   `json:"core_limit,omitempty"`
+	Trace          bool     `json:"trace,omitempty"`
+	Privileged     bool     `json:"privileged,omitempty"`
+	ConfigFile     string   `json:"-"` // --config file the options were loaded from
+	CIDFile        string   `json:"-"` // file to write the container ID to
+	Quiet          bool     `json:"-"` // print only the container ID
+	RootfsPath     string   `json:"rootfs,omitempty"`
+	Command        []string `json:"command"`
+}
+
+// newRunFlags registers the 'gocker run' flags, storing their values in opts
+func newRunFlags(opts *RunOptions) *commandFlags {
+	flags := newCommandFlags("run", "[options] <command> [args...]", "Run a new container")
+	flags.StringVar(&opts.CPULimit, "cpu-limit", "", "limit", "CPU limit (e.g., '1' for 1 CPU, '0.5' for 50% of one CPU, 'max' for unlimited)")
+	flags.StringVar(&opts.MemoryLimit, "memory-limit", "", "limit", "Memory limit (e.g., '512M', '1G', 'max' for unlimited)")
+	flags.StringVar(&opts.MemoryHigh, "memory-high", "", "limit", "Soft memory limit: above it the container is throttled and reclaimed rather than OOM killed (cgroup v2)")
+	flags.StringVar(&opts.ReserveCPU, "reserve-cpu", "", "cpus", "CPUs to reserve; refuse to start if the host cannot provide them")
+	flags.StringVar(&opts.ReserveMemory, "reserve-memory", "", "size", "Memory to reserve (e.g., '512M'); refuse to start if the host cannot provide it")
+	flags.StringSliceVar(&opts.Env, "env", "e", "KEY=VALUE", "Set an environment variable (repeatable; KEY alone copies it from the host)")
+	flags.StringSliceVar(&opts.Volumes, "volume", "v", "host:container", "Mount a host directory, or plugin:volume:container for a plugin volume, into the container (repeatable)")
+	flags.StringSliceVar(&opts.Labels, "label", "l", "KEY=VALUE", "Attach a label to the container for selecting it later (repeatable)")
+	flags.StringSliceVar(&opts.Annotations, "annotation", "", "KEY=VALUE", "Attach data for webhooks and plugins to the container (repeatable)")
+	flags.StringSliceVar(&opts.AddHosts, "add-host", "", "name:ip", "Add an entry to the container's /etc/hosts (repeatable)")
+	flags.StringSliceVar(&opts.NetworkAliases, "network-alias", "", "name", "Another name for the container in /etc/hosts and for containers linking to it (repeatable)")
+	flags.BoolVar(&opts.Internal, "internal", "", "Block traffic from the container to anything but the bridge (no internet)")
+	flags.StringVar(&opts.UsernsRemap, "userns-remap", "", "user[:group]", "Map container root to a range of the user's IDs from /etc/subuid and /etc/subgid (root only)")
+	flags.StringVar(&opts.Platform, "platform", "", "linux/ARCH", "Run a root filesystem built for another architecture, emulated through binfmt_misc and QEMU")
+	flags.StringVar(&opts.Runtime, "runtime", "", "name", "How to run the command: 'native' (default) executes it, 'wasm' runs it as a WebAssembly module with wazero")
+	flags.StringVar(&opts.CgroupNS, "cgroupns", "", "mode", "Cgroup namespace: 'private' (default) shows the container only its own cgroup, 'host' shares gocker's")
+	flags.BoolVar(&opts.Privileged, "privileged", "", "Leave /proc and /sys writable and unmasked, e.g. to run gocker inside the container")
+	flags.StringVar(&opts.GPUs, "gpus", "", "all|device=N[,N]", "Give the container NVIDIA GPUs: 'all', or 'device=0,1' for some")
+	flags.StringSliceVar(&opts.Publish, "publish", "p", "host:container[/proto]", "Publish a container port on a host port, tcp or udp (repeatable)")
+	flags.StringSliceVar(&opts.Expose, "expose", "", "port[/proto]", "Accept inbound traffic only on exposed and published ports (repeatable)")
+	flags.StringSliceVar(&opts.AllowFrom, "allow-from", "", "cidr", "Accept traffic to published ports only from these addresses (repeatable)")
+	flags.StringSliceVar(&opts.Links, "link", "", "container[:alias]", "Make a running container reachable by alias, with legacy link variables (repeatable)")
+	flags.BoolVar(&opts.Detached, "detach", "d", "Run container in background")
+	flags.StringVar(&opts.CIDFile, "cidfile", "", "file", "Write the container ID to a file, which must not exist")
+	flags.BoolVar(&opts.Quiet, "quiet", "q", "Suppress setup messages; with --detach print only the container ID")
+	flags.BoolVar(&opts.Ephemeral, "ephemeral", "", "Keep state and logs on a tmpfs and remove the container when it exits")
+	flags.StringVar(&opts.MaxLifetime, "max-lifetime", "", "duration", "Stop the container once it has run this long (e.g., '2h')")
+	flags.StringVar(&opts.StopIdle, "stop-idle", "", "duration", "Stop the container once it has used no CPU and sent or received nothing for this long (e.g., '30m')")
+	flags.BoolVar(&opts.Trace, "trace", "", "Trace the container's syscalls with the host's strace into its directory ('gocker debug trace' shows them)")
+	flags.StringVar(&opts.CoreLimit, "core-limit", "", "size", "Largest core dump to keep of a crashing process, 0 for none (default: 1G; needs 'gocker system cores --enable')")
+	flags.StringVar(&opts.TmpDir, "tmpdir", "", "dir", "Like --ephemeral, but keep state and logs in a scratch directory under dir")
+	flags.BoolVar(&opts.Init, "init", "", "Run an init as PID 1 that forwards signals and reaps zombies")
+	flags.StringVar(&opts.Syslog, "syslog", "", "mode", "Provide /dev/log in the container: 'log' writes messages to the container log, 'host' uses the host's syslog")
+	flags.StringVar(&opts.RootfsPath, "rootfs", "", "path", "Path to rootfs directory (default: ./rootfs)")
+	flags.StringVar(&opts.ConfigFile, "config", "", "file", "Load run options from a JSON file; command-line options override it")
+	return flags
+}
+
+// parseRunFlags parses 'gocker run' style arguments, exiting on errors
+// configure adjusts the flag set, e.g. its name for help output
+// With --config, the file is loaded first and the arguments parsed again on
+// top of it: command-line values replace the file's, repeatable options
+// (--volume, --env) add to them, and a command replaces the file's command
+func parseRunFlags(args []string, configure func(*commandFlags)) (*RunOptions, *commandFlags) {
+	opts := &RunOptions{}
+	flags := newRunFlags(opts)
+	configure(flags)
+	command := flags.MustParse(args)
+	if opts.ConfigFile != "" {
+		loaded, err := loadRunConfig(opts.ConfigFile)
+		if err != nil {
+			flags.Fail(err.Error())
+		}
+		opts = loaded
+		flags = newRunFlags(opts)
+		configure(flags)
+		if command = flags.MustParse(args); len(command) == 0 {
+			command = opts.Command
 		}
 	}
+	opts.Command = command
+	return opts, flags
+}
 
-	if len(remainingArgs) == 0 {
-		fmt.Println("Error: command required")
-		fmt.Println("Usage: gocker run [options] <command> [args...]")
-		os.Exit(1)
+// writeCIDFile writes a container's full ID to path for 'run --cidfile'
+// The file must not exist, so a stale ID from an earlier run is never reused
+func writeCIDFile(path, containerID string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create container ID file: %v", err)
+	}
+	if _, err := f.WriteString(containerID); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write container ID file: %v", err)
+	}
+	return f.Close()
+}
+
+// loadRunConfig reads run options from a JSON file
+// Unknown keys are rejected so a misspelled option is not silently ignored
+func loadRunConfig(path string) (*RunOptions, error) {
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		return nil, fmt.Errorf("unsupported config file %s: run configs are JSON", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run config: %v", err)
+	}
+
+	var opts RunOptions
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&opts); err != nil {
+		return nil, fmt.Errorf("failed to parse run config %s: %v", path, err)
+	}
+	return &opts, nil
+}
+
+// parseRunArgs parses run flags for resource limits, volumes, and detached mode
+// Flag parsing stops at the first non-flag argument or "--"; the rest is the container command
+func parseRunArgs(args []string) (*RunOptions, error) {
+	opts := &RunOptions{}
+	command, err := newRunFlags(opts).Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	opts.Command = command
+	return opts, nil
+}
+
+func run(args []string) {
+	opts, flags := parseRunFlags(args, func(*commandFlags) {})
+	if len(opts.Command) == 0 {
+		flags.Fail("command required")
+	}
+	requireRoot()
+	runContainer(opts)
+}
+
+// runContainer creates and starts a container from the given options
+func runContainer(opts *RunOptions) {
+	// Apply configured default limits
+	if opts.CPULimit == "" {
+		opts.CPULimit = defaultCPULimit
+	}
+	if opts.MemoryLimit == "" {
+		opts.MemoryLimit = defaultMemoryLimit
+	}
+
+	if opts.Quiet {
+		quietLogging()
+	}
+	must(checkRequiredFeatures())
+	labels, err := normalizeLabels(opts.Labels)
+	must(err)
+	opts.Labels = labels
+	annotations, err := normalizeAnnotations(opts.Annotations)
+	must(err)
+	opts.Annotations = annotations
+	gpus, err := parseGPUs(opts.GPUs)
+	must(err)
+	if opts.UsernsRemap == "" && os.Geteuid() == 0 {
+		opts.UsernsRemap = usernsRemap
+	}
+	userns, err := containerUserNamespace(opts.UsernsRemap)
+	must(err)
+	must(checkVolumeSources(opts.Volumes))
+	must(validateMemoryHigh(opts.MemoryHigh, opts.MemoryLimit))
+	lifetime, err := parseLifetime(opts)
+	must(err)
+	_, err = parseCoreLimit(opts.CoreLimit)
+	must(err)
+	var strace string
+	if opts.Trace {
+		strace, err = findStrace()
+		must(err)
+	}
+	must(validateCgroupNS(opts.CgroupNS))
+	must(validateRuntime(opts.Runtime))
+	var wasmRuntimePath string
+	if opts.Runtime == runtimeWasm {
+		wasmRuntimePath, err = findWasmRuntime()
+		must(err)
+	}
+	must(validateSyslogMode(opts.Syslog))
+	hostEntries, err := parseAddHosts(opts.AddHosts)
+	must(err)
+	must(validateNetworkAliases(opts.NetworkAliases))
+	linked, err := resolveLinks(opts.Links)
+	must(err)
+	hostEntries = append(hostEntries, linkHostEntries(linked)...)
+	ports, err := parsePortMappings(opts.Publish)
+	must(err)
+	if opts.Internal && len(ports) > 0 {
+		must(fmt.Errorf("cannot publish ports of an --internal container"))
+	}
+	firewall, err := newFirewallPolicy(opts.Expose, opts.AllowFrom, ports)
+	must(err)
+	must(requireIptables(opts))
+	if opts.TmpDir != "" {
+		tmpDir, err := filepath.Abs(opts.TmpDir)
+		must(err)
+		opts.TmpDir = tmpDir
+		opts.Ephemeral = true
+	}
+	// Refuse an existing ID file up front rather than after creating the container
+	if opts.CIDFile != "" {
+		if _, err := os.Lstat(opts.CIDFile); err == nil {
+			must(fmt.Errorf("container ID file %s already exists", opts.CIDFile))
+		}
 	}
 
 	// Resolve rootfs path
-	resolvedRootfs, err := resolveRootfsPath(rootfsPath)
+	resolvedRootfs, err := resolveRootfsPath(opts.RootfsPath)
 	if err != nil {
 		must(err)
 	}
+	// Other architectures run through the host's binfmt_misc emulation
+	var platformVolumes []string
+	if opts.Platform != "" {
+		arch, err := parsePlatform(opts.Platform)
+		must(err)
+		platformVolumes, err = preparePlatform(arch, resolvedRootfs)
+		must(err)
+	}
+	must(checkPolicy(opts, resolvedRootfs, userns))
+
+	// Check reservations against what the host has left; the admission lock is
+	// held until the container is recorded so its reservation counts
+	releaseAdmission, err := admitContainer(opts)
+	must(err)
 
 	// Generate container ID
 	containerID := generateContainerID()
@@ -809,48 +1552,80 @@ func run() {
 	}
 
 	// Configure cgroup limits
-	fmt.Fprintln(os.Stderr, "Setting up cgroups v2 for resource limits...")
-	if err := setupContainerCgroup(cgroupPath, cpuLimit, memoryLimit); err != nil {
+	logger.Info("Setting up cgroups for resource limits", "mode", cgroups.Mode())
+	if err := setupContainerCgroup(cgroupPath, opts.CPULimit, opts.MemoryLimit, opts.MemoryHigh); err != nil {
 		cleanupContainerCgroup(cgroupPath)
 		must(err)
 	}
 
-	// Set environment variables to pass to child process
-	os.Setenv("GOCKER_CONTAINER_ID", containerID)
-	os.Setenv("GOCKER_ROOTFS", resolvedRootfs)
-	os.Setenv("GOCKER_CGROUP_PATH", cgroupPath)
-	if len(volumes) > 0 {
-		os.Setenv("GOCKER_VOLUMES", strings.Join(volumes, "|"))
+	// An ephemeral container's directory (state and log) is scratch space that
+	// disappears with the container
+	if opts.Ephemeral {
+		if err := mountEphemeralDir(containerID, opts.TmpDir); err != nil {
+			cleanupContainerCgroup(cgroupPath)
+			must(err)
+		}
 	}
 
-	// Create log file for container
-	logFile := filepath.Join(stateDir, "logs", containerID+".log")
-	if err := os.MkdirAll(filepath.Dir(logFile), 0755); err != nil {
+	// abort undoes the setup so far and exits with err
+	abort := func(err error) {
+		releasePorts(containerID)
+		releaseContainerMounts(containerID)
 		cleanupContainerCgroup(cgroupPath)
-		must(fmt.Errorf("failed to create logs directory: %v", err))
+		if opts.Ephemeral {
+			unmountEphemeralDir(containerID, opts.TmpDir)
+		}
+		must(err)
 	}
 
-	logWriter, err := os.Create(logFile)
-	if err != nil {
-		cleanupContainerCgroup(cgroupPath)
-		must(fmt.Errorf("failed to create log file: %v", err))
+	// Claim the published host ports before anything else can take them
+	if err := reservePorts(containerID, ports); err != nil {
+		abort(err)
+	}
+
+	// Readiness goes to systemd from this process only; the container must not
+	// inherit the notification socket
+	notifySocket := os.Getenv("NOTIFY_SOCKET")
+	os.Unsetenv("NOTIFY_SOCKET")
+
+	// Create log file for container, unless the "none" log driver disables logging
+	var logWriter io.Writer = io.Discard
+	var logFile string
+	if logDriver == "file" {
+		logFile = containerLogFile(containerID)
+		if err := os.MkdirAll(containerDir(containerID), 0755); err != nil {
+			abort(fmt.Errorf("failed to create container directory: %v", err))
+		}
+
+		f, err := os.Create(logFile)
+		if err != nil {
+			abort(fmt.Errorf("failed to create log file: %v", err))
+		}
+		defer f.Close()
+		logWriter = f
 	}
-	defer logWriter.Close()
 
-	if !detached {
-		fmt.Fprintf(os.Stderr, "Running %v as PID %d\n", remainingArgs, os.Getpid())
+	if !opts.Detached {
+		logger.Debug("Running command", "command", opts.Command, "pid", os.Getpid())
 	}
-	fmt.Fprintln(os.Stderr, "Creating isolated namespaces...")
-	fmt.Fprintln(os.Stderr, "  - UTS namespace (hostname isolation)")
-	fmt.Fprintln(os.Stderr, "  - PID namespace (process ID isolation)")
-	fmt.Fprintln(os.Stderr, "  - Mount namespace (filesystem isolation)")
-	fmt.Fprintln(os.Stderr, "  - Network namespace (network isolation)")
-	fmt.Fprintln(os.Stderr, "  - User namespace (user ID isolation)")
 
-	cmd := exec.Command("/proc/self/exe", append([]string{"child"}, remainingArgs...)...)
+	// The child reads its state from the same data root and context as the
+	// parent and logs with the same settings
+	childArgs := append([]string{"--context", activeContextName, "--data-root", stateDir}, logFlags()...)
+	childArgs = append(append(childArgs, "child"), opts.Command...)
+	cmd := exec.Command("/proc/self/exe", childArgs...)
+
+	// Runtime logs from the child go to our stderr, not into the container's
+	// output; its configuration arrives on a pipe rather than in environment
+	// variables the container command would inherit
+	setupRead, setupWrite, err := os.Pipe()
+	if err != nil {
+		abort(fmt.Errorf("failed to create setup pipe: %v", err))
+	}
+	cmd.ExtraFiles = []*os.File{os.Stderr, setupRead}
 
 	// Set up I/O
-	if detached {
+	if opts.Detached {
 		cmd.Stdin = nil
 		cmd.Stdout = io.MultiWriter(logWriter, os.Stdout)
 		cmd.Stderr = io.MultiWriter(logWriter, os.Stderr)
@@ -860,96 +1635,250 @@ func run() {
 		cmd.Stderr = io.MultiWriter(logWriter, os.Stderr)
 	}
 
-	// Set up namespace cloneflags
-	// When running as root, skip user namespace (not needed and complicates chroot)
-	// User namespaces are primarily useful for unprivileged/rootless containers
-	cloneFlags := syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET
-
-	if os.Geteuid() == 0 {
-		// Running as root - no user namespace needed
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Cloneflags: uintptr(cloneFlags),
-		}
-		fmt.Fprintln(os.Stderr, "  - Running as root (no user namespace needed)")
+	// Set up namespaces
+	// Root runs containers without a user namespace unless --userns-remap maps
+	// them to a subordinate ID range; unprivileged users map only themselves
+	cmd.SysProcAttr = namespaceAttr(userns)
+	namespaces := "uts,pid,mount,net,cgroup"
+	if opts.CgroupNS == cgroupNSHost {
+		shareCgroupNamespace(cmd.SysProcAttr)
+		namespaces = "uts,pid,mount,net"
+	}
+	if userns == nil {
+		logger.Info("Creating isolated namespaces", "namespaces", namespaces)
+		logger.Debug("Running as root, skipping user namespace")
 	} else {
-		// Running unprivileged - use user namespace with mapping
-		cloneFlags |= syscall.CLONE_NEWUSER
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Cloneflags: uintptr(cloneFlags),
-			UidMappings: []syscall.SysProcIDMap{
-				{ContainerID: 0, HostID: os.Getuid(), Size: 1},
-			},
-			GidMappings: []syscall.SysProcIDMap{
-				{ContainerID: 0, HostID: os.Getgid(), Size: 1},
-			},
+		logger.Info("Creating isolated namespaces", "namespaces", namespaces+",user")
+		logger.Info("User namespace mapping", "container_uid", 0, "host_uid", userns.UIDs.HostID, "size", userns.UIDs.Size)
+	}
+
+	// Record the container before starting it so a crash leaves a visible trace
+	state := &ContainerState{
+		ID:         containerID,
+		Status:     statusCreated,
+		CreatedAt:  time.Now(),
+		Command:    opts.Command,
+		LogFile:    logFile,
+		Detached:   opts.Detached,
+		CgroupPath: cgroupPath,
+		RootfsPath: resolvedRootfs,
+		Owner:      invokingUser(),
+		Options:    opts,
+	}
+	err = saveContainerState(state)
+	releaseAdmission()
+	if err != nil {
+		abort(err)
+	}
+	if opts.CIDFile != "" {
+		if err := writeCIDFile(opts.CIDFile, containerID); err != nil {
+			updateContainerStatus(containerID, statusExited)
+			abort(err)
 		}
-		fmt.Fprintf(os.Stderr, "  - User namespace: mapping container UID 0 -> host UID %d\n", os.Getuid())
 	}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		cleanupContainerCgroup(cgroupPath)
-		must(err)
+	// Plugin volumes are mounted on the host and bound in like any other volume
+	resolvedVolumes, err := mountPluginVolumes(state, opts.Volumes)
+	if err != nil {
+		updateContainerStatus(containerID, statusExited)
+		abort(err)
 	}
 
-	childPid := cmd.Process.Pid
+	// Container root must own the root filesystem in a remapped user namespace;
+	// the child's mount namespace is copied from this one when it starts, so
+	// an idmapped mount made here is what it sees
+	childRootfs, err := shiftRootfs(containerID, resolvedRootfs, userns)
+	if err != nil {
+		updateContainerStatus(containerID, statusExited)
+		abort(err)
+	}
+
+	// Start the child directly in its cgroup; the cgroup namespace is rooted at
+	// the cgroup the child is created in, so joining it afterwards is too late
+	// cgroup v1 and the systemd driver cannot do this: the child is moved in
+	// after it starts (before it runs the container command), its cgroup
+	// namespace stays rooted at gocker's own cgroup, and no cgroup view is
+	// mounted in the container
+	cgroupDir, err := cgroups.StartDir(cgroupPath)
+	if err != nil {
+		updateContainerStatus(containerID, statusExited)
+		abort(err)
+	}
+	if cgroupDir != nil {
+		startInCgroup(cmd.SysProcAttr, cgroupDir)
+	}
+	// Remapped container root may manage its own cgroup, as root without a
+	// user namespace already can
+	privateCgroupNS := cgroupDir != nil && opts.CgroupNS != cgroupNSHost
+	if privateCgroupNS && userns != nil && userns.UIDs.Size >= usernsRangeSize {
+		if err := delegateCgroup(cgroupPath, userns); err != nil {
+			logger.Warn("Failed to delegate the container cgroup", "error", err)
+		}
+	}
 
-	// Add child to cgroup
-	if err := addToCgroup(cgroupPath, childPid); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Failed to add process to cgroup: %v\n", err)
+	// Start the command
+	err = cmd.Start()
+	if cgroupDir != nil {
+		cgroupDir.Close()
+	}
+	setupRead.Close()
+	if err != nil {
+		updateContainerStatus(containerID, statusExited)
+		abort(err)
 	}
 
-	// Set up parent output
-	var parentOutput io.Writer
-	if detached {
-		parentOutput = io.MultiWriter(logWriter, os.Stderr)
-	} else {
-		parentOutput = logWriter
+	childPid := cmd.Process.Pid
+	if cgroupDir == nil {
+		if err := cgroups.AddProcess(cgroupPath, childPid); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			updateContainerStatus(containerID, statusExited)
+			abort(err)
+		}
 	}
 
-	fmt.Fprintf(parentOutput, "  - Child PID: %d\n", childPid)
+	logger.Info("Container process started", "pid", childPid)
 
 	// Ensure bridge exists
 	if err := ensureBridge(); err != nil {
-		fmt.Fprintf(parentOutput, "Warning: Failed to set up bridge: %v\n", err)
+		logger.Warn("Failed to set up bridge", "error", err)
 	}
 
 	// Set up network namespace for the container
-	if !detached {
-		fmt.Fprintln(logWriter, "Setting up network namespace...")
-	} else {
-		fmt.Fprintln(os.Stderr, "Setting up network namespace...")
+	logger.Info("Setting up network namespace")
+	vethHost, vethPeer, containerIP, err := setupContainerNetwork(containerID, childPid)
+	if err != nil {
+		logger.Warn("Failed to set up network", "error", err)
+	}
+	// abortNetwork stops the container and undoes its network before aborting
+	abortNetwork := func(err error) {
+		cmd.Process.Kill()
+		cmd.Wait()
+		cleanupContainerNetwork(containerID, vethHost)
+		updateContainerStatus(containerID, statusExited)
+		abort(err)
+	}
+	// An --internal container must never start with a route out
+	if opts.Internal && containerIP != "" {
+		if err := blockOutbound(containerIP); err != nil {
+			abortNetwork(err)
+		}
+	}
+	if len(ports) > 0 {
+		if containerIP == "" {
+			abortNetwork(fmt.Errorf("cannot publish ports: container has no network"))
+		}
+		if err := publishPorts(containerID, containerIP); err != nil {
+			abortNetwork(err)
+		}
+	}
+	// Nor may a firewalled container start unprotected
+	if firewall != nil && containerIP != "" {
+		if err := setupFirewall(containerID, containerIP, firewall); err != nil {
+			abortNetwork(err)
+		}
+	}
+
+	// GPU devices and driver libraries are mounted before the command runs
+	var gpuVolumes, gpuEnv []string
+	if gpus != "" {
+		logger.Info("Setting up GPUs", "devices", gpus)
+		gpuVolumes, gpuEnv, err = setupGPUs(gpus, childPid, resolvedRootfs)
+		if err != nil {
+			abortNetwork(fmt.Errorf("failed to set up GPUs: %v", err))
+		}
 	}
 
-	vethHost, vethPeer, containerIP, err := setupContainerNetwork(containerID, childPid, !detached)
+	// Mark the container running
+	err = updateContainerState(containerID, func(state *ContainerState) error {
+		if err := validateTransition(state.Status, statusRunning); err != nil {
+			return err
+		}
+		state.PID = childPid
+		state.Status = statusRunning
+		state.VethHost = vethHost
+		state.VethPeer = vethPeer
+		state.ContainerIP = containerIP
+		if vethHost != "" {
+			state.Interfaces = []NetworkInterface{primaryInterface(vethHost, containerIP)}
+		}
+		if startTime, err := processStartTime(childPid); err == nil {
+			state.StartTime = startTime
+		}
+		state.BootID = currentBootID()
+		return nil
+	})
 	if err != nil {
-		if detached {
-			fmt.Fprintf(os.Stderr, "Warning: Failed to set up network: %v\n", err)
+		logger.Warn("Failed to save container state", "error", err)
+	}
+
+	// The managed hosts file and the syslog socket are bind mounted like volumes
+	volumes := append(append([]string{}, resolvedVolumes...), gpuVolumes...)
+	if wasmRuntimePath != "" {
+		volumes = append(volumes, wasmRuntimePath+":"+wasmRuntimeMount)
+	}
+	volumes = append(volumes, platformVolumes...)
+	if !mountsEtcHosts(resolvedVolumes) {
+		hostsFile, err := writeHostsFile(containerID, containerIP, opts.NetworkAliases, hostEntries)
+		if err != nil {
+			logger.Warn("Failed to write /etc/hosts", "error", err)
+		} else {
+			volumes = append(volumes, hostsFile+":/etc/hosts")
+		}
+	}
+	if opts.Syslog != "" {
+		socket, err := setupSyslog(containerID, childPid, opts.Syslog)
+		if err != nil {
+			logger.Warn("Failed to set up /dev/log", "error", err)
 		} else {
-			fmt.Fprintf(logWriter, "Warning: Failed to set up network: %v\n", err)
+			volumes = append(volumes, socket+":/dev/log")
 		}
 	}
 
-	// Save container state (child reads IP from state file)
-	state := &ContainerState{
-		ID:          containerID,
-		PID:         childPid,
-		Status:      "running",
-		CreatedAt:   time.Now(),
-		Command:     remainingArgs,
-		VethHost:    vethHost,
-		VethPeer:    vethPeer,
-		ContainerIP: containerIP,
-		LogFile:     logFile,
-		Detached:    detached,
-		CgroupPath:  cgroupPath,
-		RootfsPath:  resolvedRootfs,
-	}
-	if err := saveContainerState(state); err != nil {
-		fmt.Fprintf(parentOutput, "Warning: Failed to save container state: %v\n", err)
-	}
-
-	if detached {
+	// Attach the tracer while the child still waits for its configuration, so
+	// the trace covers everything from the container's setup to its exit
+	var tracer *exec.Cmd
+	if opts.Trace {
+		tracer, err = startTrace(strace, containerID, childPid)
+		if err != nil {
+			logger.Warn("Failed to trace container", "error", err)
+		}
+	}
+
+	// Release the child: it blocks on this message, so the container command
+	// cannot start before the cgroup and network setup above is complete
+	err = sendChildConfig(setupWrite, &childConfig{
+		ContainerID: containerID,
+		Rootfs:      childRootfs,
+		Volumes:     volumes,
+		Init:        opts.Init,
+		MountCgroup: privateCgroupNS,
+		Privileged:  opts.Privileged,
+		Runtime:     opts.Runtime,
+		Env:         containerEnv(containerID, "/root", !opts.Detached && isTerminal(os.Stdin), append(append(linkEnv(containerID, linked), gpuEnv...), opts.Env...)),
+	})
+	if err != nil {
+		logger.Warn("Failed to configure container", "error", err)
+	}
+
+	if err := sdNotify(notifySocket, "READY=1\nSTATUS=Container "+containerID+" running"); err != nil {
+		logger.Warn("Failed to notify systemd", "error", err)
+	}
+
+	if opts.Detached {
+		if tracer != nil {
+			tracer.Process.Release()
+		}
+		// Nothing is left running to enforce the lifetime, so start a supervisor
+		if lifetime.enabled() {
+			if err := startLifetimeSupervisor(containerID); err != nil {
+				logger.Warn("Failed to supervise container lifetime", "error", err)
+			}
+		}
+		if opts.Quiet {
+			fmt.Println(containerID)
+			return
+		}
 		fmt.Printf("Container started with ID: %s\n", containerID)
 		fmt.Printf("Use 'gocker logs %s' to view logs\n", containerID)
 		return
@@ -959,11 +1888,27 @@ func run() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	// Cleanup function
-	cleanup := func() {
-		updateContainerStatus(containerID, "exited")
+	// Cleanup function; a container already marked stopped keeps that status
+	cleanup := func(exitCode int) {
+		if state, err := readContainerState(containerID); err == nil {
+			recordUsage(state)
+		}
+		err := updateContainerState(containerID, func(state *ContainerState) error {
+			state.ExitCode = &exitCode
+			if validateTransition(state.Status, statusExited) == nil {
+				state.Status = statusExited
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Warn("Failed to update container status", "error", err)
+		}
 		cleanupContainerNetwork(containerID, vethHost)
 		cleanupContainerCgroup(cgroupPath)
+		releaseContainerMounts(containerID)
+		if opts.Ephemeral {
+			discardContainer(containerID)
+		}
 	}
 
 	// Handle signals in a goroutine
@@ -971,207 +1916,189 @@ func run() {
 	go func() {
 		select {
 		case <-sigChan:
-			fmt.Fprintf(os.Stderr, "\nReceived interrupt, cleaning up...\n")
+			logger.Info("Received interrupt, cleaning up")
+			sdNotify(notifySocket, "STOPPING=1")
 			// Kill the child process
 			cmd.Process.Signal(syscall.SIGTERM)
-			time.Sleep(500 * time.Millisecond)
-			cmd.Process.Kill()
-			cleanup()
+			if !waitForExit(cmd.Process.Pid, 0, 500*time.Millisecond) {
+				cmd.Process.Kill()
+			}
+			cleanup(130)
 			os.Exit(130)
 		case <-done:
 			return
 		}
 	}()
 
-	// Wait for the command to finish
-	waitErr := cmd.Wait()
+	// Sample usage while the container runs; its traffic counters go away
+	// with its network namespace
+	// CPU throttling is watched at the same interval to warn about a CPU limit
+	// that is too low
+	stopSampling := make(chan struct{})
+	go sampleUsageEvery(containerID, usageSampleInterval, stopSampling)
+	go watchThrottling(containerID, usageSampleInterval, stopSampling)
+	if lifetime.enabled() {
+		go superviseLifetime(containerID, lifetime, state.CreatedAt, stopSampling)
+	}
+
+	// Wait for the command to finish; the child exits with the payload's status
+	cmd.Wait()
+	close(stopSampling)
+	if tracer != nil {
+		// strace exits once it has written out the last traced process
+		tracer.Wait()
+	}
 	done <- true
 	signal.Stop(sigChan)
 
-	cleanup()
+	exitCode := exitStatus(cmd.ProcessState)
+	cleanup(exitCode)
+	os.Exit(exitCode)
+}
 
-	if waitErr != nil {
-		os.Exit(cmd.ProcessState.ExitCode())
+// exitStatus returns a process's exit code, or 128+n if it was killed by
+// signal n, matching the convention of shells
+func exitStatus(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok {
+		return waitStatusCode(status)
 	}
+	return state.ExitCode()
 }
 
-func child() {
-	fmt.Fprintf(os.Stderr, "Running in child process with PID %d\n", os.Getpid())
+// waitStatusCode converts a wait status to an exit code like exitStatus
+func waitStatusCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
 
-	containerUID := syscall.Getuid()
-	containerGID := syscall.Getgid()
-	fmt.Fprintf(os.Stderr, "Container UID: %d, GID: %d\n", containerUID, containerGID)
+// Exit codes for a payload that could not be started, as in shells
+const (
+	exitCodeNotExecutable = 126
+	exitCodeNotFound      = 127
+)
 
-	// Get rootfs path from environment
-	rootfsPath := os.Getenv("GOCKER_ROOTFS")
-	if rootfsPath == "" {
-		rootfsPath = "./rootfs"
-	}
+// child runs inside the new namespaces; args is the container command
+func child(args []string) {
+	setupChildLogging()
+	logger.Debug("Running in child process", "pid", os.Getpid(), "uid", syscall.Getuid(), "gid", syscall.Getgid())
 
-	// Configure network inside the container namespace
-	fmt.Fprintln(os.Stderr, "Configuring container network...")
-	if err := configureContainerNetwork(); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Failed to configure container network: %v\n", err)
-	}
+	// Wait for the parent to finish cgroup and network setup
+	cfg, err := readChildConfig(os.NewFile(setupFD, "setup-pipe"))
+	must(err)
+	rootfsPath := cfg.Rootfs
 
 	// Mount volumes before chroot
-	volumesStr := os.Getenv("GOCKER_VOLUMES")
-	if volumesStr != "" {
-		fmt.Fprintln(os.Stderr, "Mounting volumes...")
-		if err := mountVolumes(volumesStr, rootfsPath); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Failed to mount volumes: %v\n", err)
+	if len(cfg.Volumes) > 0 {
+		logger.Info("Mounting volumes")
+		if err := mountVolumes(cfg.Volumes, rootfsPath); err != nil {
+			logger.Warn("Failed to mount volumes", "error", err)
 		}
 	}
 
+	// Keep a way to the host's /dev/null for masking paths after chroot; the
+	// container's root filesystem may have no /dev
+	devNull, err := os.Open("/dev/null")
+	must(err)
+	defer devNull.Close()
+
 	// Set hostname for the container
-	fmt.Fprintln(os.Stderr, "Setting hostname to 'gocker-container'...")
-	must(syscall.Sethostname([]byte("gocker-container")))
+	logger.Info("Setting hostname", "hostname", containerHostname)
+	must(sethostname([]byte(containerHostname)))
 
 	// Create filesystem jail using chroot
-	fmt.Fprintf(os.Stderr, "Creating filesystem jail with chroot (%s)...\n", rootfsPath)
-	must(syscall.Chroot(rootfsPath))
+	logger.Info("Creating filesystem jail with chroot", "rootfs", rootfsPath)
+	must(chroot(rootfsPath))
 
 	// Change to root directory after chroot
 	must(os.Chdir("/"))
 
-	// Mount proc filesystem
-	fmt.Fprintln(os.Stderr, "Mounting proc filesystem...")
-	must(syscall.Mount("proc", "proc", "proc", 0, ""))
-	defer syscall.Unmount("proc", 0)
+	// Mount proc filesystem; like every mount made here it lives in the
+	// container's mount namespace and goes away with it
+	logger.Debug("Mounting proc filesystem")
+	must(mount("proc", "proc", "proc", 0, ""))
+
+	// Hide and protect the parts of /proc that expose the host; like runc,
+	// refuse to start if they cannot be
+	if !cfg.Privileged {
+		must(protectPaths("/", fmt.Sprintf("/proc/self/fd/%d", devNull.Fd())))
+	}
+
+	// Mount the container's own cgroup subtree (the root of its cgroup
+	// namespace), so /sys/fs/cgroup shows nothing outside it
+	if cfg.MountCgroup {
+		logger.Debug("Mounting cgroup filesystem")
+		must(mountCgroupView())
+	}
 
 	// Get the command to execute
 	command := "/bin/sh"
-	args := []string{}
-	if len(os.Args) > 2 {
-		command = os.Args[2]
-		if len(os.Args) > 3 {
-			args = os.Args[3:]
-		}
+	if len(args) > 0 {
+		command = args[0]
+		args = args[1:]
 	}
 
-	// Set PATH environment variable for the container
-	os.Setenv("PATH", "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin")
-
-	// Execute the user's command
-	fmt.Fprintf(os.Stderr, "Executing command: %s %v\n", command, args)
-	cmd := exec.Command(command, args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = os.Environ()
+	// Look the command up in the container's PATH, not the host's
+	containerPath, _ := lookupEnv(cfg.Env, "PATH")
+	os.Setenv("PATH", containerPath)
 
+	argv := append([]string{command}, args...)
 	// For interactive shells, ensure we have a TTY
 	if command == "/bin/sh" && len(args) == 0 {
-		cmd.Args = []string{command, "-i"}
-	}
-
-	must(cmd.Run())
-}
-
-// configureContainerNetwork sets up the network interface inside the container
-// It waits for the parent to set up the veth and reads the IP from the state file
-func configureContainerNetwork() error {
-	containerID := os.Getenv("GOCKER_CONTAINER_ID")
-	if containerID == "" {
-		return fmt.Errorf("GOCKER_CONTAINER_ID not set")
-	}
-
-	ipCmd := "/usr/bin/ip"
-	if _, err := os.Stat(ipCmd); os.IsNotExist(err) {
-		ipCmd = "/sbin/ip"
-		if _, err := os.Stat(ipCmd); os.IsNotExist(err) {
-			ipCmd = "ip"
-		}
-	}
-
-	// Bring up loopback first
-	cmd := exec.Command(ipCmd, "link", "set", "lo", "up")
-	cmd.Run() // Ignore error
-
-	// Wait for veth interface to appear (parent moves it after we start)
-	var foundVeth string
-	for i := 0; i < 50; i++ { // Wait up to 5 seconds
-		cmd := exec.Command(ipCmd, "link", "show", "type", "veth")
-		output, err := cmd.Output()
-		if err == nil {
-			lines := strings.Split(string(output), "\n")
-			for _, line := range lines {
-				if strings.Contains(line, "veth") {
-					parts := strings.Fields(line)
-					if len(parts) >= 2 {
-						name := strings.TrimSuffix(parts[1], ":")
-						// Strip @ifN suffix (e.g., "vethc123@if5" -> "vethc123")
-						if idx := strings.Index(name, "@"); idx != -1 {
-							name = name[:idx]
-						}
-						if strings.HasPrefix(name, "veth") {
-							foundVeth = name
-							break
-						}
-					}
-				}
-			}
-		}
-		if foundVeth != "" {
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
+		argv = []string{command, "-i"}
 	}
-
-	if foundVeth == "" {
-		return fmt.Errorf("no veth interface found after waiting")
+	// A WebAssembly module runs in the runtime gocker mounted, in this same
+	// chroot, cgroup, and namespaces
+	if cfg.Runtime == runtimeWasm {
+		argv = wasmArgv(append([]string{command}, args...), cfg.Env)
+		command = wasmRuntimeMount
 	}
 
-	fmt.Fprintf(os.Stderr, "  - Found container veth interface: %s\n", foundVeth)
-
-	// Wait for state file to have our IP (parent writes it after network setup)
-	var containerIP string
-	stateFile := filepath.Join(containersDir, containerID+".json")
-	for i := 0; i < 50; i++ { // Wait up to 5 seconds
-		data, err := os.ReadFile(stateFile)
-		if err == nil {
-			var state ContainerState
-			if json.Unmarshal(data, &state) == nil && state.ContainerIP != "" {
-				containerIP = state.ContainerIP
-				break
-			}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			os.Exit(exitCodeNotFound)
 		}
-		time.Sleep(100 * time.Millisecond)
+		os.Exit(exitCodeNotExecutable)
 	}
 
-	if containerIP == "" {
-		return fmt.Errorf("container IP not found in state file")
+	logger.Info("Executing command", "command", path, "args", argv[1:])
+	if cfg.Init {
+		os.Exit(runInit(path, argv, cfg.Env))
 	}
 
-	// Bring up the interface
-	cmd = exec.Command(ipCmd, "link", "set", foundVeth, "up")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to bring up container veth: %v", err)
+	// Replace this process with the command, so the command is the container's
+	// PID 1, receives 'gocker stop' signals directly, and its exit status is the
+	// one 'gocker run' passes on
+	err = syscall.Exec(path, argv, cfg.Env)
+	fmt.Fprintf(os.Stderr, "Error: failed to execute %s: %v\n", command, err)
+	if errors.Is(err, os.ErrNotExist) {
+		os.Exit(exitCodeNotFound)
 	}
+	os.Exit(exitCodeNotExecutable)
+}
 
-	// Assign IP address to container interface
-	containerCIDR := containerIP + "/24"
-	cmd = exec.Command(ipCmd, "addr", "add", containerCIDR, "dev", foundVeth)
-	if err := cmd.Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "  - Note: IP assignment: %v\n", err)
-	}
+// containerCgroupMount is where the container sees its cgroup subtree
+const containerCgroupMount = "/sys/fs/cgroup"
 
-	// Set up default route through the bridge
-	cmd = exec.Command(ipCmd, "route", "add", "default", "via", bridgeIP, "dev", foundVeth)
-	if err := cmd.Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "  - Note: Route setup: %v\n", err)
+// mountCgroupView mounts cgroup2 at /sys/fs/cgroup inside the container
+// Inside the cgroup namespace the mount shows only the container's cgroup, so
+// memory.max and cpu.max report the container's limits rather than the host's
+func mountCgroupView() error {
+	if err := os.MkdirAll(containerCgroupMount, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", containerCgroupMount, err)
+	}
+	flags := uintptr(msNosuid | msNodev | msNoexec)
+	if err := mount("cgroup2", containerCgroupMount, "cgroup2", flags, ""); err != nil {
+		return fmt.Errorf("failed to mount cgroup2: %v", err)
 	}
-
-	fmt.Fprintf(os.Stderr, "  - Container IP: %s\n", containerIP)
-	fmt.Fprintln(os.Stderr, "  - Network configuration complete")
-
 	return nil
 }
 
 // mountVolumes mounts host directories into the container rootfs
-func mountVolumes(volumesStr string, rootfsPath string) error {
-	volumes := strings.Split(volumesStr, "|")
-
+func mountVolumes(volumes []string, rootfsPath string) error {
 	for _, volume := range volumes {
 		volume = strings.TrimSpace(volume)
 		if volume == "" {
@@ -1200,7 +2127,12 @@ func mountVolumes(volumesStr string, rootfsPath string) error {
 			return fmt.Errorf("host path does not exist: %s: %v", hostPath, err)
 		}
 
-		mountPoint := filepath.Join(rootfsPath, containerPath)
+		// Resolve the mount point inside the rootfs, so its symlinks cannot
+		// point the mount at a host path
+		mountPoint, err := secureJoin(rootfsPath, containerPath)
+		if err != nil {
+			return fmt.Errorf("invalid container path %s: %v", containerPath, err)
+		}
 
 		if err := os.MkdirAll(filepath.Dir(mountPoint), 0755); err != nil {
 			return fmt.Errorf("failed to create parent directories for mount point %s: %v", mountPoint, err)
@@ -1223,16 +2155,16 @@ func mountVolumes(volumesStr string, rootfsPath string) error {
 			}
 		}
 
-		flags := syscall.MS_BIND | syscall.MS_REC
-		if err := syscall.Mount(hostPath, mountPoint, "", uintptr(flags), ""); err != nil {
+		flags := msBind | msRec
+		if err := mount(hostPath, mountPoint, "", uintptr(flags), ""); err != nil {
 			return fmt.Errorf("failed to bind mount %s to %s: %v", hostPath, mountPoint, err)
 		}
 
-		if err := syscall.Mount("", mountPoint, "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
-			fmt.Fprintf(os.Stderr, "  - Warning: Failed to set mount propagation for %s: %v\n", mountPoint, err)
+		if err := mount("", mountPoint, "", msPrivate|msRec, ""); err != nil {
+			logger.Warn("Failed to set mount propagation", "mount", mountPoint, "error", err)
 		}
 
-		fmt.Fprintf(os.Stderr, "  - Mounted %s -> %s\n", hostPath, containerPath)
+		logger.Info("Mounted volume", "host", hostPath, "container", containerPath)
 	}
 
 	return nil
@@ -1248,13 +2180,13 @@ func listContainers() {
 		return
 	}
 
-	files, err := os.ReadDir(containersDir)
+	ids, err := listContainerIDs()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading containers directory: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
 
-	if len(files) == 0 {
+	if len(ids) == 0 {
 		fmt.Println("No containers found")
 		return
 	}
@@ -1262,24 +2194,17 @@ func listContainers() {
 	fmt.Printf("%-14s %-10s %-10s %-16s %-30s %s\n", "CONTAINER ID", "STATUS", "PID", "IP", "CREATED", "COMMAND")
 	fmt.Println(strings.Repeat("-", 120))
 
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".json") {
-			continue
-		}
-
-		containerID := strings.TrimSuffix(file.Name(), ".json")
-		state, err := loadContainerState(containerID)
+	for _, containerID := range ids {
+		state, err := readContainerState(containerID)
 		if err != nil {
 			continue
 		}
 
-		// Check if process is still running
+		// Containers whose process died are reconciled before listing,
+		// but one may have exited since then
 		status := state.Status
-		if status == "running" {
-			if err := syscall.Kill(state.PID, 0); err != nil {
-				status = "exited"
-				updateContainerStatus(containerID, "exited")
-			}
+		if isActive(status) && !isProcessAlive(state) {
+			status = statusExited
 		}
 
 		command := strings.Join(state.Command, " ")
@@ -1302,11 +2227,12 @@ func listContainers() {
 	}
 }
 
-func stopContainer(containerID string) {
+// stopContainer stops a running container, escalating to SIGKILL if it does
+// not exit within timeout of SIGTERM
+func stopContainer(containerID string, timeout time.Duration) error {
 	state, err := loadContainerState(containerID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		return err
 	}
 
 	displayID := state.ID
@@ -1314,54 +2240,132 @@ func stopContainer(containerID string) {
 		displayID = displayID[:12]
 	}
 
-	if state.Status != "running" {
+	if !isActive(state.Status) {
 		fmt.Printf("Container %s is not running (status: %s)\n", displayID, state.Status)
-		return
+		return nil
 	}
 
 	// Check if process is still running
-	if err := syscall.Kill(state.PID, 0); err != nil {
+	if !isProcessAlive(state) {
 		fmt.Printf("Container %s is not running\n", displayID)
-		updateContainerStatus(state.ID, "exited")
+		updateContainerStatus(state.ID, statusExited)
+		recordUsage(state)
 		cleanupContainerNetwork(state.ID, state.VethHost)
 		cleanupContainerCgroup(state.CgroupPath)
-		return
+		releaseContainerMounts(state.ID)
+		return nil
 	}
 
-	// Send SIGTERM to stop the container
-	fmt.Printf("Stopping container %s (PID: %d)...\n", displayID, state.PID)
-	if err := syscall.Kill(state.PID, syscall.SIGTERM); err != nil {
-		fmt.Fprintf(os.Stderr, "Error stopping container: %v\n", err)
-		os.Exit(1)
+	// A frozen container cannot handle SIGTERM, so thaw it first
+	if state.Status == statusPaused {
+		if err := freezeCgroup(state.CgroupPath, false); err != nil {
+			logger.Warn("Failed to unpause container", "error", err)
+		}
 	}
 
-	// Wait a bit for graceful shutdown
-	time.Sleep(2 * time.Second)
+	// Sample traffic while the container's network still exists
+	recordUsage(state)
 
-	// Check if still running, send SIGKILL if needed
-	if err := syscall.Kill(state.PID, 0); err == nil {
-		fmt.Println("Container did not stop gracefully, sending SIGKILL...")
-		syscall.Kill(state.PID, syscall.SIGKILL)
-		time.Sleep(500 * time.Millisecond)
+	// Send SIGTERM to stop the container
+	fmt.Printf("Stopping container %s (PID: %d)...\n", displayID, state.PID)
+	if err := signalProcess(state.PID, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to stop container %s: %v", displayID, err)
+	}
+
+	// Return as soon as it exits; send SIGKILL only once the timeout expires
+	wait := startCountdown("stop", state.ID, "Waiting for "+displayID+" to exit", timeout)
+	if !waitForExit(state.PID, state.StartTime, timeout) {
+		wait.Done(fmt.Errorf("did not stop within %s", timeout))
+		fmt.Printf("Container %s did not stop within %s, sending SIGKILL...\n", displayID, timeout)
+		signalProcess(state.PID, syscall.SIGKILL)
+		if !waitForExit(state.PID, state.StartTime, killTimeout) {
+			return fmt.Errorf("container %s did not exit after SIGKILL", displayID)
+		}
+	} else {
+		wait.Done(nil)
 	}
 
 	// Cleanup
+	recordUsage(state)
 	cleanupContainerNetwork(state.ID, state.VethHost)
 	cleanupContainerCgroup(state.CgroupPath)
+	releaseContainerMounts(state.ID)
 
 	// Update status
-	if err := updateContainerStatus(state.ID, "stopped"); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Failed to update container status: %v\n", err)
+	if err := updateContainerStatus(state.ID, statusStopped); err != nil {
+		logger.Warn("Failed to update container status", "error", err)
+	}
+	if state.Options != nil && state.Options.Ephemeral {
+		discardContainer(state.ID)
 	}
 
 	fmt.Printf("Container %s stopped\n", displayID)
+	return nil
 }
 
-func removeContainer(containerID string) {
-	state, err := loadContainerState(containerID)
+// pauseContainer freezes (pause) or thaws (unpause) a running container
+func pauseContainer(containerID string, pause bool) {
+	from, to, verb := statusRunning, statusPaused, "paused"
+	if !pause {
+		from, to, verb = statusPaused, statusRunning, "unpaused"
+	}
+
+	var displayID string
+	err := updateContainerState(containerID, func(state *ContainerState) error {
+		displayID = state.ID
+		if len(displayID) > 12 {
+			displayID = displayID[:12]
+		}
+		if state.Status != from || !isProcessAlive(state) {
+			return fmt.Errorf("container %s is not %s", displayID, from)
+		}
+		if err := freezeCgroup(state.CgroupPath, pause); err != nil {
+			return fmt.Errorf("failed to update cgroup freezer: %v", err)
+		}
+		state.Status = to
+		return nil
+	})
+	must(err)
+	fmt.Printf("Container %s %s\n", displayID, verb)
+}
+
+// removeContainer removes a container that is not running, along with its
+// state, log file, and any leftover network and cgroup
+// A protected container is only removed when forceProtected is set
+func removeContainer(containerID string, forceProtected bool) error {
+	fullID, err := destroyContainer(containerID, forceProtected)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+	fmt.Printf("Container %s removed\n", shortID(fullID))
+	return nil
+}
+
+// discardContainer removes an ephemeral container once it has exited
+func discardContainer(containerID string) {
+	if _, err := destroyContainer(containerID, true); err != nil {
+		logger.Warn("Failed to remove ephemeral container", "id", shortID(containerID), "error", err)
+	}
+}
+
+// destroyContainer does the work of removeContainer without printing and
+// returns the container's full ID
+func destroyContainer(containerID string, forceProtected bool) (string, error) {
+	fullID, err := resolveContainerID(containerID)
+	if err != nil {
+		return "", err
+	}
+
+	// Hold the container lock so it cannot be restarted while being removed
+	lock, err := lockContainer(fullID)
+	if err != nil {
+		return "", err
+	}
+	defer unlockContainer(lock)
+
+	state, err := readContainerState(fullID)
+	if err != nil {
+		return "", err
 	}
 
 	displayID := state.ID
@@ -1370,35 +2374,40 @@ func removeContainer(containerID string) {
 	}
 
 	// Check if container is running
-	if state.Status == "running" {
-		if err := syscall.Kill(state.PID, 0); err == nil {
-			fmt.Fprintf(os.Stderr, "Error: Cannot remove running container %s. Stop it first with 'gocker stop %s'\n", displayID, displayID)
-			os.Exit(1)
-		}
+	if isActive(state.Status) && isProcessAlive(state) {
+		return "", fmt.Errorf("cannot remove running container %s. Stop it first with 'gocker stop %s'", displayID, displayID)
+	}
+	if err := checkRemovable(state, forceProtected); err != nil {
+		return "", err
 	}
 
 	// Cleanup network and cgroup (in case they weren't cleaned up on stop)
+	recordUsage(state)
 	cleanupContainerNetwork(state.ID, state.VethHost)
 	cleanupContainerCgroup(state.CgroupPath)
-
-	// Remove state file
-	stateFile := filepath.Join(containersDir, state.ID+".json")
-	if err := os.Remove(stateFile); err != nil {
-		fmt.Fprintf(os.Stderr, "Error removing container state: %v\n", err)
-		os.Exit(1)
+	releaseContainerMounts(state.ID)
+	// Never delete through a root filesystem that is still mounted
+	if _, err := os.Stat(idmappedRootfs(state.ID)); err == nil {
+		return "", fmt.Errorf("cannot remove container %s: its root filesystem is still mounted at %s", displayID, idmappedRootfs(state.ID))
 	}
 
-	// Remove log file if it exists
-	if state.LogFile != "" {
-		if err := os.Remove(state.LogFile); err != nil && !os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "Warning: Failed to remove log file: %v\n", err)
-		}
+	// Remove the container directory (state, log and the rest)
+	// The lock file stays: removing it while held would let a command waiting
+	// for it lock the unlinked file while a newcomer locks a new one
+	if state.Options != nil && state.Options.Ephemeral {
+		unmountEphemeralDir(state.ID, state.Options.TmpDir)
 	}
-
-	fmt.Printf("Container %s removed\n", displayID)
+	if err := os.RemoveAll(containerDir(state.ID)); err != nil {
+		return "", fmt.Errorf("failed to remove container directory: %v", err)
+	}
+	if err := recordHistory(state); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	emitEvent(eventDestroy, state)
+	return fullID, nil
 }
 
-func showLogs(containerID string) {
+func showLogs(containerID string, follow bool) {
 	state, err := loadContainerState(containerID)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -1414,6 +2423,11 @@ func showLogs(containerID string) {
 		os.Exit(1)
 	}
 
+	if follow {
+		must(tailLog(state, true, func(line string) { io.WriteString(os.Stdout, line) }))
+		return
+	}
+
 	logFile, err := os.Open(state.LogFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening log file: %v\n", err)
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -222,8 +225,8 @@ func TestMultipleContainers(t *testing.T) {
 	}
 
 	// Verify containers have different IPs via state files
-	state1File := "/var/lib/gocker/containers/" + container1ID + ".json"
-	state2File := "/var/lib/gocker/containers/" + container2ID + ".json"
+	state1File := "/var/lib/gocker/containers/" + container1ID + "/state.json"
+	state2File := "/var/lib/gocker/containers/" + container2ID + "/state.json"
 
 	data1, err := os.ReadFile(state1File)
 	if err != nil {
@@ -296,6 +299,184 @@ func TestIPAM(t *testing.T) {
 	}
 }
 
+// TestAllocateIPConcurrent runs many allocations at once, as simultaneous
+// 'gocker run' invocations would, and checks no address is handed out twice
+func TestAllocateIPConcurrent(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	const containers = 50
+	ips := make([]string, containers)
+	errs := make([]error, containers)
+	var wg sync.WaitGroup
+	for i := 0; i < containers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ips[i], errs[i] = allocateIP(fmt.Sprintf("%064x", i))
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, ip := range ips {
+		if errs[i] != nil {
+			t.Fatalf("allocateIP failed: %v", errs[i])
+		}
+		if seen[ip] {
+			t.Errorf("IP %s allocated twice", ip)
+		}
+		seen[ip] = true
+	}
+
+	ipam, err := loadIPAM()
+	if err != nil {
+		t.Fatalf("Failed to load IPAM: %v", err)
+	}
+	if len(ipam.AllocatedIPs) != containers {
+		t.Errorf("Expected %d allocations recorded, got %d", containers, len(ipam.AllocatedIPs))
+	}
+}
+
+// overlapCounter records how many callers are inside a locked section at once
+type overlapCounter struct {
+	mu          sync.Mutex
+	active, max int
+}
+
+func (c *overlapCounter) enter() {
+	c.mu.Lock()
+	c.active++
+	c.max = max(c.max, c.active)
+	c.mu.Unlock()
+	// Give a racing caller time to enter too
+	time.Sleep(5 * time.Millisecond)
+}
+
+func (c *overlapCounter) leave() {
+	c.mu.Lock()
+	c.active--
+	c.mu.Unlock()
+}
+
+// serialLinks is a LinkManager for ensureBridge that counts the runs creating
+// the bridge at once; the other LinkManager methods are not used there
+type serialLinks struct {
+	LinkManager
+	overlapCounter
+}
+
+func (l *serialLinks) AddBridge(name string) error {
+	l.enter()
+	return nil
+}
+
+func (l *serialLinks) AddAddr(name, cidr string) error { return nil }
+
+func (l *serialLinks) SetUp(name string) error {
+	l.leave()
+	return nil
+}
+
+// TestEnsureBridgeConcurrent verifies concurrent runs create the bridge and
+// its NAT rules one at a time
+// The bridge never appears on the host, so every run creates it; what the
+// network lock guarantees is that no two do so at once
+func TestEnsureBridgeConcurrent(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+	useFakeHost(t)
+	bridgeName = "gockertest0"
+
+	root := t.TempDir()
+	savedProc := procRoot
+	procRoot = root
+	t.Cleanup(func() { procRoot = savedProc })
+	os.MkdirAll(filepath.Join(root, "net"), 0755)
+	os.MkdirAll(filepath.Join(root, "sys", "net", "ipv4"), 0755)
+	route := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"eth0\t00000000\t0101A8C0\t0003\t0\t0\t0\t00000000\t0\t0\t0\n"
+	if err := os.WriteFile(filepath.Join(root, "net", "route"), []byte(route), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	savedLinks := links
+	fake := &serialLinks{}
+	links = fake
+	t.Cleanup(func() { links = savedLinks })
+
+	const runs = 10
+	errs := make([]error, runs)
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = ensureBridge()
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("ensureBridge failed: %v", err)
+		}
+	}
+	if fake.max != 1 {
+		t.Errorf("Expected bridge setup to be serialized, %d runs overlapped", fake.max)
+	}
+}
+
+// serialCgroups counts the runs creating a cgroup at once
+type serialCgroups struct {
+	*cgroupV2
+	overlapCounter
+}
+
+func (m *serialCgroups) Create(path string) error {
+	m.enter()
+	defer m.leave()
+	return m.cgroupV2.Create(path)
+}
+
+// TestCreateContainerCgroupConcurrent verifies concurrent runs set up the
+// cgroup parent one at a time and each get their own cgroup
+func TestCreateContainerCgroupConcurrent(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+	cgroupParent = filepath.Join(t.TempDir(), "gocker")
+
+	savedCgroups := cgroups
+	fake := &serialCgroups{cgroupV2: &cgroupV2{}}
+	cgroups = fake
+	t.Cleanup(func() { cgroups = savedCgroups })
+
+	const runs = 10
+	paths := make([]string, runs)
+	errs := make([]error, runs)
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			paths[i], errs[i] = createContainerCgroup(fmt.Sprintf("%064x", i))
+		}(i)
+	}
+	wg.Wait()
+
+	for i, path := range paths {
+		if errs[i] != nil {
+			t.Fatalf("createContainerCgroup failed: %v", errs[i])
+		}
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			t.Errorf("Expected cgroup directory %s to exist", path)
+		}
+	}
+	if fake.max != 1 {
+		t.Errorf("Expected cgroup setup to be serialized, %d runs overlapped", fake.max)
+	}
+}
+
 // TestRootfsResolution verifies rootfs path resolution
 func TestRootfsResolution(t *testing.T) {
 	// Test with explicit path
@@ -346,7 +527,7 @@ func TestContainerIDResolution(t *testing.T) {
 
 	defer func() {
 		// Cleanup test state
-		os.Remove(filepath.Join(containersDir, testID+".json"))
+		os.RemoveAll(containerDir(testID))
 	}()
 
 	// Test full ID resolution
@@ -440,6 +621,27 @@ func TestMemoryLimitParsing(t *testing.T) {
 	}
 }
 
+// TestValidateMemoryHigh tests the soft limit must stay below the hard limit
+func TestValidateMemoryHigh(t *testing.T) {
+	tests := []struct {
+		high, limit string
+		hasError    bool
+	}{
+		{"", "512M", false},
+		{"384M", "512M", false},
+		{"1G", "", false},
+		{"1G", "max", false},
+		{"512M", "512M", true},
+		{"1G", "512M", true},
+		{"lots", "", true},
+	}
+	for _, test := range tests {
+		if err := validateMemoryHigh(test.high, test.limit); (err != nil) != test.hasError {
+			t.Errorf("validateMemoryHigh(%q, %q) = %v, want error=%v", test.high, test.limit, err, test.hasError)
+		}
+	}
+}
+
 // TestNamespaceConfig tests that namespace configuration is correct
 func TestNamespaceConfig(t *testing.T) {
 	// When running as root, we skip user namespace
@@ -450,3 +652,270 @@ func TestNamespaceConfig(t *testing.T) {
 		t.Log("Running as non-root - user namespace will be used")
 	}
 }
+
+// TestParseRunArgs tests run flag parsing
+func TestParseRunArgs(t *testing.T) {
+	opts, err := parseRunArgs([]string{"--cpu-limit", "0.5", "-d", "-v", "/tmp:/tmp", "--memory-limit", "512M", "/bin/busybox", "sleep", "10", "-d"})
+	if err != nil {
+		t.Fatalf("parseRunArgs: unexpected error: %v", err)
+	}
+
+	if opts.CPULimit != "0.5" {
+		t.Errorf("Expected CPU limit 0.5, got %q", opts.CPULimit)
+	}
+	if opts.MemoryLimit != "512M" {
+		t.Errorf("Expected memory limit 512M, got %q", opts.MemoryLimit)
+	}
+	if !opts.Detached {
+		t.Errorf("Expected detached mode")
+	}
+	if len(opts.Volumes) != 1 || opts.Volumes[0] != "/tmp:/tmp" {
+		t.Errorf("Unexpected volumes: %v", opts.Volumes)
+	}
+	// Flags after the command belong to the container command
+	if strings.Join(opts.Command, " ") != "/bin/busybox sleep 10 -d" {
+		t.Errorf("Unexpected command: %v", opts.Command)
+	}
+
+	// "--" separates run options from a command that starts with a dash
+	opts, err = parseRunArgs([]string{"-d", "--", "--version"})
+	if err != nil {
+		t.Fatalf("parseRunArgs: unexpected error: %v", err)
+	}
+	if len(opts.Command) != 1 || opts.Command[0] != "--version" {
+		t.Errorf("Unexpected command after --: %v", opts.Command)
+	}
+
+	// Flags after the command belong to the command and are passed through
+	if _, err := parseRunArgs([]string{"/bin/sh", "--cpu-limit"}); err != nil {
+		t.Errorf("Flag after command should be passed through, got error: %v", err)
+	}
+	// A value flag at the end of the line is an error, not silently ignored
+	if _, err := parseRunArgs([]string{"--cpu-limit"}); err == nil {
+		t.Errorf("Expected error for --cpu-limit without a value, got nil")
+	}
+	if _, err := parseRunArgs([]string{"--bogus", "/bin/sh"}); err == nil {
+		t.Errorf("Expected error for unknown flag, got nil")
+	}
+}
+
+// TestForEachContainer tests bounded concurrent bulk operations and failure reporting
+func TestForEachContainer(t *testing.T) {
+	var ids []string
+	for i := 0; i < 3*bulkWorkers; i++ {
+		ids = append(ids, fmt.Sprintf("c%02d", i))
+	}
+
+	var mu sync.Mutex
+	var running, peak int
+	seen := map[string]bool{}
+	ok := forEachContainer(ids, func(id string) error {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		seen[id] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if id == "c03" {
+			return fmt.Errorf("container %s failed", id)
+		}
+		return nil
+	})
+
+	if ok {
+		t.Errorf("Expected failure to be reported")
+	}
+	if len(seen) != len(ids) {
+		t.Errorf("Expected every container to be processed despite a failure, got %d of %d", len(seen), len(ids))
+	}
+	if peak > bulkWorkers {
+		t.Errorf("Expected at most %d concurrent operations, got %d", bulkWorkers, peak)
+	}
+	if !forEachContainer(nil, func(string) error { return fmt.Errorf("unexpected call") }) {
+		t.Errorf("Expected no IDs to succeed trivially")
+	}
+}
+
+// TestMatchingContainerIDs tests --all selection of containers by state
+func TestMatchingContainerIDs(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	for _, state := range []*ContainerState{
+		{ID: "aaa", Status: statusRunning, PID: os.Getpid()},
+		{ID: "bbb", Status: statusExited},
+		{ID: "ccc", Status: statusStopped},
+	} {
+		if err := saveContainerState(state); err != nil {
+			t.Fatalf("saveContainerState failed: %v", err)
+		}
+	}
+
+	got := matchingContainerIDs(func(state *ContainerState) bool { return !isActive(state.Status) })
+	if strings.Join(got, " ") != "bbb ccc" {
+		t.Errorf("Expected inactive containers bbb ccc, got %v", got)
+	}
+}
+
+// TestParseStopTimeout tests stop --time parsing
+func TestParseStopTimeout(t *testing.T) {
+	if timeout, err := parseStopTimeout("3"); err != nil || timeout != 3*time.Second {
+		t.Errorf("parseStopTimeout(3) = %v, %v", timeout, err)
+	}
+	if timeout, err := parseStopTimeout("0"); err != nil || timeout != 0 {
+		t.Errorf("parseStopTimeout(0) = %v, %v", timeout, err)
+	}
+	for _, bad := range []string{"-1", "1.5", "ten"} {
+		if _, err := parseStopTimeout(bad); err == nil {
+			t.Errorf("parseStopTimeout(%q): expected error", bad)
+		}
+	}
+}
+
+// TestExitStatus tests exit code propagation, including deaths by signal
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		script string
+		want   int
+	}{
+		{"exit 0", 0},
+		{"exit 3", 3},
+		{"kill -TERM $$", 128 + 15},
+		{"kill -KILL $$", 128 + 9},
+	}
+
+	for _, tt := range tests {
+		cmd := exec.Command("sh", "-c", tt.script)
+		cmd.Run()
+		if cmd.ProcessState == nil {
+			t.Fatalf("sh -c %q did not run", tt.script)
+		}
+		if got := exitStatus(cmd.ProcessState); got != tt.want {
+			t.Errorf("exitStatus(sh -c %q) = %d, want %d", tt.script, got, tt.want)
+		}
+	}
+}
+
+// TestContainerInterfaceName tests in-container interface naming
+func TestContainerInterfaceName(t *testing.T) {
+	for index, want := range []string{"eth0", "eth1", "eth2"} {
+		if got := containerInterfaceName(index); got != want {
+			t.Errorf("containerInterfaceName(%d) = %q, want %q", index, got, want)
+		}
+	}
+
+	iface := primaryInterface("vethabc12345", "10.0.0.7")
+	if iface.Name != "eth0" || iface.HostVeth != "vethabc12345" || iface.IP != "10.0.0.7" || iface.Bridge != bridgeName {
+		t.Errorf("Unexpected primary interface: %+v", iface)
+	}
+}
+
+// TestInternalRuleArgs checks the --internal rule rejects only traffic leaving the bridge
+func TestInternalRuleArgs(t *testing.T) {
+	opts, err := parseRunArgs([]string{"--internal", "/bin/sh"})
+	if err != nil || !opts.Internal {
+		t.Fatalf("Expected --internal to be parsed, got %+v (%v)", opts, err)
+	}
+
+	got := strings.Join(internalRuleArgs("10.0.0.7"), " ")
+	if want := "FORWARD -i gocker0 -s 10.0.0.7 ! -o gocker0 -j REJECT"; got != want {
+		t.Errorf("internalRuleArgs = %q, want %q", got, want)
+	}
+}
+
+func TestAddressNotifySysctls(t *testing.T) {
+	got := strings.Join(addressNotifySysctls("vethc0123abcd"), " ")
+	want := "net.ipv4.conf.vethc0123abcd.arp_notify net.ipv6.conf.vethc0123abcd.ndisc_notify"
+	if got != want {
+		t.Errorf("addressNotifySysctls = %q, want %q", got, want)
+	}
+}
+
+// TestParseRunFlagsConfig tests --config loading and command-line overrides
+func TestParseRunFlagsConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "run.json")
+	config := `{"cpu_limit": "2", "memory_limit": "1G", "volumes": ["/srv:/srv"], "env": ["A=1"], "command": ["/bin/busybox", "sleep", "60"]}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	opts, _ := parseRunFlags([]string{"--config", path, "--cpu-limit", "0.5", "-v", "/tmp:/tmp"}, func(*commandFlags) {})
+	if opts.CPULimit != "0.5" || opts.MemoryLimit != "1G" {
+		t.Errorf("Expected CLI cpu limit and file memory limit, got %q and %q", opts.CPULimit, opts.MemoryLimit)
+	}
+	if strings.Join(opts.Volumes, " ") != "/srv:/srv /tmp:/tmp" || strings.Join(opts.Env, " ") != "A=1" {
+		t.Errorf("Unexpected volumes %v or env %v", opts.Volumes, opts.Env)
+	}
+	if strings.Join(opts.Command, " ") != "/bin/busybox sleep 60" {
+		t.Errorf("Expected command from the file, got %v", opts.Command)
+	}
+
+	opts, _ = parseRunFlags([]string{"--config", path, "/bin/sh"}, func(*commandFlags) {})
+	if strings.Join(opts.Command, " ") != "/bin/sh" {
+		t.Errorf("Expected command line to replace the file's command, got %v", opts.Command)
+	}
+}
+
+// TestLoadRunConfigErrors tests rejection of invalid run config files
+func TestLoadRunConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"typo.json":    `{"memory_limt": "1G"}`,
+		"invalid.json": `{"cpu_limit": 2}`,
+		"run.yaml":     "cpu_limit: 2\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		if _, err := loadRunConfig(path); err == nil {
+			t.Errorf("loadRunConfig(%s): expected error", name)
+		}
+	}
+	if _, err := loadRunConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Expected error for a missing config file")
+	}
+}
+
+// TestWriteCIDFile tests the container ID file is written once and never overwritten
+func TestWriteCIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cid")
+	if err := writeCIDFile(path, "abc123def456"); err != nil {
+		t.Fatalf("writeCIDFile failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read ID file: %v", err)
+	}
+	if string(data) != "abc123def456" {
+		t.Errorf("Expected ID file to contain the full ID, got %q", data)
+	}
+	if err := writeCIDFile(path, "other"); err == nil {
+		t.Error("Expected error writing an existing ID file")
+	}
+}
+
+// TestGenerateContainerID tests IDs are 64 hex characters and unique
+func TestGenerateContainerID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := generateContainerID()
+		if len(id) != 64 {
+			t.Fatalf("Expected a 64 character ID, got %q", id)
+		}
+		if _, err := hex.DecodeString(id); err != nil {
+			t.Fatalf("Expected a hex ID, got %q", id)
+		}
+		if seen[id[:12]] {
+			t.Fatalf("Duplicate short ID %s", id[:12])
+		}
+		seen[id[:12]] = true
+	}
+}
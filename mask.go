@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// maskedPaths expose host information or kernel interfaces a container has no
+// use for; files are hidden behind /dev/null and directories behind an empty
+// read-only tmpfs
+// The container gets no sysfs, only its cgroup view at /sys/fs/cgroup, so
+// there is nothing under /sys to mask
+var maskedPaths = []string{
+	"/proc/acpi",
+	"/proc/asound",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+}
+
+// readonlyPaths stay visible but cannot be written, so a container cannot
+// change kernel settings or trigger SysRq on the host
+var readonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// protectPaths masks maskedPaths and makes readonlyPaths read-only under root,
+// the container's root after chroot
+// devNull is a path to the host's /dev/null that still resolves after chroot,
+// such as /proc/self/fd/N for an open descriptor; paths that do not exist are
+// skipped, and any other failure is returned, as the container must not start
+// with them exposed
+func protectPaths(root, devNull string) error {
+	for _, path := range maskedPaths {
+		target := filepath.Join(root, path)
+		info, err := os.Stat(target)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to mask %s: %v", path, err)
+		}
+		if info.IsDir() {
+			err = mount("tmpfs", target, "tmpfs", msRdonly, "")
+		} else {
+			err = mount(devNull, target, "", msBind, "")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to mask %s: %v", path, err)
+		}
+	}
+
+	for _, path := range readonlyPaths {
+		target := filepath.Join(root, path)
+		_, err := os.Stat(target)
+		if os.IsNotExist(err) {
+			continue
+		}
+		// A bind mount only becomes read-only when remounted
+		if err == nil {
+			err = mount(target, target, "", msBind|msRec, "")
+		}
+		if err == nil {
+			err = mount(target, target, "", msBind|msRemount|msRdonly|msNosuid|msNodev|msNoexec, "")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to make %s read-only: %v", path, err)
+		}
+	}
+	return nil
+}
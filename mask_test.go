@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProtectPaths(t *testing.T) {
+	type mountCall struct {
+		source, target, fstype string
+		flags                  uintptr
+	}
+	var mounts []mountCall
+	saved := mount
+	t.Cleanup(func() { mount = saved })
+	mount = func(source, target, fstype string, flags uintptr, data string) error {
+		mounts = append(mounts, mountCall{source, target, fstype, flags})
+		return nil
+	}
+
+	// A root with one masked file, one masked directory, and one read-only path
+	root := t.TempDir()
+	for _, dir := range []string{"proc/acpi", "proc/sys"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "proc/kcore"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create kcore: %v", err)
+	}
+
+	if err := protectPaths(root, "/proc/self/fd/9"); err != nil {
+		t.Fatalf("protectPaths failed: %v", err)
+	}
+
+	acpi, kcore, sys := filepath.Join(root, "proc/acpi"), filepath.Join(root, "proc/kcore"), filepath.Join(root, "proc/sys")
+	want := []mountCall{
+		{"tmpfs", acpi, "tmpfs", uintptr(msRdonly)},
+		{"/proc/self/fd/9", kcore, "", uintptr(msBind)},
+		{sys, sys, "", uintptr(msBind | msRec)},
+		{sys, sys, "", uintptr(msBind | msRemount | msRdonly | msNosuid | msNodev | msNoexec)},
+	}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("mounts = %+v, want %+v", mounts, want)
+	}
+}
+
+// TestProtectPathsFailure checks a path that cannot be protected stops the
+// container instead of leaving it exposed
+func TestProtectPathsFailure(t *testing.T) {
+	saved := mount
+	t.Cleanup(func() { mount = saved })
+	mount = func(source, target, fstype string, flags uintptr, data string) error {
+		if flags&msRemount != 0 {
+			return errors.New("permission denied")
+		}
+		return nil
+	}
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "proc/sys"), 0755); err != nil {
+		t.Fatalf("Failed to create proc/sys: %v", err)
+	}
+	err := protectPaths(root, "/proc/self/fd/9")
+	if err == nil || !strings.Contains(err.Error(), "/proc/sys") {
+		t.Errorf("protectPaths with a failing remount = %v, want an error naming /proc/sys", err)
+	}
+
+	mount = func(source, target, fstype string, flags uintptr, data string) error {
+		return errors.New("no tmpfs")
+	}
+	if err := os.MkdirAll(filepath.Join(root, "proc/acpi"), 0755); err != nil {
+		t.Fatalf("Failed to create proc/acpi: %v", err)
+	}
+	if err := protectPaths(root, "/proc/self/fd/9"); err == nil || !strings.Contains(err.Error(), "/proc/acpi") {
+		t.Errorf("protectPaths with a failing mask = %v, want an error naming /proc/acpi", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// cgroupNSPrivate and cgroupNSHost are the --cgroupns modes
+const (
+	cgroupNSPrivate = "private"
+	cgroupNSHost    = "host"
+)
+
+// validateCgroupNS checks a --cgroupns value; empty means private
+func validateCgroupNS(mode string) error {
+	if mode != "" && mode != cgroupNSPrivate && mode != cgroupNSHost {
+		return fmt.Errorf("invalid --cgroupns: %s (expected 'private' or 'host')", mode)
+	}
+	return nil
+}
+
+// cgroupControllers are the controllers gocker enables for container cgroups
+const cgroupControllers = "+cpu +memory +pids"
+
+// delegatedCgroupFiles are the files of a delegated cgroup its new owner must
+// own as well, besides the directory (see "Delegation" in cgroup-v2.rst)
+var delegatedCgroupFiles = []string{"cgroup.procs", "cgroup.threads", "cgroup.subtree_control"}
+
+// delegateCgroup gives a container's root, mapped by userns, ownership of the
+// container's cgroup, so it can create child cgroups and move processes
+// between them, as a gocker running inside the container does
+func delegateCgroup(path string, userns *userNamespace) error {
+	uid, gid := userns.UIDs.HostID, userns.GIDs.HostID
+	if err := lchown(path, uid, gid); err != nil {
+		return fmt.Errorf("failed to delegate cgroup: %v", err)
+	}
+	for _, name := range delegatedCgroupFiles {
+		if err := lchown(filepath.Join(path, name), uid, gid); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delegate cgroup: %v", err)
+		}
+	}
+	return nil
+}
+
+// nestedCgroupLeaf is the child cgroup the processes of a non-root cgroup are
+// moved to so the cgroup can enable controllers for its children
+const nestedCgroupLeaf = "init"
+
+// enableControllers enables cgroupControllers for the children of dir
+// Inside a container's cgroup namespace, cgroupRoot is the container's own
+// cgroup, which holds the container's processes; cgroup v2 only lets a cgroup
+// without processes hand controllers to its children, so they are moved to
+// nestedCgroupLeaf first. The host's root cgroup is exempt from that rule, so
+// this never moves host processes
+func enableControllers(dir string) error {
+	controllersFile := filepath.Join(dir, "cgroup.subtree_control")
+	err := os.WriteFile(controllersFile, []byte(cgroupControllers), 0644)
+	if err == nil || !errors.Is(err, syscall.EBUSY) || dir != cgroupRoot {
+		return err
+	}
+	logger.Debug("Moving processes out of the cgroup namespace root", "cgroup", dir, "leaf", nestedCgroupLeaf)
+	if err := evacuateCgroup(dir); err != nil {
+		return err
+	}
+	return os.WriteFile(controllersFile, []byte(cgroupControllers), 0644)
+}
+
+// evacuateCgroup moves every process in dir into its nestedCgroupLeaf child
+// The kernel takes one PID per write to cgroup.procs
+func evacuateCgroup(dir string) error {
+	leaf := filepath.Join(dir, nestedCgroupLeaf)
+	if err := os.MkdirAll(leaf, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", leaf, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return fmt.Errorf("failed to list processes in %s: %v", dir, err)
+	}
+	for _, pid := range strings.Fields(string(data)) {
+		err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(pid), 0644)
+		// A process may exit before it is moved
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("failed to move process %s to %s: %v", pid, leaf, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateCgroupNS(t *testing.T) {
+	for _, mode := range []string{"", "private", "host"} {
+		if err := validateCgroupNS(mode); err != nil {
+			t.Errorf("validateCgroupNS(%q) failed: %v", mode, err)
+		}
+	}
+	if err := validateCgroupNS("shared"); err == nil {
+		t.Error("Expected error for an unknown mode")
+	}
+}
+
+// TestDelegateCgroup verifies the cgroup and its delegation files are given
+// to the remapped root
+func TestDelegateCgroup(t *testing.T) {
+	saved := lchown
+	t.Cleanup(func() { lchown = saved })
+	var chowned []string
+	lchown = func(path string, uid, gid int) error {
+		if uid != 100000 || gid != 200000 {
+			t.Errorf("lchown(%s) to %d:%d", path, uid, gid)
+		}
+		chowned = append(chowned, filepath.Base(path))
+		return nil
+	}
+
+	userns := &userNamespace{UIDs: idMapping{HostID: 100000, Size: usernsRangeSize}, GIDs: idMapping{HostID: 200000, Size: usernsRangeSize}}
+	if err := delegateCgroup("/sys/fs/cgroup/gocker/abc", userns); err != nil {
+		t.Fatalf("delegateCgroup failed: %v", err)
+	}
+	want := []string{"abc", "cgroup.procs", "cgroup.threads", "cgroup.subtree_control"}
+	if !reflect.DeepEqual(chowned, want) {
+		t.Errorf("Chowned %v, want %v", chowned, want)
+	}
+}
+
+// TestEvacuateCgroup verifies processes are moved into the leaf one by one
+func TestEvacuateCgroup(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("1\n17\n"), 0644)
+	if err := evacuateCgroup(dir); err != nil {
+		t.Fatalf("evacuateCgroup failed: %v", err)
+	}
+	// Each write replaces a regular file, so the leaf holds the last PID
+	data, err := os.ReadFile(filepath.Join(dir, nestedCgroupLeaf, "cgroup.procs"))
+	if err != nil || string(data) != "17" {
+		t.Errorf("Leaf cgroup.procs = %q (%v), want 17", data, err)
+	}
+
+	if err := evacuateCgroup(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected error for a cgroup without cgroup.procs")
+	}
+}
+
+// TestNestedGocker runs gocker inside a privileged gocker container, which
+// starts a container of its own
+// It needs root, cgroup v2, and the rootfs from 'make setup'; the nested
+// gocker is built static so it runs on the Alpine rootfs
+func TestNestedGocker(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("nested containers need root")
+	}
+	if _, err := os.Stat("./gocker"); os.IsNotExist(err) {
+		t.Skip("gocker binary not found. Run 'make build' first.")
+	}
+	rootfs, err := filepath.Abs("./rootfs")
+	if err != nil {
+		t.Fatalf("Failed to resolve rootfs: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, "bin/busybox")); err != nil {
+		t.Skip("rootfs not found. Run 'make setup' first.")
+	}
+	if fs, err := statFS(cgroupRoot); err != nil || fs.Type != cgroup2SuperMagic {
+		t.Skip("nested containers need cgroup v2")
+	}
+
+	nested := filepath.Join(t.TempDir(), "gocker")
+	build := exec.Command("go", "build", "-o", nested, ".")
+	build.Env = append(os.Environ(), "CGO_ENABLED=0")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to build static gocker: %v\n%s", err, output)
+	}
+
+	// The inner gocker uses the same rootfs and its own bridge and data root
+	cmd := exec.Command("./gocker", "run", "--privileged", "--cgroupns", "private",
+		"-v", nested+":/usr/local/bin/gocker",
+		"-v", rootfs+":/var/lib/gocker-nested/rootfs",
+		"-e", "GOCKER_BRIDGE_NAME=gocker-nested0",
+		"-e", "GOCKER_BRIDGE_SUBNET=10.254.0.0/24",
+		"/usr/local/bin/gocker", "--data-root", "/tmp/gocker-nested",
+		"run", "--rootfs", "/var/lib/gocker-nested/rootfs", "/bin/busybox", "echo", "nested ok")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Nested gocker failed: %v\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "nested ok") {
+		t.Errorf("Expected the nested container's output, got:\n%s", output)
+	}
+}
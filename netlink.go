@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Network interfaces, addresses and routes are configured over rtnetlink,
+// the kernel interface the ip command uses, so gocker needs no ip binary
+// The messages are built here; sending them is Linux-only (netlink_linux.go)
+
+// LinkManager configures network interfaces
+type LinkManager interface {
+	// AddBridge creates a bridge
+	AddBridge(name string) error
+	// AddVeth creates a veth pair; mtu 0 keeps the default
+	AddVeth(name, peer string, mtu int) error
+	// Delete removes an interface, and with a veth its peer
+	Delete(name string) error
+	// SetUp brings an interface up
+	SetUp(name string) error
+	// SetMaster attaches an interface to a bridge
+	SetMaster(name, master string) error
+	// SetName renames an interface
+	SetName(name, newName string) error
+	// SetNetns moves an interface into the network namespace of pid
+	SetNetns(name string, pid int) error
+	// AddAddr assigns an IPv4 address in CIDR notation
+	AddAddr(name, cidr string) error
+	// AddDefaultRoute routes everything without a better route via gateway
+	AddDefaultRoute(gateway, name string) error
+	// DeleteNeighbor removes the neighbor (ARP) entry for ip on an interface
+	DeleteNeighbor(ip, name string) error
+	// InNetns runs fn in the network namespace of pid; the operations above,
+	// sysctls and sockets created in fn apply there
+	InNetns(pid int, fn func() error) error
+}
+
+// links configures the host's network interfaces
+var links LinkManager = netlinkLinks{}
+
+// rtnetlink message types, flags and attributes, from linux/netlink.h,
+// linux/rtnetlink.h, linux/if_link.h and linux/neighbour.h
+const (
+	nlmsgError = 2
+
+	nlmFRequest = 0x1
+	nlmFAck     = 0x4
+	nlmFExcl    = 0x200
+	nlmFCreate  = 0x400
+
+	rtmNewLink   = 16
+	rtmDelLink   = 17
+	rtmNewAddr   = 20
+	rtmNewRoute  = 24
+	rtmDelNeigh  = 29
+	afUnspec     = 0
+	afInet       = 2
+	iffUp        = 0x1
+	iflaIfname   = 3
+	iflaMTU      = 4
+	iflaMaster   = 10
+	iflaLinkinfo = 18
+	iflaNetNsPID = 19
+	iflaInfoKind = 1
+	iflaInfoData = 2
+	vethInfoPeer = 1
+	ifaAddress   = 1
+	ifaLocal     = 2
+	rtaGateway   = 5
+	rtaOIF       = 4
+	ndaDst       = 1
+
+	rtTableMain   = 254
+	rtprotBoot    = 3
+	rtScopeGlobal = 0
+	rtnUnicast    = 1
+)
+
+// netlinkAttr is a netlink attribute; one with children is a nested attribute
+type netlinkAttr struct {
+	typ      uint16
+	data     []byte
+	children []netlinkAttr
+}
+
+// stringAttr is a NUL-terminated string attribute
+func stringAttr(typ uint16, value string) netlinkAttr {
+	return netlinkAttr{typ: typ, data: append([]byte(value), 0)}
+}
+
+// uint32Attr is a 32-bit attribute in host byte order
+func uint32Attr(typ uint16, value uint32) netlinkAttr {
+	data := make([]byte, 4)
+	binary.NativeEndian.PutUint32(data, value)
+	return netlinkAttr{typ: typ, data: data}
+}
+
+// nlAlign rounds n up to the 4-byte alignment of netlink messages and attributes
+func nlAlign(n int) int {
+	return (n + 3) &^ 3
+}
+
+// encode returns the attribute with its header, padded to alignment
+func (a netlinkAttr) encode() []byte {
+	data := append([]byte(nil), a.data...)
+	for _, child := range a.children {
+		data = append(data, child.encode()...)
+	}
+	b := make([]byte, nlAlign(4+len(data)))
+	binary.NativeEndian.PutUint16(b[0:], uint16(4+len(data)))
+	binary.NativeEndian.PutUint16(b[2:], a.typ)
+	copy(b[4:], data)
+	return b
+}
+
+// netlinkMessage returns a request: the header, the fixed-size body of the
+// message type, and its attributes
+func netlinkMessage(typ, flags uint16, seq uint32, body []byte, attrs ...netlinkAttr) []byte {
+	payload := append([]byte(nil), body...)
+	for _, attr := range attrs {
+		payload = append(payload, attr.encode()...)
+	}
+	msg := make([]byte, 16, 16+len(payload))
+	binary.NativeEndian.PutUint32(msg[0:], uint32(16+len(payload)))
+	binary.NativeEndian.PutUint16(msg[4:], typ)
+	binary.NativeEndian.PutUint16(msg[6:], flags)
+	binary.NativeEndian.PutUint32(msg[8:], seq)
+	return append(msg, payload...)
+}
+
+// ifInfoMsg is the body of link messages (struct ifinfomsg)
+func ifInfoMsg(index int, flags, change uint32) []byte {
+	b := make([]byte, 16)
+	b[0] = afUnspec
+	binary.NativeEndian.PutUint32(b[4:], uint32(index))
+	binary.NativeEndian.PutUint32(b[8:], flags)
+	binary.NativeEndian.PutUint32(b[12:], change)
+	return b
+}
+
+// ifAddrMsg is the body of IPv4 address messages (struct ifaddrmsg)
+func ifAddrMsg(index, prefixLen int) []byte {
+	b := make([]byte, 8)
+	b[0] = afInet
+	b[1] = byte(prefixLen)
+	binary.NativeEndian.PutUint32(b[4:], uint32(index))
+	return b
+}
+
+// rtMsg is the body of IPv4 route messages in the main table (struct rtmsg)
+func rtMsg(dstLen int) []byte {
+	return []byte{afInet, byte(dstLen), 0, 0, rtTableMain, rtprotBoot, rtScopeGlobal, rtnUnicast, 0, 0, 0, 0}
+}
+
+// ndMsg is the body of IPv4 neighbor messages (struct ndmsg)
+func ndMsg(index int) []byte {
+	b := make([]byte, 12)
+	b[0] = afInet
+	binary.NativeEndian.PutUint32(b[4:], uint32(index))
+	return b
+}
+
+// vethRequest returns the attributes that create a veth pair
+func vethRequest(name, peer string, mtu int) []netlinkAttr {
+	peerAttrs := []netlinkAttr{stringAttr(iflaIfname, peer)}
+	attrs := []netlinkAttr{stringAttr(iflaIfname, name)}
+	if mtu > 0 {
+		peerAttrs = append(peerAttrs, uint32Attr(iflaMTU, uint32(mtu)))
+		attrs = append(attrs, uint32Attr(iflaMTU, uint32(mtu)))
+	}
+	// The peer is described by an ifinfomsg followed by its own attributes
+	peerInfo := netlinkAttr{typ: vethInfoPeer, data: ifInfoMsg(0, 0, 0), children: peerAttrs}
+	return append(attrs, netlinkAttr{typ: iflaLinkinfo, children: []netlinkAttr{
+		stringAttr(iflaInfoKind, "veth"),
+		{typ: iflaInfoData, children: []netlinkAttr{peerInfo}},
+	}})
+}
+
+// parseIPv4 parses an IPv4 address for a netlink attribute
+func parseIPv4(s string) ([]byte, error) {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IPv4 address: %s", s)
+	}
+	return ip, nil
+}
+
+// sysctlPath returns the /proc/sys file of a sysctl such as net.ipv4.ip_forward
+// Interface names never contain dots, so every dot separates path elements
+func sysctlPath(name string) string {
+	return filepath.Join(append([]string{procRoot, "sys"}, strings.Split(name, ".")...)...)
+}
+
+// writeSysctl sets a sysctl, in the network namespace of the caller for net.*
+func writeSysctl(name, value string) error {
+	return os.WriteFile(sysctlPath(name), []byte(value), 0644)
+}
+
+// addressNotifySysctls are the sysctls that make the kernel announce an
+// interface's addresses when it comes up
+func addressNotifySysctls(device string) []string {
+	return []string{
+		"net.ipv4.conf." + device + ".arp_notify",
+		"net.ipv6.conf." + device + ".ndisc_notify",
+	}
+}
+
+// defaultRoute is a default route from /proc/net/route
+type defaultRoute struct {
+	iface   string
+	gateway net.IP
+	metric  int64
+}
+
+// readDefaultRoute returns the preferred default route of a network
+// namespace from its /proc/<pid>/net/route, or the caller's from /proc/net/route
+func readDefaultRoute(routeFile string) (defaultRoute, error) {
+	data, err := os.ReadFile(routeFile)
+	if err != nil {
+		return defaultRoute{}, err
+	}
+	return parseDefaultRoute(string(data))
+}
+
+// parseDefaultRoute returns the default route of a /proc/net/route table,
+// whose lines are "Iface Destination Gateway Flags RefCnt Use Metric Mask ..."
+// with addresses in hex in host byte order; with several, as on a laptop with
+// wired and wireless links, the kernel uses the one with the lowest metric
+func parseDefaultRoute(table string) (defaultRoute, error) {
+	var best *defaultRoute
+	for _, line := range strings.Split(table, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil || flags&0x1 == 0 { // RTF_UP
+			continue
+		}
+		gateway, _ := strconv.ParseUint(fields[2], 16, 32)
+		route := defaultRoute{iface: fields[0], gateway: make(net.IP, 4)}
+		binary.NativeEndian.PutUint32(route.gateway, uint32(gateway))
+		route.metric, _ = strconv.ParseInt(fields[6], 10, 64)
+		if best == nil || route.metric < best.metric {
+			best = &route
+		}
+	}
+	if best == nil {
+		return defaultRoute{}, fmt.Errorf("could not find default interface")
+	}
+	return *best, nil
+}
@@ -0,0 +1,190 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// netlinkLinks is the LinkManager of the host, talking rtnetlink
+type netlinkLinks struct{}
+
+// netlinkRequest sends one request on a new NETLINK_ROUTE socket and waits
+// for the kernel's acknowledgement
+// The socket belongs to the network namespace of the calling thread
+func netlinkRequest(typ, flags uint16, body []byte, attrs ...netlinkAttr) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("netlink socket: %v", err)
+	}
+	defer syscall.Close(fd)
+	kernel := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("netlink bind: %v", err)
+	}
+	const seq = 1
+	if err := syscall.Sendto(fd, netlinkMessage(typ, flags|nlmFRequest|nlmFAck, seq, body, attrs...), 0, kernel); err != nil {
+		return fmt.Errorf("netlink send: %v", err)
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("netlink receive: %v", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("netlink receive: %v", err)
+		}
+		for _, msg := range msgs {
+			if msg.Header.Seq != seq || msg.Header.Type != nlmsgError || len(msg.Data) < 4 {
+				continue
+			}
+			if errno := int32(binary.NativeEndian.Uint32(msg.Data)); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+// linkIndex returns the index of an interface in the caller's namespace
+func linkIndex(name string) (int, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, err
+	}
+	return iface.Index, nil
+}
+
+// setLink changes an existing interface
+func setLink(name string, flags, change uint32, attrs ...netlinkAttr) error {
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+	return netlinkRequest(rtmNewLink, 0, ifInfoMsg(index, flags, change), attrs...)
+}
+
+func (netlinkLinks) AddBridge(name string) error {
+	return netlinkRequest(rtmNewLink, nlmFCreate|nlmFExcl, ifInfoMsg(0, 0, 0),
+		stringAttr(iflaIfname, name),
+		netlinkAttr{typ: iflaLinkinfo, children: []netlinkAttr{stringAttr(iflaInfoKind, "bridge")}})
+}
+
+func (netlinkLinks) AddVeth(name, peer string, mtu int) error {
+	return netlinkRequest(rtmNewLink, nlmFCreate|nlmFExcl, ifInfoMsg(0, 0, 0), vethRequest(name, peer, mtu)...)
+}
+
+func (netlinkLinks) Delete(name string) error {
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+	return netlinkRequest(rtmDelLink, 0, ifInfoMsg(index, 0, 0))
+}
+
+func (netlinkLinks) SetUp(name string) error {
+	return setLink(name, iffUp, iffUp)
+}
+
+func (netlinkLinks) SetMaster(name, master string) error {
+	masterIndex, err := linkIndex(master)
+	if err != nil {
+		return err
+	}
+	return setLink(name, 0, 0, uint32Attr(iflaMaster, uint32(masterIndex)))
+}
+
+func (netlinkLinks) SetName(name, newName string) error {
+	return setLink(name, 0, 0, stringAttr(iflaIfname, newName))
+}
+
+func (netlinkLinks) SetNetns(name string, pid int) error {
+	return setLink(name, 0, 0, uint32Attr(iflaNetNsPID, uint32(pid)))
+}
+
+func (netlinkLinks) AddAddr(name, cidr string) error {
+	ip, subnet, err := net.ParseCIDR(cidr)
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("invalid IPv4 address: %s", cidr)
+	}
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+	prefixLen, _ := subnet.Mask.Size()
+	return netlinkRequest(rtmNewAddr, nlmFCreate|nlmFExcl, ifAddrMsg(index, prefixLen),
+		netlinkAttr{typ: ifaLocal, data: ip.To4()},
+		netlinkAttr{typ: ifaAddress, data: ip.To4()})
+}
+
+func (netlinkLinks) AddDefaultRoute(gateway, name string) error {
+	gw, err := parseIPv4(gateway)
+	if err != nil {
+		return err
+	}
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+	return netlinkRequest(rtmNewRoute, nlmFCreate|nlmFExcl, rtMsg(0),
+		netlinkAttr{typ: rtaGateway, data: gw},
+		uint32Attr(rtaOIF, uint32(index)))
+}
+
+func (netlinkLinks) DeleteNeighbor(ip, name string) error {
+	dst, err := parseIPv4(ip)
+	if err != nil {
+		return err
+	}
+	index, err := linkIndex(name)
+	if err != nil {
+		return err
+	}
+	return netlinkRequest(rtmDelNeigh, 0, ndMsg(index), netlinkAttr{typ: ndaDst, data: dst})
+}
+
+// sysSetns is the number of setns(2), which package syscall does not define
+var sysSetns = map[string]uintptr{
+	"386":     346,
+	"amd64":   308,
+	"arm":     375,
+	"arm64":   268,
+	"ppc64le": 350,
+	"riscv64": 268,
+	"s390x":   339,
+}
+
+// InNetns runs fn on a thread of its own that has joined the network
+// namespace of pid
+// The thread is never unlocked, so the runtime discards it when fn returns
+// rather than reusing it with the container's network
+func (netlinkLinks) InNetns(pid int, fn func() error) error {
+	trap, ok := sysSetns[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("entering network namespaces is not supported on %s", runtime.GOARCH)
+	}
+	target, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		return fmt.Errorf("failed to open network namespace: %v", err)
+	}
+	defer target.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if _, _, errno := syscall.RawSyscall(trap, target.Fd(), syscall.CLONE_NEWNET, 0); errno != 0 {
+			result <- fmt.Errorf("failed to enter network namespace: %v", errno)
+			return
+		}
+		result <- fn()
+	}()
+	return <-result
+}
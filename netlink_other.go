@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+// netlinkLinks stands in for rtnetlink, which only Linux has
+type netlinkLinks struct{}
+
+func (netlinkLinks) AddBridge(name string) error                { return errNotLinux }
+func (netlinkLinks) AddVeth(name, peer string, mtu int) error   { return errNotLinux }
+func (netlinkLinks) Delete(name string) error                   { return errNotLinux }
+func (netlinkLinks) SetUp(name string) error                    { return errNotLinux }
+func (netlinkLinks) SetMaster(name, master string) error        { return errNotLinux }
+func (netlinkLinks) SetName(name, newName string) error         { return errNotLinux }
+func (netlinkLinks) SetNetns(name string, pid int) error        { return errNotLinux }
+func (netlinkLinks) AddAddr(name, cidr string) error            { return errNotLinux }
+func (netlinkLinks) AddDefaultRoute(gateway, name string) error { return errNotLinux }
+func (netlinkLinks) DeleteNeighbor(ip, name string) error       { return errNotLinux }
+func (netlinkLinks) InNetns(pid int, fn func() error) error     { return errNotLinux }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+// routeTableHeader is the first line of /proc/net/route
+const routeTableHeader = "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"
+
+func TestParseDefaultRoute(t *testing.T) {
+	tests := []struct {
+		name, table, iface, gateway string
+	}{
+		{"single", "eth0\t00000000\t0101A8C0\t0003\t0\t0\t0\t00000000\t0\t0\t0\n", "eth0", "192.168.1.1"},
+		{"lowest metric", "wlan0\t00000000\t0101A8C0\t0003\t0\t0\t600\t00000000\t0\t0\t0\n" +
+			"enp3s0\t00000000\t0100010A\t0003\t0\t0\t100\t00000000\t0\t0\t0\n", "enp3s0", "10.1.0.1"},
+		{"equal metrics keep the first", "eth1\t00000000\t0100010A\t0003\t0\t0\t100\t00000000\t0\t0\t0\n" +
+			"eth2\t00000000\t0100020A\t0003\t0\t0\t100\t00000000\t0\t0\t0\n", "eth1", "10.1.0.1"},
+		{"skip down routes", "eth1\t00000000\t0100010A\t0002\t0\t0\t10\t00000000\t0\t0\t0\n" +
+			"wg0\t00000000\t00000000\t0001\t0\t0\t20\t00000000\t0\t0\t0\n", "wg0", "0.0.0.0"},
+	}
+	for _, tt := range tests {
+		route, err := parseDefaultRoute(routeTableHeader + tt.table)
+		if err != nil || route.iface != tt.iface || route.gateway.String() != tt.gateway {
+			t.Errorf("%s: parseDefaultRoute() = %s via %v, %v, want %s via %s", tt.name, route.iface, route.gateway, err, tt.iface, tt.gateway)
+		}
+	}
+	if _, err := parseDefaultRoute(routeTableHeader + "gocker0\t0000000A\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n"); err == nil {
+		t.Error("parseDefaultRoute() succeeded without a default route")
+	}
+}
+
+func TestNetlinkAttrEncode(t *testing.T) {
+	got := stringAttr(iflaIfname, "eth0").encode()
+	// length 9 (4 header + "eth0\x00"), type 3, padded to 12
+	want := make([]byte, 12)
+	binary.NativeEndian.PutUint16(want[0:], 9)
+	binary.NativeEndian.PutUint16(want[2:], iflaIfname)
+	copy(want[4:], "eth0")
+	if !bytes.Equal(got, want) {
+		t.Errorf("stringAttr(eth0).encode() = %v, want %v", got, want)
+	}
+
+	nested := netlinkAttr{typ: iflaLinkinfo, children: []netlinkAttr{stringAttr(iflaInfoKind, "bridge")}}.encode()
+	if length := binary.NativeEndian.Uint16(nested); length != 4+12 || len(nested) != 16 {
+		t.Errorf("nested attribute length = %d (%d bytes), want 16", length, len(nested))
+	}
+}
+
+func TestNetlinkMessage(t *testing.T) {
+	body := ifInfoMsg(7, iffUp, iffUp)
+	msg := netlinkMessage(rtmNewLink, nlmFRequest|nlmFAck, 1, body, uint32Attr(iflaMTU, 1280))
+	if got := binary.NativeEndian.Uint32(msg); int(got) != len(msg) || len(msg) != 16+16+8 {
+		t.Errorf("message length field %d, size %d, want 40", got, len(msg))
+	}
+	if typ := binary.NativeEndian.Uint16(msg[4:]); typ != rtmNewLink {
+		t.Errorf("message type = %d, want %d", typ, rtmNewLink)
+	}
+	if index := binary.NativeEndian.Uint32(msg[16+4:]); index != 7 {
+		t.Errorf("ifinfomsg index = %d, want 7", index)
+	}
+	if mtu := binary.NativeEndian.Uint32(msg[16+16+4:]); mtu != 1280 {
+		t.Errorf("IFLA_MTU = %d, want 1280", mtu)
+	}
+}
+
+func TestVethRequest(t *testing.T) {
+	var encoded []byte
+	for _, attr := range vethRequest("veth1", "vethc1", 1400) {
+		encoded = append(encoded, attr.encode()...)
+	}
+	// Both ends are named, the peer inside IFLA_INFO_DATA after its ifinfomsg
+	for _, want := range []string{"veth1\x00", "vethc1\x00", "veth\x00"} {
+		if !bytes.Contains(encoded, []byte(want)) {
+			t.Errorf("vethRequest() does not contain %q", want)
+		}
+	}
+	if got := len(vethRequest("veth1", "vethc1", 0)); got != 2 {
+		t.Errorf("vethRequest() without an MTU has %d attributes, want 2", got)
+	}
+}
+
+func TestSysctlPath(t *testing.T) {
+	fakeProc(t, nil, nil)
+	want := filepath.Join(procRoot, "sys", "net", "ipv4", "conf", "eth0", "arp_notify")
+	if got := sysctlPath("net.ipv4.conf.eth0.arp_notify"); got != want {
+		t.Errorf("sysctlPath() = %q, want %q", got, want)
+	}
+}
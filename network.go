@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// tcpdumpBinary is the packet capture tool, a path or a name looked up in PATH
+var tcpdumpBinary = "tcpdump"
+
+// captureOptions holds the options of 'gocker network capture'
+type captureOptions struct {
+	Output      string
+	Interface   string
+	Duration    string
+	RotateSize  string
+	RotateCount string
+	Filter      []string
+}
+
+// networkCommands lists the 'gocker network' subcommands
+func networkCommands() []*command {
+	return []*command{
+		{name: "capture", description: "Capture a container's network traffic to a pcap file", run: networkCaptureCommand},
+		{name: "doctor", description: "Diagnose why containers cannot reach the internet", run: networkDoctorCommand},
+	}
+}
+
+// networkCommand dispatches the 'gocker network' subcommands
+func networkCommand(args []string) {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		printNetworkUsage()
+		if len(args) == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, cmd := range networkCommands() {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	fmt.Printf("Unknown network command: %s\n", args[0])
+	printNetworkUsage()
+	os.Exit(1)
+}
+
+func printNetworkUsage() {
+	fmt.Println("Usage: gocker network <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range networkCommands() {
+		fmt.Printf("  %-8s %s\n", cmd.name, cmd.description)
+	}
+}
+
+func networkCaptureCommand(args []string) {
+	opts := &captureOptions{}
+	flags := newCommandFlags("network capture", "[options] <container-id> [filter]", "Capture a container's network traffic to a pcap file")
+	flags.StringVar(&opts.Output, "output", "o", "file", "pcap file to write, or - for stdout")
+	flags.StringVar(&opts.Interface, "interface", "i", "name", "Container interface to capture on (default: eth0)")
+	flags.StringVar(&opts.Duration, "duration", "", "duration", "Stop capturing after this long (e.g., '30s', '5m')")
+	flags.StringVar(&opts.RotateSize, "rotate-size", "", "size", "Start a new file when the current one reaches this size (e.g., '100M')")
+	flags.StringVar(&opts.RotateCount, "rotate-count", "", "N", "With --rotate-size, keep only the last N files, overwriting the oldest")
+	positional := flags.MustParse(args)
+	if len(positional) == 0 {
+		flags.Fail("container ID required")
+	}
+	if opts.Output == "" {
+		flags.Fail("--output required")
+	}
+	if opts.Output == "-" && isTerminal(os.Stdout) {
+		flags.Fail("refusing to write a capture to a terminal; redirect stdout or use a file")
+	}
+	opts.Filter = positional[1:]
+
+	var duration time.Duration
+	if opts.Duration != "" {
+		d, err := time.ParseDuration(opts.Duration)
+		if err != nil || d <= 0 {
+			flags.Fail(fmt.Sprintf("invalid --duration: %s (expected a duration such as 30s or 5m)", opts.Duration))
+		}
+		duration = d
+	}
+	requireRoot()
+
+	state, err := loadContainerState(positional[0])
+	must(err)
+	if !isActive(state.Status) || !isProcessAlive(state) {
+		must(fmt.Errorf("container %s is not running", shortID(state.ID)))
+	}
+	veth, err := captureInterface(state, opts.Interface)
+	must(err)
+	tcpdumpArgs, err := captureArgs(veth, opts)
+	must(err)
+	os.Exit(runCapture(tcpdumpArgs, duration))
+}
+
+// captureInterface returns the host veth of a container interface; traffic
+// is captured there, outside the container, so the container needs no tools
+func captureInterface(state *ContainerState, name string) (string, error) {
+	if name == "" {
+		name = containerInterfaceName(0)
+	}
+	interfaces := state.Interfaces
+	if len(interfaces) == 0 && state.VethHost != "" {
+		interfaces = []NetworkInterface{primaryInterface(state.VethHost, state.ContainerIP)}
+	}
+	for _, iface := range interfaces {
+		if iface.Name == name {
+			return iface.HostVeth, nil
+		}
+	}
+	return "", fmt.Errorf("container %s has no network interface %s", shortID(state.ID), name)
+}
+
+// captureArgs returns the tcpdump arguments for a capture on the host veth
+// Packets are written as they arrive so a capture cut short is still complete
+func captureArgs(veth string, opts *captureOptions) ([]string, error) {
+	// tcpdump would otherwise drop to a user that may not write the output
+	args := []string{"-i", veth, "-n", "-U", "-Z", "root", "-w", opts.Output}
+	if opts.RotateSize != "" {
+		if opts.Output == "-" {
+			return nil, fmt.Errorf("--rotate-size cannot be used when writing to stdout")
+		}
+		size, err := parseMemoryLimit(opts.RotateSize)
+		if err != nil || size == "max" {
+			return nil, fmt.Errorf("invalid --rotate-size: %s (expected a size such as 100M)", opts.RotateSize)
+		}
+		bytes, _ := strconv.ParseInt(size, 10, 64)
+		// tcpdump counts file sizes in millions of bytes
+		args = append(args, "-C", strconv.FormatInt(max(1, bytes/1000000), 10))
+	}
+	if opts.RotateCount != "" {
+		if opts.RotateSize == "" {
+			return nil, fmt.Errorf("--rotate-count requires --rotate-size")
+		}
+		count, err := strconv.Atoi(opts.RotateCount)
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid --rotate-count: %s (expected a positive number)", opts.RotateCount)
+		}
+		args = append(args, "-W", strconv.Itoa(count))
+	}
+	return append(args, opts.Filter...), nil
+}
+
+// runCapture runs tcpdump until it is interrupted or duration has passed and
+// returns its exit code
+func runCapture(args []string, duration time.Duration) int {
+	path, err := exec.LookPath(tcpdumpBinary)
+	if err != nil {
+		must(fmt.Errorf("network capture requires tcpdump on the host: %v", err))
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+	// Ctrl-C reaches tcpdump too, which then writes out what it has; wait for it
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	must(cmd.Start())
+	if duration > 0 {
+		timer := time.AfterFunc(duration, func() { cmd.Process.Signal(syscall.SIGINT) })
+		defer timer.Stop()
+	}
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitStatus(exitErr.ProcessState)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
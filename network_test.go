@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCaptureArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts captureOptions
+		want []string
+	}{
+		{
+			name: "file",
+			opts: captureOptions{Output: "out.pcap"},
+			want: []string{"-i", "veth1", "-n", "-U", "-Z", "root", "-w", "out.pcap"},
+		},
+		{
+			name: "filter",
+			opts: captureOptions{Output: "-", Filter: []string{"udp", "port", "53"}},
+			want: []string{"-i", "veth1", "-n", "-U", "-Z", "root", "-w", "-", "udp", "port", "53"},
+		},
+		{
+			name: "rotation",
+			opts: captureOptions{Output: "out.pcap", RotateSize: "100M", RotateCount: "5"},
+			want: []string{"-i", "veth1", "-n", "-U", "-Z", "root", "-w", "out.pcap", "-C", "104", "-W", "5"},
+		},
+		{
+			name: "small rotation size",
+			opts: captureOptions{Output: "out.pcap", RotateSize: "10K"},
+			want: []string{"-i", "veth1", "-n", "-U", "-Z", "root", "-w", "out.pcap", "-C", "1"},
+		},
+	}
+	for _, tt := range tests {
+		got, err := captureArgs("veth1", &tt.opts)
+		if err != nil {
+			t.Errorf("%s: captureArgs() error = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: captureArgs() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCaptureArgsInvalid(t *testing.T) {
+	tests := []struct {
+		opts captureOptions
+		want string
+	}{
+		{captureOptions{Output: "-", RotateSize: "100M"}, "stdout"},
+		{captureOptions{Output: "out.pcap", RotateSize: "lots"}, "invalid --rotate-size"},
+		{captureOptions{Output: "out.pcap", RotateCount: "5"}, "requires --rotate-size"},
+		{captureOptions{Output: "out.pcap", RotateSize: "1M", RotateCount: "0"}, "invalid --rotate-count"},
+	}
+	for _, tt := range tests {
+		_, err := captureArgs("veth1", &tt.opts)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("captureArgs(%+v) error = %v, want one containing %q", tt.opts, err, tt.want)
+		}
+	}
+}
+
+func TestCaptureInterface(t *testing.T) {
+	state := &ContainerState{ID: "abc123", Interfaces: []NetworkInterface{{Name: "eth0", HostVeth: "veth-abc"}}}
+	if got, err := captureInterface(state, ""); err != nil || got != "veth-abc" {
+		t.Errorf("captureInterface(default) = %q, %v, want veth-abc", got, err)
+	}
+	if _, err := captureInterface(state, "eth1"); err == nil {
+		t.Error("captureInterface(eth1) succeeded for a container without eth1")
+	}
+
+	legacy := &ContainerState{ID: "abc123", VethHost: "veth-old"}
+	if got, err := captureInterface(legacy, "eth0"); err != nil || got != "veth-old" {
+		t.Errorf("captureInterface(legacy) = %q, %v, want veth-old", got, err)
+	}
+	if _, err := captureInterface(&ContainerState{ID: "abc123"}, ""); err == nil {
+		t.Error("captureInterface() succeeded for a container without networking")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// binfmtDir is where the kernel lists binfmt_misc handlers, which run
+// binaries for other architectures through an emulator such as QEMU
+var binfmtDir = "/proc/sys/fs/binfmt_misc"
+
+// platformArch describes an architecture --platform accepts
+type platformArch struct {
+	Machine elf.Machine
+	Class   elf.Class
+	QEMU    string // qemu-user name, for hints
+}
+
+// platformArchs maps GOARCH-style architecture names to their ELF identity
+var platformArchs = map[string]platformArch{
+	"amd64":   {elf.EM_X86_64, elf.ELFCLASS64, "x86_64"},
+	"386":     {elf.EM_386, elf.ELFCLASS32, "i386"},
+	"arm64":   {elf.EM_AARCH64, elf.ELFCLASS64, "aarch64"},
+	"arm":     {elf.EM_ARM, elf.ELFCLASS32, "arm"},
+	"ppc64le": {elf.EM_PPC64, elf.ELFCLASS64, "ppc64le"},
+	"s390x":   {elf.EM_S390, elf.ELFCLASS64, "s390x"},
+	"riscv64": {elf.EM_RISCV, elf.ELFCLASS64, "riscv64"},
+}
+
+// rootfsProbes are binaries whose architecture stands for the root filesystem's
+var rootfsProbes = []string{"/bin/busybox", "/bin/sh", "/usr/bin/env"}
+
+// parsePlatform parses a --platform value, linux/ARCH[/VARIANT], into ARCH
+// The variant (e.g. v7 of linux/arm/v7) is accepted but not checked
+func parsePlatform(spec string) (string, error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "linux" {
+		return "", fmt.Errorf("invalid --platform: %s (expected linux/ARCH, e.g. linux/arm64)", spec)
+	}
+	if _, ok := platformArchs[parts[1]]; !ok {
+		return "", fmt.Errorf("unsupported --platform architecture: %s (supported: %s)", parts[1], strings.Join(platformNames(), ", "))
+	}
+	return parts[1], nil
+}
+
+// platformNames lists the supported architectures, sorted
+func platformNames() []string {
+	var names []string
+	for name := range platformArchs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runsNatively reports whether the host CPU runs arch without emulation
+func runsNatively(arch string) bool {
+	return arch == runtime.GOARCH || (runtime.GOARCH == "amd64" && arch == "386")
+}
+
+// binfmtHandler is a registered binfmt_misc handler
+type binfmtHandler struct {
+	Name        string
+	Enabled     bool
+	Interpreter string
+	Flags       string
+	Magic       []byte
+}
+
+// loadBinfmtHandlers reads the registered handlers; an error means
+// binfmt_misc is not mounted
+func loadBinfmtHandlers() ([]binfmtHandler, error) {
+	if _, err := os.Stat(filepath.Join(binfmtDir, "status")); err != nil {
+		return nil, fmt.Errorf("binfmt_misc is not mounted at %s (mount it with 'mount -t binfmt_misc binfmt_misc %s')", binfmtDir, binfmtDir)
+	}
+	entries, err := os.ReadDir(binfmtDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list binfmt_misc handlers: %v", err)
+	}
+	var handlers []binfmtHandler
+	for _, entry := range entries {
+		if entry.Name() == "status" || entry.Name() == "register" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(binfmtDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		handlers = append(handlers, parseBinfmtHandler(entry.Name(), string(data)))
+	}
+	return handlers, nil
+}
+
+// parseBinfmtHandler parses a handler file:
+//
+//	enabled
+//	interpreter /usr/bin/qemu-aarch64-static
+//	flags: OCF
+//	offset 0
+//	magic 7f454c460201010000000000000000000200b700
+func parseBinfmtHandler(name, data string) binfmtHandler {
+	handler := binfmtHandler{Name: name}
+	for _, line := range strings.Split(data, "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch key {
+		case "enabled":
+			handler.Enabled = true
+		case "interpreter":
+			handler.Interpreter = value
+		case "flags:":
+			handler.Flags = value
+		case "magic":
+			handler.Magic, _ = hex.DecodeString(value)
+		}
+	}
+	return handler
+}
+
+// handles reports whether a handler runs ELF binaries for arch, judged from
+// the ELF header its magic matches: class, byte order, and machine
+func (h binfmtHandler) handles(arch platformArch) bool {
+	m := h.Magic
+	if len(m) < 20 || string(m[:4]) != elf.ELFMAG || elf.Class(m[4]) != arch.Class {
+		return false
+	}
+	var machine elf.Machine
+	switch elf.Data(m[5]) {
+	case elf.ELFDATA2LSB:
+		machine = elf.Machine(uint16(m[18]) | uint16(m[19])<<8)
+	case elf.ELFDATA2MSB:
+		machine = elf.Machine(uint16(m[18])<<8 | uint16(m[19]))
+	}
+	return machine == arch.Machine
+}
+
+// findBinfmtHandler returns the enabled handler for arch
+func findBinfmtHandler(arch string) (*binfmtHandler, error) {
+	handlers, err := loadBinfmtHandlers()
+	if err != nil {
+		return nil, err
+	}
+	info := platformArchs[arch]
+	var disabled *binfmtHandler
+	for i, handler := range handlers {
+		if !handler.handles(info) {
+			continue
+		}
+		if handler.Enabled {
+			return &handlers[i], nil
+		}
+		disabled = &handlers[i]
+	}
+	if disabled != nil {
+		return nil, fmt.Errorf("binfmt_misc handler %s for linux/%s is disabled (enable it with 'echo 1 > %s')", disabled.Name, arch, filepath.Join(binfmtDir, disabled.Name))
+	}
+	return nil, fmt.Errorf("no binfmt_misc handler runs linux/%s binaries; install qemu-user-static (e.g. 'apt install qemu-user-static binfmt-support') so qemu-%s-static is registered", arch, info.QEMU)
+}
+
+// rootfsPlatform returns the architecture of a root filesystem's binaries,
+// or "" if none of rootfsProbes is there to tell
+func rootfsPlatform(rootfs string) string {
+	for _, probe := range rootfsProbes {
+		path, err := secureJoin(rootfs, probe)
+		if err != nil {
+			continue
+		}
+		file, err := elf.Open(path)
+		if err != nil {
+			continue
+		}
+		machine, class := file.Machine, file.Class
+		file.Close()
+		for name, arch := range platformArchs {
+			if arch.Machine == machine && arch.Class == class {
+				return name
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// preparePlatform checks that a container for --platform linux/arch can run
+// from rootfs, and returns the volumes it needs for emulation
+// A handler registered with the F flag has its interpreter opened by the
+// kernel already; otherwise the interpreter is looked up when each binary
+// runs, inside the container, so it is bind mounted in at the same path
+func preparePlatform(arch, rootfs string) ([]string, error) {
+	if found := rootfsPlatform(rootfs); found != "" && found != arch {
+		return nil, fmt.Errorf("root filesystem %s is linux/%s, not linux/%s", rootfs, found, arch)
+	}
+	if runsNatively(arch) {
+		return nil, nil
+	}
+	handler, err := findBinfmtHandler(arch)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Emulating platform", "platform", "linux/"+arch, "interpreter", handler.Interpreter)
+	if strings.Contains(handler.Flags, "F") {
+		return nil, nil
+	}
+	if _, err := os.Stat(handler.Interpreter); err != nil {
+		return nil, fmt.Errorf("interpreter of binfmt_misc handler %s is missing: %v", handler.Name, err)
+	}
+	return []string{handler.Interpreter + ":" + handler.Interpreter}, nil
+}
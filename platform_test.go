@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// riscvMagic is the ELF header prefix qemu-user-static registers for riscv64
+const riscvMagic = "7f454c460201010000000000000000000200f300"
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"linux/arm64", "arm64", false},
+		{"linux/arm/v7", "arm", false},
+		{"linux/amd64", "amd64", false},
+		{"arm64", "", true},
+		{"windows/amd64", "", true},
+		{"linux/mips", "", true},
+		{"linux/arm/v7/extra", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parsePlatform(tt.spec)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parsePlatform(%q) = %q, %v; want %q, error=%v", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBinfmtHandlerMatching(t *testing.T) {
+	handler := parseBinfmtHandler("qemu-aarch64", "enabled\ninterpreter /usr/bin/qemu-aarch64-static\nflags: OCF\noffset 0\nmagic 7f454c460201010000000000000000000200b700\nmask ffffffffffffff00fffffffffffffffffeffffff\n")
+	if !handler.Enabled || handler.Interpreter != "/usr/bin/qemu-aarch64-static" || handler.Flags != "OCF" {
+		t.Errorf("Unexpected handler: %+v", handler)
+	}
+	if !handler.handles(platformArchs["arm64"]) || handler.handles(platformArchs["arm"]) || handler.handles(platformArchs["amd64"]) {
+		t.Errorf("Expected the handler to match arm64 only")
+	}
+
+	// s390x is big-endian
+	s390x := parseBinfmtHandler("qemu-s390x", "disabled\nmagic 7f454c4602020100000000000000000000020016\n")
+	if s390x.Enabled || !s390x.handles(platformArchs["s390x"]) {
+		t.Errorf("Expected a disabled s390x handler, got %+v", s390x)
+	}
+
+	arm := parseBinfmtHandler("qemu-arm", "enabled\nmagic 7f454c4601010100000000000000000002002800\n")
+	if !arm.handles(platformArchs["arm"]) || arm.handles(platformArchs["arm64"]) {
+		t.Errorf("Expected the handler to match arm only")
+	}
+}
+
+func writeBinfmtHandler(t *testing.T, name, flags, interpreter string, enabled bool) {
+	state := "enabled"
+	if !enabled {
+		state = "disabled"
+	}
+	data := state + "\ninterpreter " + interpreter + "\nflags: " + flags + "\noffset 0\nmagic " + riscvMagic + "\n"
+	if err := os.WriteFile(filepath.Join(binfmtDir, name), []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write handler: %v", err)
+	}
+}
+
+// TestPreparePlatform verifies emulation is found through binfmt_misc, with
+// the interpreter mounted in unless the handler is registered with F
+func TestPreparePlatform(t *testing.T) {
+	if runsNatively("riscv64") {
+		t.Skip("riscv64 runs natively on this host")
+	}
+	saved := binfmtDir
+	t.Cleanup(func() { binfmtDir = saved })
+	binfmtDir = filepath.Join(t.TempDir(), "missing")
+	rootfs := t.TempDir()
+
+	if _, err := preparePlatform("riscv64", rootfs); err == nil || !strings.Contains(err.Error(), "not mounted") {
+		t.Errorf("Expected binfmt_misc not mounted error, got %v", err)
+	}
+
+	binfmtDir = t.TempDir()
+	os.WriteFile(filepath.Join(binfmtDir, "status"), []byte("enabled\n"), 0644)
+	if _, err := preparePlatform("riscv64", rootfs); err == nil || !strings.Contains(err.Error(), "qemu-user-static") {
+		t.Errorf("Expected missing handler error, got %v", err)
+	}
+
+	interpreter := filepath.Join(t.TempDir(), "qemu-riscv64-static")
+	os.WriteFile(interpreter, []byte{}, 0755)
+	writeBinfmtHandler(t, "qemu-riscv64", "OC", interpreter, false)
+	if _, err := preparePlatform("riscv64", rootfs); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("Expected disabled handler error, got %v", err)
+	}
+
+	writeBinfmtHandler(t, "qemu-riscv64", "OC", interpreter, true)
+	volumes, err := preparePlatform("riscv64", rootfs)
+	if err != nil || !reflect.DeepEqual(volumes, []string{interpreter + ":" + interpreter}) {
+		t.Errorf("preparePlatform = %v, %v; want the interpreter mounted", volumes, err)
+	}
+
+	writeBinfmtHandler(t, "qemu-riscv64", "OCF", interpreter, true)
+	if volumes, err := preparePlatform("riscv64", rootfs); err != nil || volumes != nil {
+		t.Errorf("preparePlatform with F = %v, %v; want no volumes", volumes, err)
+	}
+
+	// A root filesystem of another architecture is refused up front
+	exe, err := os.Open("/proc/self/exe")
+	if err != nil {
+		t.Skipf("Cannot read the test binary: %v", err)
+	}
+	defer exe.Close()
+	os.MkdirAll(filepath.Join(rootfs, "bin"), 0755)
+	busybox, _ := os.Create(filepath.Join(rootfs, "bin", "busybox"))
+	io.Copy(busybox, exe)
+	busybox.Close()
+	if got := rootfsPlatform(rootfs); got != runtime.GOARCH {
+		t.Errorf("rootfsPlatform = %q, want %q", got, runtime.GOARCH)
+	}
+	if _, err := preparePlatform("riscv64", rootfs); err == nil || !strings.Contains(err.Error(), "is linux/"+runtime.GOARCH) {
+		t.Errorf("Expected architecture mismatch error, got %v", err)
+	}
+	if volumes, err := preparePlatform(runtime.GOARCH, rootfs); err != nil || volumes != nil {
+		t.Errorf("preparePlatform for the host = %v, %v", volumes, err)
+	}
+}
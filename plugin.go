@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Plugins are separate processes that serve Docker's plugin protocol (JSON
+// over HTTP POST) on a unix socket; gocker talks to them but does not run
+// them, so start them with systemd or similar before installing them
+
+var (
+	pluginsDir       = "/var/lib/gocker/plugins"
+	volumeMountsFile = "/var/lib/gocker/volume-mounts.json"
+)
+
+// pluginSocketDir is where plugin sockets are looked for when install is not
+// given --socket
+const pluginSocketDir = "/run/gocker/plugins"
+
+// volumeDriver is what a plugin implements to provide volumes
+const volumeDriver = "VolumeDriver"
+
+// pluginTimeout bounds a single plugin call; mounting a network filesystem
+// can take a while
+const pluginTimeout = 2 * time.Minute
+
+// pluginNamePattern restricts plugin and volume names to safe file names
+var pluginNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Plugin is an installed plugin
+type Plugin struct {
+	Name        string    `json:"name"`
+	Socket      string    `json:"socket"`
+	Implements  []string  `json:"implements"`
+	Enabled     bool      `json:"enabled"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// PluginVolume is a volume a plugin has mounted for a container
+// Volume specs of the form plugin:volume:/container/path are resolved to the
+// plugin's mountpoint before the container starts
+type PluginVolume struct {
+	Plugin        string `json:"plugin"`
+	Name          string `json:"name"`
+	ContainerPath string `json:"container_path"`
+	Mountpoint    string `json:"mountpoint,omitempty"`
+}
+
+func pluginCommands() []*command {
+	return []*command{
+		{name: "install", description: "Install a plugin listening on a unix socket", run: pluginInstallCommand},
+		{name: "ls", description: "List installed plugins", run: pluginListCommand},
+		{name: "enable", description: "Enable a plugin", run: pluginEnableCommand},
+		{name: "disable", description: "Disable a plugin", run: pluginDisableCommand},
+		{name: "rm", description: "Remove a plugin", run: pluginRemoveCommand},
+	}
+}
+
+// pluginCommand dispatches the 'gocker plugin' subcommands
+func pluginCommand(args []string) {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		printPluginUsage()
+		if len(args) == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, cmd := range pluginCommands() {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	fmt.Printf("Unknown plugin command: %s\n", args[0])
+	printPluginUsage()
+	os.Exit(1)
+}
+
+func printPluginUsage() {
+	fmt.Println("Usage: gocker plugin <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range pluginCommands() {
+		fmt.Printf("  %-8s %s\n", cmd.name, cmd.description)
+	}
+}
+
+func pluginInstallCommand(args []string) {
+	var socket string
+	flags := newCommandFlags("plugin install", "<name> [options]", "Install a plugin listening on a unix socket")
+	flags.interspersed = true
+	flags.StringVar(&socket, "socket", "", "path", "Plugin socket (default: "+pluginSocketDir+"/<name>.sock)")
+	args = flags.MustParse(args)
+	if len(args) != 1 {
+		flags.Fail("plugin name required")
+	}
+	requireRoot()
+
+	plugin, err := installPlugin(args[0], socket)
+	must(err)
+	fmt.Printf("Plugin %s installed (%s)\n", plugin.Name, strings.Join(plugin.Implements, ", "))
+}
+
+func pluginListCommand(args []string) {
+	flags := newCommandFlags("plugin ls", "", "List installed plugins")
+	if len(flags.MustParse(args)) != 0 {
+		flags.Fail("plugin ls does not accept arguments")
+	}
+	requireRoot()
+
+	plugins, err := loadAllPlugins()
+	must(err)
+	if len(plugins) == 0 {
+		fmt.Println("No plugins installed")
+		return
+	}
+	fmt.Printf("%-20s %-8s %-20s %s\n", "NAME", "ENABLED", "IMPLEMENTS", "SOCKET")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, plugin := range plugins {
+		fmt.Printf("%-20s %-8t %-20s %s\n", plugin.Name, plugin.Enabled, strings.Join(plugin.Implements, ","), plugin.Socket)
+	}
+}
+
+func pluginEnableCommand(args []string) {
+	flags := newCommandFlags("plugin enable", "<name>", "Enable a plugin, checking it responds")
+	flags.interspersed = true
+	args = flags.MustParse(args)
+	if len(args) != 1 {
+		flags.Fail("plugin name required")
+	}
+	requireRoot()
+	must(setPluginEnabled(args[0], true))
+	fmt.Printf("Plugin %s enabled\n", args[0])
+}
+
+func pluginDisableCommand(args []string) {
+	flags := newCommandFlags("plugin disable", "<name>", "Disable a plugin; containers using its volumes keep them")
+	flags.interspersed = true
+	args = flags.MustParse(args)
+	if len(args) != 1 {
+		flags.Fail("plugin name required")
+	}
+	requireRoot()
+	must(setPluginEnabled(args[0], false))
+	fmt.Printf("Plugin %s disabled\n", args[0])
+}
+
+func pluginRemoveCommand(args []string) {
+	flags := newCommandFlags("plugin rm", "<name>", "Remove a plugin")
+	flags.interspersed = true
+	args = flags.MustParse(args)
+	if len(args) != 1 {
+		flags.Fail("plugin name required")
+	}
+	requireRoot()
+	must(removePlugin(args[0]))
+	fmt.Printf("Plugin %s removed\n", args[0])
+}
+
+// pluginPath returns the file path for a plugin, validating its name
+func pluginPath(name string) (string, error) {
+	if !pluginNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid plugin name: %s (use letters, digits, '_', '.', '-')", name)
+	}
+	return filepath.Join(pluginsDir, name+".json"), nil
+}
+
+// installPlugin activates the plugin listening on socket and records it
+// Installing a plugin again updates its socket and capabilities
+func installPlugin(name, socket string) (*Plugin, error) {
+	path, err := pluginPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if socket == "" {
+		socket = filepath.Join(pluginSocketDir, name+".sock")
+	}
+	if !filepath.IsAbs(socket) {
+		return nil, fmt.Errorf("plugin socket must be an absolute path: %s", socket)
+	}
+
+	plugin := &Plugin{Name: name, Socket: socket, Enabled: true, InstalledAt: time.Now()}
+	if err := activatePlugin(plugin); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(pluginsDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create plugins directory: %v", err)
+	}
+	return plugin, savePlugin(path, plugin)
+}
+
+// activatePlugin performs the plugin handshake and records what the plugin
+// implements; only volume drivers are supported
+func activatePlugin(plugin *Plugin) error {
+	var resp struct {
+		Implements []string
+	}
+	if err := callPlugin(plugin, "Plugin.Activate", struct{}{}, &resp); err != nil {
+		return err
+	}
+	for _, kind := range resp.Implements {
+		if kind == volumeDriver {
+			plugin.Implements = resp.Implements
+			return nil
+		}
+	}
+	return fmt.Errorf("plugin %s implements %v; gocker supports only %s plugins", plugin.Name, resp.Implements, volumeDriver)
+}
+
+func savePlugin(path string, plugin *Plugin) error {
+	data, err := json.MarshalIndent(plugin, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plugin: %v", err)
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write plugin: %v", err)
+	}
+	return nil
+}
+
+// loadPlugin reads an installed plugin by name
+func loadPlugin(name string) (*Plugin, error) {
+	path, err := pluginPath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("plugin not found: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin: %v", err)
+	}
+	var plugin Plugin
+	if err := json.Unmarshal(data, &plugin); err != nil {
+		return nil, fmt.Errorf("failed to parse plugin %s: %v", name, err)
+	}
+	return &plugin, nil
+}
+
+// loadAllPlugins returns the installed plugins sorted by name
+func loadAllPlugins() ([]*Plugin, error) {
+	files, err := os.ReadDir(pluginsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugins directory: %v", err)
+	}
+	var plugins []*Plugin
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			continue
+		}
+		plugin, err := loadPlugin(name)
+		if err != nil {
+			logger.Warn("Skipping unreadable plugin", "name", name, "error", err)
+			continue
+		}
+		plugins = append(plugins, plugin)
+	}
+	return plugins, nil
+}
+
+// setPluginEnabled enables or disables a plugin; enabling checks it responds
+func setPluginEnabled(name string, enabled bool) error {
+	plugin, err := loadPlugin(name)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		if err := activatePlugin(plugin); err != nil {
+			return err
+		}
+	}
+	plugin.Enabled = enabled
+	path, _ := pluginPath(name)
+	return savePlugin(path, plugin)
+}
+
+// removePlugin uninstalls a plugin unless it has volumes mounted
+func removePlugin(name string) error {
+	path, err := pluginPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := loadPlugin(name); err != nil {
+		return err
+	}
+	mounts, err := loadVolumeMounts()
+	if err != nil {
+		return err
+	}
+	for containerID, volumes := range mounts {
+		for _, volume := range volumes {
+			if volume.Plugin == name {
+				return fmt.Errorf("plugin %s has volume %s mounted for container %s", name, volume.Name, shortID(containerID))
+			}
+		}
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove plugin: %v", err)
+	}
+	return nil
+}
+
+// callPlugin posts req to a plugin method and decodes the reply into resp
+// A non-empty Err in the reply is returned as an error
+func callPlugin(plugin *Plugin, method string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %v", method, err)
+	}
+	client := &http.Client{
+		Timeout: pluginTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", plugin.Socket)
+			},
+		},
+	}
+	// The host is ignored: every request goes to the plugin's socket
+	httpResp, err := client.Post("http://plugin/"+method, "application/vnd.docker.plugins.v1+json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("plugin %s: %s failed: %v", plugin.Name, method, err)
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("plugin %s: %s failed: %v", plugin.Name, method, err)
+	}
+	var reply struct {
+		Err string
+	}
+	json.Unmarshal(data, &reply)
+	if reply.Err != "" {
+		return fmt.Errorf("plugin %s: %s", plugin.Name, reply.Err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("plugin %s: %s failed: %s", plugin.Name, method, httpResp.Status)
+	}
+	if resp != nil {
+		if err := json.Unmarshal(data, resp); err != nil {
+			return fmt.Errorf("plugin %s: invalid %s reply: %v", plugin.Name, method, err)
+		}
+	}
+	return nil
+}
+
+// parsePluginVolume parses a volume spec of the form plugin:volume:/path;
+// ok is false for plain host:container specs
+func parsePluginVolume(spec string) (volume PluginVolume, ok bool, err error) {
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	if len(parts) != 3 {
+		return PluginVolume{}, false, nil
+	}
+	volume = PluginVolume{Plugin: parts[0], Name: parts[1], ContainerPath: parts[2]}
+	if !pluginNamePattern.MatchString(volume.Plugin) || !pluginNamePattern.MatchString(volume.Name) {
+		return PluginVolume{}, true, fmt.Errorf("invalid plugin volume: %s (expected plugin:volume:/container/path)", spec)
+	}
+	if !filepath.IsAbs(volume.ContainerPath) {
+		return PluginVolume{}, true, fmt.Errorf("container path must be absolute: %s", volume.ContainerPath)
+	}
+	return volume, true, nil
+}
+
+// mountPluginVolumes asks plugins to create and mount the plugin volumes in
+// specs and returns the specs with those replaced by host:container binds of
+// the plugins' mountpoints
+// Mounted volumes are recorded so releasePluginVolumes can unmount them; on
+// error, volumes mounted so far are released
+func mountPluginVolumes(state *ContainerState, specs []string) ([]string, error) {
+	containerID := state.ID
+	resolved := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		volume, ok, err := parsePluginVolume(spec)
+		if err != nil {
+			releasePluginVolumes(containerID)
+			return nil, err
+		}
+		if !ok {
+			resolved = append(resolved, spec)
+			continue
+		}
+		if err := mountPluginVolume(state, &volume); err != nil {
+			releasePluginVolumes(containerID)
+			return nil, err
+		}
+		resolved = append(resolved, volume.Mountpoint+":"+volume.ContainerPath)
+	}
+	return resolved, nil
+}
+
+// mountPluginVolume creates and mounts one volume and records the mount
+// The Mount request also carries the container's ContainerInfo, which plugins
+// unaware of it ignore
+func mountPluginVolume(state *ContainerState, volume *PluginVolume) error {
+	containerID := state.ID
+	plugin, err := loadPlugin(volume.Plugin)
+	if err != nil {
+		return err
+	}
+	if !plugin.Enabled {
+		return fmt.Errorf("plugin %s is disabled", plugin.Name)
+	}
+
+	// Create is idempotent for an existing volume
+	create := map[string]interface{}{"Name": volume.Name, "Opts": map[string]string{}}
+	if err := callPlugin(plugin, volumeDriver+".Create", create, nil); err != nil {
+		return err
+	}
+	var resp struct {
+		Mountpoint string
+	}
+	mount := map[string]interface{}{"Name": volume.Name, "ID": containerID, "Container": newContainerInfo(state)}
+	if err := callPlugin(plugin, volumeDriver+".Mount", mount, &resp); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(resp.Mountpoint) {
+		return fmt.Errorf("plugin %s returned an invalid mountpoint for %s: %q", plugin.Name, volume.Name, resp.Mountpoint)
+	}
+	volume.Mountpoint = resp.Mountpoint
+
+	return updateVolumeMounts(func(mounts map[string][]PluginVolume) error {
+		mounts[containerID] = append(mounts[containerID], *volume)
+		return nil
+	})
+}
+
+// releasePluginVolumes unmounts a container's plugin volumes, if it has any
+// A plugin that cannot be reached keeps its entry so a later cleanup retries
+func releasePluginVolumes(containerID string) {
+	err := updateVolumeMounts(func(mounts map[string][]PluginVolume) error {
+		var remaining []PluginVolume
+		for _, volume := range mounts[containerID] {
+			if err := unmountPluginVolume(containerID, volume); err != nil {
+				logger.Warn("Failed to unmount plugin volume", "plugin", volume.Plugin, "volume", volume.Name, "error", err)
+				remaining = append(remaining, volume)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(mounts, containerID)
+		} else {
+			mounts[containerID] = remaining
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Warn("Failed to release plugin volumes", "container", shortID(containerID), "error", err)
+	}
+}
+
+func unmountPluginVolume(containerID string, volume PluginVolume) error {
+	plugin, err := loadPlugin(volume.Plugin)
+	if err != nil {
+		return err
+	}
+	return callPlugin(plugin, volumeDriver+".Unmount", map[string]string{"Name": volume.Name, "ID": containerID}, nil)
+}
+
+// loadVolumeMounts reads the plugin volumes mounted for each container
+func loadVolumeMounts() (map[string][]PluginVolume, error) {
+	mounts := make(map[string][]PluginVolume)
+	data, err := os.ReadFile(volumeMountsFile)
+	if os.IsNotExist(err) {
+		return mounts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read volume mounts: %v", err)
+	}
+	if err := json.Unmarshal(data, &mounts); err != nil {
+		return nil, fmt.Errorf("failed to parse volume mounts: %v", err)
+	}
+	return mounts, nil
+}
+
+// updateVolumeMounts runs fn on the volume mount table under its lock and saves it
+func updateVolumeMounts(fn func(mounts map[string][]PluginVolume) error) error {
+	if err := ensureStateDir(); err != nil {
+		return err
+	}
+	lock, err := lockGlobal("volumes")
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
+	mounts, err := loadVolumeMounts()
+	if err != nil {
+		return err
+	}
+	if err := fn(mounts); err != nil {
+		return err
+	}
+	if len(mounts) == 0 {
+		if err := os.Remove(volumeMountsFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to write volume mounts: %v", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(mounts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal volume mounts: %v", err)
+	}
+	if err := writeFileAtomic(volumeMountsFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write volume mounts: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakePlugin serves the plugin protocol on a unix socket and records calls
+type fakePlugin struct {
+	mu         sync.Mutex
+	calls      []string
+	containers []*ContainerInfo
+	implements []string
+	mountErr   string
+	socket     string
+}
+
+func startFakePlugin(t *testing.T, implements ...string) *fakePlugin {
+	plugin := &fakePlugin{implements: implements, socket: filepath.Join(t.TempDir(), "plugin.sock")}
+	listener, err := net.Listen("unix", plugin.socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on plugin socket: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(plugin.serve)}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return plugin
+}
+
+func (p *fakePlugin) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string
+		ID        string
+		Container *ContainerInfo
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	method := strings.TrimPrefix(r.URL.Path, "/")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, strings.TrimSpace(method+" "+req.Name))
+	if req.Container != nil {
+		p.containers = append(p.containers, req.Container)
+	}
+
+	var resp interface{} = map[string]string{}
+	switch method {
+	case "Plugin.Activate":
+		resp = map[string][]string{"Implements": p.implements}
+	case "VolumeDriver.Mount":
+		if p.mountErr != "" {
+			resp = map[string]string{"Err": p.mountErr}
+		} else {
+			resp = map[string]string{"Mountpoint": "/mnt/plugin/" + req.Name}
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (p *fakePlugin) called() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.calls...)
+}
+
+// TestInstallPlugin verifies only volume drivers can be installed
+func TestInstallPlugin(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	volume := startFakePlugin(t, "VolumeDriver")
+	if _, err := installPlugin("nfs", volume.socket); err != nil {
+		t.Fatalf("installPlugin failed: %v", err)
+	}
+	plugin, err := loadPlugin("nfs")
+	if err != nil {
+		t.Fatalf("loadPlugin failed: %v", err)
+	}
+	if !plugin.Enabled || plugin.Socket != volume.socket || !reflect.DeepEqual(plugin.Implements, []string{"VolumeDriver"}) {
+		t.Errorf("Unexpected plugin: %+v", plugin)
+	}
+
+	network := startFakePlugin(t, "NetworkDriver")
+	if _, err := installPlugin("overlay", network.socket); err == nil {
+		t.Error("Expected error installing a network driver")
+	}
+	if _, err := installPlugin("../nfs", volume.socket); err == nil {
+		t.Error("Expected error for invalid plugin name")
+	}
+
+	plugins, err := loadAllPlugins()
+	if err != nil || len(plugins) != 1 {
+		t.Errorf("Expected 1 installed plugin, got %v (%v)", plugins, err)
+	}
+}
+
+func TestParsePluginVolume(t *testing.T) {
+	tests := []struct {
+		spec    string
+		ok      bool
+		wantErr bool
+	}{
+		{"/srv/data:/data", false, false},
+		{"nfs:shared:/data", true, false},
+		{"nfs:shared:data", true, true},
+		{"nfs:../x:/data", true, true},
+	}
+	for _, tt := range tests {
+		volume, ok, err := parsePluginVolume(tt.spec)
+		if ok != tt.ok || (err != nil) != tt.wantErr {
+			t.Errorf("parsePluginVolume(%q) = %v, %v; want ok=%v, error=%v", tt.spec, ok, err, tt.ok, tt.wantErr)
+		}
+		if ok && err == nil && (volume.Plugin != "nfs" || volume.Name != "shared" || volume.ContainerPath != "/data") {
+			t.Errorf("parsePluginVolume(%q) = %+v", tt.spec, volume)
+		}
+	}
+}
+
+// TestMountPluginVolumes verifies plugin volumes are mounted, resolved to
+// host binds, and unmounted on release
+func TestMountPluginVolumes(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	fake := startFakePlugin(t, "VolumeDriver")
+	if _, err := installPlugin("nfs", fake.socket); err != nil {
+		t.Fatalf("installPlugin failed: %v", err)
+	}
+
+	id := "abcdef0123456789"
+	state := &ContainerState{ID: id, Status: statusCreated, Options: &RunOptions{Annotations: []string{"backup=daily"}}}
+	resolved, err := mountPluginVolumes(state, []string{"/srv/logs:/logs", "nfs:shared:/data"})
+	if err != nil {
+		t.Fatalf("mountPluginVolumes failed: %v", err)
+	}
+	if want := []string{"/srv/logs:/logs", "/mnt/plugin/shared:/data"}; !reflect.DeepEqual(resolved, want) {
+		t.Errorf("resolved = %v, want %v", resolved, want)
+	}
+	// Mount requests describe the container
+	if len(fake.containers) != 1 || fake.containers[0].ID != id || fake.containers[0].Annotations["backup"] != "daily" {
+		t.Errorf("Expected the container's info in the Mount request, got %+v", fake.containers)
+	}
+	mounts, err := loadVolumeMounts()
+	if err != nil || len(mounts[id]) != 1 {
+		t.Fatalf("Expected 1 recorded mount, got %v (%v)", mounts, err)
+	}
+
+	// A plugin with mounted volumes cannot be removed
+	if err := removePlugin("nfs"); err == nil {
+		t.Error("Expected error removing a plugin in use")
+	}
+
+	releasePluginVolumes(id)
+	want := []string{"Plugin.Activate", "VolumeDriver.Create shared", "VolumeDriver.Mount shared", "VolumeDriver.Unmount shared"}
+	if got := fake.called(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Plugin calls = %v, want %v", got, want)
+	}
+	if mounts, _ := loadVolumeMounts(); len(mounts) != 0 {
+		t.Errorf("Expected no recorded mounts after release, got %v", mounts)
+	}
+	if err := removePlugin("nfs"); err != nil {
+		t.Errorf("removePlugin failed: %v", err)
+	}
+}
+
+// TestMountPluginVolumesErrors verifies disabled plugins and mount failures
+// are reported and leave nothing mounted
+func TestMountPluginVolumesErrors(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	fake := startFakePlugin(t, "VolumeDriver")
+	if _, err := installPlugin("nfs", fake.socket); err != nil {
+		t.Fatalf("installPlugin failed: %v", err)
+	}
+	id := "abcdef0123456789"
+	state := &ContainerState{ID: id, Status: statusCreated}
+
+	if _, err := mountPluginVolumes(state, []string{"missing:shared:/data"}); err == nil {
+		t.Error("Expected error for a plugin that is not installed")
+	}
+
+	if err := setPluginEnabled("nfs", false); err != nil {
+		t.Fatalf("setPluginEnabled failed: %v", err)
+	}
+	if _, err := mountPluginVolumes(state, []string{"nfs:shared:/data"}); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("Expected disabled plugin error, got %v", err)
+	}
+	if err := setPluginEnabled("nfs", true); err != nil {
+		t.Fatalf("setPluginEnabled failed: %v", err)
+	}
+
+	// The first volume is unmounted again when the second fails
+	fake.mu.Lock()
+	fake.calls = nil
+	fake.mu.Unlock()
+	if _, err := mountPluginVolumes(state, []string{"nfs:first:/a", "nfs:second:b"}); err == nil {
+		t.Error("Expected error for invalid volume spec")
+	}
+	if got := fake.called(); len(got) == 0 || got[len(got)-1] != "VolumeDriver.Unmount first" {
+		t.Errorf("Expected first volume to be unmounted, got calls %v", got)
+	}
+	fake.mu.Lock()
+	fake.mountErr = "export not found"
+	fake.mu.Unlock()
+	if _, err := mountPluginVolumes(state, []string{"nfs:shared:/data"}); err == nil || !strings.Contains(err.Error(), "export not found") {
+		t.Errorf("Expected plugin error, got %v", err)
+	}
+	if mounts, _ := loadVolumeMounts(); len(mounts) != 0 {
+		t.Errorf("Expected no recorded mounts, got %v", mounts)
+	}
+}
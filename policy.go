@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// policyFile holds the admission policy checked before a container is
+// created (see Config.PolicyFile); a missing file means no policy
+var policyFile = "/etc/gocker/policy.json"
+
+// Policy is the set of rules every new container must satisfy
+// Every rule is optional; unset rules allow anything
+type Policy struct {
+	RequireMemoryLimit   bool     `json:"require_memory_limit,omitempty"`
+	RequireCPULimit      bool     `json:"require_cpu_limit,omitempty"`
+	MaxMemoryLimit       string   `json:"max_memory_limit,omitempty"`
+	MaxCPULimit          string   `json:"max_cpu_limit,omitempty"`
+	RequireUsernsRemap   bool     `json:"require_userns_remap,omitempty"` // deny containers whose root is host root
+	DenyPrivileged       bool     `json:"deny_privileged,omitempty"`
+	AllowedVolumeSources []string `json:"allowed_volume_sources,omitempty"`
+	AllowedRootfs        []string `json:"allowed_rootfs,omitempty"` // approved root filesystems, in place of image registries
+	DenyPublish          bool     `json:"deny_publish,omitempty"`
+	DenyGPUs             bool     `json:"deny_gpus,omitempty"`
+	RequiredLabels       []string `json:"required_labels,omitempty"`
+}
+
+// PolicyViolation is a policy rule a container breaks
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// policyError lists every rule a container breaks, so all of them can be
+// fixed at once
+type policyError struct {
+	File       string
+	Violations []PolicyViolation
+}
+
+func (e *policyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "container denied by policy %s:", e.File)
+	for _, v := range e.Violations {
+		fmt.Fprintf(&b, "\n  %s: %s", v.Rule, v.Message)
+	}
+	return b.String()
+}
+
+// loadPolicy reads the policy file, returning nil if there is none
+// Unknown rules are rejected, so a misspelled rule is not silently ignored
+func loadPolicy() (*Policy, error) {
+	data, err := os.ReadFile(policyFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s: %v", policyFile, err)
+	}
+	var policy Policy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %v", policyFile, err)
+	}
+	return &policy, nil
+}
+
+// checkPolicy evaluates the policy against a container about to be created
+// rootfs is the resolved root filesystem and userns its user namespace
+func checkPolicy(opts *RunOptions, rootfs string, userns *userNamespace) error {
+	policy, err := loadPolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+	violations, err := policy.evaluate(opts, rootfs, userns)
+	if err != nil {
+		return fmt.Errorf("invalid policy file %s: %v", policyFile, err)
+	}
+	if len(violations) > 0 {
+		return &policyError{File: policyFile, Violations: violations}
+	}
+	return nil
+}
+
+// evaluate returns the rules opts breaks; an error means the policy itself is invalid
+func (p *Policy) evaluate(opts *RunOptions, rootfs string, userns *userNamespace) ([]PolicyViolation, error) {
+	var violations []PolicyViolation
+	deny := func(rule, format string, args ...interface{}) {
+		violations = append(violations, PolicyViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	unlimited := func(limit string) bool { return limit == "" || limit == "max" }
+
+	if p.RequireMemoryLimit && unlimited(opts.MemoryLimit) {
+		deny("require_memory_limit", "a memory limit is required (--memory-limit)")
+	}
+	if p.RequireCPULimit && unlimited(opts.CPULimit) {
+		deny("require_cpu_limit", "a CPU limit is required (--cpu-limit)")
+	}
+	if p.MaxMemoryLimit != "" {
+		max, err := parseMemoryLimit(p.MaxMemoryLimit)
+		if err != nil || max == "max" {
+			return nil, fmt.Errorf("invalid max_memory_limit: %s", p.MaxMemoryLimit)
+		}
+		limit, _ := parseMemoryLimit(opts.MemoryLimit)
+		maxBytes, _ := strconv.ParseInt(max, 10, 64)
+		if limitBytes, err := strconv.ParseInt(limit, 10, 64); err != nil || limitBytes > maxBytes {
+			deny("max_memory_limit", "memory limit %s exceeds %s", displayLimit(opts.MemoryLimit), p.MaxMemoryLimit)
+		}
+	}
+	if p.MaxCPULimit != "" {
+		max, err := strconv.ParseFloat(p.MaxCPULimit, 64)
+		if err != nil || max <= 0 {
+			return nil, fmt.Errorf("invalid max_cpu_limit: %s", p.MaxCPULimit)
+		}
+		if cpu, err := strconv.ParseFloat(opts.CPULimit, 64); err != nil || cpu > max {
+			deny("max_cpu_limit", "CPU limit %s exceeds %s", displayLimit(opts.CPULimit), p.MaxCPULimit)
+		}
+	}
+
+	if p.RequireUsernsRemap && os.Geteuid() == 0 && (userns == nil || userns.UIDs.HostID == 0) {
+		deny("require_userns_remap", "containers must not run as host root (--userns-remap)")
+	}
+
+	if p.DenyPrivileged && opts.Privileged {
+		deny("deny_privileged", "privileged containers are not allowed (--privileged)")
+	}
+
+	if len(p.AllowedVolumeSources) > 0 {
+		for _, volume := range opts.Volumes {
+			parts := strings.Split(strings.TrimSpace(volume), ":")
+			if len(parts) != 2 {
+				continue
+			}
+			source, err := filepath.EvalSymlinks(strings.TrimSpace(parts[0]))
+			if err != nil || !pathUnder(source, p.AllowedVolumeSources) {
+				deny("allowed_volume_sources", "volume source %s is not allowed", parts[0])
+			}
+		}
+	}
+	if len(p.AllowedRootfs) > 0 {
+		resolved, err := filepath.EvalSymlinks(rootfs)
+		if err != nil || !pathUnder(resolved, p.AllowedRootfs) {
+			deny("allowed_rootfs", "root filesystem %s is not approved", rootfs)
+		}
+	}
+
+	if p.DenyPublish && len(opts.Publish) > 0 {
+		deny("deny_publish", "publishing ports is not allowed (--publish)")
+	}
+	if p.DenyGPUs && opts.GPUs != "" {
+		deny("deny_gpus", "GPUs are not allowed (--gpus)")
+	}
+	for _, key := range p.RequiredLabels {
+		if _, ok := keyValueMap(opts.Labels)[key]; !ok {
+			deny("required_labels", "label %s is required (--label %s=...)", key, key)
+		}
+	}
+	return violations, nil
+}
+
+// displayLimit shows an unset limit as "max"
+func displayLimit(limit string) string {
+	if limit == "" {
+		return "max"
+	}
+	return limit
+}
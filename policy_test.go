@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func policyRules(violations []PolicyViolation) []string {
+	var rules []string
+	for _, v := range violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	allowed := t.TempDir()
+	other := t.TempDir()
+	policy := &Policy{
+		RequireMemoryLimit:   true,
+		MaxMemoryLimit:       "1G",
+		MaxCPULimit:          "2",
+		AllowedVolumeSources: []string{allowed},
+		AllowedRootfs:        []string{allowed},
+		DenyPublish:          true,
+		DenyGPUs:             true,
+		RequiredLabels:       []string{"team"},
+		DenyPrivileged:       true,
+	}
+
+	tests := []struct {
+		name   string
+		opts   *RunOptions
+		rootfs string
+		want   []string
+	}{
+		{
+			name:   "compliant",
+			opts:   &RunOptions{MemoryLimit: "512M", CPULimit: "1.5", Volumes: []string{allowed + ":/data", "nfs:shared:/nfs"}, Labels: []string{"team=web"}},
+			rootfs: allowed,
+		},
+		{
+			name:   "no limits",
+			opts:   &RunOptions{Labels: []string{"team=web"}},
+			rootfs: allowed,
+			want:   []string{"require_memory_limit", "max_memory_limit", "max_cpu_limit"},
+		},
+		{
+			name:   "over limits",
+			opts:   &RunOptions{MemoryLimit: "2G", CPULimit: "4", Labels: []string{"team=web"}},
+			rootfs: allowed,
+			want:   []string{"max_memory_limit", "max_cpu_limit"},
+		},
+		{
+			name:   "everything else",
+			opts:   &RunOptions{MemoryLimit: "1G", CPULimit: "2", Volumes: []string{other + ":/data"}, Publish: []string{"8080:80"}, GPUs: "all", Privileged: true},
+			rootfs: other,
+			want:   []string{"deny_privileged", "allowed_volume_sources", "allowed_rootfs", "deny_publish", "deny_gpus", "required_labels"},
+		},
+	}
+	for _, tt := range tests {
+		violations, err := policy.evaluate(tt.opts, tt.rootfs, nil)
+		if err != nil {
+			t.Fatalf("%s: evaluate failed: %v", tt.name, err)
+		}
+		if got := policyRules(violations); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: violations = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []*Policy{{MaxMemoryLimit: "lots"}, {MaxMemoryLimit: "max"}, {MaxCPULimit: "0"}} {
+		if _, err := invalid.evaluate(&RunOptions{}, allowed, nil); err == nil {
+			t.Errorf("Expected error for invalid policy %+v", invalid)
+		}
+	}
+}
+
+func TestPolicyRequireUsernsRemap(t *testing.T) {
+	policy := &Policy{RequireUsernsRemap: true}
+	remapped := &userNamespace{UIDs: idMapping{HostID: 100000, Size: usernsRangeSize}}
+
+	if violations, _ := policy.evaluate(&RunOptions{}, "/", remapped); len(violations) != 0 {
+		t.Errorf("Expected a remapped container to be admitted, got %v", violations)
+	}
+	violations, _ := policy.evaluate(&RunOptions{}, "/", nil)
+	if os.Geteuid() == 0 && !reflect.DeepEqual(policyRules(violations), []string{"require_userns_remap"}) {
+		t.Errorf("Expected host root to be denied, got %v", violations)
+	}
+	if os.Geteuid() != 0 && len(violations) != 0 {
+		t.Errorf("Expected rootless containers to be admitted, got %v", violations)
+	}
+}
+
+// TestCheckPolicy verifies the policy file is optional, strict, and reports
+// every violation in one error
+func TestCheckPolicy(t *testing.T) {
+	restoreRuntimeSettings(t)
+	policyFile = filepath.Join(t.TempDir(), "policy.json")
+
+	if err := checkPolicy(&RunOptions{}, "/", nil); err != nil {
+		t.Errorf("Expected no policy to admit everything, got %v", err)
+	}
+
+	os.WriteFile(policyFile, []byte(`{"require_memory_limit": true, "required_labels": ["team"]}`), 0644)
+	err := checkPolicy(&RunOptions{}, "/", nil)
+	var denied *policyError
+	if !errors.As(err, &denied) || len(denied.Violations) != 2 {
+		t.Fatalf("Expected 2 violations, got %v", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "require_memory_limit") || !strings.Contains(msg, "label team is required") {
+		t.Errorf("Error does not list the violations: %s", msg)
+	}
+	if err := checkPolicy(&RunOptions{MemoryLimit: "64M", Labels: []string{"team=web"}}, "/", nil); err != nil {
+		t.Errorf("Expected compliant container to be admitted, got %v", err)
+	}
+
+	os.WriteFile(policyFile, []byte(`{"deny_privileges": true}`), 0644)
+	if err := checkPolicy(&RunOptions{}, "/", nil); err == nil || !strings.Contains(err.Error(), "deny_privileges") {
+		t.Errorf("Expected error for unknown rule, got %v", err)
+	}
+}
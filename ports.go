@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// PortMapping publishes a container port on a host port ('run -p')
+type PortMapping struct {
+	HostPort      int    `json:"host_port"`
+	ContainerPort int    `json:"container_port"`
+	Protocol      string `json:"protocol"` // tcp or udp
+}
+
+// PortReservation is a host port held by a container in the reservation table
+// ContainerIP is set once the port's DNAT rules are installed
+type PortReservation struct {
+	ContainerID string      `json:"container_id"`
+	ContainerIP string      `json:"container_ip,omitempty"`
+	Mapping     PortMapping `json:"mapping"`
+}
+
+func (p PortMapping) String() string {
+	return fmt.Sprintf("%d:%d/%s", p.HostPort, p.ContainerPort, p.Protocol)
+}
+
+// key identifies the host side of a mapping in the reservation table, e.g. "tcp/8080"
+func (p PortMapping) key() string {
+	return p.Protocol + "/" + strconv.Itoa(p.HostPort)
+}
+
+// parsePortMappings parses -p values of the form hostPort:containerPort[/tcp|udp]
+func parsePortMappings(specs []string) ([]PortMapping, error) {
+	var mappings []PortMapping
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		ports, protocol, hasProtocol := strings.Cut(spec, "/")
+		if !hasProtocol {
+			protocol = "tcp"
+		}
+		if protocol != "tcp" && protocol != "udp" {
+			return nil, fmt.Errorf("invalid port mapping: %s (protocol must be tcp or udp)", spec)
+		}
+		hostPart, containerPart, ok := strings.Cut(ports, ":")
+		hostPort, hostErr := strconv.Atoi(hostPart)
+		containerPort, containerErr := strconv.Atoi(containerPart)
+		if !ok || hostErr != nil || containerErr != nil || !validPort(hostPort) || !validPort(containerPort) {
+			return nil, fmt.Errorf("invalid port mapping: %s (expected hostPort:containerPort[/protocol])", spec)
+		}
+
+		mapping := PortMapping{HostPort: hostPort, ContainerPort: containerPort, Protocol: protocol}
+		if seen[mapping.key()] {
+			return nil, fmt.Errorf("host port %s is published more than once", mapping.key())
+		}
+		seen[mapping.key()] = true
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// loadPortTable reads the host port reservation table
+func loadPortTable() (map[string]PortReservation, error) {
+	table := make(map[string]PortReservation)
+	data, err := os.ReadFile(portsFile)
+	if os.IsNotExist(err) {
+		return table, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read port reservations: %v", err)
+	}
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("failed to parse port reservations: %v", err)
+	}
+	return table, nil
+}
+
+// savePortTable writes the host port reservation table atomically
+func savePortTable(table map[string]PortReservation) error {
+	data, err := json.MarshalIndent(table, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal port reservations: %v", err)
+	}
+	if err := writeFileAtomic(portsFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write port reservations: %v", err)
+	}
+	return nil
+}
+
+// updatePortTable runs fn on the reservation table under the ports lock and saves it
+func updatePortTable(fn func(table map[string]PortReservation) error) error {
+	if err := ensureStateDir(); err != nil {
+		return err
+	}
+	lock, err := lockGlobal("ports")
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
+	table, err := loadPortTable()
+	if err != nil {
+		return err
+	}
+	if err := fn(table); err != nil {
+		return err
+	}
+	return savePortTable(table)
+}
+
+// reservePorts claims host ports for a container before it starts
+// A port fails if another live container has published it or a host process
+// is listening on it; reservations left by containers that are gone are taken over
+func reservePorts(containerID string, mappings []PortMapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+	return updatePortTable(func(table map[string]PortReservation) error {
+		for _, mapping := range mappings {
+			if owner, ok := table[mapping.key()]; ok && owner.ContainerID != containerID && portOwnerActive(owner.ContainerID) {
+				return fmt.Errorf("host port %s is already published by container %s", mapping.key(), shortID(owner.ContainerID))
+			}
+			if err := checkHostPort(mapping); err != nil {
+				return err
+			}
+		}
+		for _, mapping := range mappings {
+			table[mapping.key()] = PortReservation{ContainerID: containerID, Mapping: mapping}
+		}
+		return nil
+	})
+}
+
+// portOwnerActive reports whether a reservation's container may still use it:
+// it is being created, running, or paused
+func portOwnerActive(containerID string) bool {
+	state, err := readContainerState(containerID)
+	return err == nil && (state.Status == statusCreated || isActive(state.Status))
+}
+
+// checkHostPort fails if a host process is bound to the port
+// DNAT would silently take the port's traffic away from it
+func checkHostPort(mapping PortMapping) error {
+	addr := ":" + strconv.Itoa(mapping.HostPort)
+	var err error
+	if mapping.Protocol == "udp" {
+		var conn net.PacketConn
+		if conn, err = net.ListenPacket("udp", addr); err == nil {
+			conn.Close()
+		}
+	} else {
+		var listener net.Listener
+		if listener, err = net.Listen("tcp", addr); err == nil {
+			listener.Close()
+		}
+	}
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return fmt.Errorf("host port %s is already in use by a host process", mapping.key())
+	}
+	return nil
+}
+
+// publishPorts installs the DNAT rules for a container's reserved ports
+func publishPorts(containerID, containerIP string) error {
+	return updatePortTable(func(table map[string]PortReservation) error {
+		for _, key := range sortedPortKeys(table) {
+			reservation := table[key]
+			if reservation.ContainerID != containerID {
+				continue
+			}
+			for _, rule := range portRules(reservation.Mapping, containerIP) {
+				args := append([]string{"-t", "nat", "-A"}, rule...)
+				if output, err := hostCommand("iptables", args...).CombinedOutput(); err != nil {
+					return fmt.Errorf("failed to publish port %s: %v: %s", reservation.Mapping, err, strings.TrimSpace(string(output)))
+				}
+			}
+			reservation.ContainerIP = containerIP
+			table[key] = reservation
+		}
+		return nil
+	})
+}
+
+// releasePorts removes a container's DNAT rules and reservations
+func releasePorts(containerID string) {
+	err := updatePortTable(func(table map[string]PortReservation) error {
+		for key, reservation := range table {
+			if reservation.ContainerID != containerID {
+				continue
+			}
+			if reservation.ContainerIP != "" {
+				for _, rule := range portRules(reservation.Mapping, reservation.ContainerIP) {
+					hostCommand("iptables", append([]string{"-t", "nat", "-D"}, rule...)...).Run()
+				}
+			}
+			delete(table, key)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Warn("Failed to release published ports", "container", shortID(containerID), "error", err)
+	}
+}
+
+// portRules returns the nat table rules that forward a host port to a
+// container: PREROUTING for traffic from other hosts and containers, OUTPUT
+// for connections from the host itself to one of its addresses
+func portRules(mapping PortMapping, containerIP string) [][]string {
+	match := []string{"-p", mapping.Protocol, "--dport", strconv.Itoa(mapping.HostPort), "-m", "addrtype", "--dst-type", "LOCAL"}
+	target := []string{"-j", "DNAT", "--to-destination", containerIP + ":" + strconv.Itoa(mapping.ContainerPort)}
+	return [][]string{
+		append(append([]string{"PREROUTING"}, match...), target...),
+		append(append([]string{"OUTPUT"}, match...), target...),
+	}
+}
+
+// sortedPortKeys returns the table's keys in order so rules are installed predictably
+func sortedPortKeys(table map[string]PortReservation) []string {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
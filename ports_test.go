@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePortMappings(t *testing.T) {
+	mappings, err := parsePortMappings([]string{"8080:80", "5353:53/udp"})
+	if err != nil {
+		t.Fatalf("parsePortMappings failed: %v", err)
+	}
+	want := []PortMapping{
+		{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+		{HostPort: 5353, ContainerPort: 53, Protocol: "udp"},
+	}
+	if !reflect.DeepEqual(mappings, want) {
+		t.Errorf("parsePortMappings = %+v, want %+v", mappings, want)
+	}
+
+	invalid := [][]string{
+		{"8080"},
+		{"8080:80/sctp"},
+		{"0:80"},
+		{"8080:70000"},
+		{"http:80"},
+		{"8080:80", "8080:81/tcp"},
+	}
+	for _, specs := range invalid {
+		if _, err := parsePortMappings(specs); err == nil {
+			t.Errorf("parsePortMappings(%q): expected error", specs)
+		}
+	}
+	// The same host port may be published once per protocol
+	if _, err := parsePortMappings([]string{"53:53/tcp", "53:53/udp"}); err != nil {
+		t.Errorf("Expected tcp and udp on one port to be allowed, got %v", err)
+	}
+}
+
+// freePort returns a TCP port that nothing is listening on
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestReservePorts(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	port := freePort(t)
+	mapping := []PortMapping{{HostPort: port, ContainerPort: 80, Protocol: "tcp"}}
+	if err := saveContainerState(&ContainerState{ID: "aaaa0123456789", Status: statusRunning}); err != nil {
+		t.Fatalf("saveContainerState failed: %v", err)
+	}
+	if err := reservePorts("aaaa0123456789", mapping); err != nil {
+		t.Fatalf("reservePorts failed: %v", err)
+	}
+
+	// A running container's port cannot be taken by another
+	err := reservePorts("bbbb0123456789", mapping)
+	if err == nil || !strings.Contains(err.Error(), "aaaa01234567") {
+		t.Errorf("Expected conflict naming aaaa01234567, got %v", err)
+	}
+
+	// Once the owner has exited its stale reservation is taken over
+	if err := updateContainerStatus("aaaa0123456789", statusExited); err != nil {
+		t.Fatalf("updateContainerStatus failed: %v", err)
+	}
+	if err := reservePorts("bbbb0123456789", mapping); err != nil {
+		t.Errorf("Expected stale reservation to be taken over, got %v", err)
+	}
+	table, _ := loadPortTable()
+	if owner := table[mapping[0].key()].ContainerID; owner != "bbbb0123456789" {
+		t.Errorf("Expected bbbb0123456789 to own the port, got %q", owner)
+	}
+
+	releasePorts("bbbb0123456789")
+	if table, _ := loadPortTable(); len(table) != 0 {
+		t.Errorf("Expected no reservations after release, got %+v", table)
+	}
+}
+
+func TestReservePortsHostProcess(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	err = reservePorts("cccc0123456789", []PortMapping{{HostPort: port, ContainerPort: 80, Protocol: "tcp"}})
+	if err == nil || !strings.Contains(err.Error(), "host process") {
+		t.Errorf("Expected host process conflict, got %v", err)
+	}
+	if table, _ := loadPortTable(); len(table) != 0 {
+		t.Errorf("Expected nothing reserved after a conflict, got %+v", table)
+	}
+}
+
+func TestPortRules(t *testing.T) {
+	rules := portRules(PortMapping{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}, "10.0.0.5")
+	want := []string{"PREROUTING", "-p", "tcp", "--dport", "8080", "-m", "addrtype", "--dst-type", "LOCAL", "-j", "DNAT", "--to-destination", "10.0.0.5:80"}
+	if len(rules) != 2 || !reflect.DeepEqual(rules[0], want) || rules[1][0] != "OUTPUT" {
+		t.Errorf("portRules = %v", rules)
+	}
+}
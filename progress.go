@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Long operations (waiting for a container to stop, copying root filesystems,
+// downloading updates) report progress on stderr, so stdout stays the same
+// whatever the mode
+
+// Progress modes, set with the global --progress flag or the progress key
+const (
+	progressAuto  = "auto" // tty on a terminal, else plain; none with --quiet
+	progressPlain = "plain"
+	progressTTY   = "tty"
+	progressJSON  = "json"
+	progressNone  = "none"
+)
+
+// progressMode is the configured progress mode
+var progressMode = progressAuto
+
+// progressOutput is where progress is reported
+var progressOutput io.Writer = os.Stderr
+
+const (
+	progressTick      = 100 * time.Millisecond // redraw interval on a terminal
+	progressPoll      = 500 * time.Millisecond // how often poll functions are called
+	plainInterval     = 5 * time.Second        // between plain progress lines
+	jsonInterval      = time.Second            // between JSON progress events
+	progressBarWidth  = 30
+	progressUnitBytes = "bytes"
+	progressUnitSecs  = "seconds"
+)
+
+// spinnerFrames animate operations of unknown size on a terminal
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// ProgressEvent is one line of --progress json
+type ProgressEvent struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	ID        string    `json:"id,omitempty"`
+	Status    string    `json:"status"` // start, progress, done or error
+	Current   int64     `json:"current"`
+	Total     int64     `json:"total,omitempty"` // 0 when unknown
+	Unit      string    `json:"unit,omitempty"`  // bytes, seconds, or empty for a count
+	Error     string    `json:"error,omitempty"`
+}
+
+// progress reports one operation; it is safe for concurrent use
+type progress struct {
+	mu        sync.Mutex
+	w         io.Writer
+	mode      string
+	operation string
+	id        string
+	message   string
+	total     int64
+	unit      string
+	current   int64
+	poll      func() int64 // samples current, e.g. the size of a copy; nil when set by Add
+	started   time.Time
+	lastPoll  time.Time
+	lastLine  time.Time // last plain line or JSON event
+	frame     int
+	drawn     bool // a terminal line is showing
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// resolveProgressMode turns auto into the mode for this process's stderr
+func resolveProgressMode() string {
+	if progressMode != progressAuto {
+		return progressMode
+	}
+	if logLevel > slog.LevelInfo {
+		return progressNone
+	}
+	if f, ok := progressOutput.(*os.File); ok && isTerminal(f) {
+		return progressTTY
+	}
+	return progressPlain
+}
+
+// newProgress returns a reporter that draws nothing until tick is called
+func newProgress(w io.Writer, mode, operation, id, message string, total int64, unit string) *progress {
+	return &progress{
+		w: w, mode: mode, operation: operation, id: id, message: message,
+		total: total, unit: unit, started: time.Now(),
+	}
+}
+
+// startProgress reports an operation in the configured mode until Done
+// total is 0 when unknown; poll, if not nil, samples the current value
+func startProgress(operation, id, message string, total int64, unit string, poll func() int64) *progress {
+	p := newProgress(progressOutput, resolveProgressMode(), operation, id, message, total, unit)
+	p.poll = poll
+	if p.mode == progressNone {
+		return p
+	}
+	p.emit("start", "")
+	p.stop, p.stopped = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(progressTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case now := <-ticker.C:
+				p.tick(now)
+			}
+		}
+	}()
+	return p
+}
+
+// startCountdown reports waiting up to timeout, counting elapsed seconds
+func startCountdown(operation, id, message string, timeout time.Duration) *progress {
+	started := time.Now()
+	return startProgress(operation, id, message, int64(timeout/time.Second), progressUnitSecs, func() int64 {
+		return int64(time.Since(started) / time.Second)
+	})
+}
+
+// Add counts n more units done
+func (p *progress) Add(n int64) {
+	p.mu.Lock()
+	p.current += n
+	p.mu.Unlock()
+}
+
+// Write counts the bytes written, so a download can be copied through it
+func (p *progress) Write(b []byte) (int, error) {
+	p.Add(int64(len(b)))
+	return len(b), nil
+}
+
+// Done ends the report; err marks the operation as failed
+// Callers print their own result, so a terminal line is cleared, not finished
+func (p *progress) Done(err error) {
+	if p.mode == progressNone {
+		return
+	}
+	if p.stop != nil {
+		close(p.stop)
+		<-p.stopped
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.poll != nil {
+		p.current = p.poll()
+	}
+	switch p.mode {
+	case progressTTY:
+		if p.drawn {
+			fmt.Fprint(p.w, "\r\033[K")
+			p.drawn = false
+		}
+	case progressJSON:
+		if err != nil {
+			p.emitLocked("error", err.Error())
+		} else {
+			p.emitLocked("done", "")
+		}
+	}
+}
+
+// tick samples and redraws, at most as often as the mode allows
+func (p *progress) tick(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.poll != nil && now.Sub(p.lastPoll) >= progressPoll {
+		p.current = p.poll()
+		p.lastPoll = now
+	}
+	switch p.mode {
+	case progressTTY:
+		fmt.Fprintf(p.w, "\r\033[K%s", p.line())
+		p.frame++
+		p.drawn = true
+	case progressPlain:
+		if now.Sub(p.started) >= plainInterval && now.Sub(p.lastLine) >= plainInterval {
+			fmt.Fprintf(p.w, "%s: %s\n", p.message, p.amount())
+			p.lastLine = now
+		}
+	case progressJSON:
+		if now.Sub(p.lastLine) >= jsonInterval {
+			p.emitLocked("progress", "")
+			p.lastLine = now
+		}
+	}
+}
+
+// emit writes a JSON event in json mode
+func (p *progress) emit(status, errMsg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emitLocked(status, errMsg)
+}
+
+// emitLocked is emit for callers holding p.mu
+func (p *progress) emitLocked(status, errMsg string) {
+	if p.mode != progressJSON {
+		return
+	}
+	data, _ := json.Marshal(ProgressEvent{
+		Time: time.Now(), Operation: p.operation, ID: p.id, Status: status,
+		Current: p.current, Total: p.total, Unit: p.unit, Error: errMsg,
+	})
+	fmt.Fprintf(p.w, "%s\n", data)
+	p.lastLine = time.Now()
+}
+
+// line is the terminal line: a bar when the total is known, else a spinner
+func (p *progress) line() string {
+	if p.total <= 0 {
+		return fmt.Sprintf("%s %s %s", p.message, spinnerFrames[p.frame%len(spinnerFrames)], p.amount())
+	}
+	return fmt.Sprintf("%s %s %s", p.message, progressBar(p.current, p.total, progressBarWidth), p.amount())
+}
+
+// amount formats current and total in the operation's unit, e.g. "12M/40M (30%)"
+func (p *progress) amount() string {
+	if p.total <= 0 {
+		return formatProgressValue(p.current, p.unit)
+	}
+	percent := min(p.current*100/p.total, 100)
+	return fmt.Sprintf("%s/%s (%d%%)", formatProgressValue(p.current, p.unit), formatProgressValue(p.total, p.unit), percent)
+}
+
+// progressBar draws current out of total as a bar of width cells
+func progressBar(current, total int64, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = int(min(current, total) * int64(width) / total)
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+	return "[" + bar + "]"
+}
+
+// formatProgressValue formats a value of unit for display
+func formatProgressValue(value int64, unit string) string {
+	switch unit {
+	case progressUnitBytes:
+		return formatMemory(value) + "B"
+	case progressUnitSecs:
+		return strconv.FormatInt(value, 10) + "s"
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+// validateProgressMode checks a --progress value
+func validateProgressMode(mode string) error {
+	switch mode {
+	case progressAuto, progressPlain, progressTTY, progressJSON, progressNone:
+		return nil
+	}
+	return fmt.Errorf("unsupported progress: %s (expected 'auto', 'plain', 'tty', 'json' or 'none')", mode)
+}
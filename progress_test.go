@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressBar(t *testing.T) {
+	tests := []struct {
+		current, total int64
+		want           string
+	}{
+		{0, 100, "[>         ]"},
+		{50, 100, "[=====>    ]"},
+		{100, 100, "[==========]"},
+		{150, 100, "[==========]"},
+	}
+	for _, tt := range tests {
+		if got := progressBar(tt.current, tt.total, 10); got != tt.want {
+			t.Errorf("progressBar(%d, %d) = %q, want %q", tt.current, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestProgressAmount(t *testing.T) {
+	tests := []struct {
+		current, total int64
+		unit           string
+		want           string
+	}{
+		{3 << 20, 12 << 20, progressUnitBytes, "3MB/12MB (25%)"},
+		{512, 0, progressUnitBytes, "512B"},
+		{4, 10, progressUnitSecs, "4s/10s (40%)"},
+		{7, 0, "", "7"},
+	}
+	for _, tt := range tests {
+		p := newProgress(nil, progressPlain, "op", "", "", tt.total, tt.unit)
+		p.current = tt.current
+		if got := p.amount(); got != tt.want {
+			t.Errorf("amount(%d, %d, %q) = %q, want %q", tt.current, tt.total, tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestProgressTTY(t *testing.T) {
+	var out bytes.Buffer
+	p := newProgress(&out, progressTTY, "snapshot create", "abc", "Copying", 100, progressUnitBytes)
+	p.Add(40)
+	p.tick(time.Now())
+	if got := out.String(); !strings.HasPrefix(got, "\r\033[K") || !strings.Contains(got, "Copying [") || !strings.Contains(got, "(40%)") {
+		t.Errorf("tty line = %q", got)
+	}
+
+	// Operations of unknown size spin
+	out.Reset()
+	p.total = 0
+	p.tick(time.Now())
+	p.tick(time.Now())
+	if got := out.String(); !strings.Contains(got, "Copying / 40B") || !strings.Contains(got, "Copying - 40B") {
+		t.Errorf("spinner = %q", got)
+	}
+
+	out.Reset()
+	p.Done(nil)
+	if got := out.String(); got != "\r\033[K" {
+		t.Errorf("Done wrote %q, want the line cleared", got)
+	}
+}
+
+func TestProgressPlain(t *testing.T) {
+	var out bytes.Buffer
+	p := newProgress(&out, progressPlain, "stop", "abc", "Waiting for abc to exit", 10, progressUnitSecs)
+	p.poll = func() int64 { return 6 }
+
+	// Quick operations print nothing
+	p.tick(p.started.Add(time.Second))
+	if out.Len() != 0 {
+		t.Errorf("Expected no output before %s, got %q", plainInterval, out.String())
+	}
+	p.tick(p.started.Add(plainInterval))
+	p.tick(p.started.Add(plainInterval + time.Second))
+	if got, want := out.String(), "Waiting for abc to exit: 6s/10s (60%)\n"; got != want {
+		t.Errorf("plain output = %q, want %q", got, want)
+	}
+	p.Done(nil)
+	if got := strings.Count(out.String(), "\n"); got != 1 {
+		t.Errorf("Expected Done to print nothing, got %q", out.String())
+	}
+}
+
+func TestProgressJSON(t *testing.T) {
+	var out bytes.Buffer
+	savedOutput, savedMode := progressOutput, progressMode
+	t.Cleanup(func() { progressOutput, progressMode = savedOutput, savedMode })
+	progressOutput, progressMode = &out, progressJSON
+
+	p := startProgress("self-update", "v9.9.9", "Downloading", 10, progressUnitBytes, nil)
+	p.Write([]byte("0123456789"))
+	p.Done(nil)
+	failed := startProgress("clone", "abc", "Copying", 0, progressUnitBytes, nil)
+	failed.Done(errors.New("cp failed"))
+
+	var events []ProgressEvent
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event ProgressEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Invalid event %q: %v", line, err)
+		}
+		if event.Status != "progress" {
+			events = append(events, event)
+		}
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected start and end events of two operations, got %+v", events)
+	}
+	if e := events[0]; e.Status != "start" || e.Operation != "self-update" || e.ID != "v9.9.9" || e.Total != 10 || e.Unit != progressUnitBytes {
+		t.Errorf("start event = %+v", e)
+	}
+	if e := events[1]; e.Status != "done" || e.Current != 10 {
+		t.Errorf("done event = %+v", e)
+	}
+	if e := events[3]; e.Status != "error" || e.Error != "cp failed" || e.Operation != "clone" {
+		t.Errorf("error event = %+v", e)
+	}
+}
+
+func TestResolveProgressMode(t *testing.T) {
+	restoreRuntimeSettings(t)
+	savedOutput, savedLevel := progressOutput, logLevel
+	t.Cleanup(func() { progressOutput, logLevel = savedOutput, savedLevel })
+	progressOutput = &bytes.Buffer{}
+
+	progressMode, logLevel = progressAuto, slog.LevelInfo
+	if got := resolveProgressMode(); got != progressPlain {
+		t.Errorf("auto without a terminal = %q, want plain", got)
+	}
+	logLevel = slog.LevelWarn
+	if got := resolveProgressMode(); got != progressNone {
+		t.Errorf("auto with --quiet = %q, want none", got)
+	}
+	progressMode = progressJSON
+	if got := resolveProgressMode(); got != progressJSON {
+		t.Errorf("json with --quiet = %q, want json", got)
+	}
+}
+
+func TestTreeSize(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "etc"), 0755)
+	os.WriteFile(filepath.Join(dir, "etc", "hostname"), []byte("box\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "data"), make([]byte, 1000), 0644)
+	os.Symlink("data", filepath.Join(dir, "link"))
+	if got := treeSize(dir); got != 1004 {
+		t.Errorf("treeSize = %d, want 1004", got)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func updateCommand(args []string) {
+	var protect, unprotect bool
+	flags := newCommandFlags("update", "[options] <container-id>...", "Update the settings of one or more containers")
+	flags.interspersed = true
+	flags.BoolVar(&protect, "protect", "", "Refuse to remove the container with rm and system reset unless --force-protected is given")
+	flags.BoolVar(&unprotect, "unprotect", "", "Allow the container to be removed again")
+	ids := flags.MustParse(args)
+	if len(ids) == 0 {
+		flags.Fail("container ID required")
+	}
+	if protect == unprotect {
+		flags.Fail("one of --protect or --unprotect is required")
+	}
+	requireRoot()
+
+	verb := "protected"
+	if unprotect {
+		verb = "unprotected"
+	}
+	ok := forEachContainer(ids, func(containerID string) error {
+		var fullID string
+		err := updateContainerState(containerID, func(state *ContainerState) error {
+			fullID = state.ID
+			state.Protected = protect
+			return nil
+		})
+		if err == nil {
+			fmt.Printf("Container %s %s\n", shortID(fullID), verb)
+		}
+		return err
+	})
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// checkRemovable refuses to remove a protected container unless forced
+func checkRemovable(state *ContainerState, forceProtected bool) error {
+	if state.Protected && !forceProtected {
+		id := shortID(state.ID)
+		return fmt.Errorf("container %s is protected; use --force-protected to remove it, or 'gocker update --unprotect %s'", id, id)
+	}
+	return nil
+}
+
+// protectedContainerIDs returns the short IDs of all protected containers
+func protectedContainerIDs() []string {
+	var ids []string
+	for _, id := range matchingContainerIDs(func(state *ContainerState) bool { return state.Protected }) {
+		ids = append(ids, shortID(id))
+	}
+	return ids
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestProtectedContainer checks a protected container is only removed when forced
+func TestProtectedContainer(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	for _, state := range []*ContainerState{
+		{ID: "aaa111", Status: statusExited, Protected: true},
+		{ID: "bbb222", Status: statusExited},
+	} {
+		if err := saveContainerState(state); err != nil {
+			t.Fatalf("saveContainerState failed: %v", err)
+		}
+	}
+
+	if got := protectedContainerIDs(); !reflect.DeepEqual(got, []string{"aaa111"}) {
+		t.Errorf("protectedContainerIDs = %v, want [aaa111]", got)
+	}
+	_, err := destroyContainer("aaa111", false)
+	if err == nil || !strings.Contains(err.Error(), "--force-protected") {
+		t.Errorf("Expected removing a protected container to fail, got %v", err)
+	}
+	if _, err := readContainerState("aaa111"); err != nil {
+		t.Errorf("Expected protected container to be kept: %v", err)
+	}
+
+	// Unprotecting makes it removable again
+	err = updateContainerState("aaa111", func(state *ContainerState) error {
+		state.Protected = false
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("updateContainerState failed: %v", err)
+	}
+	if got := protectedContainerIDs(); len(got) != 0 {
+		t.Errorf("Expected no protected containers, got %v", got)
+	}
+	if _, err := destroyContainer("aaa111", false); err != nil {
+		t.Errorf("destroyContainer after unprotecting failed: %v", err)
+	}
+	// Commands waiting on the lock must keep waiting on the same file
+	if _, err := os.Stat(filepath.Join(stateDir, "locks", "aaa111.lock")); err != nil {
+		t.Errorf("Expected the lock file to outlive the container, got %v", err)
+	}
+
+	if err := checkRemovable(&ContainerState{ID: "ccc333", Protected: true}, true); err != nil {
+		t.Errorf("Expected --force-protected to allow removal, got %v", err)
+	}
+}
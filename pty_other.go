@@ -0,0 +1,26 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errNotLinux
+}
+
+// isTerminal reports whether f is a character device, which is as close as
+// gocker gets to a terminal check without termios
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errNotLinux
+}
+
+func copyWindowSize(from, to *os.File) error {
+	return errNotLinux
+}
+
+func notifyResize(c chan<- os.Signal) {}
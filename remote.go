@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
+)
+
+// defaultRemoteBinary is the gocker run on a remote host when the endpoint
+// names no path; ssh looks it up in the remote user's PATH
+const defaultRemoteBinary = "gocker"
+
+// remoteHost is an ssh:// endpoint: commands are run by the gocker binary on
+// that host over ssh, so nothing but sshd has to listen there
+type remoteHost struct {
+	User   string
+	Host   string
+	Port   string
+	Binary string // path of gocker on the remote host
+}
+
+// parseRemoteHost parses an endpoint of the form ssh://[user@]host[:port][/path/to/gocker]
+func parseRemoteHost(endpoint string) (*remoteHost, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %s: %v", endpoint, err)
+	}
+	if u.Scheme != "ssh" {
+		return nil, fmt.Errorf("unsupported endpoint: %s (use '%s' or ssh://[user@]host[:port][/path/to/gocker])", endpoint, localEndpoint)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid endpoint %s: no host", endpoint)
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		return nil, fmt.Errorf("invalid endpoint %s: passwords are not supported, use an ssh key or agent", endpoint)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid endpoint %s: unexpected query or fragment", endpoint)
+	}
+
+	remote := &remoteHost{Host: u.Hostname(), Port: u.Port(), Binary: defaultRemoteBinary}
+	if u.User != nil {
+		remote.User = u.User.Username()
+	}
+	if u.Path != "" && u.Path != "/" {
+		remote.Binary = u.Path
+	}
+	return remote, nil
+}
+
+// activeRemoteHost returns the ssh endpoint commands should run on, or nil to
+// run them here: GOCKER_HOST wins over the current context, but not over an
+// explicit --context
+func activeRemoteHost(contextFlag string) (*remoteHost, error) {
+	endpoint := os.Getenv("GOCKER_HOST")
+	if contextFlag != "" || endpoint == "" {
+		ctx, err := activeContext(contextFlag)
+		if err != nil {
+			return nil, err
+		}
+		endpoint = ctx.Endpoint
+	}
+	if endpoint == "" || endpoint == localEndpoint {
+		return nil, nil
+	}
+	remote, err := parseRemoteHost(endpoint)
+	if err != nil && os.Getenv("GOCKER_HOST") == endpoint {
+		return nil, fmt.Errorf("GOCKER_HOST: %v", err)
+	}
+	return remote, err
+}
+
+// remoteGlobalArgs returns the global flags to pass on to the remote gocker
+// The context is not passed on: it was resolved here and names a local file
+func remoteGlobalArgs(cfg *Config) []string {
+	var args []string
+	for _, flag := range []struct{ name, value string }{
+		{"--data-root", cfg.DataRoot},
+		{"--cgroup-parent", cfg.CgroupParent},
+		{"--cgroup-driver", cfg.CgroupDriver},
+		{"--log-format", cfg.LogFormat},
+		{"--progress", cfg.Progress},
+	} {
+		if flag.value != "" {
+			args = append(args, flag.name, flag.value)
+		}
+	}
+	if cfg.Debug {
+		args = append(args, "--debug")
+	}
+	if cfg.Quiet {
+		args = append(args, "--quiet")
+	}
+	return args
+}
+
+// sshArgs returns the arguments of the ssh command that runs gocker with args
+// on the host, allocating a remote terminal when tty is set
+func (r *remoteHost) sshArgs(args []string, tty bool) []string {
+	sshArgs := []string{"-T"}
+	if tty {
+		sshArgs[0] = "-t"
+	}
+	if r.Port != "" {
+		sshArgs = append(sshArgs, "-p", r.Port)
+	}
+	if r.User != "" {
+		sshArgs = append(sshArgs, "-l", r.User)
+	}
+
+	// ssh joins the command into one line for the remote shell, so each
+	// argument is quoted to arrive unchanged
+	command := []string{shellQuote(r.Binary)}
+	for _, arg := range args {
+		command = append(command, shellQuote(arg))
+	}
+	return append(sshArgs, "--", r.Host, strings.Join(command, " "))
+}
+
+// shellSafePattern matches words a POSIX shell passes through unchanged
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_./:=@%+,-]+$`)
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	if shellSafePattern.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runRemote runs gocker with args on the remote host over ssh, connected to
+// this process's stdio, and returns its exit code
+// ssh itself exits with 255 when it cannot connect and prints why
+func runRemote(remote *remoteHost, args []string) (int, error) {
+	tty := isTerminal(os.Stdin) && isTerminal(os.Stdout)
+	cmd := exec.Command("ssh", remote.sshArgs(args, tty)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	// Ctrl-C reaches ssh too, which ends the remote command; stay around to
+	// report its exit code. Notify rather than Ignore, as ssh would inherit that
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitStatus(exitErr.ProcessState), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to run ssh: %v", err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedulesFile holds the schedules and their run history
+var schedulesFile = "/var/lib/gocker/schedules.json"
+
+// scheduleRunLimit bounds how many runs of each schedule are remembered
+const scheduleRunLimit = 50
+
+// scheduleLabel is the label scheduled containers carry, set to the schedule's name
+const scheduleLabel = "gocker.schedule"
+
+// Schedule runs a container from a template at the times a cron expression
+// matches
+// gocker has no daemon: 'gocker schedule tick', run every minute by a systemd
+// timer or cron, starts the runs that are due
+type Schedule struct {
+	Name      string        `json:"name"`
+	Cron      string        `json:"cron"`
+	Template  string        `json:"template"`
+	Enabled   bool          `json:"enabled"`
+	CreatedAt time.Time     `json:"created_at"`
+	CheckedAt time.Time     `json:"checked_at"`     // runs due up to this time have been started
+	Runs      []ScheduleRun `json:"runs,omitempty"` // oldest first
+}
+
+// ScheduleRun records one run of a schedule: the container it started, or why
+// it did not start one
+type ScheduleRun struct {
+	Time        time.Time `json:"time"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// due reports whether a run of the schedule is due at now
+// Runs missed while no tick ran (the host was down, say) are made up with one run
+func (s *Schedule) due(now time.Time) bool {
+	if !s.Enabled {
+		return false
+	}
+	expr, err := parseCron(s.Cron)
+	if err != nil {
+		return false
+	}
+	next, ok := expr.next(s.CheckedAt)
+	return ok && !next.After(now)
+}
+
+// recordRun appends a run, dropping the oldest beyond scheduleRunLimit
+func (s *Schedule) recordRun(run ScheduleRun) {
+	s.Runs = append(s.Runs, run)
+	if len(s.Runs) > scheduleRunLimit {
+		s.Runs = s.Runs[len(s.Runs)-scheduleRunLimit:]
+	}
+}
+
+// lastRun returns the schedule's latest run, or nil if it has not run
+func (s *Schedule) lastRun() *ScheduleRun {
+	if len(s.Runs) == 0 {
+		return nil
+	}
+	return &s.Runs[len(s.Runs)-1]
+}
+
+// loadSchedules reads the schedules, keyed by name
+func loadSchedules() (map[string]*Schedule, error) {
+	schedules := make(map[string]*Schedule)
+	data, err := os.ReadFile(schedulesFile)
+	if os.IsNotExist(err) {
+		return schedules, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedules: %v", err)
+	}
+	if err := json.Unmarshal(data, &schedules); err != nil {
+		return nil, fmt.Errorf("failed to parse schedules: %v", err)
+	}
+	return schedules, nil
+}
+
+// updateSchedules applies fn to the schedules and saves them, holding the
+// schedules lock throughout
+func updateSchedules(fn func(schedules map[string]*Schedule) error) error {
+	lock, err := lockGlobal("schedules")
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
+	schedules, err := loadSchedules()
+	if err != nil {
+		return err
+	}
+	if err := fn(schedules); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(schedules, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedules: %v", err)
+	}
+	if err := writeFileAtomic(schedulesFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write schedules: %v", err)
+	}
+	return nil
+}
+
+// updateSchedule applies fn to the named schedule
+func updateSchedule(name string, fn func(s *Schedule) error) error {
+	return updateSchedules(func(schedules map[string]*Schedule) error {
+		s, ok := schedules[name]
+		if !ok {
+			return fmt.Errorf("schedule not found: %s", name)
+		}
+		return fn(s)
+	})
+}
+
+// sortedScheduleNames returns the schedules' names in order
+func sortedScheduleNames(schedules map[string]*Schedule) []string {
+	names := make([]string, 0, len(schedules))
+	for name := range schedules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runDueSchedules starts a run of every schedule that is due at now
+// The lock is held while runs start, so overlapping ticks start each run once
+func runDueSchedules(now time.Time) error {
+	return updateSchedules(func(schedules map[string]*Schedule) error {
+		for _, name := range sortedScheduleNames(schedules) {
+			s := schedules[name]
+			if !s.due(now) {
+				continue
+			}
+			s.CheckedAt = now
+			run := startScheduledRun(s, now)
+			if run.Error != "" {
+				logger.Warn("Scheduled run did not start", "schedule", name, "error", run.Error)
+			} else {
+				logger.Info("Scheduled run started", "schedule", name, "id", shortID(run.ContainerID))
+			}
+			s.recordRun(run)
+		}
+		return nil
+	})
+}
+
+// startScheduledRun starts a container from the schedule's template
+// A run is skipped while the previous one's container is still running, so a
+// slow job does not pile up copies of itself
+func startScheduledRun(s *Schedule, now time.Time) ScheduleRun {
+	run := ScheduleRun{Time: now}
+	if last := s.lastRun(); last != nil && last.ContainerID != "" {
+		if state, err := readContainerState(last.ContainerID); err == nil && isActive(state.Status) && isProcessAlive(state) {
+			run.Error = fmt.Sprintf("skipped: the previous run %s is still running", shortID(last.ContainerID))
+			return run
+		}
+	}
+	tmpl, err := loadTemplate(s.Template)
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	if run.ContainerID, err = runScheduledTemplate(s.Name, tmpl.Options); err != nil {
+		run.Error = err.Error()
+	}
+	return run
+}
+
+// runScheduledTemplate starts a detached container with a template's options
+// and returns its ID
+// It runs 'gocker run' in a new process, as a failed run exits the process
+// that makes it
+var runScheduledTemplate = func(name string, opts *RunOptions) (string, error) {
+	config, err := os.CreateTemp("", "gocker-schedule-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to write run config: %v", err)
+	}
+	defer os.Remove(config.Name())
+	err = json.NewEncoder(config).Encode(opts)
+	config.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to write run config: %v", err)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find gocker executable: %v", err)
+	}
+	var args []string
+	if activeContextName != defaultContextName {
+		args = append(args, "--context", activeContextName)
+	}
+	args = append(args, "--data-root", stateDir, "run", "--config", config.Name(), "--detach", "--quiet", "--label", scheduleLabel+"="+name)
+	var stderr bytes.Buffer
+	cmd := exec.Command(executable, args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		message := strings.TrimPrefix(strings.TrimSpace(stderr.String()), "Error: ")
+		if message == "" {
+			message = err.Error()
+		}
+		return "", fmt.Errorf("gocker run failed: %s", message)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// scheduleCommands lists the 'gocker schedule' subcommands
+func scheduleCommands() []*command {
+	return []*command{
+		{name: "create", description: "Run a template on a cron schedule", run: scheduleCreateCommand},
+		{name: "ls", description: "List schedules", run: scheduleListCommand},
+		{name: "enable", description: "Resume a schedule", run: scheduleEnableCommand},
+		{name: "disable", description: "Stop a schedule from starting runs", run: scheduleDisableCommand},
+		{name: "history", description: "Show a schedule's runs", run: scheduleHistoryCommand},
+		{name: "rm", description: "Remove a schedule", run: scheduleRemoveCommand},
+		{name: "tick", description: "Start the runs that are due (run every minute)", run: scheduleTickCommand},
+	}
+}
+
+// scheduleCommand dispatches the 'gocker schedule' subcommands
+func scheduleCommand(args []string) {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		printScheduleUsage()
+		if len(args) == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, cmd := range scheduleCommands() {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	fmt.Printf("Unknown schedule command: %s\n", args[0])
+	printScheduleUsage()
+	os.Exit(1)
+}
+
+func printScheduleUsage() {
+	fmt.Println("Usage: gocker schedule <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range scheduleCommands() {
+		fmt.Printf("  %-8s %s\n", cmd.name, cmd.description)
+	}
+}
+
+func scheduleCreateCommand(args []string) {
+	var cronSpec, template string
+	var disabled bool
+	flags := newCommandFlags("schedule create", "<name> --cron EXPR --template NAME", "Run a container from a template on a cron schedule")
+	flags.interspersed = true
+	flags.StringVar(&cronSpec, "cron", "", "EXPR", "When to run: minute hour day-of-month month day-of-week, or @hourly, @daily, ...")
+	flags.StringVar(&template, "template", "", "NAME", "Template to run a container from")
+	flags.BoolVar(&disabled, "disabled", "", "Create the schedule disabled")
+	names := flags.MustParse(args)
+	if len(names) != 1 {
+		flags.Fail("schedule name required")
+	}
+	if cronSpec == "" || template == "" {
+		flags.Fail("--cron and --template are required")
+	}
+	name := names[0]
+	if !templateNamePattern.MatchString(name) {
+		flags.Fail("invalid schedule name: " + name + " (use letters, digits, '_', '.', '-')")
+	}
+	if _, err := parseCron(cronSpec); err != nil {
+		flags.Fail(err.Error())
+	}
+	requireRoot()
+	_, err := loadTemplate(template)
+	must(err)
+
+	now := time.Now()
+	must(updateSchedules(func(schedules map[string]*Schedule) error {
+		if _, ok := schedules[name]; ok {
+			return fmt.Errorf("schedule %s already exists", name)
+		}
+		schedules[name] = &Schedule{Name: name, Cron: cronSpec, Template: template, Enabled: !disabled, CreatedAt: now, CheckedAt: now}
+		return nil
+	}))
+	fmt.Printf("Schedule %s created\n", name)
+}
+
+func scheduleListCommand(args []string) {
+	var jsonOutput bool
+	flags := newCommandFlags("schedule ls", "[options]", "List schedules")
+	flags.BoolVar(&jsonOutput, "json", "", "Print the schedules as JSON")
+	if len(flags.MustParse(args)) != 0 {
+		flags.Fail("schedule ls does not accept arguments")
+	}
+	requireRoot()
+
+	schedules, err := loadSchedules()
+	must(err)
+	names := sortedScheduleNames(schedules)
+	if jsonOutput {
+		list := []*Schedule{}
+		for _, name := range names {
+			list = append(list, schedules[name])
+		}
+		data, err := json.MarshalIndent(list, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+		return
+	}
+	if len(names) == 0 {
+		fmt.Println("No schedules found")
+		return
+	}
+
+	now := time.Now()
+	fmt.Printf("%-20s %-18s %-20s %-8s %-20s %s\n", "NAME", "CRON", "TEMPLATE", "ENABLED", "LAST RUN", "NEXT RUN")
+	fmt.Println(strings.Repeat("-", 110))
+	for _, name := range names {
+		s := schedules[name]
+		last, next := "-", "-"
+		if run := s.lastRun(); run != nil {
+			last = run.Time.Format("2006-01-02 15:04")
+		}
+		if expr, err := parseCron(s.Cron); err == nil && s.Enabled {
+			from := s.CheckedAt
+			if from.Before(now) {
+				from = now
+			}
+			if t, ok := expr.next(from); ok {
+				next = t.Format("2006-01-02 15:04")
+			}
+		}
+		fmt.Printf("%-20s %-18s %-20s %-8s %-20s %s\n", name, s.Cron, s.Template, strconv.FormatBool(s.Enabled), last, next)
+	}
+}
+
+func scheduleEnableCommand(args []string) {
+	setScheduleEnabled("enable", args, true)
+}
+
+func scheduleDisableCommand(args []string) {
+	setScheduleEnabled("disable", args, false)
+}
+
+// setScheduleEnabled enables or disables a schedule
+// Enabling starts from now, so runs missed while disabled are not made up
+func setScheduleEnabled(verb string, args []string, enabled bool) {
+	flags := newCommandFlags("schedule "+verb, "<name>", strings.ToUpper(verb[:1])+verb[1:]+" a schedule")
+	names := flags.MustParse(args)
+	if len(names) != 1 {
+		flags.Fail("schedule name required")
+	}
+	requireRoot()
+	must(updateSchedule(names[0], func(s *Schedule) error {
+		if enabled && !s.Enabled {
+			s.CheckedAt = time.Now()
+		}
+		s.Enabled = enabled
+		return nil
+	}))
+	fmt.Printf("Schedule %s %sd\n", names[0], verb)
+}
+
+func scheduleRemoveCommand(args []string) {
+	flags := newCommandFlags("schedule rm", "<name>", "Remove a schedule; containers it started are kept")
+	names := flags.MustParse(args)
+	if len(names) != 1 {
+		flags.Fail("schedule name required")
+	}
+	requireRoot()
+	must(updateSchedules(func(schedules map[string]*Schedule) error {
+		if _, ok := schedules[names[0]]; !ok {
+			return fmt.Errorf("schedule not found: %s", names[0])
+		}
+		delete(schedules, names[0])
+		return nil
+	}))
+	fmt.Printf("Schedule %s removed\n", names[0])
+}
+
+// scheduleRunStatus is a run in 'gocker schedule history', with how its
+// container has done since
+type scheduleRunStatus struct {
+	ScheduleRun
+	Status   string `json:"status,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+}
+
+func scheduleHistoryCommand(args []string) {
+	var jsonOutput bool
+	flags := newCommandFlags("schedule history", "[options] <name>", "Show a schedule's runs, newest first")
+	flags.interspersed = true
+	flags.BoolVar(&jsonOutput, "json", "", "Print the runs as JSON")
+	names := flags.MustParse(args)
+	if len(names) != 1 {
+		flags.Fail("schedule name required")
+	}
+	requireRoot()
+
+	schedules, err := loadSchedules()
+	must(err)
+	s, ok := schedules[names[0]]
+	if !ok {
+		must(fmt.Errorf("schedule not found: %s", names[0]))
+	}
+	removed, err := loadHistory()
+	must(err)
+	runs := []scheduleRunStatus{}
+	for i := len(s.Runs) - 1; i >= 0; i-- {
+		runs = append(runs, newScheduleRunStatus(s.Runs[i], removed))
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(runs, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+		return
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs yet")
+		return
+	}
+	fmt.Printf("%-18s %-14s %-10s %-6s %s\n", "TIME", "CONTAINER ID", "STATUS", "EXIT", "ERROR")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, run := range runs {
+		id, status, exitCode := "-", run.Status, "-"
+		if run.ContainerID != "" {
+			id = shortID(run.ContainerID)
+		}
+		if status == "" {
+			status = "-"
+		}
+		if run.ExitCode != nil {
+			exitCode = strconv.Itoa(*run.ExitCode)
+		}
+		fmt.Printf("%-18s %-14s %-10s %-6s %s\n", run.Time.Format("2006-01-02 15:04"), id, status, exitCode, run.Error)
+	}
+}
+
+// newScheduleRunStatus looks up a run's container among the existing and the
+// removed containers
+func newScheduleRunStatus(run ScheduleRun, removed []HistoryEntry) scheduleRunStatus {
+	status := scheduleRunStatus{ScheduleRun: run}
+	if run.ContainerID == "" {
+		return status
+	}
+	if state, err := readContainerState(run.ContainerID); err == nil {
+		status.Status, status.ExitCode = state.Status, state.ExitCode
+		if isActive(state.Status) && !isProcessAlive(state) {
+			status.Status = statusExited
+		}
+		return status
+	}
+	for _, entry := range removed {
+		if entry.ID == run.ContainerID {
+			status.Status, status.ExitCode = entry.Status, entry.ExitCode
+		}
+	}
+	return status
+}
+
+func scheduleTickCommand(args []string) {
+	flags := newCommandFlags("schedule tick", "", "Start the scheduled runs that are due; run it every minute")
+	if len(flags.MustParse(args)) != 0 {
+		flags.Fail("schedule tick does not accept arguments")
+	}
+	requireRoot()
+	must(runDueSchedules(time.Now()))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunDueSchedules verifies due schedules start one run each, missed runs
+// are made up once, and a run is skipped while the previous one is running
+func TestRunDueSchedules(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+	saved := runScheduledTemplate
+	t.Cleanup(func() { runScheduledTemplate = saved })
+	var started []string
+	runScheduledTemplate = func(name string, opts *RunOptions) (string, error) {
+		started = append(started, name)
+		return "", nil
+	}
+	must(saveTemplate(&ContainerTemplate{Name: "backup", Options: &RunOptions{Command: []string{"/bin/true"}}}))
+
+	created := time.Date(2026, 10, 16, 2, 0, 0, 0, time.Local)
+	err := updateSchedules(func(schedules map[string]*Schedule) error {
+		schedules["nightly"] = &Schedule{Name: "nightly", Cron: "0 3 * * *", Template: "backup", Enabled: true, CreatedAt: created, CheckedAt: created}
+		schedules["off"] = &Schedule{Name: "off", Cron: "* * * * *", Template: "backup", CreatedAt: created, CheckedAt: created}
+		schedules["broken"] = &Schedule{Name: "broken", Cron: "*/30 * * * *", Template: "missing", Enabled: true, CreatedAt: created, CheckedAt: created}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("updateSchedules failed: %v", err)
+	}
+
+	if err := runDueSchedules(created.Add(30 * time.Minute)); err != nil {
+		t.Fatalf("runDueSchedules failed: %v", err)
+	}
+	if len(started) != 0 {
+		t.Errorf("Expected no runs before 03:00, started %v", started)
+	}
+
+	// Two days of missed 03:00 runs are made up with one
+	if err := runDueSchedules(created.Add(49 * time.Hour)); err != nil {
+		t.Fatalf("runDueSchedules failed: %v", err)
+	}
+	if err := runDueSchedules(created.Add(49*time.Hour + time.Minute)); err != nil {
+		t.Fatalf("runDueSchedules failed: %v", err)
+	}
+	if strings.Join(started, ",") != "nightly" {
+		t.Errorf("Started %v, want one nightly run", started)
+	}
+
+	schedules, err := loadSchedules()
+	if err != nil {
+		t.Fatalf("loadSchedules failed: %v", err)
+	}
+	if runs := schedules["broken"].Runs; len(runs) != 2 || !strings.Contains(runs[1].Error, "template not found") {
+		t.Errorf("Expected failed runs of the broken schedule, got %+v", runs)
+	}
+	if len(schedules["off"].Runs) != 0 {
+		t.Errorf("Expected a disabled schedule not to run, got %+v", schedules["off"].Runs)
+	}
+
+	// The previous run's container is still running: this process stands in for it
+	id := strings.Repeat("ab", 32)
+	must(saveContainerState(&ContainerState{ID: id, PID: os.Getpid(), Status: statusRunning}))
+	s := &Schedule{Name: "nightly", Template: "backup", Runs: []ScheduleRun{{ContainerID: id}}}
+	if run := startScheduledRun(s, time.Now()); !strings.Contains(run.Error, "still running") {
+		t.Errorf("Expected the run to be skipped, got %+v", run)
+	}
+}
+
+func TestScheduleRecordRun(t *testing.T) {
+	s := &Schedule{}
+	for i := 0; i < scheduleRunLimit+5; i++ {
+		s.recordRun(ScheduleRun{ContainerID: strconv.Itoa(i)})
+	}
+	if len(s.Runs) != scheduleRunLimit || s.Runs[0].ContainerID != "5" || s.lastRun().ContainerID != strconv.Itoa(scheduleRunLimit+4) {
+		t.Errorf("Unexpected runs: first %+v, %d kept", s.Runs[0], len(s.Runs))
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// releasesURL is the GitHub API endpoint for the latest gocker release
+var releasesURL = "https://api.github.com/repos/nguyen-daniel/Gocker/releases/latest"
+
+// releaseChecksums is the release asset listing the SHA-256 of every binary,
+// in sha256sum format
+const releaseChecksums = "SHA256SUMS"
+
+// updateClient downloads releases; binaries can take a while on slow links
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+// release is the part of a GitHub release gocker uses
+type release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+func selfUpdateCommand(args []string) {
+	var check bool
+	flags := newCommandFlags("self-update", "[options]", "Replace gocker with the latest release, verified against its checksums")
+	flags.BoolVar(&check, "check", "", "Only report whether a newer release exists")
+	if len(flags.MustParse(args)) > 0 {
+		flags.Fail("self-update does not accept arguments")
+	}
+
+	latest, err := fetchLatestRelease()
+	must(err)
+	if compareVersions(latest.TagName, version) <= 0 {
+		fmt.Printf("gocker %s is up to date\n", version)
+		return
+	}
+	if check {
+		fmt.Printf("gocker %s is available (current: %s)\n", latest.TagName, version)
+		return
+	}
+
+	executable, err := os.Executable()
+	must(err)
+	executable, err = filepath.EvalSymlinks(executable)
+	must(err)
+	fmt.Printf("Updating gocker %s to %s...\n", version, latest.TagName)
+	must(installRelease(latest, executable))
+	fmt.Printf("Installed gocker %s at %s\n", latest.TagName, executable)
+}
+
+// fetchLatestRelease reads the latest release from releasesURL
+func fetchLatestRelease() (*release, error) {
+	resp, err := updateClient.Get(releasesURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for updates: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to check for updates: %s", resp.Status)
+	}
+	var latest release
+	if err := json.NewDecoder(resp.Body).Decode(&latest); err != nil {
+		return nil, fmt.Errorf("failed to parse release: %v", err)
+	}
+	if latest.TagName == "" {
+		return nil, fmt.Errorf("failed to parse release: no tag name")
+	}
+	return &latest, nil
+}
+
+// releaseBinaryName is the release asset for this host, e.g. gocker-linux-amd64
+func releaseBinaryName() string {
+	return fmt.Sprintf("gocker-%s-%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// installRelease downloads the release binary for this host next to target,
+// checks it against the release checksums, and renames it over target so the
+// swap is atomic; a failed download leaves target untouched
+func installRelease(rel *release, target string) error {
+	assets := make(map[string]string)
+	for _, asset := range rel.Assets {
+		assets[asset.Name] = asset.URL
+	}
+	name := releaseBinaryName()
+	binaryURL, ok := assets[name]
+	if !ok {
+		return fmt.Errorf("release %s has no binary for %s/%s", rel.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	checksumsURL, ok := assets[releaseChecksums]
+	if !ok {
+		return fmt.Errorf("release %s has no %s; refusing to install an unverified binary", rel.TagName, releaseChecksums)
+	}
+	want, err := fetchChecksum(checksumsURL, name)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".gocker-update-")
+	if err != nil {
+		return fmt.Errorf("failed to create update file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	resp, err := updateClient.Get(binaryURL)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", name, resp.Status)
+	}
+	hash := sha256.New()
+	download := startProgress("self-update", rel.TagName, "Downloading "+name, max(resp.ContentLength, 0), progressUnitBytes, nil)
+	_, err = io.Copy(io.MultiWriter(tmp, hash, download), resp.Body)
+	download.Done(err)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", name, err)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, got, want)
+	}
+
+	if err := tmp.Chmod(0755); err != nil {
+		return fmt.Errorf("failed to set update permissions: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write update: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", target, err)
+	}
+	return nil
+}
+
+// fetchChecksum returns the SHA-256 listed for name in a sha256sum file
+func fetchChecksum(url, name string) (string, error) {
+	resp, err := updateClient.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %v", releaseChecksums, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", releaseChecksums, resp.Status)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		// "<hex>  <name>", or "<hex> *<name>" for binary mode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", releaseChecksums, err)
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", releaseChecksums, name)
+}
+
+// compareVersions compares release versions such as v1.2.3, returning -1, 0,
+// or 1; anything after a '-' is ignored, so a build from git describe such as
+// v1.2.3-4-gabcdef counts as v1.2.3, and "dev" is older than any release
+func compareVersions(a, b string) int {
+	pa, okA := parseVersion(a)
+	pb, okB := parseVersion(b)
+	switch {
+	case !okA && !okB:
+		return 0
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseVersion parses vMAJOR.MINOR[.PATCH], with the v optional
+// A bare number is not a version: git describe prints an abbreviated commit
+// in an untagged repository
+func parseVersion(v string) ([3]int, bool) {
+	var parts [3]int
+	v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	fields := strings.Split(v, ".")
+	if len(fields) < 2 || len(fields) > 3 {
+		return parts, false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.10.0", "v1.9.9", 1},
+		{"v1.2", "v1.2.1", -1},
+		{"1.2.3", "v1.2.3", 0},
+		{"v1.2.3", "v1.2.3-4-gabcdef0-dirty", 0},
+		{"v0.1.0", "dev", 1},
+		{"v0.1.0", "6066452-dirty", 1},
+		{"dev", "dev", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// releaseServer serves a release whose SHA256SUMS lists checksum for the binary
+func releaseServer(t *testing.T, binary []byte, checksum string) *release {
+	name := releaseBinaryName()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) { w.Write(binary) })
+	mux.HandleFunc("/SHA256SUMS", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  gocker-other-arch\n%s  %s\n", strings.Repeat("0", 64), checksum, name)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	savedURL := releasesURL
+	releasesURL = server.URL + "/latest"
+	t.Cleanup(func() { releasesURL = savedURL })
+	return &release{TagName: "v9.9.9", Assets: []releaseAsset{
+		{Name: name, URL: server.URL + "/" + name},
+		{Name: releaseChecksums, URL: server.URL + "/SHA256SUMS"},
+	}}
+}
+
+func TestInstallRelease(t *testing.T) {
+	binary := []byte("#!/bin/sh\necho new gocker\n")
+	sum := sha256.Sum256(binary)
+	rel := releaseServer(t, binary, hex.EncodeToString(sum[:]))
+
+	target := filepath.Join(t.TempDir(), "gocker")
+	os.WriteFile(target, []byte("old"), 0755)
+	if err := installRelease(rel, target); err != nil {
+		t.Fatalf("installRelease failed: %v", err)
+	}
+	data, _ := os.ReadFile(target)
+	info, _ := os.Stat(target)
+	if string(data) != string(binary) || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected new executable binary, got %q (%v)", data, info.Mode())
+	}
+	// Nothing is left behind in the target's directory
+	if entries, _ := os.ReadDir(filepath.Dir(target)); len(entries) != 1 {
+		t.Errorf("Expected only the binary, got %d entries", len(entries))
+	}
+}
+
+func TestInstallReleaseChecksumMismatch(t *testing.T) {
+	rel := releaseServer(t, []byte("tampered"), strings.Repeat("a", 64))
+	target := filepath.Join(t.TempDir(), "gocker")
+	os.WriteFile(target, []byte("old"), 0755)
+
+	err := installRelease(rel, target)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Expected checksum mismatch, got %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "old" {
+		t.Errorf("Expected the old binary to be kept, got %q", data)
+	}
+
+	// A release without checksums is not installed at all
+	rel.Assets = rel.Assets[:1]
+	if err := installRelease(rel, target); err == nil || !strings.Contains(err.Error(), "unverified") {
+		t.Errorf("Expected refusal without checksums, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// setupFD is the descriptor the child reads its childConfig from; the
+// runtime log is on runtimeLogFD just below it
+const setupFD = 4
+
+// containerPathEnv is the PATH every container process starts with
+const containerPathEnv = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// containerHostname is the hostname of every container
+const containerHostname = "gocker-container"
+
+// childConfig is the setup message 'gocker run' sends to the container child
+// The parent writes it once the child is in its cgroup and its network
+// interface is configured, so receiving it is also the signal that the
+// parent's part of the setup is done
+type childConfig struct {
+	ContainerID string   `json:"container_id"`
+	Rootfs      string   `json:"rootfs"`
+	Volumes     []string `json:"volumes,omitempty"`
+	Init        bool     `json:"init,omitempty"`
+	MountCgroup bool     `json:"mount_cgroup,omitempty"` // cgroup namespace is rooted at the container's cgroup
+	Privileged  bool     `json:"privileged,omitempty"`   // leave /proc and /sys unprotected
+	Runtime     string   `json:"runtime,omitempty"`      // runtimeWasm runs the command with the runtime at wasmRuntimeMount
+	Env         []string `json:"env"`                    // complete environment of the container command
+}
+
+// sendChildConfig writes the setup message to the child and closes the pipe
+func sendChildConfig(pipe *os.File, cfg *childConfig) error {
+	defer pipe.Close()
+	if err := json.NewEncoder(pipe).Encode(cfg); err != nil {
+		return fmt.Errorf("failed to send setup to container: %v", err)
+	}
+	return nil
+}
+
+// readChildConfig waits for the parent's setup message on the pipe (setupFD
+// in the child); the pipe is closed afterwards so the container command
+// never inherits it
+func readChildConfig(pipe *os.File) (*childConfig, error) {
+	defer pipe.Close()
+
+	var cfg childConfig
+	if err := json.NewDecoder(pipe).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to read container setup from parent: %v", err)
+	}
+	return &cfg, nil
+}
+
+// containerEnv returns the environment of a container process: the defaults
+// every container gets, including configured proxies, then user-supplied
+// values, which replace defaults
+// A user value without "=" copies the variable from the host if it is set;
+// nothing else from the host environment reaches the container
+func containerEnv(containerID, home string, tty bool, user []string) []string {
+	env := []string{
+		containerPathEnv,
+		"HOSTNAME=" + containerHostname,
+		"HOME=" + home,
+		"GOCKER_CONTAINER_ID=" + shortID(containerID),
+	}
+	if tty {
+		env = append(env, "TERM=xterm")
+	}
+	env = append(env, proxyEnv...)
+	for _, value := range user {
+		if !strings.Contains(value, "=") {
+			hostValue, ok := os.LookupEnv(value)
+			if !ok {
+				continue
+			}
+			value += "=" + hostValue
+		}
+		env = setEnv(env, value)
+	}
+	return env
+}
+
+// setEnv sets a KEY=VALUE entry in env, replacing an existing entry for KEY
+// Duplicates must not be left in place: getenv returns the first match
+func setEnv(env []string, entry string) []string {
+	key, _, _ := strings.Cut(entry, "=")
+	for i, existing := range env {
+		if existingKey, _, _ := strings.Cut(existing, "="); existingKey == key {
+			env[i] = entry
+			return env
+		}
+	}
+	return append(env, entry)
+}
+
+// lookupEnv returns the value of key in env
+func lookupEnv(env []string, key string) (string, bool) {
+	for _, entry := range env {
+		if k, v, ok := strings.Cut(entry, "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestChildConfigRoundTrip checks the setup message survives the pipe
+func TestChildConfigRoundTrip(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+
+	sent := &childConfig{
+		ContainerID: "abc123",
+		Rootfs:      "/var/lib/rootfs",
+		Volumes:     []string{"/tmp:/tmp", "/srv/data:/data"},
+		Init:        true,
+	}
+	go sendChildConfig(w, sent)
+
+	got, err := readChildConfig(r)
+	if err != nil {
+		t.Fatalf("readChildConfig failed: %v", err)
+	}
+	if got.ContainerID != sent.ContainerID || got.Rootfs != sent.Rootfs || !got.Init ||
+		strings.Join(got.Volumes, ",") != strings.Join(sent.Volumes, ",") {
+		t.Errorf("readChildConfig = %+v, want %+v", got, sent)
+	}
+}
+
+// TestReadChildConfigParentGone checks the child fails instead of hanging when
+// the parent exits without sending its setup
+func TestReadChildConfigParentGone(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	w.Close()
+
+	if _, err := readChildConfig(r); err == nil {
+		t.Error("Expected error when the pipe closes without a message")
+	}
+}
+
+// TestReadChildConfigBlocks checks the child cannot proceed before the parent
+// has finished its setup and sent the message
+func TestReadChildConfigBlocks(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+
+	received := make(chan *childConfig, 1)
+	go func() {
+		cfg, _ := readChildConfig(r)
+		received <- cfg
+	}()
+
+	select {
+	case <-received:
+		t.Fatal("readChildConfig returned before the parent sent its setup")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sendChildConfig(w, &childConfig{ContainerID: "abc123"})
+	select {
+	case cfg := <-received:
+		if cfg == nil || cfg.ContainerID != "abc123" {
+			t.Errorf("Unexpected setup message: %+v", cfg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("readChildConfig did not return after the setup was sent")
+	}
+}
+
+// TestContainerEnv checks container defaults, user overrides, and that the
+// host environment is not passed through
+func TestContainerEnv(t *testing.T) {
+	t.Setenv("GOCKER_TEST_HOST_VAR", "from-host")
+	t.Setenv("SUDO_USER", "alice")
+
+	env := containerEnv("0123456789abcdef", "/root", true, []string{"FOO=bar", "GOCKER_TEST_HOST_VAR", "GOCKER_TEST_UNSET_VAR", "PATH=/custom/bin", "FOO=baz"})
+	want := []string{
+		"PATH=/custom/bin",
+		"HOSTNAME=gocker-container",
+		"HOME=/root",
+		"GOCKER_CONTAINER_ID=0123456789ab",
+		"TERM=xterm",
+		"FOO=baz",
+		"GOCKER_TEST_HOST_VAR=from-host",
+	}
+	if strings.Join(env, "\n") != strings.Join(want, "\n") {
+		t.Errorf("containerEnv =\n%s\nwant\n%s", strings.Join(env, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, ok := lookupEnv(env, "SUDO_USER"); ok {
+		t.Error("Expected host variables not to leak into the container")
+	}
+	if path, _ := lookupEnv(env, "PATH"); path != "/custom/bin" {
+		t.Errorf("lookupEnv(PATH) = %q, want /custom/bin", path)
+	}
+	if _, ok := lookupEnv(containerEnv("abc", "/", false, nil), "TERM"); ok {
+		t.Error("Expected no TERM without a terminal")
+	}
+}
+
+// TestContainerEnvProxies checks configured proxies are container defaults that -e overrides
+func TestContainerEnvProxies(t *testing.T) {
+	restoreRuntimeSettings(t)
+	configPath := filepath.Join(t.TempDir(), "daemon.json")
+	config := `{"proxies": {"http_proxy": "http://proxy.corp:3128", "no_proxy": "localhost,.corp"}}`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Setenv("GOCKER_CONFIG", configPath)
+	if err := loadConfig(nil); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	env := containerEnv("abc", "/", false, []string{"no_proxy=*"})
+	for key, want := range map[string]string{
+		"HTTP_PROXY": "http://proxy.corp:3128",
+		"http_proxy": "http://proxy.corp:3128",
+		"NO_PROXY":   "localhost,.corp",
+		"no_proxy":   "*",
+	} {
+		if got, _ := lookupEnv(env, key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if _, ok := lookupEnv(env, "HTTPS_PROXY"); ok {
+		t.Error("Expected no HTTPS_PROXY when it is not configured")
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshots live in the container's directory, one directory per snapshot:
+// snapshots/<name>/snapshot.json and a copy of the root filesystem in rootfs/
+
+// snapshotNamePattern restricts snapshot names to safe directory names
+var snapshotNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Snapshot is a copy of a container's root filesystem taken at a point in time
+type Snapshot struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Rootfs    string    `json:"rootfs"` // rootfs directory the snapshot was taken from
+}
+
+// snapshotCommands lists the 'gocker snapshot' subcommands
+func snapshotCommands() []*command {
+	return []*command{
+		{name: "create", description: "Snapshot the --rootfs directory a container runs on", run: snapshotCreateCommand},
+		{name: "ls", description: "List a container's snapshots", run: snapshotListCommand},
+		{name: "restore", description: "Roll a --rootfs directory back to a snapshot, for every container on it", run: snapshotRestoreCommand},
+		{name: "rm", description: "Remove a snapshot", run: snapshotRemoveCommand},
+	}
+}
+
+// snapshotCommand dispatches the 'gocker snapshot' subcommands
+func snapshotCommand(args []string) {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		printSnapshotUsage()
+		if len(args) == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, cmd := range snapshotCommands() {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	fmt.Printf("Unknown snapshot command: %s\n", args[0])
+	printSnapshotUsage()
+	os.Exit(1)
+}
+
+func printSnapshotUsage() {
+	fmt.Println("Usage: gocker snapshot <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range snapshotCommands() {
+		fmt.Printf("  %-8s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Println()
+	fmt.Println("Containers have no writable layer of their own: they write into their --rootfs")
+	fmt.Println("directory, which every container started from that path shares. A snapshot")
+	fmt.Println("copies that whole directory, and a restore rolls back every container on it.")
+}
+
+func snapshotCreateCommand(args []string) {
+	flags := newCommandFlags("snapshot create", "<container-id> <name>", "Snapshot the --rootfs directory a container runs on, which containers started from the same path share")
+	flags.interspersed = true
+	args = flags.MustParse(args)
+	if len(args) != 2 {
+		flags.Fail("container ID and snapshot name required")
+	}
+	requireRoot()
+	must(createSnapshot(args[0], args[1]))
+	fmt.Printf("Snapshot %s created\n", args[1])
+}
+
+func snapshotListCommand(args []string) {
+	flags := newCommandFlags("snapshot ls", "<container-id>", "List a container's snapshots")
+	flags.interspersed = true
+	args = flags.MustParse(args)
+	if len(args) != 1 {
+		flags.Fail("container ID required")
+	}
+	requireRoot()
+
+	snapshots, err := listSnapshots(args[0])
+	must(err)
+	if len(snapshots) == 0 {
+		fmt.Println("No snapshots found")
+		return
+	}
+
+	fmt.Printf("%-20s %-20s %s\n", "NAME", "CREATED", "ROOTFS")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, snapshot := range snapshots {
+		fmt.Printf("%-20s %-20s %s\n", snapshot.Name, snapshot.CreatedAt.Format("2006-01-02 15:04:05"), snapshot.Rootfs)
+	}
+}
+
+func snapshotRestoreCommand(args []string) {
+	var force bool
+	flags := newCommandFlags("snapshot restore", "<container-id> <name>", "Roll a stopped container's --rootfs directory back to a snapshot; the rootfs is shared, so every container on it is rolled back")
+	flags.interspersed = true
+	flags.BoolVar(&force, "force", "f", "Restore even though stopped containers share the root filesystem; they are rolled back too")
+	args = flags.MustParse(args)
+	if len(args) != 2 {
+		flags.Fail("container ID and snapshot name required")
+	}
+	requireRoot()
+	must(restoreSnapshot(args[0], args[1], force))
+	fmt.Printf("Restored snapshot %s\n", args[1])
+}
+
+func snapshotRemoveCommand(args []string) {
+	flags := newCommandFlags("snapshot rm", "<container-id> <name>", "Remove a snapshot")
+	flags.interspersed = true
+	args = flags.MustParse(args)
+	if len(args) != 2 {
+		flags.Fail("container ID and snapshot name required")
+	}
+	requireRoot()
+	must(removeSnapshot(args[0], args[1]))
+	fmt.Printf("Snapshot %s removed\n", args[1])
+}
+
+// snapshotDir returns the directory of a container's snapshot, validating its name
+func snapshotDir(containerID, name string) (string, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid snapshot name: %s (use letters, digits, '_', '.', '-')", name)
+	}
+	return filepath.Join(containerDir(containerID), "snapshots", name), nil
+}
+
+// createSnapshot copies a container's root filesystem into a new snapshot
+// A running container is frozen during the copy so the snapshot is consistent
+func createSnapshot(containerID, name string) error {
+	fullID, err := resolveContainerID(containerID)
+	if err != nil {
+		return err
+	}
+	dir, err := snapshotDir(fullID, name)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockContainer(fullID)
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
+	state, err := readContainerState(fullID)
+	if err != nil {
+		return err
+	}
+	if state.RootfsPath == "" {
+		return fmt.Errorf("container %s has no recorded rootfs", shortID(fullID))
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("snapshot %s already exists", name)
+	}
+
+	if state.Status == statusRunning && isProcessAlive(state) {
+		if err := freezeCgroup(state.CgroupPath, true); err != nil {
+			return fmt.Errorf("failed to freeze container: %v", err)
+		}
+		defer freezeCgroup(state.CgroupPath, false)
+	}
+
+	if err := copyTree(state.RootfsPath, filepath.Join(dir, "rootfs"), "snapshot create", fullID); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	snapshot := &Snapshot{Name: name, CreatedAt: time.Now(), Rootfs: state.RootfsPath}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("failed to marshal snapshot: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "snapshot.json"), data, 0644); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	return nil
+}
+
+// loadSnapshot reads a snapshot's metadata
+func loadSnapshot(fullID, name string) (*Snapshot, error) {
+	dir, err := snapshotDir(fullID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "snapshot.json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("snapshot not found: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %v", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %v", err)
+	}
+	return &snapshot, nil
+}
+
+// listSnapshots returns a container's snapshots, oldest first
+func listSnapshots(containerID string) ([]*Snapshot, error) {
+	fullID, err := resolveContainerID(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(containerDir(fullID), "snapshots"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshots directory: %v", err)
+	}
+
+	var snapshots []*Snapshot
+	for _, entry := range entries {
+		snapshot, err := loadSnapshot(fullID, entry.Name())
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// restoreSnapshot replaces a stopped container's root filesystem with a snapshot
+// The rootfs directory may be shared: restoring refuses while another
+// container is running on it, and unless force, while stopped ones record it
+func restoreSnapshot(containerID, name string, force bool) error {
+	fullID, err := resolveContainerID(containerID)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockContainer(fullID)
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
+	state, err := readContainerState(fullID)
+	if err != nil {
+		return err
+	}
+	if isActive(state.Status) && isProcessAlive(state) {
+		return fmt.Errorf("cannot restore running container %s. Stop it first with 'gocker stop %s'", shortID(fullID), shortID(fullID))
+	}
+	snapshot, err := loadSnapshot(fullID, name)
+	if err != nil {
+		return err
+	}
+	var stopped []string
+	for _, other := range rootfsUsers(snapshot.Rootfs, fullID) {
+		if isActive(other.Status) && isProcessAlive(other) {
+			return fmt.Errorf("rootfs %s is in use by running container %s", snapshot.Rootfs, shortID(other.ID))
+		}
+		stopped = append(stopped, shortID(other.ID))
+	}
+	if len(stopped) > 0 && !force {
+		return fmt.Errorf("rootfs %s is shared with containers %s, which would be rolled back too; use --force to restore anyway", snapshot.Rootfs, strings.Join(stopped, ", "))
+	}
+
+	// Copy next to the rootfs first and swap directories, so a failed copy
+	// leaves the current filesystem untouched
+	dir, _ := snapshotDir(fullID, name)
+	staging := snapshot.Rootfs + ".restore"
+	previous := snapshot.Rootfs + ".previous"
+	os.RemoveAll(staging)
+	os.RemoveAll(previous)
+	if err := copyTree(filepath.Join(dir, "rootfs"), staging, "snapshot restore", fullID); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	if err := os.Rename(snapshot.Rootfs, previous); err != nil {
+		os.RemoveAll(staging)
+		return fmt.Errorf("failed to move current rootfs aside: %v", err)
+	}
+	if err := os.Rename(staging, snapshot.Rootfs); err != nil {
+		os.Rename(previous, snapshot.Rootfs)
+		os.RemoveAll(staging)
+		return fmt.Errorf("failed to replace rootfs: %v", err)
+	}
+	if err := os.RemoveAll(previous); err != nil {
+		logger.Warn("Failed to remove previous rootfs", "path", previous, "error", err)
+	}
+	return nil
+}
+
+// rootfsUsers returns the containers other than exclude whose recorded
+// rootfs is rootfs, whatever their status
+func rootfsUsers(rootfs, exclude string) []*ContainerState {
+	ids, err := listContainerIDs()
+	if err != nil {
+		return nil
+	}
+	var users []*ContainerState
+	for _, id := range ids {
+		if id == exclude {
+			continue
+		}
+		state, err := readContainerState(id)
+		if err == nil && state.RootfsPath != "" && filepath.Clean(state.RootfsPath) == filepath.Clean(rootfs) {
+			users = append(users, state)
+		}
+	}
+	return users
+}
+
+// removeSnapshot deletes a container's snapshot
+func removeSnapshot(containerID, name string) error {
+	fullID, err := resolveContainerID(containerID)
+	if err != nil {
+		return err
+	}
+	if _, err := loadSnapshot(fullID, name); err != nil {
+		return err
+	}
+
+	lock, err := lockContainer(fullID)
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
+	dir, _ := snapshotDir(fullID, name)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove snapshot: %v", err)
+	}
+	return nil
+}
+
+// copyTree copies the contents of src into dst, preserving ownership,
+// permissions and links; copies share blocks where the filesystem supports it
+// Progress is reported as operation on the container id
+func copyTree(src, dst, operation, id string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dst, err)
+	}
+	var total int64
+	if resolveProgressMode() != progressNone {
+		total = treeSize(src)
+	}
+	copying := startProgress(operation, id, "Copying "+src, total, progressUnitBytes, func() int64 { return treeSize(dst) })
+	output, err := hostCommand("cp", "-a", "--reflink=auto", src+"/.", dst).CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("failed to copy %s: %v: %s", src, err, strings.TrimSpace(string(output)))
+	}
+	copying.Done(err)
+	return err
+}
+
+// treeSize returns the size of the regular files under dir; unreadable
+// entries are skipped, as it only feeds progress reports
+func treeSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSnapshotRestore checks a snapshot brings back a stopped container's rootfs
+func TestSnapshotRestore(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	rootfs := filepath.Join(t.TempDir(), "rootfs")
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatalf("Failed to create rootfs: %v", err)
+	}
+	config := filepath.Join(rootfs, "etc", "app.conf")
+	if err := os.WriteFile(config, []byte("v1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := os.Symlink("app.conf", filepath.Join(rootfs, "etc", "link.conf")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := saveContainerState(&ContainerState{ID: "abc123", Status: statusStopped, RootfsPath: rootfs}); err != nil {
+		t.Fatalf("saveContainerState failed: %v", err)
+	}
+
+	if err := createSnapshot("abc", "before-upgrade"); err != nil {
+		t.Fatalf("createSnapshot failed: %v", err)
+	}
+	if err := createSnapshot("abc", "before-upgrade"); err == nil {
+		t.Error("Expected error creating a snapshot with an existing name")
+	}
+
+	// Change the filesystem, then roll it back
+	if err := os.WriteFile(config, []byte("v2\n"), 0644); err != nil {
+		t.Fatalf("Failed to update config: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "new-file"), nil, 0644); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if err := restoreSnapshot("abc", "before-upgrade", false); err != nil {
+		t.Fatalf("restoreSnapshot failed: %v", err)
+	}
+
+	if data, err := os.ReadFile(config); err != nil || string(data) != "v1\n" {
+		t.Errorf("Expected restored config v1, got %q (%v)", data, err)
+	}
+	if target, err := os.Readlink(filepath.Join(rootfs, "etc", "link.conf")); err != nil || target != "app.conf" {
+		t.Errorf("Expected symlink to be preserved, got %q (%v)", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, "new-file")); !os.IsNotExist(err) {
+		t.Error("Expected files added after the snapshot to be gone")
+	}
+	for _, leftover := range []string{rootfs + ".restore", rootfs + ".previous"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be cleaned up", leftover)
+		}
+	}
+
+	snapshots, err := listSnapshots("abc123")
+	if err != nil || len(snapshots) != 1 || snapshots[0].Name != "before-upgrade" {
+		t.Fatalf("Expected one snapshot, got %+v (%v)", snapshots, err)
+	}
+	if err := removeSnapshot("abc123", "before-upgrade"); err != nil {
+		t.Fatalf("removeSnapshot failed: %v", err)
+	}
+	if err := restoreSnapshot("abc123", "before-upgrade", false); err == nil {
+		t.Error("Expected error restoring a removed snapshot")
+	}
+}
+
+// TestSnapshotRestoreSharedRootfs checks a restore does not silently roll
+// back other containers on the same rootfs
+func TestSnapshotRestoreSharedRootfs(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	rootfs := filepath.Join(t.TempDir(), "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		t.Fatalf("Failed to create rootfs: %v", err)
+	}
+	config := filepath.Join(rootfs, "app.conf")
+	if err := os.WriteFile(config, []byte("v1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	for _, id := range []string{"abc123", "def456"} {
+		if err := saveContainerState(&ContainerState{ID: id, Status: statusStopped, RootfsPath: rootfs}); err != nil {
+			t.Fatalf("saveContainerState failed: %v", err)
+		}
+	}
+	if err := createSnapshot("abc123", "before-upgrade"); err != nil {
+		t.Fatalf("createSnapshot failed: %v", err)
+	}
+	if err := os.WriteFile(config, []byte("v2\n"), 0644); err != nil {
+		t.Fatalf("Failed to update config: %v", err)
+	}
+
+	err := restoreSnapshot("abc123", "before-upgrade", false)
+	if err == nil || !strings.Contains(err.Error(), "def456") || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("Expected restore to name the other container and --force, got %v", err)
+	}
+	if data, _ := os.ReadFile(config); string(data) != "v2\n" {
+		t.Errorf("Expected a refused restore to leave the rootfs alone, got %q", data)
+	}
+
+	if err := restoreSnapshot("abc123", "before-upgrade", true); err != nil {
+		t.Fatalf("restoreSnapshot --force failed: %v", err)
+	}
+	if data, _ := os.ReadFile(config); string(data) != "v1\n" {
+		t.Errorf("Expected restored config v1, got %q", data)
+	}
+}
+
+// TestCopyTreeHostCommand checks copies go through the host command hook
+func TestCopyTreeHostCommand(t *testing.T) {
+	host := useFakeHost(t)
+	src, dst := t.TempDir(), filepath.Join(t.TempDir(), "copy")
+	if err := copyTree(src, dst, "clone", "abc123"); err != nil {
+		t.Fatalf("copyTree failed: %v", err)
+	}
+	want := "cp -a --reflink=auto " + src + "/. " + dst
+	if got := host.ran("cp "); len(got) != 1 || got[0] != want {
+		t.Errorf("copyTree ran %v, want [%s]", got, want)
+	}
+
+	host.failures = []string{"cp "}
+	if err := copyTree(src, dst, "clone", "abc123"); err == nil {
+		t.Error("Expected error when cp fails")
+	}
+}
+
+// TestSnapshotNameValidation checks that unsafe snapshot names are rejected
+func TestSnapshotNameValidation(t *testing.T) {
+	for _, name := range []string{"", "../etc", "a/b", ".hidden"} {
+		if _, err := snapshotDir("abc123", name); err == nil {
+			t.Errorf("snapshotDir(%q): expected error", name)
+		}
+	}
+	if _, err := snapshotDir("abc123", "v1.0_pre-upgrade"); err != nil {
+		t.Errorf("snapshotDir: unexpected error for valid name: %v", err)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Container lifecycle states
+//
+//	created -> running -> paused -> running
+//	running/paused -> stopped (gocker stop) or exited (process ended on its own)
+//	stopped/exited -> running (restart)
+const (
+	statusCreated = "created"
+	statusRunning = "running"
+	statusPaused  = "paused"
+	statusStopped = "stopped"
+	statusExited  = "exited"
+)
+
+// statusTransitions lists the states each state may move to
+var statusTransitions = map[string][]string{
+	statusCreated: {statusRunning, statusExited},
+	statusRunning: {statusPaused, statusStopped, statusExited},
+	statusPaused:  {statusRunning, statusStopped, statusExited},
+	statusStopped: {statusRunning},
+	statusExited:  {statusRunning},
+}
+
+// validateTransition returns an error if a container may not move from one state to another
+// Moving to the current state is always allowed so updates are idempotent
+func validateTransition(from, to string) error {
+	if from == to {
+		return nil
+	}
+	for _, allowed := range statusTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid state transition: %s -> %s", from, to)
+}
+
+// isActive reports whether a status claims the container has a live process
+func isActive(status string) bool {
+	return status == statusRunning || status == statusPaused
+}
+
+// processStartTime returns the start time of a process in clock ticks since boot
+// (field 22 of /proc/<pid>/stat), which distinguishes a process from a later one
+// that reuses its PID
+func processStartTime(pid int) (uint64, error) {
+	_, startTime, err := readProcStat(pid)
+	return startTime, err
+}
+
+// readProcStat returns the state (field 3, such as 'R', 'S' or 'Z') and the
+// start time of a process from /proc/<pid>/stat
+func readProcStat(pid int) (byte, uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The command name (field 2) may contain spaces, so parse after its closing paren
+	stat := string(data)
+	end := strings.LastIndex(stat, ")")
+	if end == -1 {
+		return 0, 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(stat[end+1:])
+	// fields[0] is field 3 (state), so starttime (field 22) is fields[19]
+	if len(fields) < 20 || len(fields[0]) != 1 {
+		return 0, 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	return fields[0][0], startTime, err
+}
+
+// bootIDFile holds a random ID the kernel generates at every boot
+var bootIDFile = "/proc/sys/kernel/random/boot_id"
+
+// currentBootID returns the ID of the running kernel boot, or "" if unknown
+func currentBootID() string {
+	data, err := os.ReadFile(bootIDFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// isProcessAlive reports whether the container's process still exists and is
+// the same process that was started, not a recycled PID
+// Start times count from boot, so after a reboot a new process can match both
+// PID and start time; a container started in an earlier boot is never alive
+func isProcessAlive(state *ContainerState) bool {
+	if state.BootID != "" {
+		if current := currentBootID(); current != "" && current != state.BootID {
+			return false
+		}
+	}
+	return processAlive(state.PID, state.StartTime)
+}
+
+// processAlive reports whether pid exists and, if startTime is known, was
+// started at that time
+// A zombie has exited: signal 0 still succeeds until its parent reaps it,
+// as when gocker waits for its own child, so its state is checked too
+func processAlive(pid int, startTime uint64) bool {
+	if pid <= 0 {
+		return false
+	}
+	if err := signalProcess(pid, 0); err != nil {
+		return false
+	}
+	state, current, err := readProcStat(pid)
+	if err != nil {
+		// State written before start times were recorded has only the PID
+		return startTime == 0
+	}
+	if state == 'Z' {
+		return false
+	}
+	return startTime == 0 || current == startTime
+}
+
+// exitPollInterval is how often waitForExit checks a process when pidfds are
+// unavailable (kernels before 5.3)
+const exitPollInterval = 50 * time.Millisecond
+
+// waitForExit waits up to timeout for a process to exit and reports whether it did
+// It returns as soon as the process exits, using a pidfd where the kernel
+// supports one and polling otherwise
+func waitForExit(pid int, startTime uint64, timeout time.Duration) bool {
+	if exited, ok := waitForExitPidfd(pid, startTime, timeout); ok {
+		return exited
+	}
+
+	deadline := time.Now().Add(timeout)
+	for processAlive(pid, startTime) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(exitPollInterval)
+	}
+	return true
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path,
+// so readers never observe a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// Each container keeps everything it owns in containersDir/<id>/, so removing
+// a container is a single RemoveAll
+const (
+	containerStateName = "state.json"
+	containerLogName   = "container.log"
+)
+
+// containerDir returns the directory holding a container's files
+func containerDir(containerID string) string {
+	return filepath.Join(containersDir, containerID)
+}
+
+// containerStateFile returns the path of a container's state file
+func containerStateFile(containerID string) string {
+	return filepath.Join(containerDir(containerID), containerStateName)
+}
+
+// containerLogFile returns the path of a container's output log
+func containerLogFile(containerID string) string {
+	return filepath.Join(containerDir(containerID), containerLogName)
+}
+
+// listContainerIDs returns the full IDs of all containers with a state file
+func listContainerIDs() ([]string, error) {
+	entries, err := os.ReadDir(containersDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read containers directory: %v", err)
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if _, err := os.Stat(containerStateFile(entry.Name())); err == nil {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+// migrateContainerLayout moves containers stored in the old flat layout
+// (containers/<id>.json and logs/<id>.log) into per-container directories
+func migrateContainerLayout() {
+	entries, err := os.ReadDir(containersDir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		if err := migrateContainer(id); err != nil {
+			logger.Warn("Failed to migrate container state", "id", shortID(id), "error", err)
+		}
+	}
+	os.Remove(filepath.Join(stateDir, "logs"))
+}
+
+// migrateContainer moves one container's legacy state and log files into its directory
+func migrateContainer(id string) error {
+	lock, err := lockContainer(id)
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
+	legacyState := filepath.Join(containersDir, id+".json")
+	data, err := os.ReadFile(legacyState)
+	if err != nil {
+		return err
+	}
+	var state ContainerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse container state: %v", err)
+	}
+	if err := os.MkdirAll(containerDir(id), 0755); err != nil {
+		return fmt.Errorf("failed to create container directory: %v", err)
+	}
+
+	if state.LogFile != "" {
+		if err := os.Rename(state.LogFile, containerLogFile(id)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move log file: %v", err)
+		}
+		state.LogFile = containerLogFile(id)
+	}
+	if err := writeContainerState(&state); err != nil {
+		return err
+	}
+	return os.Remove(legacyState)
+}
+
+// errStateUnchanged aborts an updateContainerState transaction without writing
+var errStateUnchanged = errors.New("state unchanged")
+
+// reconcileContainers brings every container's recorded state in line with reality
+// Containers that claim to be running or paused but whose process is gone (or whose
+// PID was recycled) are marked exited and their network and cgroup are released
+func reconcileContainers() {
+	ids, err := listContainerIDs()
+	if err != nil {
+		return
+	}
+
+	for _, id := range ids {
+		// Check without the lock first so healthy containers cost no writes
+		state, err := readContainerState(id)
+		if err != nil || !isActive(state.Status) || isProcessAlive(state) {
+			continue
+		}
+
+		err = updateContainerState(id, func(state *ContainerState) error {
+			if !isActive(state.Status) || isProcessAlive(state) {
+				return errStateUnchanged
+			}
+			state.Status = statusExited
+			return nil
+		})
+		if err == nil {
+			// Release resources after the die event so it can still inspect the cgroup
+			recordUsage(state)
+			cleanupContainerNetwork(state.ID, state.VethHost)
+			cleanupContainerCgroup(state.CgroupPath)
+			releaseContainerMounts(state.ID)
+			if state.Options != nil && state.Options.Ephemeral {
+				discardContainer(id)
+			}
+		} else if err != errStateUnchanged {
+			logger.Warn("Failed to reconcile container", "id", id, "error", err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestValidateTransition checks the container state machine
+func TestValidateTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		valid    bool
+	}{
+		{statusCreated, statusRunning, true},
+		{statusRunning, statusPaused, true},
+		{statusPaused, statusRunning, true},
+		{statusRunning, statusStopped, true},
+		{statusPaused, statusExited, true},
+		{statusStopped, statusRunning, true},
+		{statusExited, statusExited, true},
+		{statusCreated, statusPaused, false},
+		{statusStopped, statusPaused, false},
+		{statusStopped, statusExited, false},
+		{statusExited, statusStopped, false},
+	}
+
+	for _, tt := range tests {
+		err := validateTransition(tt.from, tt.to)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateTransition(%s, %s) error = %v, want valid %v", tt.from, tt.to, err, tt.valid)
+		}
+	}
+}
+
+// TestIsProcessAlive checks that liveness detects exited processes and recycled PIDs
+func TestIsProcessAlive(t *testing.T) {
+	startTime, err := processStartTime(os.Getpid())
+	if err != nil {
+		t.Fatalf("processStartTime failed: %v", err)
+	}
+
+	if !isProcessAlive(&ContainerState{PID: os.Getpid(), StartTime: startTime}) {
+		t.Error("Expected current process to be alive")
+	}
+	if !isProcessAlive(&ContainerState{PID: os.Getpid()}) {
+		t.Error("Expected state without a start time to fall back to the PID check")
+	}
+	if isProcessAlive(&ContainerState{PID: os.Getpid(), StartTime: startTime + 1}) {
+		t.Error("Expected a start time mismatch to be treated as a recycled PID")
+	}
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run 'true': %v", err)
+	}
+	if isProcessAlive(&ContainerState{PID: cmd.Process.Pid}) {
+		t.Error("Expected exited process to be dead")
+	}
+}
+
+// TestWaitForExit checks that exits are detected promptly and timeouts are honored
+func TestWaitForExit(t *testing.T) {
+	cmd := exec.Command("sleep", "0.1")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot run 'sleep': %v", err)
+	}
+	startTime, _ := processStartTime(cmd.Process.Pid)
+
+	begin := time.Now()
+	if !waitForExit(cmd.Process.Pid, startTime, 10*time.Second) {
+		t.Error("Expected process to exit before the timeout")
+	}
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("Expected exit to be detected promptly, took %s", elapsed)
+	}
+	cmd.Wait()
+
+	// A reaped process has exited even though nothing waits on a pidfd
+	if !waitForExit(cmd.Process.Pid, startTime, time.Second) {
+		t.Error("Expected reaped process to count as exited")
+	}
+
+	cmd = exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("cannot run 'sleep': %v", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	if waitForExit(cmd.Process.Pid, 0, 100*time.Millisecond) {
+		t.Error("Expected timeout while the process is still running")
+	}
+}
+
+// TestProcessAliveZombie checks an exited child that has not been reaped yet
+// counts as exited, so waiting on it does not run into the timeout
+func TestProcessAliveZombie(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot run 'true': %v", err)
+	}
+	defer cmd.Wait()
+
+	// Until it is reaped, the exited child stays a zombie
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if state, _, err := readProcStat(cmd.Process.Pid); err == nil && state == 'Z' {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("child did not become a zombie")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if processAlive(cmd.Process.Pid, 0) {
+		t.Error("Expected an unreaped zombie to count as exited")
+	}
+}
+
+// TestReconcileContainers checks that dead running containers are marked exited
+func TestReconcileContainers(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run 'true': %v", err)
+	}
+	startTime, _ := processStartTime(os.Getpid())
+
+	// A live process recorded in another boot must belong to a container that
+	// died in the reboot
+	savedBootIDFile := bootIDFile
+	t.Cleanup(func() { bootIDFile = savedBootIDFile })
+	bootIDFile = filepath.Join(t.TempDir(), "boot_id")
+	if err := os.WriteFile(bootIDFile, []byte("current-boot\n"), 0644); err != nil {
+		t.Fatalf("Failed to write boot ID: %v", err)
+	}
+
+	states := []*ContainerState{
+		{ID: "dead", PID: cmd.Process.Pid, Status: statusRunning},
+		{ID: "alive", PID: os.Getpid(), StartTime: startTime, BootID: "current-boot", Status: statusRunning},
+		{ID: "rebooted", PID: os.Getpid(), StartTime: startTime, BootID: "earlier-boot", Status: statusRunning},
+		{ID: "stopped", PID: cmd.Process.Pid, Status: statusStopped},
+	}
+	for _, state := range states {
+		if err := saveContainerState(state); err != nil {
+			t.Fatalf("saveContainerState failed: %v", err)
+		}
+	}
+
+	reconcileContainers()
+
+	want := map[string]string{"dead": statusExited, "alive": statusRunning, "rebooted": statusExited, "stopped": statusStopped}
+	for id, status := range want {
+		state, err := loadContainerState(id)
+		if err != nil {
+			t.Fatalf("loadContainerState(%s) failed: %v", id, err)
+		}
+		if state.Status != status {
+			t.Errorf("container %s: expected status %s, got %s", id, status, state.Status)
+		}
+	}
+
+	// Atomic writes must not leave temporary files behind
+	files, _ := filepath.Glob(filepath.Join(containersDir, "*", ".*"))
+	if len(files) != 0 {
+		t.Errorf("Expected no temporary files, found %v", files)
+	}
+}
+
+// TestUpdateContainerStateConcurrent checks that concurrent transactions do not lose updates
+func TestUpdateContainerStateConcurrent(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+
+	if err := saveContainerState(&ContainerState{ID: "counter", Status: statusCreated}); err != nil {
+		t.Fatalf("saveContainerState failed: %v", err)
+	}
+
+	const workers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := updateContainerState("counter", func(state *ContainerState) error {
+				state.Command = append(state.Command, "x")
+				return nil
+			})
+			if err != nil {
+				t.Errorf("updateContainerState failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	state, err := loadContainerState("counter")
+	if err != nil {
+		t.Fatalf("loadContainerState failed: %v", err)
+	}
+	if len(state.Command) != workers {
+		t.Errorf("Expected %d updates, got %d", workers, len(state.Command))
+	}
+
+	// A failed transaction must leave the state untouched
+	err = updateContainerState("counter", func(state *ContainerState) error {
+		state.Status = statusRunning
+		return errStateUnchanged
+	})
+	if err != errStateUnchanged {
+		t.Errorf("Expected errStateUnchanged, got %v", err)
+	}
+	if state, _ := loadContainerState("counter"); state.Status != statusCreated {
+		t.Errorf("Expected status %s after aborted update, got %s", statusCreated, state.Status)
+	}
+}
+
+// TestMigrateContainerLayout checks flat state and log files move into per-container directories
+func TestMigrateContainerLayout(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+	if err := ensureStateDir(); err != nil {
+		t.Fatalf("ensureStateDir failed: %v", err)
+	}
+
+	legacyLog := filepath.Join(stateDir, "logs", "abc123.log")
+	if err := os.MkdirAll(filepath.Dir(legacyLog), 0755); err != nil {
+		t.Fatalf("Failed to create logs directory: %v", err)
+	}
+	if err := os.WriteFile(legacyLog, []byte("hello\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	legacyState := `{"id": "abc123", "status": "exited", "log_file": "` + legacyLog + `"}`
+	if err := os.WriteFile(filepath.Join(containersDir, "abc123.json"), []byte(legacyState), 0644); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+
+	migrateContainerLayout()
+
+	state, err := loadContainerState("abc")
+	if err != nil {
+		t.Fatalf("loadContainerState after migration failed: %v", err)
+	}
+	if state.LogFile != containerLogFile("abc123") {
+		t.Errorf("Expected log file %s, got %s", containerLogFile("abc123"), state.LogFile)
+	}
+	if data, err := os.ReadFile(state.LogFile); err != nil || string(data) != "hello\n" {
+		t.Errorf("Expected migrated log contents, got %q (%v)", data, err)
+	}
+	for _, path := range []string{filepath.Join(containersDir, "abc123.json"), filepath.Join(stateDir, "logs")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be gone after migration", path)
+		}
+	}
+}
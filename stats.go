@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pressureResources are the resources the kernel reports pressure stall
+// information (PSI) for, each in a <resource>.pressure file
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// sysClassNet is where the kernel exposes host network interfaces
+var sysClassNet = "/sys/class/net"
+
+// CgroupStats is a snapshot of a container cgroup's resource usage
+type CgroupStats struct {
+	MemoryUsage  int64         `json:"memory_usage"`
+	MemoryLimit  int64         `json:"memory_limit,omitempty"` // 0 when unlimited
+	MemoryPeak   int64         `json:"memory_peak,omitempty"`  // 0 when the kernel does not track it
+	MemoryHigh   int64         `json:"memory_high,omitempty"`  // 0 when there is no soft limit
+	SwapUsage    int64         `json:"swap_usage,omitempty"`   // 0 without swap accounting
+	MemoryEvents *MemoryEvents `json:"memory_events,omitempty"`
+	Pids         int64         `json:"pids"`
+	CPUUsageUsec int64         `json:"cpu_usage_usec"`
+	// CPUThrottling is nil unless the container has a CPU limit
+	CPUThrottling *CPUThrottling       `json:"cpu_throttling,omitempty"`
+	Pressure      map[string]*Pressure `json:"pressure,omitempty"` // keyed by resource
+}
+
+// MemoryEvents counts how often a cgroup hit its memory limits: High is
+// times it was throttled over memory.high, Max times it reached memory.max
+// and had to reclaim, and OOMKill processes the OOM killer killed
+// v1 only counts OOM kills
+type MemoryEvents struct {
+	High    int64 `json:"high"`
+	Max     int64 `json:"max"`
+	OOMKill int64 `json:"oom_kill"`
+}
+
+// Pressure is a cgroup's PSI for one resource
+// Some is time at least one task was stalled on the resource, Full is time
+// all tasks were; cpu has no meaningful Full outside the root cgroup
+type Pressure struct {
+	Some PressureStall `json:"some"`
+	Full PressureStall `json:"full"`
+}
+
+// PressureStall holds the share of wall time stalled, as a percentage averaged
+// over 10, 60 and 300 seconds, and the total stall time in microseconds
+type PressureStall struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  int64   `json:"total"`
+}
+
+// InterfaceStats holds a container interface's traffic counters, as seen
+// from inside the container
+type InterfaceStats struct {
+	Name      string `json:"name"`
+	RxBytes   int64  `json:"rx_bytes"`
+	RxPackets int64  `json:"rx_packets"`
+	TxBytes   int64  `json:"tx_bytes"`
+	TxPackets int64  `json:"tx_packets"`
+}
+
+// ContainerStats is the output of 'gocker stats' for one container
+type ContainerStats struct {
+	ID string `json:"id"`
+	*CgroupStats
+	Networks []InterfaceStats `json:"networks,omitempty"`
+}
+
+func statsCommand(args []string) {
+	var jsonOutput bool
+	flags := newCommandFlags("stats", "[options] [container-id...]", "Show resource usage and pressure of running containers")
+	flags.interspersed = true
+	flags.BoolVar(&jsonOutput, "json", "", "Print the stats as JSON")
+	ids := flags.MustParse(args)
+	requireRoot()
+
+	if len(ids) == 0 {
+		ids = matchingContainerIDs(func(state *ContainerState) bool {
+			return isActive(state.Status) && isProcessAlive(state)
+		})
+	}
+
+	var all []ContainerStats
+	for _, id := range ids {
+		stats, err := containerStats(id)
+		must(err)
+		all = append(all, *stats)
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(all, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+		return
+	}
+	if len(all) == 0 {
+		fmt.Println("No running containers")
+		return
+	}
+
+	// Pressure columns show the "some" share stalled over the last 10s/60s
+	// MEM EVENTS are the memory.events high/max/oom_kill counts: throttling
+	// over --memory-high, reclaim at --memory-limit, and OOM kills
+	// THROTTLED is the share of CPU periods the container used up its
+	// --cpu-limit quota in
+	fmt.Printf("%-14s %-18s %-10s %-12s %-14s %-6s %-10s %-14s %-14s %s\n", "CONTAINER ID", "MEM USAGE / LIMIT", "SWAP", "MEM EVENTS", "NET I/O", "PIDS", "THROTTLED", "CPU PSI 10/60", "MEM PSI 10/60", "IO PSI 10/60")
+	fmt.Println(strings.Repeat("-", 136))
+	var warnings []string
+	for _, stats := range all {
+		limit := "max"
+		if stats.MemoryLimit > 0 {
+			limit = formatMemory(stats.MemoryLimit)
+		}
+		events := "-"
+		if e := stats.MemoryEvents; e != nil {
+			events = fmt.Sprintf("%d/%d/%d", e.High, e.Max, e.OOMKill)
+		}
+		var rx, tx int64
+		for _, iface := range stats.Networks {
+			rx += iface.RxBytes
+			tx += iface.TxBytes
+		}
+		throttled := "-"
+		if t := stats.CPUThrottling; t != nil {
+			throttled = formatRatio(t.Ratio())
+			if t.Ratio() >= throttleWarnRatio {
+				warnings = append(warnings, fmt.Sprintf("Warning: container %s was throttled in %s of CPU periods, waiting %s in total; its --cpu-limit may be too low",
+					shortID(stats.ID), throttled, time.Duration(t.ThrottledUsec)*time.Microsecond))
+			}
+		}
+		fmt.Printf("%-14s %-18s %-10s %-12s %-14s %-6d %-10s %-14s %-14s %s\n", shortID(stats.ID),
+			formatMemory(stats.MemoryUsage)+" / "+limit, formatMemory(stats.SwapUsage), events, formatMemory(rx)+" / "+formatMemory(tx), stats.Pids, throttled,
+			formatPressure(stats.Pressure["cpu"]), formatPressure(stats.Pressure["memory"]), formatPressure(stats.Pressure["io"]))
+	}
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+}
+
+// containerStats reads the stats of a running container's cgroup
+func containerStats(containerID string) (*ContainerStats, error) {
+	state, err := loadContainerState(containerID)
+	if err != nil {
+		return nil, err
+	}
+	if !isActive(state.Status) || !isProcessAlive(state) {
+		return nil, fmt.Errorf("container %s is not running", shortID(state.ID))
+	}
+	if state.CgroupPath == "" {
+		return nil, fmt.Errorf("container %s has no cgroup", shortID(state.ID))
+	}
+
+	stats, err := cgroups.Stats(state.CgroupPath)
+	if err != nil {
+		return nil, err
+	}
+	return &ContainerStats{ID: state.ID, CgroupStats: stats, Networks: networkStats(state)}, nil
+}
+
+// networkStats reads the counters of a container's interfaces from the host
+// end of each veth pair; what the host end receives, the container sent
+func networkStats(state *ContainerState) []InterfaceStats {
+	interfaces := state.Interfaces
+	if len(interfaces) == 0 && state.VethHost != "" {
+		interfaces = []NetworkInterface{{Name: containerInterfaceName(0), HostVeth: state.VethHost}}
+	}
+
+	var networks []InterfaceStats
+	for _, iface := range interfaces {
+		dir := filepath.Join(sysClassNet, iface.HostVeth, "statistics")
+		if _, err := os.Stat(dir); err != nil {
+			logger.Debug("Interface statistics unavailable", "interface", iface.HostVeth, "error", err)
+			continue
+		}
+		networks = append(networks, InterfaceStats{
+			Name:      iface.Name,
+			RxBytes:   readIntFile(filepath.Join(dir, "tx_bytes")),
+			RxPackets: readIntFile(filepath.Join(dir, "tx_packets")),
+			TxBytes:   readIntFile(filepath.Join(dir, "rx_bytes")),
+			TxPackets: readIntFile(filepath.Join(dir, "rx_packets")),
+		})
+	}
+	return networks
+}
+
+// formatPressure formats the 10s and 60s "some" averages, or "-" without PSI
+func formatPressure(p *Pressure) string {
+	if p == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%/%.1f%%", p.Some.Avg10, p.Some.Avg60)
+}
+
+// parsePressure parses a PSI file:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func parsePressure(data string) (*Pressure, error) {
+	pressure := &Pressure{}
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var stall *PressureStall
+		switch fields[0] {
+		case "some":
+			stall = &pressure.Some
+		case "full":
+			stall = &pressure.Full
+		default:
+			return nil, fmt.Errorf("unexpected line: %q", line)
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("unexpected field: %q", field)
+			}
+			var err error
+			switch key {
+			case "avg10":
+				stall.Avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				stall.Avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				stall.Avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				stall.Total, err = strconv.ParseInt(value, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %q", key, value)
+			}
+		}
+	}
+	return pressure, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestParsePressure checks parsing of cgroup PSI files
+func TestParsePressure(t *testing.T) {
+	p, err := parsePressure("some avg10=1.50 avg60=0.75 avg300=0.10 total=123456\nfull avg10=0.50 avg60=0.25 avg300=0.00 total=4567\n")
+	if err != nil {
+		t.Fatalf("parsePressure failed: %v", err)
+	}
+	want := Pressure{
+		Some: PressureStall{Avg10: 1.5, Avg60: 0.75, Avg300: 0.1, Total: 123456},
+		Full: PressureStall{Avg10: 0.5, Avg60: 0.25, Avg300: 0, Total: 4567},
+	}
+	if *p != want {
+		t.Errorf("parsePressure = %+v, want %+v", *p, want)
+	}
+	if got := formatPressure(p); got != "1.5%/0.8%" {
+		t.Errorf("formatPressure = %q", got)
+	}
+	if got := formatPressure(nil); got != "-" {
+		t.Errorf("formatPressure(nil) = %q, want -", got)
+	}
+
+	for _, data := range []string{"partial avg10=0.00", "some avg10", "some avg10=abc"} {
+		if _, err := parsePressure(data); err == nil {
+			t.Errorf("parsePressure(%q): expected error", data)
+		}
+	}
+}
+
+// TestCgroupStats checks usage and pressure are read from v2 and v1 cgroup files
+func TestCgroupStats(t *testing.T) {
+	root := t.TempDir()
+	v2 := filepath.Join(root, "v2")
+	os.MkdirAll(v2, 0755)
+	files := map[string]string{
+		"memory.current":      "1048576\n",
+		"memory.max":          "max\n",
+		"memory.peak":         "2097152\n",
+		"memory.high":         "1572864\n",
+		"memory.events":       "low 0\nhigh 42\nmax 3\noom 1\noom_kill 1\n",
+		"memory.swap.current": "65536\n",
+		"pids.current":        "3\n",
+		"cpu.stat":            "usage_usec 2500\nuser_usec 2000\nsystem_usec 500\nnr_periods 80\nnr_throttled 20\nthrottled_usec 150000\n",
+		"memory.pressure":     "some avg10=12.00 avg60=4.00 avg300=1.00 total=900\nfull avg10=6.00 avg60=2.00 avg300=0.50 total=400\n",
+	}
+	for name, data := range files {
+		os.WriteFile(filepath.Join(v2, name), []byte(data), 0644)
+	}
+
+	stats, err := (&cgroupV2{}).Stats(v2)
+	if err != nil {
+		t.Fatalf("v2 Stats failed: %v", err)
+	}
+	if stats.MemoryUsage != 1<<20 || stats.MemoryLimit != 0 || stats.MemoryPeak != 2<<20 || stats.Pids != 3 || stats.CPUUsageUsec != 2500 {
+		t.Errorf("Unexpected v2 stats: %+v", stats)
+	}
+	if stats.MemoryHigh != 1536<<10 || stats.SwapUsage != 64<<10 || *stats.MemoryEvents != (MemoryEvents{High: 42, Max: 3, OOMKill: 1}) {
+		t.Errorf("Unexpected v2 memory stats: high %d, swap %d, events %+v", stats.MemoryHigh, stats.SwapUsage, stats.MemoryEvents)
+	}
+	if throttling := stats.CPUThrottling; throttling == nil || *throttling != (CPUThrottling{Periods: 80, Throttled: 20, ThrottledUsec: 150000}) {
+		t.Errorf("Unexpected v2 CPU throttling: %+v", throttling)
+	}
+	if len(stats.Pressure) != 1 || stats.Pressure["memory"].Full.Avg10 != 6 {
+		t.Errorf("Expected only memory pressure, got %+v", stats.Pressure)
+	}
+
+	v1 := &cgroupV1{root: root}
+	v1Path := filepath.Join(root, "gocker", "abc123")
+	if err := v1.Create(v1Path); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	os.WriteFile(filepath.Join(root, "memory/gocker/abc123/memory.usage_in_bytes"), []byte("4096\n"), 0644)
+	os.WriteFile(filepath.Join(root, "memory/gocker/abc123/memory.limit_in_bytes"), []byte("9223372036854771712\n"), 0644)
+	os.WriteFile(filepath.Join(root, "memory/gocker/abc123/memory.max_usage_in_bytes"), []byte("8192\n"), 0644)
+	os.WriteFile(filepath.Join(root, "memory/gocker/abc123/memory.memsw.usage_in_bytes"), []byte("6144\n"), 0644)
+	os.WriteFile(filepath.Join(root, "cpu/gocker/abc123/cpuacct.usage"), []byte("5000000\n"), 0644)
+	stats, err = v1.Stats(v1Path)
+	if err != nil {
+		t.Fatalf("v1 Stats failed: %v", err)
+	}
+	if stats.MemoryUsage != 4096 || stats.MemoryLimit != 0 || stats.MemoryPeak != 8192 || stats.SwapUsage != 2048 || stats.CPUUsageUsec != 5000 || stats.CPUThrottling != nil || stats.Pressure != nil {
+		t.Errorf("Unexpected v1 stats: %+v", stats)
+	}
+
+	if _, err := (&cgroupV2{}).Stats(filepath.Join(root, "missing")); err == nil {
+		t.Error("Expected error for a missing cgroup")
+	}
+}
+
+// TestNetworkStats checks that host veth counters are reported from the container's side
+func TestNetworkStats(t *testing.T) {
+	saved := sysClassNet
+	defer func() { sysClassNet = saved }()
+	sysClassNet = t.TempDir()
+
+	dir := filepath.Join(sysClassNet, "vethabc12345", "statistics")
+	os.MkdirAll(dir, 0755)
+	counters := map[string]string{"rx_bytes": "100\n", "rx_packets": "2\n", "tx_bytes": "3000\n", "tx_packets": "4\n"}
+	for name, value := range counters {
+		os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+	}
+
+	state := &ContainerState{Interfaces: []NetworkInterface{
+		{Name: "eth0", HostVeth: "vethabc12345"},
+		{Name: "eth1", HostVeth: "vethgone"},
+	}}
+	got := networkStats(state)
+	want := InterfaceStats{Name: "eth0", RxBytes: 3000, RxPackets: 4, TxBytes: 100, TxPackets: 2}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("networkStats = %+v, want [%+v]", got, want)
+	}
+
+	// Containers from before interfaces were recorded only have VethHost
+	got = networkStats(&ContainerState{VethHost: "vethabc12345"})
+	if len(got) != 1 || got[0].Name != "eth0" || got[0].RxBytes != 3000 {
+		t.Errorf("networkStats(VethHost) = %+v", got)
+	}
+}
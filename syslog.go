@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Modes for 'run --syslog', which provides a /dev/log socket in the container
+// for daemons that log through syslog(3)
+const (
+	syslogToLog  = "log"  // relay messages into the container log
+	syslogToHost = "host" // bind the host's /dev/log, usually the journal
+)
+
+// hostSyslogSocket is the host's syslog socket used by --syslog host
+var hostSyslogSocket = "/dev/log"
+
+// syslogSocketName is the relay socket in the container's directory
+const syslogSocketName = "syslog.sock"
+
+// syslogRelayCheckInterval is how often the relay checks the container is alive
+const syslogRelayCheckInterval = time.Second
+
+// validateSyslogMode checks a --syslog value
+func validateSyslogMode(mode string) error {
+	switch mode {
+	case "", syslogToHost:
+		return nil
+	case syslogToLog:
+		if logDriver != "file" {
+			return fmt.Errorf("--syslog log requires the file log driver (log_driver is %s)", logDriver)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid syslog mode: %s (expected log or host)", mode)
+}
+
+// setupSyslog returns the host socket to mount at /dev/log in a container
+// started as pid, starting a relay into the container log if needed
+func setupSyslog(containerID string, pid int, mode string) (string, error) {
+	if mode == syslogToHost {
+		if _, err := os.Stat(hostSyslogSocket); err != nil {
+			return "", fmt.Errorf("host syslog socket not available: %v", err)
+		}
+		return hostSyslogSocket, nil
+	}
+	return startSyslogRelay(containerID, pid)
+}
+
+// startSyslogRelay creates the container's syslog socket and starts a relay
+// process that appends each message to the container log until pid exits
+// The relay runs in its own session so it outlives 'gocker run --detach'
+func startSyslogRelay(containerID string, pid int) (string, error) {
+	path := filepath.Join(containerDir(containerID), syslogSocketName)
+	os.Remove(path)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return "", fmt.Errorf("failed to create syslog socket: %v", err)
+	}
+	defer conn.Close()
+	// Any user in the container may log
+	if err := os.Chmod(path, 0666); err != nil {
+		return "", fmt.Errorf("failed to set syslog socket permissions: %v", err)
+	}
+	socket, err := conn.File()
+	if err != nil {
+		return "", fmt.Errorf("failed to get syslog socket: %v", err)
+	}
+	defer socket.Close()
+
+	logFile, err := os.OpenFile(containerLogFile(containerID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to open container log: %v", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command("/proc/self/exe", append(logFlags(), "syslog-relay", strconv.Itoa(pid))...)
+	cmd.ExtraFiles = []*os.File{socket, logFile}
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	newSession(cmd.SysProcAttr, false)
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to start syslog relay: %v", err)
+	}
+	cmd.Process.Release()
+	return path, nil
+}
+
+// syslogRelayCommand is the relay process started by startSyslogRelay; the
+// socket and the container log arrive as descriptors 3 and 4
+func syslogRelayCommand(args []string) {
+	if len(args) != 1 {
+		must(fmt.Errorf("syslog-relay: container PID required"))
+	}
+	pid, err := strconv.Atoi(args[0])
+	must(err)
+	startTime, err := processStartTime(pid)
+	must(err)
+
+	conn, err := net.FilePacketConn(os.NewFile(3, "syslog-socket"))
+	must(err)
+	logFile := os.NewFile(4, "container-log")
+	err = relaySyslog(conn, logFile, func() bool { return processAlive(pid, startTime) })
+	if err != nil {
+		logger.Warn("Syslog relay failed", "error", err)
+	}
+}
+
+// relaySyslog writes each message received on conn to w as a line until
+// alive reports the container has exited
+func relaySyslog(conn net.PacketConn, w io.Writer, alive func() bool) error {
+	buf := make([]byte, 64*1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(syslogRelayCheckInterval))
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if !alive() {
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(formatSyslogMessage(buf[:n])); err != nil {
+			return err
+		}
+	}
+}
+
+// formatSyslogMessage turns a syslog datagram such as
+// "<30>Oct 16 12:00:00 app[12]: started" into a log line without the priority
+func formatSyslogMessage(msg []byte) []byte {
+	if len(msg) > 0 && msg[0] == '<' {
+		if end := bytes.IndexByte(msg, '>'); end > 0 && end <= 4 {
+			msg = msg[end+1:]
+		}
+	}
+	msg = bytes.TrimRight(msg, "\n\x00")
+	return append(append([]byte{}, msg...), '\n')
+}
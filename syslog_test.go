@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestFormatSyslogMessage checks the priority is stripped and each message ends in a newline
+func TestFormatSyslogMessage(t *testing.T) {
+	tests := map[string]string{
+		"<30>Oct 16 12:00:00 app[12]: started": "Oct 16 12:00:00 app[12]: started\n",
+		"<191>Oct 16 12:00:00 app: debug\n":    "Oct 16 12:00:00 app: debug\n",
+		"Oct 16 12:00:00 app: no priority\x00": "Oct 16 12:00:00 app: no priority\n",
+		"<not a priority> message":             "<not a priority> message\n",
+	}
+	for msg, want := range tests {
+		if got := string(formatSyslogMessage([]byte(msg))); got != want {
+			t.Errorf("formatSyslogMessage(%q) = %q, want %q", msg, got, want)
+		}
+	}
+}
+
+// TestValidateSyslogMode checks --syslog values and the log driver requirement
+func TestValidateSyslogMode(t *testing.T) {
+	restoreRuntimeSettings(t)
+	for _, mode := range []string{"", "log", "host"} {
+		if err := validateSyslogMode(mode); err != nil {
+			t.Errorf("validateSyslogMode(%q): unexpected error: %v", mode, err)
+		}
+	}
+	if err := validateSyslogMode("journal"); err == nil {
+		t.Error("Expected error for an unknown mode")
+	}
+	logDriver = "none"
+	if err := validateSyslogMode("log"); err == nil {
+		t.Error("Expected error relaying to the log without a log file")
+	}
+}
+
+// TestRelaySyslog checks messages sent to the socket reach the log until the container exits
+func TestRelaySyslog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), syslogSocketName)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to create socket: %v", err)
+	}
+	defer conn.Close()
+
+	var alive atomic.Bool
+	alive.Store(true)
+	var log bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- relaySyslog(conn, &log, alive.Load) }()
+
+	client, err := net.Dial("unixgram", path)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+	for _, msg := range []string{"<14>app: one", "<14>app: two\n"} {
+		if _, err := client.Write([]byte(msg)); err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+	}
+
+	// Give the relay time to read both messages before the container "exits"
+	time.Sleep(100 * time.Millisecond)
+	alive.Store(false)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("relaySyslog failed: %v", err)
+		}
+	case <-time.After(3 * syslogRelayCheckInterval):
+		t.Fatal("relaySyslog did not return after the container exited")
+	}
+	if got := log.String(); got != "app: one\napp: two\n" {
+		t.Errorf("Relayed log = %q", got)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// iptablesChains are the chains gocker adds rules to, by table
+var iptablesChains = []struct{ table, chain string }{
+	{"filter", "FORWARD"},
+	{"nat", "PREROUTING"},
+	{"nat", "OUTPUT"},
+	{"nat", "POSTROUTING"},
+}
+
+func systemCommands() []*command {
+	return []*command{
+		{name: "reset", description: "Remove all containers and everything gocker set up on the host", run: systemResetCommand},
+		{name: "cores", description: "Show or change whether core dumps of container processes are kept", run: systemCoresCommand},
+	}
+}
+
+// systemCommand dispatches the 'gocker system' subcommands
+func systemCommand(args []string) {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		printSystemUsage()
+		if len(args) == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, cmd := range systemCommands() {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	fmt.Printf("Unknown system command: %s\n", args[0])
+	printSystemUsage()
+	os.Exit(1)
+}
+
+func printSystemUsage() {
+	fmt.Println("Usage: gocker system <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range systemCommands() {
+		fmt.Printf("  %-6s %s\n", cmd.name, cmd.description)
+	}
+}
+
+func systemResetCommand(args []string) {
+	var force, forceProtected bool
+	flags := newCommandFlags("system reset", "[options]", "Stop and remove all containers and templates, and remove the bridge, iptables rules, veths, and cgroup tree gocker created")
+	flags.BoolVar(&force, "force", "f", "Do not prompt for confirmation")
+	flags.BoolVar(&forceProtected, "force-protected", "", "Reset even if containers are protected with 'gocker update --protect'")
+	if len(flags.MustParse(args)) > 0 {
+		flags.Fail("system reset does not accept arguments")
+	}
+	requireRoot()
+
+	// Refuse before removing anything: a reset deletes the whole state directory
+	if protected := protectedContainerIDs(); len(protected) > 0 && !forceProtected {
+		must(fmt.Errorf("refusing to reset: protected containers %s; use --force-protected", strings.Join(protected, ", ")))
+	}
+
+	if !force {
+		if !isTerminal(os.Stdin) {
+			must(fmt.Errorf("refusing to reset without confirmation; use --force"))
+		}
+		prompt := fmt.Sprintf("This stops and removes every container and template in %s and removes bridge %s with its iptables rules. Continue? [y/N] ", stateDir, bridgeName)
+		if !confirm(os.Stdin, os.Stdout, prompt) {
+			fmt.Println("Aborted")
+			os.Exit(1)
+		}
+	}
+
+	ok := resetContainers()
+	for _, step := range []struct {
+		name string
+		run  func() error
+	}{
+		{"iptables rules", removeIptablesRules},
+		{"nftables rules", removeNftNATRules},
+		{"firewalld zone", removeFirewalldZone},
+		{"network interfaces", removeNetworkInterfaces},
+		{"cgroups", cgroups.RemoveTree},
+		{"core pattern", restoreCorePattern},
+		{"state", removeState},
+	} {
+		if err := step.run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to remove %s: %v\n", step.name, err)
+			ok = false
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+	fmt.Println("gocker has been reset")
+}
+
+// confirm asks a yes/no question and reports whether the answer was yes
+func confirm(r io.Reader, w io.Writer, prompt string) bool {
+	fmt.Fprint(w, prompt)
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// resetContainers stops every running container, then removes them all,
+// protected ones included
+// It reports whether every container was removed
+func resetContainers() bool {
+	running := matchingContainerIDs(func(state *ContainerState) bool {
+		return isActive(state.Status) && isProcessAlive(state)
+	})
+	stopped := forEachContainer(running, func(containerID string) error {
+		return stopContainer(containerID, defaultStopTimeout)
+	})
+	all, err := listContainerIDs()
+	must(err)
+	removed := forEachContainer(all, func(containerID string) error {
+		return removeContainer(containerID, true)
+	})
+	return removed && stopped
+}
+
+// removeIptablesRules deletes every rule gocker added, including rules left
+// behind by containers that were never cleaned up, and the per-container
+// firewall chains
+func removeIptablesRules() error {
+	if !hasHostTool("iptables") {
+		return nil
+	}
+	removed := 0
+	for _, c := range iptablesChains {
+		output, err := hostCommand("iptables", "-t", c.table, "-S", c.chain).Output()
+		if err != nil {
+			return fmt.Errorf("failed to list %s %s rules: %v", c.table, c.chain, err)
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != "-A" || !isGockerRule(fields) {
+				continue
+			}
+			args := append([]string{"-t", c.table, "-D"}, fields[1:]...)
+			if output, err := hostCommand("iptables", args...).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to delete rule %q: %v: %s", line, err, strings.TrimSpace(string(output)))
+			}
+			removed++
+		}
+	}
+
+	output, err := hostCommand("iptables", "-S").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list chains: %v", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		chain, ok := strings.CutPrefix(line, "-N ")
+		if !ok || !strings.HasPrefix(chain, firewallChainPrefix) {
+			continue
+		}
+		hostCommand("iptables", "-F", chain).Run()
+		if err := hostCommand("iptables", "-X", chain).Run(); err != nil {
+			return fmt.Errorf("failed to delete chain %s: %v", chain, err)
+		}
+		removed++
+	}
+	fmt.Printf("Removed %d iptables rules and chains\n", removed)
+	return nil
+}
+
+// isGockerRule reports whether a rule from 'iptables -S' belongs to gocker:
+// it names the bridge, the container subnet, or a firewall chain, or forwards
+// a published port into the subnet
+func isGockerRule(fields []string) bool {
+	_, subnet, _ := net.ParseCIDR(containerNet)
+	for i, field := range fields {
+		if field == bridgeName || field == containerNet || strings.HasPrefix(field, firewallChainPrefix) {
+			return true
+		}
+		if field == "--to-destination" && i+1 < len(fields) && subnet != nil {
+			host, _, _ := strings.Cut(fields[i+1], ":")
+			if ip := net.ParseIP(host); ip != nil && subnet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeNetworkInterfaces deletes the veths still attached to the bridge,
+// then the bridge
+func removeNetworkInterfaces() error {
+	if _, err := net.InterfaceByName(bridgeName); err != nil {
+		return nil
+	}
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return fmt.Errorf("failed to list interfaces: %v", err)
+	}
+	for _, iface := range interfaces {
+		master, err := os.Readlink(filepath.Join(sysClassNet, iface.Name, "master"))
+		if err == nil && filepath.Base(master) == bridgeName {
+			cleanupVeth(iface.Name)
+		}
+	}
+	if err := links.Delete(bridgeName); err != nil {
+		return fmt.Errorf("failed to delete bridge %s: %v", bridgeName, err)
+	}
+	fmt.Printf("Removed bridge %s\n", bridgeName)
+	return nil
+}
+
+// restoreCorePattern stops gocker from handling core dumps, as its handler
+// would outlive the state it writes dumps into
+func restoreCorePattern() error {
+	_, err := disableCoreCapture()
+	return err
+}
+
+// removeState deletes the container, template, IP, port, history, and usage
+// records; the data root itself and its instance settings are kept
+func removeState() error {
+	for _, path := range []string{containersDir, templatesDir, ipamFile, portsFile, historyFile, pluginsDir, volumeMountsFile, usageFile, schedulesFile, jobsFile, filepath.Join(stateDir, "logs")} {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsGockerRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{"-A POSTROUTING -s 10.0.0.0/24 -o eth0 -j MASQUERADE", true},
+		{"-A FORWARD -i gocker0 -o eth0 -j ACCEPT", true},
+		{"-A FORWARD -s 10.0.0.7/32 -i gocker0 ! -o gocker0 -j REJECT --reject-with icmp-port-unreachable", true},
+		{"-A FORWARD -d 10.0.0.7/32 -o gocker0 -j GOCKER-abcdef012345", true},
+		{"-A PREROUTING -p tcp -m tcp --dport 8080 -m addrtype --dst-type LOCAL -j DNAT --to-destination 10.0.0.7:80", true},
+		{"-A PREROUTING -p tcp -m tcp --dport 8080 -j DNAT --to-destination 172.17.0.2:80", false},
+		{"-A FORWARD -i docker0 -o eth0 -j ACCEPT", false},
+		{"-A FORWARD -j DOCKER-USER", false},
+	}
+	for _, tt := range tests {
+		if got := isGockerRule(strings.Fields(tt.rule)); got != tt.want {
+			t.Errorf("isGockerRule(%q) = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	for answer, want := range map[string]bool{"y\n": true, "YES\n": true, "n\n": false, "\n": false, "": false} {
+		var out bytes.Buffer
+		if got := confirm(strings.NewReader(answer), &out, "Continue? "); got != want {
+			t.Errorf("confirm(%q) = %v, want %v", answer, got, want)
+		}
+		if out.String() != "Continue? " {
+			t.Errorf("Expected prompt to be written, got %q", out.String())
+		}
+	}
+}
+
+func TestRemoveState(t *testing.T) {
+	restoreRuntimeSettings(t)
+	root := t.TempDir()
+	setDataRoot(root)
+	for _, path := range []string{filepath.Join(containersDir, "abc", "state.json"), filepath.Join(templatesDir, "web.json"), ipamFile, portsFile, filepath.Join(root, "instance.json")} {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte("{}"), 0644)
+	}
+
+	if err := removeState(); err != nil {
+		t.Fatalf("removeState failed: %v", err)
+	}
+	for _, path := range []string{containersDir, templatesDir, ipamFile, portsFile} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "instance.json")); err != nil {
+		t.Errorf("Expected instance settings to be kept, got %v", err)
+	}
+}
+
+func TestRemoveIptablesRules(t *testing.T) {
+	host := useFakeHost(t)
+	host.outputs["iptables -t filter -S FORWARD"] = "-P FORWARD DROP\n" +
+		"-A FORWARD -i gocker0 -o eth0 -j ACCEPT\n" +
+		"-A FORWARD -j DOCKER-USER\n"
+	host.outputs["iptables -t nat -S POSTROUTING"] = "-A POSTROUTING -s 10.0.0.0/24 -o eth0 -j MASQUERADE\n"
+	host.outputs["iptables -S"] = "-N DOCKER-USER\n-N GOCKER-abcdef012345\n"
+
+	if err := removeIptablesRules(); err != nil {
+		t.Fatalf("removeIptablesRules failed: %v", err)
+	}
+	want := []string{
+		"iptables -t filter -D FORWARD -i gocker0 -o eth0 -j ACCEPT",
+		"iptables -t nat -D POSTROUTING -s 10.0.0.0/24 -o eth0 -j MASQUERADE",
+	}
+	if got := host.ran("iptables -t filter -D"); !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("Deleted filter rules %v, want %v", got, want[:1])
+	}
+	if got := host.ran("iptables -t nat -D"); !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("Deleted nat rules %v, want %v", got, want[1:])
+	}
+	if got := host.ran("iptables -X"); !reflect.DeepEqual(got, []string{"iptables -X GOCKER-abcdef012345"}) {
+		t.Errorf("Expected only the gocker chain to be deleted, got %v", got)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// systemdRestartPolicies are the Restart= values accepted by generate systemd
+var systemdRestartPolicies = []string{"no", "on-success", "on-failure", "on-abnormal", "on-abort", "always"}
+
+// generateCommands lists the 'gocker generate' subcommands
+func generateCommands() []*command {
+	return []*command{
+		{name: "systemd", description: "Generate a systemd unit file for a container", run: generateSystemdCommand},
+	}
+}
+
+// generateCommand dispatches the 'gocker generate' subcommands
+func generateCommand(args []string) {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		printGenerateUsage()
+		if len(args) == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, cmd := range generateCommands() {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	fmt.Printf("Unknown generate command: %s\n", args[0])
+	printGenerateUsage()
+	os.Exit(1)
+}
+
+func printGenerateUsage() {
+	fmt.Println("Usage: gocker generate <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range generateCommands() {
+		fmt.Printf("  %-8s %s\n", cmd.name, cmd.description)
+	}
+}
+
+func generateSystemdCommand(args []string) {
+	name, restart := "", "on-failure"
+	flags := newCommandFlags("generate systemd", "[options] <container-id>", "Generate a systemd unit file for a container")
+	flags.interspersed = true
+	flags.StringVar(&name, "name", "", "unit", "Unit name (default: gocker-<container-id>.service)")
+	flags.StringVar(&restart, "restart", "", "policy", "systemd Restart= policy (default: on-failure)")
+	ids := flags.MustParse(args)
+	if len(ids) != 1 {
+		flags.Fail("container ID required")
+	}
+
+	requireRoot()
+	state, err := loadContainerState(ids[0])
+	must(err)
+
+	executable, err := os.Executable()
+	must(err)
+
+	unit, err := systemdUnit(state, executable, restart)
+	must(err)
+
+	if name == "" {
+		name = "gocker-" + shortID(state.ID) + ".service"
+	}
+	fmt.Printf("# %s\n", name)
+	fmt.Print(unit)
+}
+
+// systemdUnit returns a unit file that runs a fresh container with the same
+// configuration as state in the foreground, so systemd supervises it directly
+func systemdUnit(state *ContainerState, executable, restart string) (string, error) {
+	if !validRestartPolicy(restart) {
+		return "", fmt.Errorf("invalid restart policy: %s (expected one of %s)", restart, strings.Join(systemdRestartPolicies, ", "))
+	}
+
+	opts := state.Options
+	if opts == nil {
+		// Containers created before run options were recorded
+		opts = &RunOptions{Command: state.Command, RootfsPath: state.RootfsPath}
+	}
+	if opts.RootfsPath == "" {
+		opts.RootfsPath = state.RootfsPath
+	}
+
+	execArgs := []string{executable}
+	if activeContextName != defaultContextName {
+		execArgs = append(execArgs, "--context", activeContextName)
+	}
+	execArgs = append(execArgs, "--data-root", stateDir, "run")
+	if opts.CPULimit != "" {
+		execArgs = append(execArgs, "--cpu-limit", opts.CPULimit)
+	}
+	if opts.MemoryLimit != "" {
+		execArgs = append(execArgs, "--memory-limit", opts.MemoryLimit)
+	}
+	if opts.MemoryHigh != "" {
+		execArgs = append(execArgs, "--memory-high", opts.MemoryHigh)
+	}
+	if opts.MaxLifetime != "" {
+		execArgs = append(execArgs, "--max-lifetime", opts.MaxLifetime)
+	}
+	if opts.StopIdle != "" {
+		execArgs = append(execArgs, "--stop-idle", opts.StopIdle)
+	}
+	if opts.Trace {
+		execArgs = append(execArgs, "--trace")
+	}
+	if opts.CoreLimit != "" {
+		execArgs = append(execArgs, "--core-limit", opts.CoreLimit)
+	}
+	if opts.ReserveCPU != "" {
+		execArgs = append(execArgs, "--reserve-cpu", opts.ReserveCPU)
+	}
+	if opts.ReserveMemory != "" {
+		execArgs = append(execArgs, "--reserve-memory", opts.ReserveMemory)
+	}
+	if opts.Init {
+		execArgs = append(execArgs, "--init")
+	}
+	if opts.Internal {
+		execArgs = append(execArgs, "--internal")
+	}
+	if opts.Syslog != "" {
+		execArgs = append(execArgs, "--syslog", opts.Syslog)
+	}
+	if opts.TmpDir != "" {
+		execArgs = append(execArgs, "--tmpdir", opts.TmpDir)
+	} else if opts.Ephemeral {
+		execArgs = append(execArgs, "--ephemeral")
+	}
+	for _, env := range opts.Env {
+		execArgs = append(execArgs, "--env", env)
+	}
+	for _, volume := range opts.Volumes {
+		execArgs = append(execArgs, "--volume", volume)
+	}
+	for _, label := range opts.Labels {
+		execArgs = append(execArgs, "--label", label)
+	}
+	if opts.UsernsRemap != "" {
+		execArgs = append(execArgs, "--userns-remap", opts.UsernsRemap)
+	}
+	if opts.GPUs != "" {
+		execArgs = append(execArgs, "--gpus", opts.GPUs)
+	}
+	if opts.Platform != "" {
+		execArgs = append(execArgs, "--platform", opts.Platform)
+	}
+	if opts.Runtime != "" {
+		execArgs = append(execArgs, "--runtime", opts.Runtime)
+	}
+	if opts.CgroupNS != "" {
+		execArgs = append(execArgs, "--cgroupns", opts.CgroupNS)
+	}
+	if opts.Privileged {
+		execArgs = append(execArgs, "--privileged")
+	}
+	for _, annotation := range opts.Annotations {
+		execArgs = append(execArgs, "--annotation", annotation)
+	}
+	for _, host := range opts.AddHosts {
+		execArgs = append(execArgs, "--add-host", host)
+	}
+	for _, alias := range opts.NetworkAliases {
+		execArgs = append(execArgs, "--network-alias", alias)
+	}
+	for _, link := range opts.Links {
+		execArgs = append(execArgs, "--link", link)
+	}
+	for _, port := range opts.Publish {
+		execArgs = append(execArgs, "--publish", port)
+	}
+	for _, port := range opts.Expose {
+		execArgs = append(execArgs, "--expose", port)
+	}
+	for _, cidr := range opts.AllowFrom {
+		execArgs = append(execArgs, "--allow-from", cidr)
+	}
+	if opts.RootfsPath != "" {
+		execArgs = append(execArgs, "--rootfs", opts.RootfsPath)
+	}
+	execArgs = append(execArgs, "--")
+	execArgs = append(execArgs, opts.Command...)
+
+	var quoted []string
+	for _, arg := range execArgs {
+		quoted = append(quoted, systemdQuote(arg))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by gocker generate systemd from container %s\n", state.ID)
+	b.WriteString("# Each start creates a new container with the same configuration\n")
+	b.WriteString("\n[Unit]\n")
+	fmt.Fprintf(&b, "Description=gocker container %s (%s)\n", shortID(state.ID), systemdEscape(strings.Join(opts.Command, " ")))
+	b.WriteString("Wants=network-online.target\n")
+	b.WriteString("After=network-online.target\n")
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=notify\n")
+	b.WriteString("NotifyAccess=main\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+	b.WriteString("KillMode=mixed\n")
+	b.WriteString("TimeoutStopSec=10\n")
+	// gocker run exits with 130 after cleaning up on SIGTERM
+	b.WriteString("SuccessExitStatus=130\n")
+	fmt.Fprintf(&b, "Restart=%s\n", restart)
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+	return b.String(), nil
+}
+
+func validRestartPolicy(policy string) bool {
+	for _, p := range systemdRestartPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// systemdEscape escapes the characters systemd expands in unit settings
+func systemdEscape(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+// systemdQuote quotes one ExecStart= argument if it needs it
+func systemdQuote(arg string) string {
+	arg = systemdEscape(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\;") {
+		return arg
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	return `"` + replacer.Replace(arg) + `"`
+}
+
+// sdNotify sends a state update (e.g. "READY=1") to systemd's notification socket
+// It does nothing when socket is empty, i.e. when not run by a Type=notify unit
+func sdNotify(socket, state string) error {
+	if socket == "" {
+		return nil
+	}
+
+	// An abstract socket name starts with '@', which net handles for us
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to notify socket: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %v", err)
+	}
+	return nil
+}
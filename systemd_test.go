@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSystemdUnit verifies the generated unit runs the container in the foreground with its options
+func TestSystemdUnit(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot("/var/lib/gocker")
+
+	state := &ContainerState{
+		ID: "0123456789abcdef",
+		Options: &RunOptions{
+			CPULimit:    "0.5",
+			MemoryLimit: "256M",
+			Volumes:     []string{"/srv/data:/data"},
+			Detached:    true,
+			RootfsPath:  "/opt/rootfs",
+			Command:     []string{"/bin/sh", "-c", "echo 100% $HOME"},
+		},
+	}
+
+	unit, err := systemdUnit(state, "/usr/local/bin/gocker", "always")
+	if err != nil {
+		t.Fatalf("systemdUnit failed: %v", err)
+	}
+
+	wantLines := []string{
+		"Type=notify",
+		"Restart=always",
+		`ExecStart=/usr/local/bin/gocker --data-root /var/lib/gocker run --cpu-limit 0.5 --memory-limit 256M --volume /srv/data:/data --rootfs /opt/rootfs -- /bin/sh -c "echo 100%% $$HOME"`,
+	}
+	for _, line := range wantLines {
+		if !strings.Contains(unit, line+"\n") {
+			t.Errorf("unit is missing line %q:\n%s", line, unit)
+		}
+	}
+	if strings.Contains(unit, "--detach") {
+		t.Errorf("unit must run the container in the foreground:\n%s", unit)
+	}
+
+	if _, err := systemdUnit(state, "/usr/local/bin/gocker", "sometimes"); err == nil {
+		t.Error("Expected error for an invalid restart policy")
+	}
+}
+
+// TestSystemdQuote verifies ExecStart= argument quoting
+func TestSystemdQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"/bin/sh", "/bin/sh"},
+		{"", `""`},
+		{"a b", `"a b"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{"50%", "50%%"},
+		{"$PATH", "$$PATH"},
+	}
+
+	for _, tt := range tests {
+		if got := systemdQuote(tt.arg); got != tt.want {
+			t.Errorf("systemdQuote(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+}
+
+// TestSdNotify verifies readiness messages reach the notification socket
+func TestSdNotify(t *testing.T) {
+	if err := sdNotify("", "READY=1"); err != nil {
+		t.Errorf("sdNotify without a socket should be a no-op, got %v", err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("cannot create unixgram socket: %v", err)
+	}
+	defer conn.Close()
+
+	if err := sdNotify(socket, "READY=1"); err != nil {
+		t.Fatalf("sdNotify failed: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read notification: %v", err)
+	}
+	if got := string(buf[:n]); got != "READY=1" {
+		t.Errorf("Expected READY=1, got %q", got)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var templatesDir = "/var/lib/gocker/templates"
+
+// templateNamePattern restricts template names to safe file names
+var templateNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ContainerTemplate is a named, saved run configuration
+type ContainerTemplate struct {
+	Name      string      `json:"name"`
+	CreatedAt time.Time   `json:"created_at"`
+	Options   *RunOptions `json:"options"`
+}
+
+// templateCommands lists the 'gocker template' subcommands
+func templateCommands() []*command {
+	return []*command{
+		{name: "save", description: "Save a run configuration as a template", run: templateSaveCommand},
+		{name: "ls", description: "List saved templates", run: templateListCommand},
+		{name: "run", description: "Run a container from a template", run: templateRunCommand},
+		{name: "rm", description: "Remove a template", run: templateRemoveCommand},
+	}
+}
+
+// templateCommand dispatches the 'gocker template' subcommands
+func templateCommand(args []string) {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		printTemplateUsage()
+		if len(args) == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, cmd := range templateCommands() {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	fmt.Printf("Unknown template command: %s\n", args[0])
+	printTemplateUsage()
+	os.Exit(1)
+}
+
+func printTemplateUsage() {
+	fmt.Println("Usage: gocker template <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range templateCommands() {
+		fmt.Printf("  %-6s %s\n", cmd.name, cmd.description)
+	}
+}
+
+func templateSaveCommand(args []string) {
+	configure := func(flags *commandFlags) {
+		flags.name = "template save"
+		flags.usage = "<name> [run options] <command> [args...]"
+		flags.description = "Save a run configuration as a template"
+	}
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		_, flags := parseRunFlags(args, configure)
+		flags.Fail("template name required")
+	}
+	name := args[0]
+	opts, flags := parseRunFlags(args[1:], configure)
+	if len(opts.Command) == 0 {
+		flags.Fail("command required")
+	}
+
+	requireRoot()
+	must(saveTemplate(&ContainerTemplate{Name: name, CreatedAt: time.Now(), Options: opts}))
+	fmt.Printf("Template %s saved\n", name)
+}
+
+func templateListCommand(args []string) {
+	flags := newCommandFlags("template ls", "", "List saved templates")
+	if len(flags.MustParse(args)) != 0 {
+		flags.Fail("template ls does not accept arguments")
+	}
+	requireRoot()
+	listTemplates()
+}
+
+func templateRunCommand(args []string) {
+	flags := newCommandFlags("template run", "<name> [command] [args...]", "Run a container from a template")
+	args = flags.MustParse(args)
+	if len(args) == 0 {
+		flags.Fail("template name required")
+	}
+
+	requireRoot()
+	tmpl, err := loadTemplate(args[0])
+	must(err)
+	// A command given on the command line replaces the saved one
+	if len(args) > 1 {
+		tmpl.Options.Command = args[1:]
+	}
+	runContainer(tmpl.Options)
+}
+
+func templateRemoveCommand(args []string) {
+	flags := newCommandFlags("template rm", "<name>", "Remove a template")
+	flags.interspersed = true
+	names := flags.MustParse(args)
+	if len(names) != 1 {
+		flags.Fail("template name required")
+	}
+
+	requireRoot()
+	must(removeTemplate(names[0]))
+	fmt.Printf("Template %s removed\n", names[0])
+}
+
+// templatePath returns the file path for a template, validating its name
+func templatePath(name string) (string, error) {
+	if !templateNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid template name: %s (use letters, digits, '_', '.', '-')", name)
+	}
+	return filepath.Join(templatesDir, name+".json"), nil
+}
+
+// saveTemplate writes a template to disk, replacing any template with the same name
+func saveTemplate(tmpl *ContainerTemplate) error {
+	path, err := templatePath(tmpl.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(templatesDir, 0755); err != nil {
+		return fmt.Errorf("failed to create templates directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(tmpl, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal template: %v", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write template: %v", err)
+	}
+	return nil
+}
+
+// loadTemplate reads a template from disk by name
+func loadTemplate(name string) (*ContainerTemplate, error) {
+	path, err := templatePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("template not found: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %v", err)
+	}
+
+	var tmpl ContainerTemplate
+	if err := json.Unmarshal(data, &tmpl); err != nil {
+		return nil, fmt.Errorf("failed to parse template: %v", err)
+	}
+	if tmpl.Options == nil || len(tmpl.Options.Command) == 0 {
+		return nil, fmt.Errorf("template %s has no command", name)
+	}
+	return &tmpl, nil
+}
+
+// removeTemplate deletes a saved template
+func removeTemplate(name string) error {
+	path, err := templatePath(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("template not found: %s", name)
+		}
+		return fmt.Errorf("failed to remove template: %v", err)
+	}
+	return nil
+}
+
+// loadAllTemplates returns all saved templates
+func loadAllTemplates() ([]*ContainerTemplate, error) {
+	files, err := os.ReadDir(templatesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read templates directory: %v", err)
+	}
+
+	var templates []*ContainerTemplate
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		tmpl, err := loadTemplate(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		templates = append(templates, tmpl)
+	}
+	return templates, nil
+}
+
+func listTemplates() {
+	templates, err := loadAllTemplates()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+
+	if len(templates) == 0 {
+		fmt.Println("No templates found")
+		return
+	}
+
+	fmt.Printf("%-20s %-20s %-12s %-12s %s\n", "NAME", "CREATED", "CPU", "MEMORY", "COMMAND")
+	fmt.Println(strings.Repeat("-", 100))
+
+	for _, tmpl := range templates {
+		cpu := tmpl.Options.CPULimit
+		if cpu == "" {
+			cpu = "-"
+		}
+		memory := tmpl.Options.MemoryLimit
+		if memory == "" {
+			memory = "-"
+		}
+
+		command := strings.Join(tmpl.Options.Command, " ")
+		if len(command) > 30 {
+			command = command[:27] + "..."
+		}
+
+		created := tmpl.CreatedAt.Format("2006-01-02 15:04:05")
+		fmt.Printf("%-20s %-20s %-12s %-12s %s\n", tmpl.Name, created, cpu, memory, command)
+	}
+}
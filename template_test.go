@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTemplateRoundTrip verifies templates can be saved, loaded, and removed
+func TestTemplateRoundTrip(t *testing.T) {
+	name := "test-template-" + time.Now().Format("20060102150405")
+	opts, err := parseRunArgs([]string{"--memory-limit", "256M", "-v", "/tmp:/mnt/tmp", "/bin/busybox", "ls"})
+	if err != nil {
+		t.Fatalf("Failed to parse run options: %v", err)
+	}
+	tmpl := &ContainerTemplate{Name: name, CreatedAt: time.Now(), Options: opts}
+
+	if err := saveTemplate(tmpl); err != nil {
+		t.Fatalf("Failed to save template: %v", err)
+	}
+	defer removeTemplate(name)
+
+	loaded, err := loadTemplate(name)
+	if err != nil {
+		t.Fatalf("Failed to load template: %v", err)
+	}
+	if loaded.Options.MemoryLimit != "256M" {
+		t.Errorf("Expected memory limit 256M, got %q", loaded.Options.MemoryLimit)
+	}
+	if strings.Join(loaded.Options.Command, " ") != "/bin/busybox ls" {
+		t.Errorf("Unexpected command: %v", loaded.Options.Command)
+	}
+
+	if err := removeTemplate(name); err != nil {
+		t.Fatalf("Failed to remove template: %v", err)
+	}
+	if _, err := loadTemplate(name); err == nil {
+		t.Errorf("Expected error loading removed template, got nil")
+	}
+}
+
+// TestTemplateNameValidation verifies unsafe template names are rejected
+func TestTemplateNameValidation(t *testing.T) {
+	for _, name := range []string{"", "../etc", "a/b", ".hidden"} {
+		if _, err := templatePath(name); err == nil {
+			t.Errorf("templatePath(%q): expected error, got nil", name)
+		}
+	}
+	if _, err := templatePath("web-server_1.0"); err != nil {
+		t.Errorf("templatePath(web-server_1.0): unexpected error: %v", err)
+	}
+}
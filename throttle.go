@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// throttleWarnRatio is the share of CPU periods a container may be throttled
+// in before gocker warns that its --cpu-limit is holding it back
+var throttleWarnRatio = 0.25
+
+// CPUThrottling counts the scheduler periods of a container with a CPU limit:
+// Periods is how many periods the container was runnable in, Throttled how
+// many of them it used up its quota in and had to wait for the next one
+type CPUThrottling struct {
+	Periods       int64 `json:"periods"`
+	Throttled     int64 `json:"throttled"`
+	ThrottledUsec int64 `json:"throttled_usec"` // total time spent waiting
+}
+
+// Ratio returns the share of periods the container was throttled in
+func (t CPUThrottling) Ratio() float64 {
+	if t.Periods == 0 {
+		return 0
+	}
+	return float64(t.Throttled) / float64(t.Periods)
+}
+
+// since returns the throttling between an earlier sample and t
+func (t CPUThrottling) since(prev CPUThrottling) CPUThrottling {
+	return CPUThrottling{
+		Periods:       t.Periods - prev.Periods,
+		Throttled:     t.Throttled - prev.Throttled,
+		ThrottledUsec: t.ThrottledUsec - prev.ThrottledUsec,
+	}
+}
+
+// throttleMonitor decides when a sampled container has become heavily throttled
+// It warns once when throttling crosses throttleWarnRatio and again only
+// after it has dropped back below
+type throttleMonitor struct {
+	last   *CPUThrottling
+	warned bool
+}
+
+// observe records a sample and returns the throttling since the previous one,
+// and whether to warn about it
+func (m *throttleMonitor) observe(sample CPUThrottling) (CPUThrottling, bool) {
+	last := m.last
+	m.last = &sample
+	if last == nil {
+		return CPUThrottling{}, false
+	}
+	interval := sample.since(*last)
+	heavy := interval.Ratio() >= throttleWarnRatio
+	warn := heavy && !m.warned
+	m.warned = heavy
+	return interval, warn
+}
+
+// watchThrottling samples a container's CPU throttling until stop is closed,
+// logging a warning and sending a throttle event when it becomes heavy
+func watchThrottling(containerID string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var monitor throttleMonitor
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			state, err := readContainerState(containerID)
+			if err != nil || state.CgroupPath == "" {
+				continue
+			}
+			stats, err := cgroups.Stats(state.CgroupPath)
+			if err != nil || stats.CPUThrottling == nil {
+				continue
+			}
+			throttling, warn := monitor.observe(*stats.CPUThrottling)
+			if !warn {
+				continue
+			}
+			logger.Warn("Container is being CPU throttled; its CPU limit may be too low",
+				"container", shortID(containerID), "throttled", formatRatio(throttling.Ratio()),
+				"waited", time.Duration(throttling.ThrottledUsec)*time.Microsecond, "over", interval)
+			emitEvent(eventThrottle, state)
+		}
+	}
+}
+
+// formatRatio formats a share as a percentage
+func formatRatio(ratio float64) string {
+	return fmt.Sprintf("%.0f%%", ratio*100)
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+// TestThrottleMonitor verifies a warning is given once each time throttling
+// over an interval crosses throttleWarnRatio
+func TestThrottleMonitor(t *testing.T) {
+	var m throttleMonitor
+	samples := []struct {
+		sample CPUThrottling
+		ratio  float64
+		warn   bool
+	}{
+		{CPUThrottling{Periods: 100, Throttled: 90, ThrottledUsec: 9000}, 0, false}, // no interval yet
+		{CPUThrottling{Periods: 200, Throttled: 100, ThrottledUsec: 10000}, 0.1, false},
+		{CPUThrottling{Periods: 300, Throttled: 150}, 0.5, true},
+		{CPUThrottling{Periods: 400, Throttled: 200}, 0.5, false}, // still heavy, already warned
+		{CPUThrottling{Periods: 500, Throttled: 210}, 0.1, false},
+		{CPUThrottling{Periods: 600, Throttled: 240}, 0.3, true},
+		{CPUThrottling{Periods: 600, Throttled: 240}, 0, false}, // idle
+	}
+	for i, s := range samples {
+		interval, warn := m.observe(s.sample)
+		if interval.Ratio() != s.ratio || warn != s.warn {
+			t.Errorf("Sample %d: ratio %v, warn %v; want %v, %v", i, interval.Ratio(), warn, s.ratio, s.warn)
+		}
+	}
+}
+
+func TestCPUThrottling(t *testing.T) {
+	if got := cpuThrottling("usage_usec 2500\nuser_usec 2000\nsystem_usec 500\n", "throttled_usec", 1); got != nil {
+		t.Errorf("Expected no throttling without a CPU limit, got %+v", got)
+	}
+	got := cpuThrottling("nr_periods 40\nnr_throttled 10\nthrottled_time 3000000\n", "throttled_time", 1000)
+	if got == nil || *got != (CPUThrottling{Periods: 40, Throttled: 10, ThrottledUsec: 3000}) || got.Ratio() != 0.25 {
+		t.Errorf("Unexpected v1 throttling: %+v", got)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// straceBinary is the tracer used by --trace, a path or a name looked up in PATH
+var straceBinary = "strace"
+
+// traceFileName is the file in a container's directory holding its trace
+const traceFileName = "trace.log"
+
+// traceAttachTimeout bounds how long gocker waits for strace to attach
+const traceAttachTimeout = 5 * time.Second
+
+// containerTraceFile returns the path of a container's syscall trace
+func containerTraceFile(containerID string) string {
+	return filepath.Join(containerDir(containerID), traceFileName)
+}
+
+// findStrace returns the path of the strace binary
+func findStrace() (string, error) {
+	path, err := exec.LookPath(straceBinary)
+	if err != nil {
+		return "", fmt.Errorf("--trace requires strace on the host: %v", err)
+	}
+	return path, nil
+}
+
+// straceArgs returns the arguments that trace pid and every process it starts
+// into file, with timestamps, syscall durations and longer strings than the
+// default
+func straceArgs(file string, pid int) []string {
+	return []string{"-f", "-tt", "-T", "-s", "256", "-qq", "-o", file, "-p", strconv.Itoa(pid)}
+}
+
+// startTrace attaches strace to the container process pid, returning once it
+// is traced so that nothing the process does afterwards is missed
+// strace runs in its own session and exits when the last traced process does
+func startTrace(strace, containerID string, pid int) (*exec.Cmd, error) {
+	cmd := exec.Command(strace, straceArgs(containerTraceFile(containerID), pid)...)
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	newSession(cmd.SysProcAttr, false)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start strace: %v", err)
+	}
+
+	deadline := time.Now().Add(traceAttachTimeout)
+	for time.Now().Before(deadline) {
+		status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+		if err == nil && parseTracerPID(string(status)) != 0 {
+			return cmd, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	return nil, fmt.Errorf("strace did not attach to the container within %s", traceAttachTimeout)
+}
+
+// parseTracerPID returns the TracerPid of a /proc/<pid>/status file, 0 when
+// the process is not traced
+func parseTracerPID(status string) int {
+	for _, line := range strings.Split(status, "\n") {
+		if value, ok := strings.CutPrefix(line, "TracerPid:"); ok {
+			pid, _ := strconv.Atoi(strings.TrimSpace(value))
+			return pid
+		}
+	}
+	return 0
+}
+
+func debugTraceCommand(args []string) {
+	flags := newCommandFlags("debug trace", "<container-id>", "Show the syscall trace of a container run with --trace")
+	flags.interspersed = true
+	ids := flags.MustParse(args)
+	if len(ids) != 1 {
+		flags.Fail("container ID required")
+	}
+	requireRoot()
+
+	state, err := loadContainerState(ids[0])
+	must(err)
+	f, err := os.Open(containerTraceFile(state.ID))
+	if os.IsNotExist(err) {
+		must(fmt.Errorf("container %s has no trace; run it with --trace", shortID(state.ID)))
+	}
+	must(err)
+	defer f.Close()
+	_, err = io.Copy(os.Stdout, f)
+	must(err)
+}
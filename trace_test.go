@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseTracerPID verifies the tracer is read from /proc/<pid>/status
+func TestParseTracerPID(t *testing.T) {
+	status := "Name:\tsh\nState:\tS (sleeping)\nPid:\t4242\nPPid:\t1\nTracerPid:\t5151\nUid:\t0\t0\t0\t0\n"
+	if got := parseTracerPID(status); got != 5151 {
+		t.Errorf("parseTracerPID = %d, want 5151", got)
+	}
+	if got := parseTracerPID(strings.Replace(status, "5151", "0", 1)); got != 0 {
+		t.Errorf("parseTracerPID of an untraced process = %d, want 0", got)
+	}
+}
+
+// TestStraceArgs verifies the trace follows children into the container's file
+func TestStraceArgs(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot("/var/lib/gocker")
+
+	file := containerTraceFile("abc123")
+	if file != filepath.Join("/var/lib/gocker/containers/abc123", traceFileName) {
+		t.Errorf("containerTraceFile = %s", file)
+	}
+	want := []string{"-f", "-tt", "-T", "-s", "256", "-qq", "-o", file, "-p", "4242"}
+	if got := straceArgs(file, 4242); !reflect.DeepEqual(got, want) {
+		t.Errorf("straceArgs = %q, want %q", got, want)
+	}
+}
+
+// TestFindStrace verifies a missing strace is reported with the option needing it
+func TestFindStrace(t *testing.T) {
+	saved := straceBinary
+	t.Cleanup(func() { straceBinary = saved })
+	straceBinary = filepath.Join(t.TempDir(), "strace")
+
+	_, err := findStrace()
+	if err == nil || !strings.Contains(err.Error(), "--trace") {
+		t.Errorf("Expected an error naming --trace, got %v", err)
+	}
+}
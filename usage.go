@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// usageFile holds the usage records of current and removed containers
+var usageFile = "/var/lib/gocker/usage.json"
+
+// usageLimit bounds how many containers' usage is kept; export it regularly
+// on hosts that run more containers than that
+const usageLimit = 5000
+
+// usageSampleInterval is how often a foreground 'gocker run' samples its
+// container's usage, so traffic is counted before the network goes away
+const usageSampleInterval = 10 * time.Second
+
+// UsageRecord is the resource usage a container has accumulated
+// Counters are cumulative over the container's life and only grow; each
+// sample keeps the largest value seen, as the cgroup and veth that hold them
+// disappear once the container is cleaned up
+type UsageRecord struct {
+	ID           string            `json:"id"`
+	User         string            `json:"user,omitempty"`
+	Labels       map[string]string `json:"labels"`
+	Command      []string          `json:"command"`
+	CreatedAt    time.Time         `json:"created_at"`
+	FinishedAt   *time.Time        `json:"finished_at,omitempty"`
+	CPUUsageUsec int64             `json:"cpu_usage_usec"`
+	MemoryPeak   int64             `json:"memory_peak"`
+	RxBytes      int64             `json:"rx_bytes"`
+	TxBytes      int64             `json:"tx_bytes"`
+	SampledAt    time.Time         `json:"sampled_at"`
+}
+
+// runtime is the wall-clock time the container ran, until now if it still is
+func (r *UsageRecord) runtime(now time.Time) time.Duration {
+	if r.FinishedAt != nil {
+		now = *r.FinishedAt
+	}
+	if now.Before(r.CreatedAt) {
+		return 0
+	}
+	return now.Sub(r.CreatedAt)
+}
+
+// invokingUser is the user creating a container: the one behind sudo if any
+func invokingUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return strconv.Itoa(os.Getuid())
+}
+
+// loadUsage reads the usage records, keyed by container ID
+func loadUsage() (map[string]*UsageRecord, error) {
+	records := make(map[string]*UsageRecord)
+	data, err := os.ReadFile(usageFile)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage: %v", err)
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse usage: %v", err)
+	}
+	return records, nil
+}
+
+// sampleUsage adds a container's current counters to its usage record
+// Whatever is gone (a removed cgroup, a destroyed veth) keeps its last sample
+func sampleUsage(state *ContainerState) error {
+	usage := &UsageRecord{SampledAt: time.Now()}
+	if state.CgroupPath != "" {
+		if stats, err := cgroups.Stats(state.CgroupPath); err == nil {
+			usage.CPUUsageUsec = stats.CPUUsageUsec
+			usage.MemoryPeak = stats.MemoryPeak
+			if stats.MemoryUsage > usage.MemoryPeak {
+				usage.MemoryPeak = stats.MemoryUsage
+			}
+		}
+	}
+	for _, iface := range networkStats(state) {
+		usage.RxBytes += iface.RxBytes
+		usage.TxBytes += iface.TxBytes
+	}
+
+	lock, err := lockGlobal("usage")
+	if err != nil {
+		return err
+	}
+	defer unlockContainer(lock)
+
+	records, err := loadUsage()
+	if err != nil {
+		return err
+	}
+	record := records[state.ID]
+	if record == nil {
+		record = &UsageRecord{ID: state.ID}
+		records[state.ID] = record
+	}
+	record.User = state.Owner
+	record.Command = state.Command
+	record.CreatedAt = state.CreatedAt
+	record.Labels = keyValueMap(nil)
+	if state.Options != nil {
+		record.Labels = keyValueMap(state.Options.Labels)
+	}
+	record.CPUUsageUsec = max(record.CPUUsageUsec, usage.CPUUsageUsec)
+	record.MemoryPeak = max(record.MemoryPeak, usage.MemoryPeak)
+	record.RxBytes = max(record.RxBytes, usage.RxBytes)
+	record.TxBytes = max(record.TxBytes, usage.TxBytes)
+	record.SampledAt = usage.SampledAt
+	if state.FinishedAt != nil {
+		record.FinishedAt = state.FinishedAt
+	} else if record.FinishedAt == nil && !(isActive(state.Status) && isProcessAlive(state)) {
+		record.FinishedAt = &usage.SampledAt
+	}
+	pruneUsage(records)
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage: %v", err)
+	}
+	if err := writeFileAtomic(usageFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write usage: %v", err)
+	}
+	return nil
+}
+
+// recordUsage samples a container's usage before its resources are released,
+// logging rather than failing, as the container must be cleaned up anyway
+func recordUsage(state *ContainerState) {
+	if err := sampleUsage(state); err != nil {
+		logger.Warn("Failed to record container usage", "id", shortID(state.ID), "error", err)
+	}
+}
+
+// sampleUsageEvery samples a container's usage until stop is closed
+func sampleUsageEvery(containerID string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if state, err := readContainerState(containerID); err == nil {
+				recordUsage(state)
+			}
+		}
+	}
+}
+
+// pruneUsage drops the records of the longest finished containers beyond usageLimit
+func pruneUsage(records map[string]*UsageRecord) {
+	if len(records) <= usageLimit {
+		return
+	}
+	var finished []*UsageRecord
+	for _, record := range records {
+		if record.FinishedAt != nil {
+			finished = append(finished, record)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(*finished[j].FinishedAt) })
+	for _, record := range finished {
+		if len(records) <= usageLimit {
+			break
+		}
+		delete(records, record.ID)
+	}
+}
+
+// parseSince parses a --since value: a duration before now (e.g. 24h), a
+// date (2006-01-02), or an RFC 3339 time
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since value: %s (expected a duration like 24h, a date like 2006-01-02, or an RFC 3339 time)", value)
+}
+
+// UsageTotal is the usage of a group of containers, for --group-by
+type UsageTotal struct {
+	Group          string  `json:"group"`
+	Containers     int     `json:"containers"`
+	CPUUsageUsec   int64   `json:"cpu_usage_usec"`
+	MemoryPeak     int64   `json:"memory_peak"` // the largest of the containers' peaks
+	RxBytes        int64   `json:"rx_bytes"`
+	TxBytes        int64   `json:"tx_bytes"`
+	RuntimeSeconds float64 `json:"runtime_seconds"`
+}
+
+// usageGroup returns the group a record belongs to for a --group-by value:
+// "user" or "label:KEY"
+func usageGroup(record *UsageRecord, groupBy string) string {
+	if groupBy == "user" {
+		return record.User
+	}
+	return record.Labels[strings.TrimPrefix(groupBy, "label:")]
+}
+
+// totalUsage sums records per group, sorted by group
+func totalUsage(records []*UsageRecord, groupBy string, now time.Time) []UsageTotal {
+	byGroup := make(map[string]*UsageTotal)
+	var groups []string
+	for _, record := range records {
+		group := usageGroup(record, groupBy)
+		total := byGroup[group]
+		if total == nil {
+			total = &UsageTotal{Group: group}
+			byGroup[group] = total
+			groups = append(groups, group)
+		}
+		total.Containers++
+		total.CPUUsageUsec += record.CPUUsageUsec
+		total.MemoryPeak = max(total.MemoryPeak, record.MemoryPeak)
+		total.RxBytes += record.RxBytes
+		total.TxBytes += record.TxBytes
+		total.RuntimeSeconds += record.runtime(now).Seconds()
+	}
+	sort.Strings(groups)
+	totals := make([]UsageTotal, 0, len(groups))
+	for _, group := range groups {
+		totals = append(totals, *byGroup[group])
+	}
+	return totals
+}
+
+func usageCommand(args []string) {
+	var since, format, groupBy string
+	flags := newCommandFlags("usage", "[options]", "Show the resources containers have used, including removed ones")
+	flags.StringVar(&since, "since", "", "TIME", "Only containers running at or after TIME (e.g. 24h, 2006-01-02)")
+	flags.StringVar(&format, "format", "", "FORMAT", "Output format: table (default), csv or json")
+	flags.StringVar(&groupBy, "group-by", "", "KEY", "Total the usage per 'user' or per 'label:NAME'")
+	if len(flags.MustParse(args)) > 0 {
+		flags.Fail("usage does not accept arguments")
+	}
+	if format == "" {
+		format = "table"
+	}
+	if format != "table" && format != "csv" && format != "json" {
+		flags.Fail("invalid --format: " + format + " (expected table, csv or json)")
+	}
+	if groupBy != "" && groupBy != "user" && (!strings.HasPrefix(groupBy, "label:") || groupBy == "label:") {
+		flags.Fail("invalid --group-by: " + groupBy + " (expected 'user' or 'label:NAME')")
+	}
+	now := time.Now()
+	var from time.Time
+	if since != "" {
+		var err error
+		if from, err = parseSince(since, now); err != nil {
+			flags.Fail(err.Error())
+		}
+	}
+	requireRoot()
+
+	// Bring the running containers' counters up to date first
+	for _, id := range matchingContainerIDs(func(state *ContainerState) bool {
+		return isActive(state.Status) && isProcessAlive(state)
+	}) {
+		if state, err := readContainerState(id); err == nil {
+			recordUsage(state)
+		}
+	}
+
+	all, err := loadUsage()
+	must(err)
+	var records []*UsageRecord
+	for _, record := range all {
+		if record.FinishedAt == nil || !record.FinishedAt.Before(from) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+
+	if groupBy != "" {
+		printUsageTotals(totalUsage(records, groupBy, now), format)
+		return
+	}
+	printUsageRecords(records, format, now)
+}
+
+// printUsageRecords prints one row per container
+func printUsageRecords(records []*UsageRecord, format string, now time.Time) {
+	switch format {
+	case "json":
+		type usageExport struct {
+			*UsageRecord
+			RuntimeSeconds float64 `json:"runtime_seconds"`
+		}
+		export := []usageExport{}
+		for _, record := range records {
+			export = append(export, usageExport{record, record.runtime(now).Seconds()})
+		}
+		data, err := json.MarshalIndent(export, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"id", "user", "labels", "command", "created_at", "finished_at", "runtime_seconds", "cpu_usage_usec", "memory_peak", "rx_bytes", "tx_bytes"})
+		for _, record := range records {
+			finished := ""
+			if record.FinishedAt != nil {
+				finished = record.FinishedAt.Format(time.RFC3339)
+			}
+			w.Write([]string{record.ID, record.User, formatLabels(record.Labels), strings.Join(record.Command, " "),
+				record.CreatedAt.Format(time.RFC3339), finished, formatSeconds(record.runtime(now)),
+				strconv.FormatInt(record.CPUUsageUsec, 10), strconv.FormatInt(record.MemoryPeak, 10),
+				strconv.FormatInt(record.RxBytes, 10), strconv.FormatInt(record.TxBytes, 10)})
+		}
+		w.Flush()
+		must(w.Error())
+	default:
+		if len(records) == 0 {
+			fmt.Println("No container usage recorded")
+			return
+		}
+		fmt.Printf("%-14s %-12s %-10s %-10s %-10s %-18s %s\n", "CONTAINER ID", "USER", "RUNTIME", "CPU TIME", "PEAK MEM", "NET RX / TX", "LABELS")
+		fmt.Println(strings.Repeat("-", 100))
+		for _, record := range records {
+			fmt.Printf("%-14s %-12s %-10s %-10s %-10s %-18s %s\n", shortID(record.ID), record.User,
+				record.runtime(now).Round(time.Second), (time.Duration(record.CPUUsageUsec) * time.Microsecond).Round(time.Millisecond),
+				formatMemory(record.MemoryPeak), formatMemory(record.RxBytes)+" / "+formatMemory(record.TxBytes), formatLabels(record.Labels))
+		}
+	}
+}
+
+// printUsageTotals prints one row per --group-by group
+func printUsageTotals(totals []UsageTotal, format string) {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(totals, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"group", "containers", "runtime_seconds", "cpu_usage_usec", "memory_peak", "rx_bytes", "tx_bytes"})
+		for _, total := range totals {
+			w.Write([]string{total.Group, strconv.Itoa(total.Containers), strconv.FormatFloat(total.RuntimeSeconds, 'f', 0, 64),
+				strconv.FormatInt(total.CPUUsageUsec, 10), strconv.FormatInt(total.MemoryPeak, 10),
+				strconv.FormatInt(total.RxBytes, 10), strconv.FormatInt(total.TxBytes, 10)})
+		}
+		w.Flush()
+		must(w.Error())
+	default:
+		if len(totals) == 0 {
+			fmt.Println("No container usage recorded")
+			return
+		}
+		fmt.Printf("%-20s %-10s %-12s %-12s %-10s %s\n", "GROUP", "CONTAINERS", "RUNTIME", "CPU TIME", "PEAK MEM", "NET RX / TX")
+		fmt.Println(strings.Repeat("-", 90))
+		for _, total := range totals {
+			group := total.Group
+			if group == "" {
+				group = "-"
+			}
+			fmt.Printf("%-20s %-10d %-12s %-12s %-10s %s\n", group, total.Containers,
+				(time.Duration(total.RuntimeSeconds) * time.Second).String(), (time.Duration(total.CPUUsageUsec) * time.Microsecond).Round(time.Millisecond),
+				formatMemory(total.MemoryPeak), formatMemory(total.RxBytes)+" / "+formatMemory(total.TxBytes))
+		}
+	}
+}
+
+// formatLabels formats labels as sorted KEY=VALUE pairs separated by commas
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// formatSeconds formats a duration as whole seconds
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d.Round(time.Second)/time.Second), 10)
+}
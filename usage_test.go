@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSampleUsage verifies counters only grow and survive the cgroup going away
+func TestSampleUsage(t *testing.T) {
+	restoreRuntimeSettings(t)
+	setDataRoot(t.TempDir())
+	savedCgroups := cgroups
+	t.Cleanup(func() { cgroups = savedCgroups })
+	cgroups = &cgroupV2{}
+
+	cgroup := filepath.Join(t.TempDir(), "abcdef0123456789")
+	os.MkdirAll(cgroup, 0755)
+	writeCgroup := func(current, peak, cpu string) {
+		os.WriteFile(filepath.Join(cgroup, "memory.current"), []byte(current), 0644)
+		os.WriteFile(filepath.Join(cgroup, "memory.peak"), []byte(peak), 0644)
+		os.WriteFile(filepath.Join(cgroup, "cpu.stat"), []byte("usage_usec "+cpu+"\n"), 0644)
+	}
+
+	created := time.Now().Add(-time.Hour)
+	state := &ContainerState{
+		ID:         "abcdef0123456789",
+		Status:     statusExited,
+		CreatedAt:  created,
+		Command:    []string{"/bin/sh"},
+		CgroupPath: cgroup,
+		Owner:      "alice",
+		Options:    &RunOptions{Labels: []string{"team=web"}},
+	}
+	writeCgroup("1024", "4096", "2000000")
+	if err := sampleUsage(state); err != nil {
+		t.Fatalf("sampleUsage failed: %v", err)
+	}
+	os.RemoveAll(cgroup)
+	if err := sampleUsage(state); err != nil {
+		t.Fatalf("sampleUsage failed: %v", err)
+	}
+
+	records, err := loadUsage()
+	if err != nil {
+		t.Fatalf("loadUsage failed: %v", err)
+	}
+	record := records[state.ID]
+	if record == nil {
+		t.Fatalf("Expected a usage record, got %v", records)
+	}
+	if record.CPUUsageUsec != 2000000 || record.MemoryPeak != 4096 || record.User != "alice" || record.Labels["team"] != "web" {
+		t.Errorf("Unexpected usage record: %+v", record)
+	}
+	// An exited container's record is closed
+	if record.FinishedAt == nil || record.runtime(time.Now()) < time.Hour {
+		t.Errorf("Expected a finished record of at least an hour, got %+v", record)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"24h", now.Add(-24 * time.Hour)},
+		{"2026-10-01T08:00:00Z", time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)},
+		{"2026-10-01", time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.value, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+	for _, value := range []string{"yesterday", "-1h", ""} {
+		if _, err := parseSince(value, now); err == nil {
+			t.Errorf("parseSince(%q): expected error", value)
+		}
+	}
+}
+
+func TestTotalUsage(t *testing.T) {
+	now := time.Now()
+	finished := now.Add(-time.Hour)
+	records := []*UsageRecord{
+		{ID: "a", User: "alice", Labels: map[string]string{"team": "web"}, CreatedAt: now.Add(-3 * time.Hour), FinishedAt: &finished, CPUUsageUsec: 100, MemoryPeak: 10, RxBytes: 1, TxBytes: 2},
+		{ID: "b", User: "bob", Labels: map[string]string{"team": "web"}, CreatedAt: now.Add(-time.Hour), FinishedAt: &finished, CPUUsageUsec: 50, MemoryPeak: 30, RxBytes: 3, TxBytes: 4},
+		{ID: "c", User: "alice", Labels: map[string]string{}, CreatedAt: now.Add(-3 * time.Hour), FinishedAt: &finished, CPUUsageUsec: 7},
+	}
+
+	byUser := totalUsage(records, "user", now)
+	want := []UsageTotal{
+		{Group: "alice", Containers: 2, CPUUsageUsec: 107, MemoryPeak: 10, RxBytes: 1, TxBytes: 2, RuntimeSeconds: 4 * 3600},
+		{Group: "bob", Containers: 1, CPUUsageUsec: 50, MemoryPeak: 30, RxBytes: 3, TxBytes: 4},
+	}
+	if !reflect.DeepEqual(byUser, want) {
+		t.Errorf("totalUsage by user = %+v, want %+v", byUser, want)
+	}
+
+	byTeam := totalUsage(records, "label:team", now)
+	if len(byTeam) != 2 || byTeam[0].Group != "" || byTeam[1].Group != "web" || byTeam[1].Containers != 2 || byTeam[1].MemoryPeak != 30 {
+		t.Errorf("Unexpected totals by label: %+v", byTeam)
+	}
+}
+
+func TestPruneUsage(t *testing.T) {
+	records := make(map[string]*UsageRecord)
+	start := time.Now()
+	for i := 0; i < usageLimit+2; i++ {
+		finished := start.Add(time.Duration(i) * time.Second)
+		id := filepath.Join("id", time.Duration(i).String())
+		records[id] = &UsageRecord{ID: id, FinishedAt: &finished}
+	}
+	records["running"] = &UsageRecord{ID: "running"}
+
+	pruneUsage(records)
+	if len(records) != usageLimit {
+		t.Errorf("Expected %d records, got %d", usageLimit, len(records))
+	}
+	for _, id := range []string{"id/0s", "id/1ns", "id/2ns"} {
+		if _, ok := records[id]; ok {
+			t.Errorf("Expected oldest record %s to be pruned", id)
+		}
+	}
+	if _, ok := records["running"]; !ok {
+		t.Error("Expected the running container's record to be kept")
+	}
+}
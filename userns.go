@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// subuidFile and subgidFile list the subordinate ID ranges each user may map
+var (
+	subuidFile = "/etc/subuid"
+	subgidFile = "/etc/subgid"
+)
+
+// usernsRemap is the default --userns-remap for containers started as root
+// (see Config.UsernsRemap); empty runs them without a user namespace
+var usernsRemap = ""
+
+// usernsRangeSize is how many IDs a remapped container gets, enough for every
+// user and group a distribution's root filesystem uses
+const usernsRangeSize = 65536
+
+// idMapping maps IDs 0 to Size-1 inside a user namespace to HostID onwards
+type idMapping struct {
+	HostID int
+	Size   int
+}
+
+// userNamespace holds the ID mappings of a container's user namespace
+type userNamespace struct {
+	UIDs idMapping
+	GIDs idMapping
+}
+
+// containerUserNamespace returns the user namespace for a new container, or
+// nil if it runs without one
+// Root runs containers without a user namespace unless remap names a user
+// whose subordinate IDs to use; other users get a namespace mapping only
+// themselves to root, as unprivileged processes cannot map more
+func containerUserNamespace(remap string) (*userNamespace, error) {
+	if os.Geteuid() != 0 {
+		if remap != "" {
+			return nil, fmt.Errorf("--userns-remap requires root; rootless containers map only the calling user")
+		}
+		return &userNamespace{
+			UIDs: idMapping{HostID: os.Getuid(), Size: 1},
+			GIDs: idMapping{HostID: os.Getgid(), Size: 1},
+		}, nil
+	}
+	if remap == "" {
+		return nil, nil
+	}
+	return remappedUserNamespace(remap)
+}
+
+// remappedUserNamespace maps a full ID range from the subordinate IDs of a
+// user[:group] spec; the group defaults to the user's name
+func remappedUserNamespace(spec string) (*userNamespace, error) {
+	userName, groupName, hasGroup := strings.Cut(spec, ":")
+	if !hasGroup {
+		groupName = userName
+	}
+	if userName == "" || groupName == "" {
+		return nil, fmt.Errorf("invalid --userns-remap: %q (expected user[:group])", spec)
+	}
+
+	// Entries may name the user or give its numeric ID
+	userID, groupID := "", ""
+	if u, err := user.Lookup(userName); err == nil {
+		userID = u.Uid
+	}
+	if g, err := user.LookupGroup(groupName); err == nil {
+		groupID = g.Gid
+	}
+
+	uids, err := subordinateRange(subuidFile, userName, userID)
+	if err != nil {
+		return nil, err
+	}
+	gids, err := subordinateRange(subgidFile, groupName, groupID)
+	if err != nil {
+		return nil, err
+	}
+	return &userNamespace{UIDs: uids, GIDs: gids}, nil
+}
+
+// subordinateRange returns the first range of at least usernsRangeSize IDs in
+// a subuid or subgid file for name or id
+func subordinateRange(file, name, id string) (idMapping, error) {
+	entries, err := readColonFile(file)
+	if err != nil {
+		return idMapping{}, fmt.Errorf("failed to read %s: %v", file, err)
+	}
+	for _, fields := range entries {
+		if len(fields) != 3 || (fields[0] != name && (id == "" || fields[0] != id)) {
+			continue
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil || count < usernsRangeSize {
+			continue
+		}
+		return idMapping{HostID: start, Size: usernsRangeSize}, nil
+	}
+	return idMapping{}, fmt.Errorf("no range of %d IDs for %s in %s (add e.g. '%s:100000:%d')", usernsRangeSize, name, file, name, usernsRangeSize)
+}
+
+// lchown changes file ownership when a root filesystem is shifted
+var lchown = os.Lchown
+
+// idShiftMarker is the file in a root filesystem recording the ID range its
+// files were chowned to
+const idShiftMarker = ".gocker-idshift"
+
+// idmappedRootfs is where a remapped container's idmapped root filesystem is
+// mounted on the host
+func idmappedRootfs(containerID string) string {
+	return filepath.Join(containerDir(containerID), "rootfs")
+}
+
+// shiftRootfs returns the root filesystem a container with userns should
+// use, so that files host root owns belong to container root
+// An idmapped mount of rootfs is used where the kernel (5.12+) and the
+// filesystem support it; otherwise rootfs itself is chowned into the range,
+// once, which leaves it owned by that range on the host
+func shiftRootfs(containerID, rootfs string, userns *userNamespace) (string, error) {
+	if userns == nil || userns.UIDs.Size < usernsRangeSize {
+		return rootfs, nil
+	}
+	target := idmappedRootfs(containerID)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return "", fmt.Errorf("failed to create rootfs mount point: %v", err)
+	}
+	err := idmappedMount(rootfs, target, userns)
+	if err == nil {
+		return target, nil
+	}
+	os.Remove(target)
+	logger.Warn("Idmapped mounts unavailable, chowning the root filesystem instead", "rootfs", rootfs, "error", err)
+	return rootfs, chownRootfs(rootfs, userns)
+}
+
+// chownRootfs shifts the ownership of every file in rootfs into the userns
+// range, recording the range so it is done only once
+func chownRootfs(rootfs string, userns *userNamespace) error {
+	shift := fmt.Sprintf("%d:%d", userns.UIDs.HostID, userns.GIDs.HostID)
+	marker := filepath.Join(rootfs, idShiftMarker)
+	if data, err := os.ReadFile(marker); err == nil {
+		if strings.TrimSpace(string(data)) == shift {
+			return nil
+		}
+		return fmt.Errorf("root filesystem %s is already shifted to %s; use a copy for another range", rootfs, strings.TrimSpace(string(data)))
+	}
+
+	logger.Info("Shifting root filesystem ownership", "rootfs", rootfs, "uid", userns.UIDs.HostID, "gid", userns.GIDs.HostID)
+	err := filepath.WalkDir(rootfs, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		uid, gid, ok := fileOwner(info)
+		if !ok {
+			return nil
+		}
+		if uid < userns.UIDs.Size {
+			uid += userns.UIDs.HostID
+		}
+		if gid < userns.GIDs.Size {
+			gid += userns.GIDs.HostID
+		}
+		if err := lchown(path, uid, gid); err != nil {
+			return err
+		}
+		// chown clears the setuid and setgid bits, even for root
+		if mode := info.Mode(); mode.Type() == 0 && mode&(fs.ModeSetuid|fs.ModeSetgid) != 0 {
+			return os.Chmod(path, mode)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to shift root filesystem ownership: %v", err)
+	}
+	if err := os.WriteFile(marker, []byte(shift+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to record root filesystem shift: %v", err)
+	}
+	return nil
+}
+
+// releaseContainerMounts undoes the host mounts made for a container: its
+// plugin volumes and its idmapped root filesystem
+func releaseContainerMounts(containerID string) {
+	releasePluginVolumes(containerID)
+	target := idmappedRootfs(containerID)
+	if _, err := os.Stat(target); err == nil {
+		unmount(target, mntDetach)
+		os.Remove(target)
+	}
+}
+
+// usernsHolderCommand keeps a user namespace alive until its stdin closes,
+// for idmappedMount
+func usernsHolderCommand(args []string) {
+	io.Copy(io.Discard, os.Stdin)
+}
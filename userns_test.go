@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useSubordinateIDs points subuidFile and subgidFile at files with the given contents
+func useSubordinateIDs(t *testing.T, subuid, subgid string) {
+	savedUID, savedGID := subuidFile, subgidFile
+	t.Cleanup(func() { subuidFile, subgidFile = savedUID, savedGID })
+	dir := t.TempDir()
+	subuidFile, subgidFile = filepath.Join(dir, "subuid"), filepath.Join(dir, "subgid")
+	if err := os.WriteFile(subuidFile, []byte(subuid), 0644); err != nil {
+		t.Fatalf("Failed to write subuid: %v", err)
+	}
+	if err := os.WriteFile(subgidFile, []byte(subgid), 0644); err != nil {
+		t.Fatalf("Failed to write subgid: %v", err)
+	}
+}
+
+func TestRemappedUserNamespace(t *testing.T) {
+	useSubordinateIDs(t,
+		"# comment\nother:100000:65536\ngocker:200000:1000\ngocker:300000:65536\n",
+		"gocker:400000:131072\nstaff:500000:65536\n")
+
+	userns, err := remappedUserNamespace("gocker")
+	if err != nil {
+		t.Fatalf("remappedUserNamespace failed: %v", err)
+	}
+	// The range too small for a full mapping is skipped
+	want := userNamespace{UIDs: idMapping{HostID: 300000, Size: usernsRangeSize}, GIDs: idMapping{HostID: 400000, Size: usernsRangeSize}}
+	if *userns != want {
+		t.Errorf("remappedUserNamespace(gocker) = %+v, want %+v", *userns, want)
+	}
+
+	userns, err = remappedUserNamespace("gocker:staff")
+	if err != nil {
+		t.Fatalf("remappedUserNamespace failed: %v", err)
+	}
+	if userns.GIDs.HostID != 500000 {
+		t.Errorf("Expected the staff group's range, got %+v", userns.GIDs)
+	}
+
+	for _, spec := range []string{"nobody-here", "other", ":staff", "gocker:"} {
+		if _, err := remappedUserNamespace(spec); err == nil {
+			t.Errorf("remappedUserNamespace(%q): expected error", spec)
+		}
+	}
+}
+
+func TestContainerUserNamespace(t *testing.T) {
+	useSubordinateIDs(t, "gocker:100000:65536\n", "gocker:100000:65536\n")
+
+	userns, err := containerUserNamespace("")
+	if os.Geteuid() == 0 {
+		if err != nil || userns != nil {
+			t.Errorf("Expected no user namespace for root, got %+v (%v)", userns, err)
+		}
+		if userns, err := containerUserNamespace("gocker"); err != nil || userns.UIDs.HostID != 100000 {
+			t.Errorf("Expected remapped namespace, got %+v (%v)", userns, err)
+		}
+		return
+	}
+	if err != nil || userns == nil || userns.UIDs != (idMapping{HostID: os.Getuid(), Size: 1}) {
+		t.Errorf("Expected the caller mapped to root, got %+v (%v)", userns, err)
+	}
+	if _, err := containerUserNamespace("gocker"); err == nil {
+		t.Error("Expected --userns-remap to require root")
+	}
+}
+
+func TestChownRootfs(t *testing.T) {
+	type owner struct{ uid, gid int }
+	chowned := make(map[string]owner)
+	saved := lchown
+	t.Cleanup(func() { lchown = saved })
+	lchown = func(path string, uid, gid int) error {
+		chowned[path] = owner{uid, gid}
+		return nil
+	}
+
+	rootfs := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatalf("Failed to create rootfs: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "etc", "passwd"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create rootfs: %v", err)
+	}
+	userns := &userNamespace{UIDs: idMapping{HostID: 100000, Size: usernsRangeSize}, GIDs: idMapping{HostID: 200000, Size: usernsRangeSize}}
+
+	if err := chownRootfs(rootfs, userns); err != nil {
+		t.Fatalf("chownRootfs failed: %v", err)
+	}
+	if _, _, ok := fileOwner(mustStat(t, rootfs)); !ok {
+		t.Skip("file owners are not available on this system")
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	want := owner{uid + 100000, gid + 200000}
+	for _, path := range []string{rootfs, filepath.Join(rootfs, "etc"), filepath.Join(rootfs, "etc", "passwd")} {
+		if chowned[path] != want {
+			t.Errorf("%s chowned to %+v, want %+v", path, chowned[path], want)
+		}
+	}
+
+	// The shift is recorded and done only once per range
+	chowned = make(map[string]owner)
+	if err := chownRootfs(rootfs, userns); err != nil || len(chowned) != 0 {
+		t.Errorf("Expected no second shift, got %v (%v)", chowned, err)
+	}
+	other := &userNamespace{UIDs: idMapping{HostID: 300000, Size: usernsRangeSize}, GIDs: idMapping{HostID: 300000, Size: usernsRangeSize}}
+	if err := chownRootfs(rootfs, other); err == nil {
+		t.Error("Expected error shifting to a second range")
+	}
+}
+
+func mustStat(t *testing.T, path string) os.FileInfo {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	return info
+}
+
+// TestShiftRootfsWithoutRemap verifies root filesystems are used as is
+// without a remapped user namespace
+func TestShiftRootfsWithoutRemap(t *testing.T) {
+	rootless := &userNamespace{UIDs: idMapping{HostID: 1000, Size: 1}, GIDs: idMapping{HostID: 1000, Size: 1}}
+	for _, userns := range []*userNamespace{nil, rootless} {
+		if got, err := shiftRootfs("abc", "/srv/rootfs", userns); err != nil || got != "/srv/rootfs" {
+			t.Errorf("shiftRootfs(%+v) = %q, %v", userns, got, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set at build time with -ldflags "-X main.version=..." and
+// likewise for commit and buildDate (see the Makefile)
+// A plain 'go build' leaves version as "dev" and takes the commit from the
+// VCS information Go records in the binary
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// BuildInfo describes the gocker binary for 'gocker version'
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+func versionCommand(args []string) {
+	var jsonOutput bool
+	flags := newCommandFlags("version", "[options]", "Show the gocker version and build information")
+	flags.BoolVar(&jsonOutput, "json", "", "Print the build information as JSON")
+	if len(flags.MustParse(args)) > 0 {
+		flags.Fail("version does not accept arguments")
+	}
+
+	info := buildInfo()
+	if jsonOutput {
+		data, err := json.MarshalIndent(info, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Printf("gocker version %s\n", info.Version)
+	if info.Commit != "" {
+		fmt.Printf("  Commit:     %s\n", info.Commit)
+	}
+	if info.BuildDate != "" {
+		fmt.Printf("  Built:      %s\n", info.BuildDate)
+	}
+	fmt.Printf("  Go version: %s\n", info.GoVersion)
+	fmt.Printf("  Platform:   %s\n", info.Platform)
+}
+
+// buildInfo returns the binary's build metadata
+func buildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if info.Commit == "" {
+		info.Commit = vcsRevision()
+	}
+	return info
+}
+
+// vcsRevision returns the VCS revision Go recorded in the binary, if any
+func vcsRevision() string {
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision string
+	modified := false
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = shortID(setting.Value)
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	return revision
+}
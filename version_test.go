@@ -0,0 +1,29 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestBuildInfo(t *testing.T) {
+	savedVersion, savedCommit, savedDate := version, commit, buildDate
+	t.Cleanup(func() { version, commit, buildDate = savedVersion, savedCommit, savedDate })
+
+	version, commit, buildDate = "v1.2.0", "0123456789ab", "2026-10-16T00:00:00Z"
+	want := BuildInfo{
+		Version:   "v1.2.0",
+		Commit:    "0123456789ab",
+		BuildDate: "2026-10-16T00:00:00Z",
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if info := buildInfo(); info != want {
+		t.Errorf("buildInfo = %+v, want %+v", info, want)
+	}
+
+	// Without ldflags the commit comes from the VCS information, if any
+	commit = ""
+	if info := buildInfo(); info.Commit != vcsRevision() {
+		t.Errorf("Expected commit %q from VCS information, got %q", vcsRevision(), info.Commit)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinks bounds symlink resolution in secureJoin, as the kernel's
+// limit does for path lookups
+const maxSymlinks = 255
+
+// allowedVolumeSources restricts the host paths volumes may bind mount (see
+// Config.AllowedVolumeSources); empty allows any
+var allowedVolumeSources []string
+
+// secureJoin joins path onto root as if root were the filesystem root
+// Symlinks are resolved inside root, absolute ones relative to it, and ".."
+// stops at root, so a symlink in a container's root filesystem cannot make a
+// mount point resolve to a host path outside it
+// Components that do not exist yet are joined as they are
+func secureJoin(root, path string) (string, error) {
+	resolved := ""
+	pending := strings.Split(path, "/")
+	links := 0
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if resolved = filepath.Dir(resolved); resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks resolving %s in %s", path, root)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// checkVolumeSources checks the host paths of host:container volumes against
+// allowedVolumeSources, after resolving symlinks on the host
+func checkVolumeSources(volumes []string) error {
+	if len(allowedVolumeSources) == 0 {
+		return nil
+	}
+	for _, volume := range volumes {
+		parts := strings.Split(strings.TrimSpace(volume), ":")
+		if len(parts) != 2 {
+			continue // plugin volumes and malformed specs are checked elsewhere
+		}
+		source, err := filepath.EvalSymlinks(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return fmt.Errorf("host path does not exist: %s: %v", parts[0], err)
+		}
+		if !pathUnder(source, allowedVolumeSources) {
+			return fmt.Errorf("volume source %s is not under an allowed path (%s)", parts[0], strings.Join(allowedVolumeSources, ", "))
+		}
+	}
+	return nil
+}
+
+// pathUnder reports whether path is one of dirs or inside one of them, after
+// resolving symlinks in dirs
+func pathUnder(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		if path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecureJoin(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"etc", "srv/data"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	links := map[string]string{
+		"escape":   "/../../etc",
+		"relative": "../../../../etc",
+		"data":     "srv/data",
+		"abs":      "/srv",
+		"loop":     "loop",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/etc/hosts", "etc/hosts"},
+		{"/escape", "etc"},
+		{"/escape/passwd", "etc/passwd"},
+		{"/relative/passwd", "etc/passwd"},
+		{"/../../etc/passwd", "etc/passwd"},
+		{"/data/file", "srv/data/file"},
+		{"/abs/data", "srv/data"},
+		{"/new/dir", "new/dir"},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		got, err := secureJoin(root, tt.path)
+		if err != nil {
+			t.Errorf("secureJoin(%q) failed: %v", tt.path, err)
+			continue
+		}
+		if want := filepath.Join(root, tt.want); got != want {
+			t.Errorf("secureJoin(%q) = %q, want %q", tt.path, got, want)
+		}
+	}
+
+	if _, err := secureJoin(root, "/loop/x"); err == nil || !strings.Contains(err.Error(), "too many symlinks") {
+		t.Errorf("Expected symlink loop error, got %v", err)
+	}
+}
+
+// TestMountVolumesSymlinkEscape verifies a rootfs symlink cannot redirect a
+// mount point out of the rootfs
+func TestMountVolumesSymlinkEscape(t *testing.T) {
+	var targets []string
+	saved := mount
+	t.Cleanup(func() { mount = saved })
+	mount = func(source, target, fstype string, flags uintptr, data string) error {
+		targets = append(targets, target)
+		return nil
+	}
+
+	rootfs := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(rootfs, "data")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := mountVolumes([]string{t.TempDir() + ":/data"}, rootfs); err != nil {
+		t.Fatalf("mountVolumes failed: %v", err)
+	}
+	for _, target := range targets {
+		if !strings.HasPrefix(target, rootfs+"/") {
+			t.Errorf("Mount target %s escapes the rootfs", target)
+		}
+	}
+}
+
+func TestCheckVolumeSources(t *testing.T) {
+	restoreRuntimeSettings(t)
+	allowed := t.TempDir()
+	data := filepath.Join(allowed, "data")
+	if err := os.Mkdir(data, 0755); err != nil {
+		t.Fatalf("Failed to create data: %v", err)
+	}
+	other := t.TempDir()
+	link := filepath.Join(allowed, "link")
+	if err := os.Symlink(other, link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	if err := checkVolumeSources([]string{other + ":/other"}); err != nil {
+		t.Errorf("Expected any source to be allowed by default, got %v", err)
+	}
+
+	if err := applyConfig(&Config{AllowedVolumeSources: []string{allowed}}); err != nil {
+		t.Fatalf("applyConfig failed: %v", err)
+	}
+	if err := checkVolumeSources([]string{data + ":/data", allowed + ":/all", "nfs:shared:/nfs"}); err != nil {
+		t.Errorf("Expected allowed sources to pass, got %v", err)
+	}
+	for _, volume := range []string{other + ":/other", link + ":/link", allowed + "/../" + filepath.Base(other) + ":/x"} {
+		if err := checkVolumeSources([]string{volume}); err == nil {
+			t.Errorf("checkVolumeSources(%s): expected error", volume)
+		}
+	}
+
+	if err := applyConfig(&Config{AllowedVolumeSources: []string{"relative"}}); err == nil {
+		t.Error("Expected error for a relative allowed path")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"os/exec"
+)
+
+// Container runtimes selected with --runtime
+const (
+	runtimeNative = "native" // chroot and exec the command (the default)
+	runtimeWasm   = "wasm"   // run the command as a WebAssembly module with WASI
+)
+
+// wasmRuntime is the wazero CLI that runs WebAssembly modules (see
+// Config.WasmRuntime); a bare name is looked up in the host's PATH
+// gocker drives the CLI rather than embedding wazero, so it keeps building
+// from the standard library alone
+var wasmRuntime = "wazero"
+
+// wasmRuntimeMount is where the WebAssembly runtime appears in a container
+const wasmRuntimeMount = "/.gocker/wazero"
+
+// validateRuntime checks a --runtime value; empty means native
+func validateRuntime(runtime string) error {
+	if runtime != "" && runtime != runtimeNative && runtime != runtimeWasm {
+		return fmt.Errorf("invalid --runtime: %s (expected 'native' or 'wasm')", runtime)
+	}
+	return nil
+}
+
+// findWasmRuntime locates the WebAssembly runtime on the host
+// It is bind mounted into the container and runs after chroot, so it must
+// not need the host's dynamic loader or libraries
+func findWasmRuntime() (string, error) {
+	path, err := exec.LookPath(wasmRuntime)
+	if err != nil {
+		return "", fmt.Errorf("WebAssembly runtime %s not found (install the wazero CLI or set wasm_runtime): %v", wasmRuntime, err)
+	}
+	file, err := elf.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("WebAssembly runtime %s is not a Linux executable: %v", path, err)
+	}
+	defer file.Close()
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_INTERP {
+			return "", fmt.Errorf("WebAssembly runtime %s is dynamically linked; it must be static to run in the container's root filesystem", path)
+		}
+	}
+	return path, nil
+}
+
+// wasmArgv returns the command that runs a module with the runtime mounted
+// in the container, giving it the container's root directory and environment
+// through WASI; args[0] is the module's path in the container
+func wasmArgv(args, env []string) []string {
+	argv := []string{"wazero", "run", "-mount=/:/"}
+	for _, value := range env {
+		argv = append(argv, "-env="+value)
+	}
+	argv = append(argv, args[0])
+	if len(args) > 1 {
+		argv = append(append(argv, "--"), args[1:]...)
+	}
+	return argv
+}
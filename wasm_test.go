@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateRuntime(t *testing.T) {
+	for _, runtime := range []string{"", "native", "wasm"} {
+		if err := validateRuntime(runtime); err != nil {
+			t.Errorf("validateRuntime(%q) failed: %v", runtime, err)
+		}
+	}
+	if err := validateRuntime("runc"); err == nil {
+		t.Error("Expected error for an unknown runtime")
+	}
+}
+
+func TestWasmArgv(t *testing.T) {
+	env := []string{"PATH=/bin", "HOME=/root"}
+	got := wasmArgv([]string{"/app/server.wasm", "--port", "8080"}, env)
+	want := []string{"wazero", "run", "-mount=/:/", "-env=PATH=/bin", "-env=HOME=/root", "/app/server.wasm", "--", "--port", "8080"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wasmArgv = %v, want %v", got, want)
+	}
+	if got := wasmArgv([]string{"/hello.wasm"}, nil); !reflect.DeepEqual(got, []string{"wazero", "run", "-mount=/:/", "/hello.wasm"}) {
+		t.Errorf("wasmArgv without arguments = %v", got)
+	}
+}
+
+// TestFindWasmRuntime verifies the runtime must exist and be an executable
+func TestFindWasmRuntime(t *testing.T) {
+	restoreRuntimeSettings(t)
+
+	wasmRuntime = filepath.Join(t.TempDir(), "missing")
+	if _, err := findWasmRuntime(); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+
+	script := filepath.Join(t.TempDir(), "wazero")
+	os.WriteFile(script, []byte("#!/bin/sh\n"), 0755)
+	wasmRuntime = script
+	if _, err := findWasmRuntime(); err == nil || !strings.Contains(err.Error(), "not a Linux executable") {
+		t.Errorf("Expected error for a script, got %v", err)
+	}
+}